@@ -40,6 +40,7 @@ func main() {
 	install := flag.Bool("install", false, "Install systemd service")
 	check := flag.Bool("check", false, "Check system configuration and offer to fix issues")
 	configTemplate := flag.Bool("config-template", false, "Print a commented config template and exit")
+	writeTemplate := flag.String("write-config-template", "", "Write every config field at its default, with comments, to this path and exit")
 	iamPolicy := flag.Bool("iam-policy", false, "Print IAM policy template for Route53 access")
 	dryRun := flag.Bool("dry-run", false, "Dry run mode - show what would be done without making changes")
 	showSystemdService := flag.Bool("show-systemd", false, "Show the systemd service file that would be generated")
@@ -53,7 +54,18 @@ func main() {
 	case *version:
 		fmt.Printf("homelab-horizon %s (built %s)\n", Version, BuildTime)
 	case *configTemplate:
-		fmt.Print(config.Template())
+		data, err := config.Template()
+		if err != nil {
+			slog.Error("failed to render config template", "err", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+	case *writeTemplate != "":
+		if err := config.WriteTemplate(*writeTemplate); err != nil {
+			slog.Error("failed to write config template", "err", err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", *writeTemplate)
 	case *iamPolicy:
 		fmt.Print(config.IAMPolicyTemplate())
 	case *showSystemdService:
@@ -105,6 +117,10 @@ func runServer(configPath string, dryRun bool, mcpEnabled bool) {
 		slog.Error("failed to load config", "err", err)
 		os.Exit(1)
 	}
+	if err := cfg.Validate(); err != nil {
+		slog.Error("invalid config", "path", cfgPath, "err", err)
+		os.Exit(1)
+	}
 
 	slog.Debug("config search paths", "paths", strings.Join(config.SearchPaths, ", "))
 
@@ -230,6 +246,7 @@ func runMCPStdio(configPath string, dryRun bool) {
 
 func loadConfig(configPath string) (*config.Config, string, error) {
 	if configPath != "" {
+		configPath = config.ExpandPath(configPath)
 		cfg, err := config.Load(configPath)
 		if err != nil {
 			return nil, "", err
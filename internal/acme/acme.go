@@ -9,16 +9,20 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // User implements registration.User for Lego
@@ -36,6 +40,10 @@ func (u *User) GetPrivateKey() crypto.PrivateKey        { return u.key }
 type Client struct {
 	accountDir string
 	staging    bool
+	caDirURL   string
+	dryRun     bool
+	resolvers  []string
+	fs         system.FileSystem
 }
 
 // NewClient creates a new ACME client
@@ -43,19 +51,95 @@ func NewClient(accountDir string, staging bool) *Client {
 	return &Client{
 		accountDir: accountDir,
 		staging:    staging,
+		fs:         &system.RealFileSystem{},
+	}
+}
+
+// SetFileSystem replaces the filesystem used for account persistence and
+// for reading "file:" provider credentials.
+func (c *Client) SetFileSystem(fs system.FileSystem) {
+	c.fs = fs
+}
+
+// SetCADirectoryURL points the client at a custom ACME directory (e.g. a
+// private step-ca). It takes precedence over staging; empty restores the
+// Let's Encrypt default.
+func (c *Client) SetCADirectoryURL(dirURL string) {
+	c.caDirURL = dirURL
+}
+
+// SetDNSResolvers makes DNS-01 propagation checks query the given
+// nameservers ("ip" or "ip:port") instead of the system resolver. Empty
+// restores the default.
+func (c *Client) SetDNSResolvers(resolvers []string) {
+	c.resolvers = resolvers
+}
+
+// SetDryRun makes ObtainCertificate stop after creating the DNS provider and
+// return a self-signed placeholder instead of registering with the CA or
+// performing any challenge.
+func (c *Client) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// directoryURL returns the ACME directory this client talks to.
+func (c *Client) directoryURL() string {
+	switch {
+	case c.caDirURL != "":
+		return c.caDirURL
+	case c.staging:
+		return lego.LEDirectoryStaging
+	default:
+		return lego.LEDirectoryProduction
+	}
+}
+
+// userDir returns where the ACME account for the current CA is stored.
+// Registrations are only valid at the CA that issued them, so a custom CA
+// gets its own subdirectory instead of reusing the Let's Encrypt account.
+func (c *Client) userDir() string {
+	if c.caDirURL == "" {
+		return c.accountDir
+	}
+	u, err := url.Parse(c.caDirURL)
+	if err != nil || u.Host == "" {
+		return filepath.Join(c.accountDir, "custom")
 	}
+	return filepath.Join(c.accountDir, strings.ReplaceAll(u.Host, ":", "_"))
 }
 
-// ObtainCertificate requests a certificate for the given domains
-func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *DNSProviderConfig, logFn func(string)) (*certificate.Resource, error) {
+// ObtainCertificate requests a single certificate covering all of the given
+// domains as SANs; the first becomes the CN. Each domain gets its own DNS-01
+// challenge. An empty keyType means DefaultKeyType.
+func (c *Client) ObtainCertificate(email string, domains []string, keyType KeyType, providerCfg *DNSProviderConfig, logFn func(string)) (*certificate.Resource, error) {
 	if logFn == nil {
 		logFn = func(s string) {}
 	}
 
-	logFn(fmt.Sprintf("Using DNS provider: %s", ProviderName(providerCfg)))
+	domains, err := NormalizeDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+	certKeyType, err := legoKeyType(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	useHTTP01 := providerCfg.challenge() == ChallengeHTTP01
+	if useHTTP01 {
+		if err := providerCfg.Validate(); err != nil {
+			return nil, err
+		}
+		if err := checkHTTP01Domains(domains); err != nil {
+			return nil, err
+		}
+		logFn(fmt.Sprintf("Using HTTP-01 challenge, serving tokens on %s", providerCfg.http01Address()))
+	} else {
+		logFn(fmt.Sprintf("Using DNS provider: %s", ProviderName(providerCfg)))
+	}
 
 	// Log provider config details (without secrets)
-	if providerCfg != nil {
+	if providerCfg != nil && !useHTTP01 {
 		switch providerCfg.Type {
 		case DNSProviderRoute53:
 			if providerCfg.AWSProfile != "" {
@@ -84,13 +168,29 @@ func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *
 			if providerCfg.CloudflareZoneID != "" {
 				logFn(fmt.Sprintf("  Cloudflare Zone ID: %s", providerCfg.CloudflareZoneID))
 			}
+		case DNSProviderGCloud:
+			if providerCfg.GCloudProject != "" {
+				logFn(fmt.Sprintf("  Google Cloud Project: %s", providerCfg.GCloudProject))
+			}
 		}
 	}
 
 	// Create DNS challenge provider with logging
-	dnsProvider, err := CreateChallengeProvider(providerCfg, logFn)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create DNS provider: %w", err)
+	var dnsProvider challenge.Provider
+	if !useHTTP01 {
+		dnsProvider, err = createChallengeProvider(c.fs, providerCfg, logFn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create DNS provider: %w", err)
+		}
+	}
+
+	if c.dryRun {
+		logFn(fmt.Sprintf("DRY RUN: would request a %s certificate from %s for:", certKeyType, c.directoryURL()))
+		for i, d := range domains {
+			logFn(fmt.Sprintf("  [%d/%d] %s", i+1, len(domains), d))
+		}
+		logFn(fmt.Sprintf("DRY RUN: skipping %d %s challenge(s); issuing a self-signed placeholder", len(domains), providerCfg.challenge()))
+		return placeholderCertificate(domains, certKeyType)
 	}
 
 	// Load or create user
@@ -101,12 +201,16 @@ func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *
 
 	// Configure lego client
 	legoConfig := lego.NewConfig(user)
-	legoConfig.Certificate.KeyType = certcrypto.RSA2048
-
-	if c.staging {
-		legoConfig.CADirURL = lego.LEDirectoryStaging
+	legoConfig.Certificate.KeyType = certKeyType
+	logFn(fmt.Sprintf("Certificate key type: %s", certKeyType))
+
+	legoConfig.CADirURL = c.directoryURL()
+	switch {
+	case c.caDirURL != "":
+		logFn(fmt.Sprintf("Using custom ACME directory: %s", c.caDirURL))
+	case c.staging:
 		logFn("Using Let's Encrypt STAGING environment")
-	} else {
+	default:
 		logFn("Using Let's Encrypt PRODUCTION environment")
 	}
 
@@ -115,9 +219,28 @@ func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *
 		return nil, fmt.Errorf("failed to create ACME client: %w", err)
 	}
 
-	// Set DNS provider (vanilla Lego)
-	if err := client.Challenge.SetDNS01Provider(dnsProvider); err != nil {
-		return nil, fmt.Errorf("failed to set DNS provider: %w", err)
+	if useHTTP01 {
+		httpProvider, err := newHTTP01Provider(providerCfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Challenge.SetHTTP01Provider(httpProvider); err != nil {
+			return nil, fmt.Errorf("failed to set HTTP-01 provider: %w", err)
+		}
+	} else {
+		// Set DNS provider (vanilla Lego)
+		var dnsOpts []dns01.ChallengeOption
+		if len(c.resolvers) > 0 {
+			nameservers := dns01.ParseNameservers(c.resolvers)
+			logFn(fmt.Sprintf("Checking propagation against: %s", strings.Join(nameservers, ", ")))
+			dnsOpts = append(dnsOpts, dns01.AddRecursiveNameservers(nameservers))
+		}
+		if b := providerCfg.PropagationBackoff; b != nil {
+			dnsOpts = append(dnsOpts, dns01.WrapPreCheck(newPropagationPoller(*b, logFn).wrap))
+		}
+		if err := client.Challenge.SetDNS01Provider(dnsProvider, dnsOpts...); err != nil {
+			return nil, fmt.Errorf("failed to set DNS provider: %w", err)
+		}
 	}
 
 	// Register if needed
@@ -135,9 +258,16 @@ func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *
 		}
 	}
 
-	logFn(fmt.Sprintf("Requesting certificate for: %v", domains))
+	logFn(fmt.Sprintf("Requesting certificate for %d domain(s):", len(domains)))
+	for i, d := range domains {
+		logFn(fmt.Sprintf("  [%d/%d] %s", i+1, len(domains), d))
+	}
 	logFn("Starting ACME challenge process...")
-	logFn(fmt.Sprintf("Staging all %d DNS challenge record(s), then checking propagation once (30-120s)...", len(domains)))
+	if useHTTP01 {
+		logFn(fmt.Sprintf("Serving %d HTTP-01 token(s) for the CA to fetch...", len(domains)))
+	} else {
+		logFn(fmt.Sprintf("Staging all %d DNS challenge record(s), then checking propagation once (30-120s)...", len(domains)))
+	}
 
 	// Request certificate
 	request := certificate.ObtainRequest{
@@ -170,30 +300,23 @@ func (c *Client) ObtainCertificate(email string, domains []string, providerCfg *
 	return certificates, nil
 }
 
+// loadOrCreateUser returns the ACME account for the current CA, reusing the
+// stored key and registration when present so renewals don't register a new
+// account (Let's Encrypt rate-limits new accounts per IP).
 func (c *Client) loadOrCreateUser(email string) (*User, error) {
-	if err := os.MkdirAll(c.accountDir, 0700); err != nil {
+	accountDir := c.userDir()
+	if err := c.fs.MkdirAll(accountDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create account directory: %w", err)
 	}
 
-	accountFile := filepath.Join(c.accountDir, "account.json")
-	keyFile := filepath.Join(c.accountDir, "account.key")
+	accountFile := filepath.Join(accountDir, "account.json")
+	keyFile := filepath.Join(accountDir, "account.key")
 
 	user := &User{Email: email}
 
-	// Try to load existing user
-	if _, err := os.Stat(accountFile); err == nil {
-		data, err := os.ReadFile(accountFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read account file: %w", err)
-		}
-		if err := json.Unmarshal(data, user); err != nil {
-			return nil, fmt.Errorf("failed to parse account file: %w", err)
-		}
-	}
-
 	// Try to load existing key
-	if _, err := os.Stat(keyFile); err == nil {
-		keyPEM, err := os.ReadFile(keyFile)
+	if c.fs.Exists(keyFile) {
+		keyPEM, err := c.fs.ReadFile(keyFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read key file: %w", err)
 		}
@@ -206,23 +329,39 @@ func (c *Client) loadOrCreateUser(email string) (*User, error) {
 			return nil, fmt.Errorf("failed to parse EC private key: %w", err)
 		}
 		user.key = key
-	} else {
-		// Generate new key
-		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate key: %w", err)
-		}
-		user.key = key
 
-		// Save the key
-		keyBytes, err := x509.MarshalECPrivateKey(key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal key: %w", err)
-		}
-		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
-		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
-			return nil, fmt.Errorf("failed to save key: %w", err)
+		// The registration is bound to this key, so it's only worth loading
+		// alongside it.
+		if c.fs.Exists(accountFile) {
+			data, err := c.fs.ReadFile(accountFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read account file: %w", err)
+			}
+			if err := json.Unmarshal(data, user); err != nil {
+				return nil, fmt.Errorf("failed to parse account file: %w", err)
+			}
+			// The registration belongs to whoever created it; a changed
+			// contact address doesn't need a new account.
+			user.Email = email
 		}
+		return user, nil
+	}
+
+	// Generate new key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	user.key = key
+
+	// Save the key
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := c.fs.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save key: %w", err)
 	}
 
 	return user, nil
@@ -230,12 +369,12 @@ func (c *Client) loadOrCreateUser(email string) (*User, error) {
 
 // saveUser saves the user registration to disk
 func (c *Client) saveUser(user *User) error {
-	accountFile := filepath.Join(c.accountDir, "account.json")
+	accountFile := filepath.Join(c.userDir(), "account.json")
 	data, err := json.MarshalIndent(user, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal user: %w", err)
 	}
-	return os.WriteFile(accountFile, data, 0600)
+	return c.fs.WriteFile(accountFile, data, 0600)
 }
 
 // verifyRoute53Zone checks if a Route53 zone exists and returns its name
@@ -0,0 +1,85 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+func TestDirectoryURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		staging  bool
+		caDirURL string
+		want     string
+		wantDir  string
+	}{
+		{"production", false, "", lego.LEDirectoryProduction, "/acct"},
+		{"staging", true, "", lego.LEDirectoryStaging, "/acct"},
+		{"custom overrides staging", true, "https://ca.internal:9000/acme/acme/directory", "https://ca.internal:9000/acme/acme/directory", filepath.Join("/acct", "ca.internal_9000")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("/acct", tt.staging)
+			c.SetCADirectoryURL(tt.caDirURL)
+			if got := c.directoryURL(); got != tt.want {
+				t.Errorf("directoryURL() = %q, want %q", got, tt.want)
+			}
+			if got := c.userDir(); got != tt.wantDir {
+				t.Errorf("userDir() = %q, want %q", got, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestLoadOrCreateUserReusesAccount(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	c := NewClient("/acct", false)
+	c.SetFileSystem(fs)
+
+	first, err := c.loadOrCreateUser("admin@example.com")
+	if err != nil {
+		t.Fatalf("loadOrCreateUser() error = %v", err)
+	}
+	if _, ok := fs.GetWrittenFiles()["/acct/account.key"]; !ok {
+		t.Fatal("Expected account key to be saved")
+	}
+
+	first.Registration = &registration.Resource{URI: "https://acme.example/acct/1"}
+	if err := c.saveUser(first); err != nil {
+		t.Fatalf("saveUser() error = %v", err)
+	}
+
+	second, err := c.loadOrCreateUser("admin@example.com")
+	if err != nil {
+		t.Fatalf("loadOrCreateUser() error = %v", err)
+	}
+	firstKey := first.GetPrivateKey().(*ecdsa.PrivateKey)
+	if !firstKey.Equal(second.GetPrivateKey()) {
+		t.Error("Expected the stored account key to be reused")
+	}
+	if second.Registration == nil || second.Registration.URI != "https://acme.example/acct/1" {
+		t.Errorf("Expected stored registration to be reused, got %+v", second.Registration)
+	}
+}
+
+func TestLoadOrCreateUserIgnoresOrphanedRegistration(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	fs.AddFile("/acct/account.json", []byte(`{"email":"admin@example.com","registration":{"uri":"https://acme.example/acct/1"}}`))
+	c := NewClient("/acct", false)
+	c.SetFileSystem(fs)
+
+	user, err := c.loadOrCreateUser("admin@example.com")
+	if err != nil {
+		t.Fatalf("loadOrCreateUser() error = %v", err)
+	}
+	if user.Registration != nil {
+		t.Error("Expected registration without its key to be discarded")
+	}
+}
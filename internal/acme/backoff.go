@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// Backoff is a DNS-01 propagation polling strategy. The wait after check n
+// (from 0) is Initial * Multiplier^n, capped at Max, then shifted by up to
+// ±Jitter of itself so concurrent issuances don't poll the resolver in step.
+// Polling gives up after MaxAttempts checks or once MaxWait has been spent
+// waiting, whichever comes first. Zero fields take the defaults below.
+type Backoff struct {
+	Initial     time.Duration // default 5s
+	Multiplier  float64       // default 2; 1 polls at a fixed interval
+	Max         time.Duration // longest single wait; default 1m
+	Jitter      float64       // 0-1; default 0.2
+	MaxAttempts int           // default 20
+	MaxWait     time.Duration // total wait cap; 0 = bounded by MaxAttempts only
+}
+
+// Validate rejects settings that would poll backwards or never stop.
+func (b Backoff) Validate() error {
+	var errs []error
+	if b.Initial < 0 || b.Max < 0 || b.MaxWait < 0 {
+		errs = append(errs, errors.New("durations must not be negative"))
+	}
+	if b.Multiplier != 0 && b.Multiplier < 1 {
+		errs = append(errs, fmt.Errorf("multiplier %v must be at least 1", b.Multiplier))
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		errs = append(errs, fmt.Errorf("jitter %v must be between 0 and 1", b.Jitter))
+	}
+	if b.MaxAttempts < 0 {
+		errs = append(errs, fmt.Errorf("max attempts %d must not be negative", b.MaxAttempts))
+	}
+	return errors.Join(errs...)
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial == 0 {
+		b.Initial = 5 * time.Second
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = 2
+	}
+	if b.Max == 0 {
+		b.Max = time.Minute
+	}
+	if b.Jitter == 0 {
+		b.Jitter = 0.2
+	}
+	if b.MaxAttempts == 0 {
+		b.MaxAttempts = 20
+	}
+	return b
+}
+
+// delay is the wait after check n (from 0); r in [0, 1) picks the jitter.
+func (b Backoff) delay(n int, r float64) time.Duration {
+	d := min(float64(b.Initial)*math.Pow(b.Multiplier, float64(n)), float64(b.Max))
+	d += d * b.Jitter * (2*r - 1)
+	return time.Duration(d)
+}
+
+// propagationPoller replaces lego's fixed-interval propagation loop with a
+// Backoff. It is installed via dns01.WrapPreCheck: lego calls wrap once per
+// record and stops as soon as it returns true, so the whole loop — and its
+// final error — lives here.
+type propagationPoller struct {
+	backoff Backoff
+	logFn   func(string)
+
+	// sleep and rand are overridden in tests.
+	sleep func(time.Duration)
+	rand  func() float64
+}
+
+func newPropagationPoller(b Backoff, logFn func(string)) *propagationPoller {
+	if logFn == nil {
+		logFn = func(string) {}
+	}
+	return &propagationPoller{backoff: b.withDefaults(), logFn: logFn, sleep: time.Sleep, rand: rand.Float64}
+}
+
+func (p *propagationPoller) wrap(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+	b := p.backoff
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		ok, err := check(fqdn, value)
+		if ok && err == nil {
+			if attempt > 1 {
+				p.logFn(fmt.Sprintf("  ✓ %s propagated after %v", fqdn, waited.Round(time.Millisecond)))
+			}
+			return true, nil
+		}
+		if attempt >= b.MaxAttempts || (b.MaxWait > 0 && waited >= b.MaxWait) {
+			if err == nil {
+				err = errors.New("TXT record not visible yet")
+			}
+			return true, fmt.Errorf("%s not propagated after %d checks over %v: %w", fqdn, attempt, waited.Round(time.Millisecond), err)
+		}
+
+		d := b.delay(attempt-1, p.rand())
+		if b.MaxWait > 0 {
+			d = min(d, b.MaxWait-waited)
+		}
+		p.logFn(fmt.Sprintf("  Waiting %v for %s to propagate (check %d/%d)", d.Round(time.Millisecond), fqdn, attempt, b.MaxAttempts))
+		p.sleep(d)
+		waited += d
+	}
+}
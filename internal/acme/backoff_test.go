@@ -0,0 +1,110 @@
+package acme
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Multiplier: 2, Max: 5 * time.Second, Jitter: 0.5}
+	tests := []struct {
+		n    int
+		r    float64
+		want time.Duration
+	}{
+		{0, 0.5, time.Second}, // r=0.5 is no jitter
+		{1, 0.5, 2 * time.Second},
+		{2, 0.5, 4 * time.Second},
+		{3, 0.5, 5 * time.Second}, // capped at Max
+		{1, 0, time.Second},       // -50%
+		{1, 1, 3 * time.Second},   // +50%
+	}
+	for _, tt := range tests {
+		if got := b.delay(tt.n, tt.r); got != tt.want {
+			t.Errorf("delay(%d, %v) = %v, want %v", tt.n, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffValidate(t *testing.T) {
+	if err := (Backoff{}).Validate(); err != nil {
+		t.Errorf("zero Backoff should be valid, got %v", err)
+	}
+	for _, b := range []Backoff{
+		{Multiplier: 0.5},
+		{Jitter: 1.5},
+		{MaxAttempts: -1},
+		{Initial: -time.Second},
+	} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", b)
+		}
+	}
+
+	cfg := &DNSProviderConfig{Type: DNSProviderCloudflare, CloudflareAPIToken: "cf-token", PropagationBackoff: &Backoff{Multiplier: 0.5}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "propagation backoff") {
+		t.Errorf("DNSProviderConfig.Validate() = %v, want propagation backoff error", err)
+	}
+}
+
+func newTestPoller(b Backoff) (*propagationPoller, *[]time.Duration) {
+	var sleeps []time.Duration
+	p := newPropagationPoller(b, nil)
+	p.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	p.rand = func() float64 { return 0.5 }
+	return p, &sleeps
+}
+
+func TestPropagationPollerBacksOff(t *testing.T) {
+	p, sleeps := newTestPoller(Backoff{Initial: time.Second, Multiplier: 3})
+
+	calls := 0
+	check := func(fqdn, value string) (bool, error) {
+		calls++
+		if calls < 4 {
+			return false, errors.New("NXDOMAIN")
+		}
+		return true, nil
+	}
+	stop, err := p.wrap("example.com", "_acme-challenge.example.com.", "v", check)
+	if !stop || err != nil {
+		t.Fatalf("wrap() = %v, %v; want true, nil", stop, err)
+	}
+	want := []time.Duration{time.Second, 3 * time.Second, 9 * time.Second}
+	if len(*sleeps) != len(want) {
+		t.Fatalf("sleeps = %v, want %v", *sleeps, want)
+	}
+	for i := range want {
+		if (*sleeps)[i] != want[i] {
+			t.Errorf("sleep %d = %v, want %v", i, (*sleeps)[i], want[i])
+		}
+	}
+}
+
+func TestPropagationPollerLimits(t *testing.T) {
+	never := func(fqdn, value string) (bool, error) { return false, errors.New("NXDOMAIN") }
+
+	p, sleeps := newTestPoller(Backoff{Initial: time.Second, MaxAttempts: 3})
+	stop, err := p.wrap("example.com", "_acme-challenge.example.com.", "v", never)
+	if !stop || err == nil || !strings.Contains(err.Error(), "after 3 checks") || !strings.Contains(err.Error(), "NXDOMAIN") {
+		t.Errorf("MaxAttempts: wrap() = %v, %v; want true and a 3-check error", stop, err)
+	}
+	if len(*sleeps) != 2 {
+		t.Errorf("MaxAttempts: expected 2 waits between 3 checks, got %v", *sleeps)
+	}
+
+	// MaxWait trims the last wait so the total lands exactly on the cap.
+	p, sleeps = newTestPoller(Backoff{Initial: 4 * time.Second, MaxWait: 10 * time.Second})
+	if _, err := p.wrap("example.com", "_acme-challenge.example.com.", "v", never); err == nil {
+		t.Fatal("MaxWait: expected error")
+	}
+	var total time.Duration
+	for _, d := range *sleeps {
+		total += d
+	}
+	if total != 10*time.Second || len(*sleeps) != 2 {
+		t.Errorf("MaxWait: sleeps = %v (total %v), want [4s 6s]", *sleeps, total)
+	}
+}
@@ -0,0 +1,34 @@
+package acme
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeDomains cleans up the SAN list for a certificate request:
+// lowercases, strips trailing dots, and drops duplicates while keeping the
+// first entry (the certificate's CN) first. A wildcard and its apex
+// ("*.example.com" + "example.com") are distinct SANs and both kept; their
+// DNS-01 challenges share one _acme-challenge name with two TXT values.
+func NormalizeDomains(domains []string) ([]string, error) {
+	seen := make(map[string]bool, len(domains))
+	var out []string
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			return nil, fmt.Errorf("empty domain in certificate request")
+		}
+		if strings.Contains(strings.TrimPrefix(d, "*."), "*") {
+			return nil, fmt.Errorf("invalid domain %q: wildcard must be the leftmost label", d)
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		out = append(out, d)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no domains in certificate request")
+	}
+	return out, nil
+}
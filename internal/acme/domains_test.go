@@ -0,0 +1,23 @@
+package acme
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeDomains(t *testing.T) {
+	got, err := NormalizeDomains([]string{"*.Example.com", "example.com.", " app.example.com ", "*.example.com"})
+	if err != nil {
+		t.Fatalf("NormalizeDomains() error = %v", err)
+	}
+	want := []string{"*.example.com", "example.com", "app.example.com"}
+	if !slices.Equal(got, want) {
+		t.Errorf("NormalizeDomains() = %v, want %v", got, want)
+	}
+
+	for _, bad := range [][]string{nil, {""}, {"app.*.example.com"}, {"**.example.com"}} {
+		if _, err := NormalizeDomains(bad); err == nil {
+			t.Errorf("NormalizeDomains(%q) expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// placeholderValidity matches a Let's Encrypt certificate's lifetime so
+// renewal checks treat a placeholder like a freshly issued cert.
+const placeholderValidity = 90 * 24 * time.Hour
+
+// placeholderCertificate returns a self-signed certificate for domains in
+// the shape ObtainCertificate hands back, so the rest of the pipeline
+// (saving, HAProxy packaging) can be rehearsed without contacting a CA. The
+// issuer is left empty: there is no chain.
+func placeholderCertificate(domains []string, keyType certcrypto.KeyType) (*certificate.Resource, error) {
+	key, err := certcrypto.GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("generating placeholder key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("placeholder key type %T cannot sign", key)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domains[0], Organization: []string{"homelab-horizon dry run"}},
+		DNSNames:     domains,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(placeholderValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("creating placeholder certificate: %w", err)
+	}
+
+	return &certificate.Resource{
+		Domain:      domains[0],
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  certcrypto.PEMEncode(key),
+	}, nil
+}
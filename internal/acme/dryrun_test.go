@@ -0,0 +1,57 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObtainCertificateDryRun(t *testing.T) {
+	c := NewClient(t.TempDir(), false)
+	c.SetDryRun(true)
+
+	var logs []string
+	res, err := c.ObtainCertificate("admin@example.com", []string{"*.example.com", "example.com"}, KeyTypeEC384,
+		&DNSProviderConfig{Type: DNSProviderDigitalOcean, DigitalOceanAuthToken: "do-token"},
+		func(s string) { logs = append(logs, s) })
+	if err != nil {
+		t.Fatalf("ObtainCertificate() error = %v", err)
+	}
+
+	if !slices.ContainsFunc(logs, func(s string) bool { return strings.HasPrefix(s, "DRY RUN") }) {
+		t.Errorf("expected DRY RUN log lines, got %q", logs)
+	}
+	if len(res.IssuerCertificate) != 0 {
+		t.Error("placeholder should have no issuer chain")
+	}
+
+	pair, err := tls.X509KeyPair(res.Certificate, res.PrivateKey)
+	if err != nil {
+		t.Fatalf("placeholder cert and key don't match: %v", err)
+	}
+	leaf := pair.Leaf
+	if got, want := leaf.DNSNames, []string{"*.example.com", "example.com"}; !slices.Equal(got, want) {
+		t.Errorf("DNSNames = %v, want %v", got, want)
+	}
+	if leaf.Subject.CommonName != "*.example.com" {
+		t.Errorf("CN = %q", leaf.Subject.CommonName)
+	}
+	if _, ok := pair.PrivateKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("key type = %T, want ECDSA", pair.PrivateKey)
+	}
+	if time.Until(leaf.NotAfter) < DefaultRenewThreshold {
+		t.Errorf("placeholder expires %v, should not immediately need renewal", leaf.NotAfter)
+	}
+}
+
+func TestObtainCertificateDryRunStillValidatesProvider(t *testing.T) {
+	c := NewClient(t.TempDir(), false)
+	c.SetDryRun(true)
+	if _, err := c.ObtainCertificate("admin@example.com", []string{"example.com"}, "",
+		&DNSProviderConfig{Type: DNSProviderDigitalOcean}, nil); err == nil {
+		t.Error("expected provider creation error in dry run")
+	}
+}
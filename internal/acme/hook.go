@@ -0,0 +1,107 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+// PostIssueHook runs after a certificate has been issued and saved, e.g. to
+// reload HAProxy or send a notification. Either half may be left empty.
+type PostIssueHook struct {
+	// Command is run through `sh -c` with HORIZON_CERT_DOMAIN,
+	// HORIZON_CERT_DOMAINS (comma-separated SANs), HORIZON_CERT_NOT_AFTER
+	// (RFC 3339) and HORIZON_CERT_PATH in its environment.
+	Command string
+	// WebhookURL receives a JSON POST of the IssuedEvent.
+	WebhookURL string
+}
+
+// IssuedEvent describes a freshly issued certificate.
+type IssuedEvent struct {
+	Domain   string    `json:"domain"`
+	Domains  []string  `json:"domains"`
+	NotAfter time.Time `json:"not_after"`
+	CertPath string    `json:"cert_path"`
+}
+
+// NewIssuedEvent builds the event for res, reading the expiry from its leaf
+// certificate.
+func NewIssuedEvent(res *certificate.Resource, domains []string, certPath string) (IssuedEvent, error) {
+	ev := IssuedEvent{Domains: domains, CertPath: certPath}
+	if len(domains) > 0 {
+		ev.Domain = domains[0]
+	}
+	leaf, err := parseLeaf(res.Certificate)
+	if err != nil {
+		return ev, err
+	}
+	ev.NotAfter = leaf.NotAfter
+	return ev, nil
+}
+
+// Empty reports whether the hook has nothing to do.
+func (h PostIssueHook) Empty() bool {
+	return h.Command == "" && h.WebhookURL == ""
+}
+
+// Run executes the command and then the webhook. Both are attempted even if
+// the first fails; failures come back joined. A hook failure never affects
+// the certificate itself, which is already on disk.
+func (h PostIssueHook) Run(ctx context.Context, runner system.CommandRunner, client *http.Client, ev IssuedEvent) error {
+	var errs []error
+
+	if h.Command != "" {
+		opts := system.RunOpts{Env: []string{
+			"HORIZON_CERT_DOMAIN=" + ev.Domain,
+			"HORIZON_CERT_DOMAINS=" + strings.Join(ev.Domains, ","),
+			"HORIZON_CERT_NOT_AFTER=" + ev.NotAfter.UTC().Format(time.RFC3339),
+			"HORIZON_CERT_PATH=" + ev.CertPath,
+		}}
+		if out, err := runner.WithOpts(opts).Output(ctx, "sh", "-c", h.Command); err != nil {
+			errs = append(errs, fmt.Errorf("post-issue command: %w %s", err, strings.TrimSpace(string(out))))
+		}
+	}
+
+	if h.WebhookURL != "" {
+		if err := postWebhook(ctx, client, h.WebhookURL, ev); err != nil {
+			errs = append(errs, fmt.Errorf("post-issue webhook: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url string, ev IssuedEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+func TestNewIssuedEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fullchain.pem")
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	writeTestCert(t, path, notAfter)
+	data, _ := os.ReadFile(path)
+
+	ev, err := NewIssuedEvent(&certificate.Resource{Certificate: data}, []string{"*.example.com", "example.com"}, path)
+	if err != nil {
+		t.Fatalf("NewIssuedEvent() error = %v", err)
+	}
+	if ev.Domain != "*.example.com" || !ev.NotAfter.Equal(notAfter) || ev.CertPath != path {
+		t.Errorf("NewIssuedEvent() = %+v", ev)
+	}
+
+	if _, err := NewIssuedEvent(&certificate.Resource{Certificate: []byte("junk")}, nil, path); err == nil {
+		t.Error("Expected error for unparsable certificate")
+	}
+}
+
+func TestPostIssueHookRun(t *testing.T) {
+	ev := IssuedEvent{
+		Domain:   "example.com",
+		Domains:  []string{"example.com", "www.example.com"},
+		NotAfter: time.Date(2027, 1, 2, 3, 4, 5, 0, time.UTC),
+		CertPath: "/etc/letsencrypt/live/example.com/fullchain.pem",
+	}
+
+	var got IssuedEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	runner := system.NewDryRunCommandRunner()
+	hook := PostIssueHook{Command: "systemctl reload haproxy", WebhookURL: srv.URL}
+	if err := hook.Run(context.Background(), runner, srv.Client(), ev); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	cmds := runner.GetRunCommands()
+	if len(cmds) != 1 || cmds[0] != "sh -c systemctl reload haproxy" {
+		t.Fatalf("Expected hook command to run, got %v", cmds)
+	}
+	opts := runner.GetRunOpts()[0]
+	env := strings.Join(opts.Env, "\n")
+	for _, want := range []string{
+		"HORIZON_CERT_DOMAIN=example.com",
+		"HORIZON_CERT_DOMAINS=example.com,www.example.com",
+		"HORIZON_CERT_NOT_AFTER=2027-01-02T03:04:05Z",
+		"HORIZON_CERT_PATH=/etc/letsencrypt/live/example.com/fullchain.pem",
+	} {
+		if !strings.Contains(env, want) {
+			t.Errorf("hook env missing %s: %v", want, opts.Env)
+		}
+	}
+	if got.Domain != ev.Domain || !got.NotAfter.Equal(ev.NotAfter) || len(got.Domains) != 2 {
+		t.Errorf("webhook received %+v, want %+v", got, ev)
+	}
+}
+
+func TestPostIssueHookErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("sh -c false", errors.New("exit status 1"))
+
+	hook := PostIssueHook{Command: "false", WebhookURL: srv.URL}
+	err := hook.Run(context.Background(), runner, srv.Client(), IssuedEvent{Domain: "example.com"})
+	if err == nil {
+		t.Fatal("Expected hook errors")
+	}
+	if !strings.Contains(err.Error(), "post-issue command") || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected both failures reported, got %v", err)
+	}
+
+	if !(PostIssueHook{}).Empty() || hook.Empty() {
+		t.Error("Empty() mismatch")
+	}
+}
@@ -0,0 +1,73 @@
+package acme
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+)
+
+// ChallengeType selects how domain ownership is proved to the CA.
+type ChallengeType string
+
+const (
+	// ChallengeDNS01 publishes a TXT record through the DNS provider. It is
+	// the default and the only type that can issue wildcards.
+	ChallengeDNS01 ChallengeType = "dns-01"
+	// ChallengeHTTP01 serves the token over plain HTTP from a built-in
+	// server, for hosts whose port 80 is reachable from the internet and
+	// that have no DNS API credentials.
+	ChallengeHTTP01 ChallengeType = "http-01"
+)
+
+// DefaultHTTP01Address is where the HTTP-01 server listens when
+// HTTP01Address is empty. The CA always connects to port 80, which HAProxy
+// owns, so the token server stays on loopback and the generated HAProxy
+// config forwards /.well-known/acme-challenge/ to it.
+const DefaultHTTP01Address = "127.0.0.1:8402"
+
+// challenge returns the configured challenge type; nil or empty means DNS-01.
+func (cfg *DNSProviderConfig) challenge() ChallengeType {
+	if cfg == nil || cfg.Challenge == "" {
+		return ChallengeDNS01
+	}
+	return cfg.Challenge
+}
+
+// http01Address returns the listen address for the HTTP-01 server.
+func (cfg *DNSProviderConfig) http01Address() string {
+	if cfg.HTTP01Address == "" {
+		return DefaultHTTP01Address
+	}
+	return cfg.HTTP01Address
+}
+
+// validateHTTP01 checks the HTTP-01 settings of cfg.
+func (cfg *DNSProviderConfig) validateHTTP01() error {
+	if _, _, err := net.SplitHostPort(cfg.http01Address()); err != nil {
+		return fmt.Errorf("invalid HTTP01Address %q: %w", cfg.HTTP01Address, err)
+	}
+	return nil
+}
+
+// newHTTP01Provider returns lego's built-in token server for cfg. It only
+// listens while a challenge is being presented.
+func newHTTP01Provider(cfg *DNSProviderConfig) (*http01.ProviderServer, error) {
+	host, port, err := net.SplitHostPort(cfg.http01Address())
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP01Address %q: %w", cfg.HTTP01Address, err)
+	}
+	return http01.NewProviderServer(host, port), nil
+}
+
+// checkHTTP01Domains rejects wildcard SANs, which CAs only validate over
+// DNS-01.
+func checkHTTP01Domains(domains []string) error {
+	for _, d := range domains {
+		if strings.HasPrefix(d, "*.") {
+			return fmt.Errorf("wildcard domain %s requires the dns-01 challenge", d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package acme
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateHTTP01(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DNSProviderConfig
+		wantErr bool
+	}{
+		{"default address", DNSProviderConfig{Challenge: ChallengeHTTP01}, false},
+		{"custom port", DNSProviderConfig{Challenge: ChallengeHTTP01, HTTP01Address: "127.0.0.1:8402"}, false},
+		{"missing port", DNSProviderConfig{Challenge: ChallengeHTTP01, HTTP01Address: "127.0.0.1"}, true},
+		{"unknown challenge", DNSProviderConfig{Challenge: "tls-alpn-01", Type: DNSProviderCloudflare, CloudflareAPIToken: "t"}, true},
+		{"explicit dns-01", DNSProviderConfig{Challenge: ChallengeDNS01, Type: DNSProviderCloudflare, CloudflareAPIToken: "t"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestObtainCertificateHTTP01DryRun(t *testing.T) {
+	c := NewClient(t.TempDir(), false)
+	c.SetDryRun(true)
+
+	var logs []string
+	res, err := c.ObtainCertificate("admin@example.com", []string{"www.example.com", "example.com"}, "",
+		&DNSProviderConfig{Challenge: ChallengeHTTP01, HTTP01Address: ":8402"},
+		func(s string) { logs = append(logs, s) })
+	if err != nil {
+		t.Fatalf("ObtainCertificate() error = %v", err)
+	}
+	if len(res.Certificate) == 0 {
+		t.Error("expected a placeholder certificate")
+	}
+	if !slices.ContainsFunc(logs, func(s string) bool { return strings.Contains(s, "HTTP-01") && strings.Contains(s, ":8402") }) {
+		t.Errorf("expected an HTTP-01 log line naming the listen address, got %q", logs)
+	}
+	if slices.ContainsFunc(logs, func(s string) bool { return strings.Contains(s, "DNS provider") }) {
+		t.Errorf("HTTP-01 should not set up a DNS provider, got %q", logs)
+	}
+}
+
+func TestObtainCertificateHTTP01RejectsWildcard(t *testing.T) {
+	c := NewClient(t.TempDir(), false)
+	c.SetDryRun(true)
+
+	_, err := c.ObtainCertificate("admin@example.com", []string{"*.example.com"}, "",
+		&DNSProviderConfig{Challenge: ChallengeHTTP01}, nil)
+	if err == nil || !strings.Contains(err.Error(), "dns-01") {
+		t.Errorf("ObtainCertificate() error = %v, want wildcard rejection", err)
+	}
+}
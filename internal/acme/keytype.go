@@ -0,0 +1,40 @@
+package acme
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// KeyType selects the private key algorithm for issued certificates.
+type KeyType string
+
+const (
+	KeyTypeEC256   KeyType = "ec256"
+	KeyTypeEC384   KeyType = "ec384"
+	KeyTypeRSA2048 KeyType = "rsa2048"
+	KeyTypeRSA4096 KeyType = "rsa4096"
+)
+
+// DefaultKeyType is used when no key type is configured. P-256 is smaller
+// and faster than RSA and accepted by every modern client; pick RSA2048 for
+// older devices that don't speak ECDSA.
+const DefaultKeyType = KeyTypeEC256
+
+// legoKeyType maps k to lego's certcrypto value. Matching is
+// case-insensitive and "" means DefaultKeyType.
+func legoKeyType(k KeyType) (certcrypto.KeyType, error) {
+	switch KeyType(strings.ToLower(string(k))) {
+	case "", KeyTypeEC256:
+		return certcrypto.EC256, nil
+	case KeyTypeEC384:
+		return certcrypto.EC384, nil
+	case KeyTypeRSA2048:
+		return certcrypto.RSA2048, nil
+	case KeyTypeRSA4096:
+		return certcrypto.RSA4096, nil
+	default:
+		return "", fmt.Errorf("unknown key type %q (want ec256, ec384, rsa2048 or rsa4096)", k)
+	}
+}
@@ -0,0 +1,30 @@
+package acme
+
+import (
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+func TestLegoKeyType(t *testing.T) {
+	tests := []struct {
+		in   KeyType
+		want certcrypto.KeyType
+	}{
+		{"", certcrypto.EC256},
+		{KeyTypeEC256, certcrypto.EC256},
+		{KeyTypeEC384, certcrypto.EC384},
+		{"RSA2048", certcrypto.RSA2048},
+		{KeyTypeRSA4096, certcrypto.RSA4096},
+	}
+	for _, tt := range tests {
+		got, err := legoKeyType(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("legoKeyType(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	if _, err := legoKeyType("dsa1024"); err == nil {
+		t.Error("Expected error for unknown key type")
+	}
+}
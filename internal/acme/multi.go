@@ -0,0 +1,112 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+// ProviderRoute sends challenges for Suffix and every name under it to the
+// provider described by Config. An empty Suffix matches any domain.
+type ProviderRoute struct {
+	Suffix string
+	Config *DNSProviderConfig
+}
+
+// MultiProvider dispatches DNS-01 challenges to one of several providers by
+// domain suffix, for setups that split zones across DNS hosts. Routes are
+// tried in order and the first match wins, so list more specific suffixes
+// (and any catch-all "") accordingly.
+type MultiProvider struct {
+	routes []multiRoute
+}
+
+type multiRoute struct {
+	suffix   string
+	provider challenge.Provider
+}
+
+// NewMultiProvider creates a provider for each route. Each provider logs to
+// logFn as CreateChallengeProvider's would.
+func NewMultiProvider(routes []ProviderRoute, logFn func(string)) (*MultiProvider, error) {
+	return newMultiProvider(&system.RealFileSystem{}, routes, logFn)
+}
+
+// newMultiProvider is NewMultiProvider with "file:" credentials read
+// through fs.
+func newMultiProvider(fs system.FileSystem, routes []ProviderRoute, logFn func(string)) (*MultiProvider, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("multi provider needs at least one route")
+	}
+	p := &MultiProvider{}
+	for _, r := range routes {
+		provider, err := createChallengeProvider(fs, r.Config, logFn)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", r.Suffix, err)
+		}
+		p.routes = append(p.routes, multiRoute{suffix: normalizeSuffix(r.Suffix), provider: provider})
+	}
+	return p, nil
+}
+
+// normalizeSuffix lowercases a domain and drops a leading "*." or "." and a
+// trailing root dot, so "*.Example.com." and "example.com" compare equal.
+func normalizeSuffix(s string) string {
+	s = strings.ToLower(strings.TrimSuffix(s, "."))
+	s = strings.TrimPrefix(s, "*.")
+	return strings.TrimPrefix(s, ".")
+}
+
+// route returns the provider for domain. Suffixes match on label
+// boundaries: "example.com" covers "vpn.example.com" but not
+// "badexample.com".
+func (p *MultiProvider) route(domain string) (challenge.Provider, error) {
+	d := normalizeSuffix(domain)
+	for _, r := range p.routes {
+		if r.suffix == "" || d == r.suffix || strings.HasSuffix(d, "."+r.suffix) {
+			return r.provider, nil
+		}
+	}
+	return nil, fmt.Errorf("no DNS provider route matches %s", domain)
+}
+
+func (p *MultiProvider) Present(domain, token, keyAuth string) error {
+	provider, err := p.route(domain)
+	if err != nil {
+		return err
+	}
+	return provider.Present(domain, token, keyAuth)
+}
+
+func (p *MultiProvider) CleanUp(domain, token, keyAuth string) error {
+	provider, err := p.route(domain)
+	if err != nil {
+		return err
+	}
+	return provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout is the most patient of the routed providers: lego waits once for
+// all records, so the slowest provider's timeout and the quickest polling
+// interval apply. Providers without their own values count as the lego
+// defaults LoggingProvider uses.
+func (p *MultiProvider) Timeout() (timeout, interval time.Duration) {
+	for i, r := range p.routes {
+		t, iv := 2*time.Minute, 5*time.Second
+		if pt, ok := r.provider.(challenge.ProviderTimeout); ok {
+			t, iv = pt.Timeout()
+		}
+		if i == 0 || t > timeout {
+			timeout = t
+		}
+		if i == 0 || iv < interval {
+			interval = iv
+		}
+	}
+	return timeout, interval
+}
@@ -0,0 +1,79 @@
+package acme
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultiProviderRouting(t *testing.T) {
+	route53 := &recordingProvider{}
+	cloudflare := &recordingProvider{}
+	p := &MultiProvider{routes: []multiRoute{
+		{suffix: "vpn.example.com", provider: cloudflare},
+		{suffix: "example.com", provider: route53},
+	}}
+
+	for _, d := range []string{"example.com", "www.example.com", "*.example.com"} {
+		if err := p.Present(d, "t", "k"); err != nil {
+			t.Errorf("Present(%q) error = %v", d, err)
+		}
+	}
+	for _, d := range []string{"vpn.example.com", "a.vpn.example.com", "VPN.Example.com."} {
+		if err := p.Present(d, "t", "k"); err != nil {
+			t.Errorf("Present(%q) error = %v", d, err)
+		}
+	}
+	if len(route53.presented) != 3 || len(cloudflare.presented) != 3 {
+		t.Errorf("route53 got %q, cloudflare got %q; want 3 each", route53.presented, cloudflare.presented)
+	}
+
+	for _, d := range []string{"badexample.com", "example.org"} {
+		if err := p.Present(d, "t", "k"); err == nil || !strings.Contains(err.Error(), "no DNS provider route") {
+			t.Errorf("Present(%q) error = %v, want no-route error", d, err)
+		}
+		if err := p.CleanUp(d, "t", "k"); err == nil {
+			t.Errorf("CleanUp(%q) should fail without a matching route", d)
+		}
+	}
+
+	catchAll := &recordingProvider{}
+	p.routes = append(p.routes, multiRoute{suffix: "", provider: catchAll})
+	if err := p.Present("example.org", "t", "k"); err != nil || len(catchAll.presented) != 1 {
+		t.Errorf("catch-all route: err = %v, presented %q", err, catchAll.presented)
+	}
+}
+
+func TestNewMultiProvider(t *testing.T) {
+	p, err := NewMultiProvider([]ProviderRoute{
+		{Suffix: "*.example.com", Config: &DNSProviderConfig{Type: DNSProviderCloudflare, CloudflareAPIToken: "cf-token"}},
+		{Suffix: "example.org", Config: &DNSProviderConfig{Type: DNSProviderDigitalOcean, DigitalOceanAuthToken: "do-token"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewMultiProvider() error = %v", err)
+	}
+	if len(p.routes) != 2 || p.routes[0].suffix != "example.com" {
+		t.Errorf("routes = %+v", p.routes)
+	}
+
+	if _, err := NewMultiProvider(nil, nil); err == nil {
+		t.Error("expected error for no routes")
+	}
+	_, err = NewMultiProvider([]ProviderRoute{
+		{Suffix: "example.com", Config: &DNSProviderConfig{Type: DNSProviderCloudflare}},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), `route "example.com"`) {
+		t.Errorf("invalid route config: err = %v, want it to name the route", err)
+	}
+}
+
+func TestMultiProviderTimeout(t *testing.T) {
+	p := &MultiProvider{routes: []multiRoute{
+		{suffix: "example.com", provider: &recordingProvider{}},
+		{suffix: "example.org", provider: &timeoutProvider{}},
+	}}
+	timeout, interval := p.Timeout()
+	if timeout != 5*time.Minute || interval != 5*time.Second {
+		t.Errorf("Timeout() = %v, %v; want 5m, 5s", timeout, interval)
+	}
+}
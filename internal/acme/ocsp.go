@@ -0,0 +1,95 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPStatus is the revocation state a responder reports for a certificate.
+type OCSPStatus string
+
+const (
+	OCSPGood    OCSPStatus = "good"
+	OCSPRevoked OCSPStatus = "revoked"
+	OCSPUnknown OCSPStatus = "unknown"
+)
+
+// maxOCSPResponse caps how much of a responder's reply is read. Real
+// responses are a few KB.
+const maxOCSPResponse = 1 << 20
+
+// OCSPResult is a verified OCSP answer. NextUpdate is zero when the
+// responder did not set it, meaning newer information is always available.
+type OCSPResult struct {
+	Status     OCSPStatus
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	// Raw is the DER response, suitable for stapling.
+	Raw []byte
+}
+
+// CheckOCSP asks the responder named in leaf's Authority Information Access
+// extension for leaf's status. The response signature is checked against
+// issuer. A nil client uses http.DefaultClient.
+func CheckOCSP(ctx context.Context, client *http.Client, leaf, issuer *x509.Certificate) (*OCSPResult, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, fmt.Errorf("certificate has no OCSP responder URL")
+	}
+	responder := leaf.OCSPServer[0]
+
+	reqDER, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building OCSP request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", responder, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponse))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP response: %w", err)
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OCSP response: %w", err)
+	}
+
+	res := &OCSPResult{
+		ThisUpdate: parsed.ThisUpdate,
+		NextUpdate: parsed.NextUpdate,
+		Raw:        body,
+	}
+	switch parsed.Status {
+	case ocsp.Good:
+		res.Status = OCSPGood
+	case ocsp.Revoked:
+		res.Status = OCSPRevoked
+		res.RevokedAt = parsed.RevokedAt
+	default:
+		res.Status = OCSPUnknown
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspFixture is a CA and a leaf it issued whose AIA points at url.
+type ocspFixture struct {
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	leaf  *x509.Certificate
+}
+
+func newOCSPFixture(t *testing.T, url string) *ocspFixture {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{url},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	return &ocspFixture{ca: ca, caKey: caKey, leaf: leaf}
+}
+
+func TestCheckOCSP(t *testing.T) {
+	nextUpdate := time.Now().Add(12 * time.Hour).UTC().Truncate(time.Second)
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+
+	for _, tc := range []struct {
+		status int
+		want   OCSPStatus
+	}{
+		{ocsp.Good, OCSPGood},
+		{ocsp.Revoked, OCSPRevoked},
+		{ocsp.Unknown, OCSPUnknown},
+	} {
+		var fx *ocspFixture
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ct := r.Header.Get("Content-Type"); ct != "application/ocsp-request" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			body, _ := io.ReadAll(r.Body)
+			req, err := ocsp.ParseRequest(body)
+			if err != nil {
+				t.Errorf("ParseRequest: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if req.SerialNumber.Cmp(fx.leaf.SerialNumber) != 0 {
+				t.Errorf("serial = %v, want %v", req.SerialNumber, fx.leaf.SerialNumber)
+			}
+			resp, err := ocsp.CreateResponse(fx.ca, fx.ca, ocsp.Response{
+				Status:       tc.status,
+				SerialNumber: req.SerialNumber,
+				ThisUpdate:   time.Now().Add(-time.Hour),
+				NextUpdate:   nextUpdate,
+				RevokedAt:    revokedAt,
+			}, fx.caKey)
+			if err != nil {
+				t.Errorf("CreateResponse: %v", err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(resp)
+		}))
+		fx = newOCSPFixture(t, srv.URL)
+
+		res, err := CheckOCSP(context.Background(), srv.Client(), fx.leaf, fx.ca)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%s: CheckOCSP: %v", tc.want, err)
+		}
+		if res.Status != tc.want {
+			t.Errorf("Status = %q, want %q", res.Status, tc.want)
+		}
+		if !res.NextUpdate.Equal(nextUpdate) {
+			t.Errorf("%s: NextUpdate = %v, want %v", tc.want, res.NextUpdate, nextUpdate)
+		}
+		if tc.want == OCSPRevoked && !res.RevokedAt.Equal(revokedAt) {
+			t.Errorf("RevokedAt = %v, want %v", res.RevokedAt, revokedAt)
+		}
+		if len(res.Raw) == 0 {
+			t.Errorf("%s: Raw is empty", tc.want)
+		}
+	}
+}
+
+func TestCheckOCSPErrors(t *testing.T) {
+	fx := newOCSPFixture(t, "")
+	fx.leaf.OCSPServer = nil
+	if _, err := CheckOCSP(context.Background(), nil, fx.leaf, fx.ca); err == nil {
+		t.Error("expected error for certificate without a responder URL")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	fx = newOCSPFixture(t, srv.URL)
+	if _, err := CheckOCSP(context.Background(), srv.Client(), fx.leaf, fx.ca); err == nil {
+		t.Error("expected error for non-200 responder")
+	}
+
+	// A response signed by someone other than the issuer must be rejected.
+	other := newOCSPFixture(t, srv.URL)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, _ := ocsp.CreateResponse(other.ca, other.ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: fx.leaf.SerialNumber,
+			ThisUpdate:   time.Now(),
+		}, other.caKey)
+		w.Write(resp)
+	}))
+	defer bad.Close()
+	fx.leaf.OCSPServer = []string{bad.URL}
+	if _, err := CheckOCSP(context.Background(), bad.Client(), fx.leaf, fx.ca); err == nil {
+		t.Error("expected error for response signed by the wrong issuer")
+	}
+}
@@ -1,26 +1,64 @@
 package acme
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awsroute53 "github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/digitalocean"
+	"github.com/go-acme/lego/v4/providers/dns/gcloud"
 	"github.com/go-acme/lego/v4/providers/dns/namedotcom"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
 	"github.com/go-acme/lego/v4/providers/dns/route53"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	gdns "google.golang.org/api/dns/v1"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // LoggingProvider wraps a DNS provider to add logging
 type LoggingProvider struct {
 	provider challenge.Provider
 	logFn    func(string)
+
+	// PropagationTimeout and PollingInterval override how long lego waits
+	// for the TXT record to propagate. Zero defers to the wrapped provider.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+
+	mu        sync.Mutex
+	presented map[string]int // TXT values staged per record name
 }
 
 func (p *LoggingProvider) Present(domain, token, keyAuth string) error {
-	// Extract the challenge record name from the domain
+	// Extract the challenge record name from the domain. Lego passes a
+	// wildcard's base domain, so "*.example.com" and "example.com" land on
+	// the same record.
 	fqdn := fmt.Sprintf("_acme-challenge.%s", domain)
-	p.logFn(fmt.Sprintf("  Creating DNS TXT record: %s", fqdn))
+	p.mu.Lock()
+	if p.presented == nil {
+		p.presented = make(map[string]int)
+	}
+	p.presented[fqdn]++
+	n := p.presented[fqdn]
+	p.mu.Unlock()
+
+	if n > 1 {
+		p.logFn(fmt.Sprintf("  Creating DNS TXT record: %s (value %d — wildcard and apex share this name)", fqdn, n))
+	} else {
+		p.logFn(fmt.Sprintf("  Creating DNS TXT record: %s", fqdn))
+	}
 
 	start := time.Now()
 	err := p.provider.Present(domain, token, keyAuth)
@@ -57,36 +95,65 @@ func (p *LoggingProvider) CleanUp(domain, token, keyAuth string) error {
 	return nil
 }
 
-// Timeout returns the timeout and interval for DNS propagation checks
+// Timeout returns the timeout and interval for DNS propagation checks.
+// Explicit overrides win, then the wrapped provider's own values, then a
+// default of 2 minutes with 5 second intervals; each value falls back
+// independently.
 func (p *LoggingProvider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = 2*time.Minute, 5*time.Second
 	// Check if underlying provider has custom timeout
 	if t, ok := p.provider.(interface {
 		Timeout() (time.Duration, time.Duration)
 	}); ok {
-		return t.Timeout()
+		timeout, interval = t.Timeout()
+	}
+	if p.PropagationTimeout > 0 {
+		timeout = p.PropagationTimeout
 	}
-	// Default timeout of 2 minutes with 5 second intervals
-	return 2 * time.Minute, 5 * time.Second
+	if p.PollingInterval > 0 {
+		interval = p.PollingInterval
+	}
+	return timeout, interval
 }
 
-// wrapWithLogging wraps a provider with logging
-func wrapWithLogging(provider challenge.Provider, logFn func(string)) challenge.Provider {
+// NewLoggingProvider wraps provider so each record change is reported to
+// logFn (which may be nil).
+func NewLoggingProvider(provider challenge.Provider, logFn func(string)) *LoggingProvider {
 	if logFn == nil {
-		return provider
+		logFn = func(string) {}
 	}
 	return &LoggingProvider{provider: provider, logFn: logFn}
 }
 
+// wrapWithLogging wraps a provider with logging and any propagation
+// overrides from cfg. The provider is returned as-is when there is nothing
+// to add.
+func wrapWithLogging(provider challenge.Provider, cfg *DNSProviderConfig, logFn func(string)) challenge.Provider {
+	if logFn == nil && cfg.PropagationTimeout == 0 && cfg.PollingInterval == 0 {
+		return provider
+	}
+	lp := NewLoggingProvider(provider, logFn)
+	lp.PropagationTimeout = cfg.PropagationTimeout
+	lp.PollingInterval = cfg.PollingInterval
+	return lp
+}
+
 // DNSProviderType identifies the DNS provider for ACME challenges
 type DNSProviderType string
 
 const (
-	DNSProviderRoute53    DNSProviderType = "route53"
-	DNSProviderNamecom    DNSProviderType = "namecom"
-	DNSProviderCloudflare DNSProviderType = "cloudflare"
+	DNSProviderRoute53      DNSProviderType = "route53"
+	DNSProviderNamecom      DNSProviderType = "namecom"
+	DNSProviderCloudflare   DNSProviderType = "cloudflare"
+	DNSProviderDigitalOcean DNSProviderType = "digitalocean"
+	DNSProviderGCloud       DNSProviderType = "googlecloud"
+	DNSProviderRFC2136      DNSProviderType = "rfc2136"
 )
 
-// DNSProviderConfig holds provider-specific credentials for ACME challenges
+// DNSProviderConfig holds provider-specific credentials for ACME challenges.
+// Any credential field may instead hold a "file:/path/to/secret" reference;
+// the file is read and trimmed when the provider is created, so secrets can
+// be mounted (Docker/Kubernetes) rather than embedded in the config.
 type DNSProviderConfig struct {
 	Type DNSProviderType
 
@@ -104,16 +171,172 @@ type DNSProviderConfig struct {
 	// Cloudflare
 	CloudflareAPIToken string
 	CloudflareZoneID   string
+	// CloudflareZoneToken is an optional second token with Zone:Read, for
+	// setups where CloudflareAPIToken is scoped to DNS:Edit only.
+	CloudflareZoneToken string
+
+	// DigitalOcean
+	DigitalOceanAuthToken string
+
+	// Google Cloud DNS. GCloudServiceAccountFile is the path to a
+	// service-account JSON key; inline key JSON is accepted too. Without it,
+	// Application Default Credentials are used for GCloudProject.
+	GCloudProject            string
+	GCloudServiceAccountFile string
+
+	// RFC2136 dynamic updates (BIND, PowerDNS, Knot...). Nameserver is
+	// "host" or "host:port"; the TSIG fields are optional but go together.
+	// An empty algorithm defaults to hmac-sha1.
+	RFC2136Nameserver    string
+	RFC2136TSIGKey       string
+	RFC2136TSIGSecret    string
+	RFC2136TSIGAlgorithm string
+
+	// Challenge selects DNS-01 (default) or HTTP-01. With HTTP-01 the fields
+	// above are unused and Type may be empty; HTTP01Address is the
+	// "host:port" the token server listens on (default DefaultHTTP01Address).
+	Challenge     ChallengeType
+	HTTP01Address string
+
+	// Optional DNS propagation overrides; zero keeps the provider's default.
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+
+	// PropagationBackoff, when set, polls propagation with exponential
+	// backoff and jitter instead of lego's fixed PollingInterval, and its
+	// limits replace PropagationTimeout.
+	PropagationBackoff *Backoff
+}
+
+// Validate checks that the fields the selected Type needs are set, so a
+// missing credential is reported by name instead of as whatever lego makes
+// of an empty string.
+func (cfg *DNSProviderConfig) Validate() error {
+	switch cfg.challenge() {
+	case ChallengeDNS01:
+	case ChallengeHTTP01:
+		return cfg.validateHTTP01()
+	default:
+		return fmt.Errorf("unknown ACME challenge type: %s", cfg.Challenge)
+	}
+
+	if cfg.PropagationBackoff != nil {
+		if err := cfg.PropagationBackoff.Validate(); err != nil {
+			return fmt.Errorf("propagation backoff: %w", err)
+		}
+	}
+
+	switch cfg.Type {
+	case DNSProviderRoute53:
+		// Either a shared-config profile or a full static key pair.
+		if cfg.AWSProfile != "" {
+			return nil
+		}
+		if cfg.AWSAccessKeyID == "" && cfg.AWSSecretAccessKey == "" {
+			return errors.New("route53 requires AWSProfile or AWSAccessKeyID + AWSSecretAccessKey")
+		}
+		if cfg.AWSAccessKeyID == "" {
+			return errors.New("route53 requires AWSAccessKeyID when AWSSecretAccessKey is set")
+		}
+		if cfg.AWSSecretAccessKey == "" {
+			return errors.New("route53 requires AWSSecretAccessKey when AWSAccessKeyID is set")
+		}
+	case DNSProviderNamecom:
+		if cfg.NamecomUsername == "" {
+			return errors.New("namecom requires NamecomUsername")
+		}
+		if cfg.NamecomAPIToken == "" {
+			return errors.New("namecom requires NamecomAPIToken")
+		}
+	case DNSProviderCloudflare:
+		if cfg.CloudflareAPIToken == "" {
+			return errors.New("cloudflare requires CloudflareAPIToken")
+		}
+	case DNSProviderDigitalOcean:
+		if cfg.DigitalOceanAuthToken == "" {
+			return errors.New("digitalocean requires DigitalOceanAuthToken")
+		}
+	case DNSProviderGCloud:
+		if cfg.GCloudProject == "" && cfg.GCloudServiceAccountFile == "" {
+			return errors.New("googlecloud requires GCloudProject or GCloudServiceAccountFile")
+		}
+	case DNSProviderRFC2136:
+		if cfg.RFC2136Nameserver == "" {
+			return errors.New("rfc2136 requires RFC2136Nameserver")
+		}
+		if cfg.RFC2136TSIGKey != "" && cfg.RFC2136TSIGSecret == "" {
+			return errors.New("rfc2136 requires RFC2136TSIGSecret when RFC2136TSIGKey is set")
+		}
+		if cfg.RFC2136TSIGSecret != "" && cfg.RFC2136TSIGKey == "" {
+			return errors.New("rfc2136 requires RFC2136TSIGKey when RFC2136TSIGSecret is set")
+		}
+	default:
+		return fmt.Errorf("unknown dns provider type for ACME: %s", cfg.Type)
+	}
+	return nil
 }
 
-// CreateChallengeProvider creates a Lego DNS challenge provider from configuration
+// secretFilePrefix marks a credential value as a path to read it from.
+const secretFilePrefix = "file:"
+
+// credentials returns pointers to every credential field, for resolveSecrets.
+func (cfg *DNSProviderConfig) credentials() []*string {
+	return []*string{
+		&cfg.AWSAccessKeyID,
+		&cfg.AWSSecretAccessKey,
+		&cfg.NamecomUsername,
+		&cfg.NamecomAPIToken,
+		&cfg.CloudflareAPIToken,
+		&cfg.CloudflareZoneToken,
+		&cfg.DigitalOceanAuthToken,
+		&cfg.RFC2136TSIGSecret,
+	}
+}
+
+// resolveSecrets returns a copy of cfg with every "file:" credential
+// replaced by the trimmed contents of the file. Other values pass through
+// unchanged. Errors name the file but never its contents.
+func (cfg *DNSProviderConfig) resolveSecrets(fs system.FileSystem) (*DNSProviderConfig, error) {
+	resolved := *cfg
+	for _, field := range resolved.credentials() {
+		path, ok := strings.CutPrefix(*field, secretFilePrefix)
+		if !ok {
+			continue
+		}
+		if path == "" {
+			return nil, fmt.Errorf("empty %s secret reference", secretFilePrefix)
+		}
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read secret file: %w", err)
+		}
+		*field = strings.TrimSpace(string(data))
+	}
+	return &resolved, nil
+}
+
+// CreateChallengeProvider creates a Lego DNS challenge provider from
+// configuration, reading any "file:" credentials from disk.
 func CreateChallengeProvider(cfg *DNSProviderConfig, logFn func(string)) (challenge.Provider, error) {
+	return createChallengeProvider(&system.RealFileSystem{}, cfg, logFn)
+}
+
+// createChallengeProvider is CreateChallengeProvider with "file:"
+// credentials read through fs.
+func createChallengeProvider(fs system.FileSystem, cfg *DNSProviderConfig, logFn func(string)) (challenge.Provider, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("dns provider config is nil")
 	}
+	cfg, err := cfg.resolveSecrets(fs)
+	if err != nil {
+		return nil, err
+	}
+	// Validate after resolving so an empty secret file counts as missing.
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dns provider config: %w", err)
+	}
 
 	var provider challenge.Provider
-	var err error
 
 	switch cfg.Type {
 	case DNSProviderRoute53:
@@ -122,6 +345,12 @@ func CreateChallengeProvider(cfg *DNSProviderConfig, logFn func(string)) (challe
 		provider, err = createNamecomProvider(cfg)
 	case DNSProviderCloudflare:
 		provider, err = createCloudflareProvider(cfg)
+	case DNSProviderDigitalOcean:
+		provider, err = createDigitalOceanProvider(cfg)
+	case DNSProviderGCloud:
+		provider, err = createGCloudProvider(fs, cfg)
+	case DNSProviderRFC2136:
+		provider, err = createRFC2136Provider(cfg)
 	default:
 		return nil, fmt.Errorf("unknown dns provider type for ACME: %s", cfg.Type)
 	}
@@ -131,41 +360,25 @@ func CreateChallengeProvider(cfg *DNSProviderConfig, logFn func(string)) (challe
 	}
 
 	// Wrap with logging if logFn provided
-	return wrapWithLogging(provider, logFn), nil
+	return wrapWithLogging(provider, cfg, logFn), nil
 }
 
 // createRoute53Provider creates a Lego Route53 provider.
 //
-// Credentials, profile, and region flow through the AWS default credential chain
-// via environment variables. These are process-global, but they are identical
-// across every zone in a single sync, so setting them repeatedly is race-free
-// even when several certificates are obtained concurrently (the same value is
-// written every time).
+// Everything — static credentials, region, and the per-zone HostedZoneID — is
+// passed on the provider Config rather than through AWS_* environment
+// variables. The environment is process-global, so two concurrent obtains for
+// different zones would otherwise clobber each other and could write
+// challenge records to the wrong hosted zone; it also keeps secrets out of
+// the environment of every child process we spawn.
 //
-// The per-zone HostedZoneID is the ONE value that differs between zones, so it is
-// passed on the provider Config below rather than via a shared AWS_HOSTED_ZONE_ID
-// env var. If it went through the environment, two concurrent obtains would clobber
-// each other's zone and could write challenge records to the wrong hosted zone.
+// Lego's Config has no profile field, so when a profile is configured we load
+// the shared AWS config ourselves and hand lego a ready-made client.
 func createRoute53Provider(cfg *DNSProviderConfig) (challenge.Provider, error) {
-	if cfg.AWSAccessKeyID != "" {
-		if err := os.Setenv("AWS_ACCESS_KEY_ID", cfg.AWSAccessKeyID); err != nil {
-			return nil, fmt.Errorf("setenv AWS_ACCESS_KEY_ID: %w", err)
-		}
-	}
-	if cfg.AWSSecretAccessKey != "" {
-		if err := os.Setenv("AWS_SECRET_ACCESS_KEY", cfg.AWSSecretAccessKey); err != nil {
-			return nil, fmt.Errorf("setenv AWS_SECRET_ACCESS_KEY: %w", err)
-		}
-	}
-	if cfg.AWSProfile != "" {
-		if err := os.Setenv("AWS_PROFILE", cfg.AWSProfile); err != nil {
-			return nil, fmt.Errorf("setenv AWS_PROFILE: %w", err)
-		}
-	}
-
 	rcfg := route53.NewDefaultConfig()
-	// Pin the hosted zone per provider (not via env) so parallel obtains don't race.
-	// Setting it also skips lego's ListHostedZonesByName lookup at challenge time.
+	rcfg.AccessKeyID = cfg.AWSAccessKeyID
+	rcfg.SecretAccessKey = cfg.AWSSecretAccessKey
+	// Setting the zone also skips lego's ListHostedZonesByName lookup at challenge time.
 	if cfg.AWSHostedZoneID != "" {
 		rcfg.HostedZoneID = cfg.AWSHostedZoneID
 	}
@@ -176,6 +389,14 @@ func createRoute53Provider(cfg *DNSProviderConfig) (challenge.Provider, error) {
 	rcfg.PropagationTimeout = 5 * time.Minute
 	rcfg.PollingInterval = 10 * time.Second
 
+	if cfg.AWSProfile != "" {
+		client, err := route53ProfileClient(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create route53 provider: %w", err)
+		}
+		rcfg.Client = client
+	}
+
 	provider, err := route53.NewDNSProviderConfig(rcfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create route53 provider: %w", err)
@@ -184,21 +405,35 @@ func createRoute53Provider(cfg *DNSProviderConfig) (challenge.Provider, error) {
 	return provider, nil
 }
 
-// createNamecomProvider creates a Lego Name.com provider
-func createNamecomProvider(cfg *DNSProviderConfig) (challenge.Provider, error) {
-	// Set environment variables for lego's namedotcom provider
-	if cfg.NamecomUsername != "" {
-		if err := os.Setenv("NAMECOM_USERNAME", cfg.NamecomUsername); err != nil {
-			return nil, fmt.Errorf("setenv NAMECOM_USERNAME: %w", err)
-		}
+// route53ProfileClient builds a Route53 client from a named shared-config
+// profile, layering any explicit region and static keys on top.
+func route53ProfileClient(cfg *DNSProviderConfig) (*awsroute53.Client, error) {
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithSharedConfigProfile(cfg.AWSProfile),
 	}
-	if cfg.NamecomAPIToken != "" {
-		if err := os.Setenv("NAMECOM_API_TOKEN", cfg.NamecomAPIToken); err != nil {
-			return nil, fmt.Errorf("setenv NAMECOM_API_TOKEN: %w", err)
-		}
+	if cfg.AWSRegion != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.AWSRegion))
+	}
+	if cfg.AWSAccessKeyID != "" && cfg.AWSSecretAccessKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, ""),
+		))
 	}
 
-	provider, err := namedotcom.NewDNSProvider()
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config for profile %s: %w", cfg.AWSProfile, err)
+	}
+	return awsroute53.NewFromConfig(awsCfg), nil
+}
+
+// createNamecomProvider creates a Lego Name.com provider
+func createNamecomProvider(cfg *DNSProviderConfig) (challenge.Provider, error) {
+	ncfg := namedotcom.NewDefaultConfig()
+	ncfg.Username = cfg.NamecomUsername
+	ncfg.APIToken = cfg.NamecomAPIToken
+
+	provider, err := namedotcom.NewDNSProviderConfig(ncfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create namecom provider: %w", err)
 	}
@@ -206,23 +441,107 @@ func createNamecomProvider(cfg *DNSProviderConfig) (challenge.Provider, error) {
 	return provider, nil
 }
 
-// createCloudflareProvider creates a Lego Cloudflare provider
+// createCloudflareProvider creates a Lego Cloudflare provider.
+// CloudflareZoneID identifies a zone, not a credential, so it is never used
+// as a token. Without a CloudflareZoneToken lego uses the DNS token for zone
+// lookups as well.
 func createCloudflareProvider(cfg *DNSProviderConfig) (challenge.Provider, error) {
-	// Set environment variables for lego's cloudflare provider
-	if cfg.CloudflareAPIToken != "" {
-		if err := os.Setenv("CF_DNS_API_TOKEN", cfg.CloudflareAPIToken); err != nil {
-			return nil, fmt.Errorf("setenv CF_DNS_API_TOKEN: %w", err)
+	ccfg := cloudflare.NewDefaultConfig()
+	ccfg.AuthToken = cfg.CloudflareAPIToken
+	ccfg.ZoneToken = cfg.CloudflareZoneToken
+
+	provider, err := cloudflare.NewDNSProviderConfig(ccfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloudflare provider: %w", err)
+	}
+
+	return provider, nil
+}
+
+// createDigitalOceanProvider creates a Lego DigitalOcean provider
+func createDigitalOceanProvider(cfg *DNSProviderConfig) (challenge.Provider, error) {
+	dcfg := digitalocean.NewDefaultConfig()
+	dcfg.AuthToken = cfg.DigitalOceanAuthToken
+
+	provider, err := digitalocean.NewDNSProviderConfig(dcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create digitalocean provider: %w", err)
+	}
+
+	return provider, nil
+}
+
+// createGCloudProvider creates a Lego Google Cloud DNS provider. The key
+// file is read through fs and the project comes from GCloudProject, else
+// from the key's project_id; neither goes through lego's GCE_* environment
+// variables, for the same reason as Route53.
+func createGCloudProvider(fs system.FileSystem, cfg *DNSProviderConfig) (challenge.Provider, error) {
+	gcfg := gcloud.NewDefaultConfig()
+	gcfg.Project = cfg.GCloudProject
+
+	var err error
+	if cfg.GCloudServiceAccountFile != "" {
+		gcfg.HTTPClient, gcfg.Project, err = gcloudServiceAccountClient(fs, cfg)
+	} else {
+		gcfg.HTTPClient, err = google.DefaultClient(context.Background(), gdns.NdevClouddnsReadwriteScope)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create googlecloud provider: %w", err)
+	}
+
+	provider, err := gcloud.NewDNSProviderConfig(gcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create googlecloud provider: %w", err)
+	}
+
+	return provider, nil
+}
+
+// gcloudServiceAccountClient builds an HTTP client authenticated with the
+// configured service-account key and returns the project to use with it.
+func gcloudServiceAccountClient(fs system.FileSystem, cfg *DNSProviderConfig) (*http.Client, string, error) {
+	key := []byte(strings.TrimSpace(cfg.GCloudServiceAccountFile))
+	if !strings.HasPrefix(string(key), "{") {
+		data, err := fs.ReadFile(cfg.GCloudServiceAccountFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("read service account file: %w", err)
 		}
+		key = data
 	}
-	if cfg.CloudflareZoneID != "" {
-		if err := os.Setenv("CF_ZONE_API_TOKEN", cfg.CloudflareAPIToken); err != nil { // Same token for zone API
-			return nil, fmt.Errorf("setenv CF_ZONE_API_TOKEN: %w", err)
+
+	project := cfg.GCloudProject
+	if project == "" {
+		var sa struct {
+			ProjectID string `json:"project_id"`
+		}
+		if err := json.Unmarshal(key, &sa); err != nil || sa.ProjectID == "" {
+			return nil, "", errors.New("no GCloudProject set and no project_id in service account key")
 		}
+		project = sa.ProjectID
 	}
 
-	provider, err := cloudflare.NewDNSProvider()
+	ctx := context.Background()
+	creds, err := google.CredentialsFromJSONWithType(ctx, key, google.ServiceAccount, gdns.NdevClouddnsReadwriteScope)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cloudflare provider: %w", err)
+		return nil, "", fmt.Errorf("parse service account key: %w", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), project, nil
+}
+
+// createRFC2136Provider creates a Lego RFC2136 (dynamic update) provider.
+// Without TSIG fields, updates are sent unsigned.
+func createRFC2136Provider(cfg *DNSProviderConfig) (challenge.Provider, error) {
+	rcfg := rfc2136.NewDefaultConfig()
+	rcfg.Nameserver = cfg.RFC2136Nameserver
+	rcfg.TSIGKey = cfg.RFC2136TSIGKey
+	rcfg.TSIGSecret = cfg.RFC2136TSIGSecret
+	if cfg.RFC2136TSIGAlgorithm != "" {
+		rcfg.TSIGAlgorithm = cfg.RFC2136TSIGAlgorithm
+	}
+
+	provider, err := rfc2136.NewDNSProviderConfig(rcfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rfc2136 provider: %w", err)
 	}
 
 	return provider, nil
@@ -0,0 +1,61 @@
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+// DefaultRenewThreshold is how long before expiry ShouldRenew starts
+// reporting true when no threshold is given. Let's Encrypt certs last 90
+// days, so this leaves a month of retries.
+const DefaultRenewThreshold = 30 * 24 * time.Hour
+
+// ShouldRenew reports whether the leaf certificate in certPath expires
+// within threshold (DefaultRenewThreshold if threshold <= 0). A missing file
+// needs initial issuance and so also returns true. Unreadable or malformed
+// certificates return an error.
+func ShouldRenew(certPath string, threshold time.Duration) (bool, error) {
+	return ShouldRenewFS(&system.RealFileSystem{}, certPath, threshold)
+}
+
+// ShouldRenewFS is ShouldRenew reading certPath through fsys.
+func ShouldRenewFS(fsys system.FileSystem, certPath string, threshold time.Duration) (bool, error) {
+	if threshold <= 0 {
+		threshold = DefaultRenewThreshold
+	}
+
+	data, err := fsys.ReadFile(certPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading certificate: %w", err)
+	}
+
+	leaf, err := parseLeaf(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", certPath, err)
+	}
+	return time.Until(leaf.NotAfter) < threshold, nil
+}
+
+// parseLeaf returns the first certificate in a PEM bundle, which for a
+// fullchain file is the leaf.
+func parseLeaf(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
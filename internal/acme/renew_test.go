@@ -0,0 +1,92 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+func writeTestCert(t *testing.T, path string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShouldRenew(t *testing.T) {
+	dir := t.TempDir()
+
+	renew, err := ShouldRenew(filepath.Join(dir, "missing.pem"), 0)
+	if err != nil || !renew {
+		t.Errorf("missing cert: ShouldRenew() = %v, %v; want true, nil", renew, err)
+	}
+
+	soon := filepath.Join(dir, "soon.pem")
+	writeTestCert(t, soon, time.Now().Add(10*24*time.Hour))
+	if renew, err := ShouldRenew(soon, 0); err != nil || !renew {
+		t.Errorf("expiring in 10d with default threshold: got %v, %v; want true", renew, err)
+	}
+	if renew, err := ShouldRenew(soon, 5*24*time.Hour); err != nil || renew {
+		t.Errorf("expiring in 10d with 5d threshold: got %v, %v; want false", renew, err)
+	}
+
+	fresh := filepath.Join(dir, "fresh.pem")
+	writeTestCert(t, fresh, time.Now().Add(60*24*time.Hour))
+	if renew, err := ShouldRenew(fresh, DefaultRenewThreshold); err != nil || renew {
+		t.Errorf("expiring in 60d: got %v, %v; want false", renew, err)
+	}
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a cert"), 0644)
+	if _, err := ShouldRenew(garbage, 0); err == nil {
+		t.Error("Expected error for malformed certificate")
+	}
+}
+
+func TestShouldRenewFS(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fullchain.pem")
+	writeTestCert(t, path, time.Now().Add(60*24*time.Hour))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+
+	fs := system.NewDryRunFileSystem()
+	if renew, err := ShouldRenewFS(fs, path, 0); err != nil || !renew {
+		t.Errorf("missing cert: got %v, %v; want true, nil", renew, err)
+	}
+	fs.AddFile(path, data)
+	if renew, err := ShouldRenewFS(fs, path, 0); err != nil || renew {
+		t.Errorf("in-memory cert expiring in 60d: got %v, %v; want false", renew, err)
+	}
+	if renew, _ := ShouldRenew(path, 0); !renew {
+		t.Error("ShouldRenew should read the real disk, where the cert is missing")
+	}
+}
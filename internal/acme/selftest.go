@@ -0,0 +1,51 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge"
+)
+
+// selfTestLabel is the subdomain TestProvider writes its throwaway record
+// under, so it can't collide with a real challenge for the domain itself.
+const selfTestLabel = "horizon-selftest"
+
+// TestProvider checks that cfg's credentials can change records for domain
+// by creating a throwaway TXT record at
+// _acme-challenge.horizon-selftest.<domain> and removing it again. It does
+// not wait for propagation: the point is to catch bad credentials or a
+// wrong zone in seconds rather than after a full challenge timeout.
+func TestProvider(cfg *DNSProviderConfig, domain string) error {
+	provider, err := CreateChallengeProvider(cfg, nil)
+	if err != nil {
+		return err
+	}
+	return testProvider(provider, domain)
+}
+
+// testProvider is TestProvider for an already created provider.
+func testProvider(provider challenge.Provider, domain string) error {
+	domains, err := NormalizeDomains([]string{domain})
+	if err != nil {
+		return err
+	}
+	// A wildcard is tested under its base domain.
+	name := selfTestLabel + "." + strings.TrimPrefix(domains[0], "*.")
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	keyAuth := hex.EncodeToString(nonce)
+
+	if err := provider.Present(name, "selftest", keyAuth); err != nil {
+		return fmt.Errorf("dns provider self-test: create TXT record for %s: %w", name, err)
+	}
+	if err := provider.CleanUp(name, "selftest", keyAuth); err != nil {
+		return fmt.Errorf("dns provider self-test: record for %s was created but not removed: %w", name, err)
+	}
+	return nil
+}
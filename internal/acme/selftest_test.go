@@ -0,0 +1,64 @@
+package acme
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type selfTestProvider struct {
+	presentErr, cleanUpErr error
+	presented, cleaned     []string
+}
+
+func (p *selfTestProvider) Present(domain, token, keyAuth string) error {
+	p.presented = append(p.presented, domain+" "+keyAuth)
+	return p.presentErr
+}
+
+func (p *selfTestProvider) CleanUp(domain, token, keyAuth string) error {
+	p.cleaned = append(p.cleaned, domain+" "+keyAuth)
+	return p.cleanUpErr
+}
+
+func TestTestProvider(t *testing.T) {
+	p := &selfTestProvider{}
+	if err := testProvider(p, "*.Example.com."); err != nil {
+		t.Fatalf("testProvider() error = %v", err)
+	}
+	if len(p.presented) != 1 || !strings.HasPrefix(p.presented[0], "horizon-selftest.example.com ") {
+		t.Fatalf("presented = %q, want one record under horizon-selftest.example.com", p.presented)
+	}
+	if len(p.cleaned) != 1 || p.cleaned[0] != p.presented[0] {
+		t.Errorf("cleaned = %q, want the presented record %q removed", p.cleaned, p.presented[0])
+	}
+
+	// Each run uses a fresh value so a leftover record can't mask a failure.
+	testProvider(p, "example.com")
+	if p.presented[0] == p.presented[1] {
+		t.Error("testProvider() reused the TXT value between runs")
+	}
+}
+
+func TestTestProviderFailures(t *testing.T) {
+	denied := errors.New("access denied")
+
+	p := &selfTestProvider{presentErr: denied}
+	err := testProvider(p, "example.com")
+	if !errors.Is(err, denied) {
+		t.Errorf("testProvider() error = %v, want the Present error", err)
+	}
+	if len(p.cleaned) != 0 {
+		t.Error("testProvider() should not clean up a record it failed to create")
+	}
+
+	p = &selfTestProvider{cleanUpErr: denied}
+	err = testProvider(p, "example.com")
+	if !errors.Is(err, denied) || !strings.Contains(err.Error(), "not removed") {
+		t.Errorf("testProvider() error = %v, want the CleanUp error", err)
+	}
+
+	if err := TestProvider(&DNSProviderConfig{Type: DNSProviderCloudflare}, "example.com"); err == nil {
+		t.Error("TestProvider() with missing credentials should fail")
+	}
+}
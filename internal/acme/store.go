@@ -0,0 +1,73 @@
+package acme
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+// CertPaths says where SaveCertificate writes each part of an issued
+// certificate. Empty paths are skipped, except FullChain and PrivateKey.
+type CertPaths struct {
+	FullChain  string // leaf + intermediates
+	PrivateKey string // written 0600
+	Issuer     string // intermediates only; skipped if the CA sent none
+	Combined   string // cert + key in one file, as HAProxy's `crt` expects; written 0600
+}
+
+type certFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+	what string
+}
+
+// SaveCertificate writes an issued certificate to disk through fs, creating
+// parent directories as needed.
+func SaveCertificate(fs system.FileSystem, res *certificate.Resource, paths CertPaths) error {
+	if res == nil {
+		return fmt.Errorf("no certificate to save")
+	}
+	if paths.FullChain == "" || paths.PrivateKey == "" {
+		return fmt.Errorf("certificate and private key paths are required")
+	}
+
+	// Everything is owner-only, public certificates included, matching what
+	// was on disk before SaveCertificate existed.
+	files := []certFile{
+		{paths.FullChain, res.Certificate, 0600, "certificate"},
+		{paths.PrivateKey, res.PrivateKey, 0600, "private key"},
+	}
+	if paths.Issuer != "" && len(res.IssuerCertificate) > 0 {
+		files = append(files, certFile{paths.Issuer, res.IssuerCertificate, 0600, "issuer certificate"})
+	}
+	if paths.Combined != "" {
+		files = append(files, certFile{paths.Combined, CombinedPEM(res.Certificate, res.PrivateKey), 0600, "combined cert"})
+	}
+
+	for _, f := range files {
+		if err := fs.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", f.what, err)
+		}
+		if err := fs.WriteFile(f.path, f.data, f.perm); err != nil {
+			return fmt.Errorf("writing %s: %w", f.what, err)
+		}
+	}
+	return nil
+}
+
+// CombinedPEM joins a PEM certificate chain and its key into the single file
+// HAProxy loads, making sure the key starts on its own line.
+func CombinedPEM(cert, key []byte) []byte {
+	combined := make([]byte, 0, len(cert)+len(key)+1)
+	combined = append(combined, cert...)
+	if len(combined) > 0 && !bytes.HasSuffix(combined, []byte("\n")) {
+		combined = append(combined, '\n')
+	}
+	return append(combined, key...)
+}
@@ -10,8 +10,11 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/acme"
 )
 
 // Routing profile constants for WireGuard peers
@@ -60,14 +63,58 @@ type DNSProviderConfig struct {
 	// Cloudflare credentials
 	CloudflareAPIToken string `json:"cloudflare_api_token,omitempty"`
 	CloudflareZoneID   string `json:"cloudflare_zone_id,omitempty"` // Optional: Cloudflare zone ID (if not provided, looked up by zone name)
+	// Optional: separate token with Zone:Read, for when cloudflare_api_token is scoped to DNS:Edit only
+	CloudflareZoneToken string `json:"cloudflare_zone_token,omitempty"`
 
 	// Google Cloud DNS credentials
 	GCPProject            string `json:"gcp_project,omitempty"`
 	GCPServiceAccountJSON string `json:"gcp_service_account_json,omitempty"` // JSON key file contents or path
+
+	// Optional DNS-01 propagation overrides in seconds; 0 keeps the provider's default
+	PropagationTimeoutSeconds int `json:"propagation_timeout_seconds,omitempty"`
+	PollingIntervalSeconds    int `json:"polling_interval_seconds,omitempty"`
+	// Optional: poll propagation with exponential backoff and jitter instead of a fixed interval
+	PropagationBackoff *PropagationBackoff `json:"propagation_backoff,omitempty"`
+}
+
+// PropagationBackoff is the config form of acme.Backoff: the wait between
+// DNS-01 propagation checks grows from initial_seconds by multiplier up to
+// max_seconds, shifted by up to ±jitter, until max_attempts checks or
+// max_wait_seconds of waiting. Zero fields keep acme's defaults.
+type PropagationBackoff struct {
+	InitialSeconds int     `json:"initial_seconds,omitempty"`
+	Multiplier     float64 `json:"multiplier,omitempty"`
+	MaxSeconds     int     `json:"max_seconds,omitempty"`
+	Jitter         float64 `json:"jitter,omitempty"`
+	MaxAttempts    int     `json:"max_attempts,omitempty"`
+	MaxWaitSeconds int     `json:"max_wait_seconds,omitempty"`
+}
+
+// Backoff converts b to the strategy acme polls with; nil stays nil.
+func (b *PropagationBackoff) Backoff() *acme.Backoff {
+	if b == nil {
+		return nil
+	}
+	return &acme.Backoff{
+		Initial:     time.Duration(b.InitialSeconds) * time.Second,
+		Multiplier:  b.Multiplier,
+		Max:         time.Duration(b.MaxSeconds) * time.Second,
+		Jitter:      b.Jitter,
+		MaxAttempts: b.MaxAttempts,
+		MaxWait:     time.Duration(b.MaxWaitSeconds) * time.Second,
+	}
 }
 
 // Validate checks if the provider config has required fields
 func (d *DNSProviderConfig) Validate() error {
+	if d.PropagationTimeoutSeconds < 0 || d.PollingIntervalSeconds < 0 {
+		return errors.New("propagation_timeout_seconds and polling_interval_seconds must not be negative")
+	}
+	if b := d.PropagationBackoff.Backoff(); b != nil {
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("propagation_backoff: %w", err)
+		}
+	}
 	switch d.Type {
 	case DNSProviderRoute53:
 		// Either AWS profile or explicit credentials required
@@ -108,15 +155,33 @@ func (d *DNSProviderConfig) Validate() error {
 	return nil
 }
 
-// SearchPaths defines where to look for config files, in order of preference
+// SearchPaths defines where to look for config files, in order of preference.
+// A leading ~ and $VARS are expanded (see ExpandPath).
 var SearchPaths = []string{
 	"/etc/homelab-horizon/config.json",
 	"/etc/homelab-horizon.json",
 	"./config.json",
 	"./homelab-horizon.json",
+	"~/.config/homelab-horizon.json",
+}
+
+// ExpandPath expands $VAR / ${VAR} references and a leading ~ (the current
+// user's home directory) in path. If the home directory can't be determined
+// the ~ is left as-is.
+func ExpandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
 }
 
 type Config struct {
+	// Version is the schema version of the file; see Migrate. Absent = 0.
+	Version int `json:"version"`
+
 	// Core server settings
 	ListenAddr string `json:"listen_addr"`
 	AdminToken string `json:"admin_token,omitempty"`
@@ -163,12 +228,20 @@ type Config struct {
 	PublicIPMaxAge int `json:"public_ip_max_age"`
 
 	// DNSMasq configuration
-	DNSMasqEnabled    bool     `json:"dnsmasq_enabled"`
-	DNSMasqConfigPath string   `json:"dnsmasq_config_path"`
-	DNSMasqHostsPath  string   `json:"dnsmasq_hosts_path"`
-	DNSMasqInterfaces []string `json:"dnsmasq_interfaces"` // Additional interfaces for dnsmasq (beyond WG interface)
-	UpstreamDNS       []string `json:"upstream_dns"`
-	LocalInterface    string   `json:"local_interface"` // Local interface IP for DNS resolution of localhost-bound services
+	DNSMasqEnabled    bool   `json:"dnsmasq_enabled"`
+	DNSMasqConfigPath string `json:"dnsmasq_config_path"`
+	DNSMasqHostsPath  string `json:"dnsmasq_hosts_path"`
+	// DNSMasqPeerHostsPath is the addn-hosts file mapping WireGuard peer
+	// names to their VPN IPs. Keep it out of /etc/dnsmasq.d: it is in hosts
+	// format, which a conf-dir would try to parse as config.
+	DNSMasqPeerHostsPath string   `json:"dnsmasq_peer_hosts_path,omitempty"`
+	DNSMasqInterfaces    []string `json:"dnsmasq_interfaces"` // Additional interfaces for dnsmasq (beyond WG interface)
+	UpstreamDNS          []string `json:"upstream_dns"`
+	LocalInterface       string   `json:"local_interface"` // Local interface IP for DNS resolution of localhost-bound services
+	// LocalInterfaceName pins LocalInterface detection to one NIC (e.g.
+	// "eth0") on hosts where the default route points elsewhere, such as a
+	// Tailscale interface. Empty = auto-detect. Local-only.
+	LocalInterfaceName string `json:"local_interface_name,omitempty"`
 
 	// LastLocalIface and LastLanCIDR persist what the interface sync last
 	// reconciled against. On startup the watcher seeds from these (not from
@@ -224,6 +297,18 @@ type Config struct {
 	SSLEnabled        bool   `json:"ssl_enabled"`
 	SSLCertDir        string `json:"ssl_cert_dir"`
 	SSLHAProxyCertDir string `json:"ssl_haproxy_cert_dir"`
+	// SSLCADirectoryURL points ACME at a different CA, e.g. Let's Encrypt
+	// staging or an internal step-ca. Empty means Let's Encrypt production.
+	SSLCADirectoryURL string `json:"ssl_ca_directory_url,omitempty"`
+	// SSLPostIssueCommand (run with sh -c) and SSLPostIssueWebhook (JSON
+	// POST) fire after each successful issuance or renewal, e.g. to reload
+	// HAProxy or send a notification.
+	SSLPostIssueCommand string `json:"ssl_post_issue_command,omitempty"`
+	SSLPostIssueWebhook string `json:"ssl_post_issue_webhook,omitempty"`
+	// SSLDNSResolvers are the nameservers (ip or ip:port) lego queries to see
+	// whether DNS-01 challenge records have propagated. Set this when the
+	// host's own resolver is split-horizon or caching, e.g. dnsmasq.
+	SSLDNSResolvers []string `json:"ssl_dns_resolvers,omitempty"`
 
 	// Service monitoring with ntfy notifications
 	NtfyURL            string         `json:"ntfy_url,omitempty"`             // e.g., "https://ntfy.sh/my-homelab-alerts"
@@ -279,6 +364,14 @@ type Config struct {
 	// on first use; rotatable. Separate from the admin token so a scraper never
 	// holds admin rights. Empty until first requested.
 	ScrapeToken string `json:"scrape_token,omitempty"`
+
+	// fragments is set by Load when config.d fragments changed anything, so
+	// Save writes only the base layer back.
+	fragments *fragmentLayer
+
+	// path is the file Load read this config from (after ExpandPath), for
+	// Watch. Empty for configs that didn't come from a file.
+	path string
 }
 
 // HostDecl is an operator-declared host in the topology, beyond the hosts hz
@@ -549,7 +642,12 @@ func (z *Zone) GetDNSProvider() *DNSProviderConfig {
 // ZoneSSL configures wildcard SSL for a zone
 type ZoneSSL struct {
 	Enabled bool   `json:"enabled"`
-	Email   string `json:"email"` // Let's Encrypt email
+	Email   string `json:"email"`              // Let's Encrypt email
+	KeyType string `json:"key_type,omitempty"` // ec256 (default), ec384, rsa2048 or rsa4096
+
+	// HTTP-01 instead of DNS-01, for a host with a public port 80 and no DNS API access
+	Challenge     string `json:"challenge,omitempty"`      // dns-01 (default) or http-01
+	HTTP01Address string `json:"http01_address,omitempty"` // http-01 token server listen address; default "127.0.0.1:8402", behind HAProxy
 }
 
 // Service represents a unified service configuration with clear separation of concerns
@@ -769,6 +867,8 @@ func GenerateDeployToken() string {
 
 func Default() *Config {
 	return &Config{
+		Version: CurrentVersion,
+
 		// Core server settings
 		ListenAddr: ":8080",
 		KioskURL:   "https://kiosk.vpn.example.com",
@@ -790,12 +890,13 @@ func Default() *Config {
 		PublicIPMaxAge:   3600, // 1 hour staleness threshold
 
 		// DNSMasq configuration
-		DNSMasqEnabled:    true,
-		DNSMasqConfigPath: "/etc/dnsmasq.d/wg-vpn.conf",
-		DNSMasqHostsPath:  "/etc/dnsmasq.d/wg-hosts.conf",
-		DNSMasqInterfaces: []string{},
-		UpstreamDNS:       []string{"1.1.1.1", "8.8.8.8", "8.8.4.4"},
-		LocalInterface:    "", // Auto-detected from eth0 or falls back to VPN server IP
+		DNSMasqEnabled:       true,
+		DNSMasqConfigPath:    "/etc/dnsmasq.d/wg-vpn.conf",
+		DNSMasqHostsPath:     "/etc/dnsmasq.d/wg-hosts.conf",
+		DNSMasqPeerHostsPath: "/var/lib/homelab-horizon/wg-peers.hosts",
+		DNSMasqInterfaces:    []string{},
+		UpstreamDNS:          []string{"1.1.1.1", "8.8.8.8", "8.8.4.4"},
+		LocalInterface:       "", // Auto-detected from eth0 or falls back to VPN server IP
 
 		// HAProxy configuration
 		HAProxyEnabled:    false,
@@ -815,14 +916,20 @@ func Default() *Config {
 	}
 }
 
-// Find locates a config file from search paths, returns path and whether it exists
+// Find locates a config file from search paths, returns path and whether it
+// exists. A search path whose fragment directory (see FragmentDir) exists
+// counts as found even if the base file doesn't.
 func Find() (string, bool) {
 	for _, p := range SearchPaths {
+		p = ExpandPath(p)
 		if _, err := os.Stat(p); err == nil {
 			return p, true
 		}
+		if info, err := os.Stat(FragmentDir(p)); err == nil && info.IsDir() {
+			return p, true
+		}
 	}
-	return SearchPaths[0], false // default to first path for creation
+	return ExpandPath(SearchPaths[0]), false // default to first path for creation
 }
 
 // stripJSONCComments removes // comments from JSONC content
@@ -867,35 +974,82 @@ func stripInlineComment(line []byte) []byte {
 // LoadFromJSON parses config JSON (with JSONC comment support), overlaying on defaults
 func LoadFromJSON(data []byte) (*Config, error) {
 	cfg := Default()
-	data = stripJSONCComments(data)
+	data, err := Migrate(stripJSONCComments(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
 	if err := json.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
+	if err := validateVPNRange(cfg.VPNRange); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// validateVPNRange rejects a vpn_range that isn't a CIDR. Empty is allowed:
+// callers fall back to their built-in defaults for it.
+func validateVPNRange(vpnRange string) error {
+	if vpnRange == "" {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(vpnRange); err != nil {
+		return fmt.Errorf("invalid vpn_range %q: must be a CIDR such as 10.100.0.0/24", vpnRange)
+	}
+	return nil
+}
+
 // Load reads config from path, overlaying on defaults
-// Supports JSONC format (JSON with // comments)
+// Supports JSONC format (JSON with // comments). path is expanded with ExpandPath.
+// The base file and each fragment are upgraded with Migrate before decoding.
+//
+// Fragments in FragmentDir(path) are then merged on top in lexical filename
+// order, so precedence is defaults < base file < fragments, and among
+// fragments later files win (10-net.json is overridden by 20-acme.json).
+// Either the base file or the directory may be missing. The returned config
+// remembers the base layer: Save writes only that back to path, so values a
+// fragment supplies (secrets included) never land in the base file.
 func Load(path string) (*Config, error) {
+	path = ExpandPath(path)
+	cfg := Default()
 	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return Default(), nil // just use defaults
+	switch {
+	case err == nil:
+		if cfg, err = LoadFromJSON(data); err != nil {
+			return nil, err
 		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	base := *cfg
+	if err := loadFragments(cfg, FragmentDir(path)); err != nil {
+		return nil, err
+	}
+	cfg.fragments = newFragmentLayer(&base, cfg)
+	cfg.path = path
+	if err := validateVPNRange(cfg.VPNRange); err != nil {
 		return nil, err
 	}
-	return LoadFromJSON(data)
+	return cfg, nil
 }
 
 // LoadAuto finds and loads config from standard paths.
 // If HZ_CONFIG env var is set, it is parsed as JSON config directly.
+// HORIZON_* overrides (see ApplyEnvOverrides) are applied on top either way.
 func LoadAuto() (*Config, string, error) {
 	if envCfg := os.Getenv("HZ_CONFIG"); envCfg != "" {
 		cfg, err := LoadFromJSON([]byte(envCfg))
 		if err != nil {
 			return nil, "", fmt.Errorf("parsing HZ_CONFIG: %w", err)
 		}
-		path := SearchPaths[0] // default save path
+		if err := cfg.ApplyEnvOverrides(); err != nil {
+			return nil, "", fmt.Errorf("applying environment overrides: %w", err)
+		}
+		if err := validateVPNRange(cfg.VPNRange); err != nil {
+			return nil, "", fmt.Errorf("HORIZON_VPN_RANGE: %w", err)
+		}
+		path := ExpandPath(SearchPaths[0]) // default save path
 		slog.Info("loaded config from HZ_CONFIG environment variable")
 		return cfg, path, nil
 	}
@@ -905,6 +1059,12 @@ func LoadAuto() (*Config, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	if err := cfg.ApplyEnvOverrides(); err != nil {
+		return nil, "", fmt.Errorf("applying environment overrides: %w", err)
+	}
+	if err := validateVPNRange(cfg.VPNRange); err != nil {
+		return nil, "", fmt.Errorf("HORIZON_VPN_RANGE: %w", err)
+	}
 	if !found {
 		slog.Info("no config file found, using defaults", "create_at", path)
 	} else {
@@ -913,6 +1073,11 @@ func LoadAuto() (*Config, string, error) {
 	return cfg, path, nil
 }
 
+// Save writes cfg to path as indented JSON, stamped with CurrentVersion. If
+// the file already exists and carries // comments (JSONC), those comments are
+// kept on the keys they annotate. A cfg returned by Load with fragments is
+// saved as its base layer: fields still holding a fragment's value are
+// written with the base file's value instead.
 func Save(path string, cfg *Config) error {
 	dir := filepath.Dir(path)
 	if dir != "." && dir != "" {
@@ -921,10 +1086,16 @@ func Save(path string, cfg *Config) error {
 		}
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	out := *cfg
+	out.fragments.restoreBase(&out)
+	out.Version = CurrentVersion
+	data, err := json.MarshalIndent(&out, "", "  ")
 	if err != nil {
 		return err
 	}
+	if old, err := os.ReadFile(path); err == nil && hasComments(old) {
+		data = preserveComments(old, data)
+	}
 	return os.WriteFile(path, data, 0600)
 }
 
@@ -950,6 +1121,57 @@ func GetInterfaceIP(ifaceName string) (string, error) {
 	return "", fmt.Errorf("no IPv4 address found on %s", ifaceName)
 }
 
+// AddressFamily selects between IPv4 and IPv6 addresses when detecting an
+// interface IP.
+type AddressFamily string
+
+const (
+	FamilyIPv4 AddressFamily = "ipv4"
+	FamilyIPv6 AddressFamily = "ipv6"
+)
+
+// GetInterfaceIPFamily returns the first usable address of the interface in
+// the preferred family, or of the other family if it has none. Loopback,
+// link-local and unspecified addresses are never returned.
+func GetInterfaceIPFamily(ifaceName string, prefer AddressFamily) (string, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	if ip := pickAddr(addrs, prefer); ip != nil {
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("no usable address found on %s", ifaceName)
+}
+
+// pickAddr implements GetInterfaceIPFamily's selection over addrs.
+func pickAddr(addrs []net.Addr, prefer AddressFamily) net.IP {
+	var other net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		if (ip.To4() != nil) == (prefer != FamilyIPv6) {
+			return ip
+		}
+		if other == nil {
+			other = ip
+		}
+	}
+	return other
+}
+
 // DetectDefaultInterface returns the name of the network interface used for the default route.
 func DetectDefaultInterface() string {
 	data, err := os.ReadFile("/proc/net/route")
@@ -966,31 +1188,68 @@ func DetectDefaultInterface() string {
 	return ""
 }
 
-// DetectLocalInterface attempts to detect the local interface IP
-// Uses the default route interface, falls back to eth0, then VPN range
+// DetectLocalInterface attempts to detect the local interface IP,
+// preferring IPv4. See DetectLocalInterfaceFamily.
 func (c *Config) DetectLocalInterface() string {
+	return c.DetectLocalInterfaceFamily(FamilyIPv4)
+}
+
+// DetectLocalInterfaceFamily attempts to detect the local interface IP in
+// the preferred address family (see GetInterfaceIPFamily).
+// Uses LocalInterfaceName if set, then the default route interface, falls
+// back to eth0, then VPN range
+func (c *Config) DetectLocalInterfaceFamily(prefer AddressFamily) string {
+	// A configured interface wins over auto-detection
+	if c.LocalInterfaceName != "" {
+		ip, err := GetInterfaceIPFamily(c.LocalInterfaceName, prefer)
+		if err == nil {
+			return ip
+		}
+		slog.Warn("local_interface_name unusable, auto-detecting", "iface", c.LocalInterfaceName, "err", err)
+	}
+
 	// Try the default route interface first
 	if iface := DetectDefaultInterface(); iface != "" {
-		if ip, err := GetInterfaceIP(iface); err == nil {
+		if ip, err := GetInterfaceIPFamily(iface, prefer); err == nil {
 			return ip
 		}
 	}
 
 	// Try eth0 as fallback
-	if ip, err := GetInterfaceIP("eth0"); err == nil {
+	if ip, err := GetInterfaceIPFamily("eth0", prefer); err == nil {
 		return ip
 	}
 
 	// Fall back to VPN server IP (first IP in VPN range, typically .1)
-	if c.VPNRange != "" {
-		parts := strings.Split(c.VPNRange, "/")
-		if len(parts) > 0 {
-			base := strings.TrimSuffix(parts[0], ".0")
-			return base + ".1"
+	return c.GetWGGatewayIP()
+}
+
+// DefaultWGListenPort is the port GetEndpoint assumes when wg0.conf sets no
+// ListenPort.
+const DefaultWGListenPort = 51820
+
+// GetEndpoint returns the host:port generated client configs dial.
+// ServerEndpoint is used as is when it carries a port; a bare host or IP
+// there is joined with listenPort (DefaultWGListenPort if 0). With no
+// ServerEndpoint it falls back to DetectLocalInterface, so clients on the
+// local network still work.
+func (c *Config) GetEndpoint(listenPort int) string {
+	host := c.ServerEndpoint
+	if host != "" {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			return host
 		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	} else {
+		host = c.DetectLocalInterface()
 	}
-
-	return "10.100.0.1" // Last resort default
+	if host == "" {
+		return ""
+	}
+	if listenPort == 0 {
+		listenPort = DefaultWGListenPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(listenPort))
 }
 
 // EnsureLocalInterface sets LocalInterface if not already configured
@@ -1002,6 +1261,13 @@ func (c *Config) EnsureLocalInterface() {
 
 // GetLocalNetworkCIDR attempts to get the CIDR for the local network interface
 func GetLocalNetworkCIDR(ifaceName string) string {
+	return localNetworkCIDR(ifaceName, false)
+}
+
+// localNetworkCIDR returns the first IPv4 (or, with v6, IPv6) network on the
+// interface, e.g. 192.168.1.0/24. Link-local IPv6 networks are skipped: every
+// interface has one and they aren't routable through the tunnel.
+func localNetworkCIDR(ifaceName string, v6 bool) string {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return ""
@@ -1013,14 +1279,13 @@ func GetLocalNetworkCIDR(ifaceName string) string {
 	}
 
 	for _, addr := range addrs {
-		if ipNet, ok := addr.(*net.IPNet); ok {
-			if ip4 := ipNet.IP.To4(); ip4 != nil {
-				// Return the network CIDR (e.g., 192.168.1.0/24)
-				ones, _ := ipNet.Mask.Size()
-				network := ip4.Mask(ipNet.Mask)
-				return fmt.Sprintf("%s/%d", network.String(), ones)
-			}
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != v6 || ipNet.IP.IsLinkLocalUnicast() {
+			continue
 		}
+		// Return the network CIDR (e.g., 192.168.1.0/24)
+		network := &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+		return network.String()
 	}
 	return ""
 }
@@ -1031,18 +1296,22 @@ func (c *Config) DeriveAllowedIPs() string {
 	var cidrs []string
 
 	// Always include VPN range
+	var vpnNet *net.IPNet
 	if c.VPNRange != "" {
 		cidrs = append(cidrs, c.VPNRange)
+		_, vpnNet, _ = net.ParseCIDR(c.VPNRange)
 	}
 
-	// Try to detect local network from the default route interface, then fall back to eth0
+	// Try to detect local network from the default route interface, then fall back to eth0.
+	// The LAN is looked up in the VPN range's address family.
 	iface := DetectDefaultInterface()
 	if iface == "" {
 		iface = "eth0"
 	}
-	if localCIDR := GetLocalNetworkCIDR(iface); localCIDR != "" {
+	v6 := vpnNet != nil && vpnNet.IP.To4() == nil
+	if localCIDR := localNetworkCIDR(iface, v6); localCIDR != "" {
 		// Don't duplicate if it's the same as VPN range
-		if localCIDR != c.VPNRange {
+		if vpnNet == nil || localCIDR != vpnNet.String() {
 			cidrs = append(cidrs, localCIDR)
 		}
 	}
@@ -1054,12 +1323,43 @@ func (c *Config) DeriveAllowedIPs() string {
 	return strings.Join(cidrs, ", ")
 }
 
-// GetAllowedIPs returns AllowedIPs, deriving it if not explicitly set
-func (c *Config) GetAllowedIPs() string {
+// GetAllowedIPs returns AllowedIPs, deriving it if not explicitly set, as
+// a normalized list (see NormalizeCIDRList).
+func (c *Config) GetAllowedIPs() (string, error) {
 	if c.AllowedIPs != "" {
-		return c.AllowedIPs
+		return NormalizeCIDRList(c.AllowedIPs)
+	}
+	return NormalizeCIDRList(c.DeriveAllowedIPs())
+}
+
+// NormalizeCIDRList parses a comma-separated CIDR list and returns it with
+// each entry in canonical form (host bits cleared), duplicates dropped and
+// entries joined by ", ". Blank entries are skipped; every invalid entry is
+// reported in the joined error.
+func NormalizeCIDRList(list string) (string, error) {
+	var out []string
+	var errs []error
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid CIDR %q", entry))
+			continue
+		}
+		cidr := ipNet.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			out = append(out, cidr)
+		}
 	}
-	return c.DeriveAllowedIPs()
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	return strings.Join(out, ", "), nil
 }
 
 // GetPeerProfile returns the routing profile for a peer, defaulting to "lan-access"
@@ -1222,130 +1522,39 @@ func (c *Config) GetAllowedIPsForProfile(profile string) string {
 		}
 		return "10.100.0.0/24"
 	default:
-		return c.GetAllowedIPs()
+		// Validate rejects bad entries, but only runServer (main) runs
+		// it; Load does not. Configs reaching here another way (other
+		// subcommands, a config pulled from a fleet peer) may still hold
+		// one, and get the raw string back.
+		allowed, err := c.GetAllowedIPs()
+		if err != nil {
+			return c.AllowedIPs
+		}
+		return allowed
 	}
 }
 
-// GetWGGatewayIP returns the WireGuard gateway IP (first IP in VPN range, typically .1)
+// GetWGGatewayIP returns the WireGuard gateway IP: the first host in the VPN
+// range, e.g. 10.100.0.1 for 10.100.0.0/24 or fd00:100::1 for fd00:100::/64.
 func (c *Config) GetWGGatewayIP() string {
-	if c.VPNRange != "" {
-		parts := strings.Split(c.VPNRange, "/")
-		if len(parts) > 0 {
-			base := strings.TrimSuffix(parts[0], ".0")
-			return base + ".1"
+	if _, network, err := net.ParseCIDR(c.VPNRange); err == nil {
+		ip := network.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		gw := make(net.IP, len(ip))
+		copy(gw, ip)
+		for i := len(gw) - 1; i >= 0; i-- {
+			gw[i]++
+			if gw[i] != 0 {
+				break
+			}
 		}
+		return gw.String()
 	}
 	return "10.100.0.1" // Fallback
 }
 
-// Template returns a commented config template for user reference
-func Template() string {
-	return strings.TrimSpace(`
-{
-  // HTTP server listen address
-  "listen_addr": ":8080",
-
-  // Public URL of this kiosk (for invite QR codes)
-  "kiosk_url": "https://vpn.example.com",
-
-  // === LAYER 1: VPN CLIENTS (WireGuard) ===
-
-  // WireGuard interface name
-  "wg_interface": "wg0",
-
-  // Path to WireGuard configuration file
-  "wg_config_path": "/etc/wireguard/wg0.conf",
-
-  // File to store invite tokens (one per line)
-  "invites_file": "invites.txt",
-
-  // Public endpoint for clients to connect to (host:port)
-  "server_endpoint": "vpn.example.com:51820",
-
-  // Server's WireGuard public key (auto-detected if empty)
-  "server_public_key": "",
-
-  // VPN IP address range (CIDR notation)
-  "vpn_range": "10.100.0.0/24",
-
-  // DNS server for VPN clients
-  "dns": "10.100.0.1",
-
-  // Routes to send through VPN (empty = auto-detect VPN + local network)
-  "allowed_ips": "",
-
-  // === LAYER 2: SERVICES (Split-Horizon DNS) ===
-
-  // DNS Zones - shared configuration for Route53 and SSL certificates
-  "zones": [
-    {
-      "name": "example.com",
-      "zone_id": "Z1234567890ABC",
-      "aws_profile": "default",
-      "ssl": {
-        "enabled": true,
-        "email": "admin@example.com"
-      },
-      // Static records hz publishes on DNS sync (verification, SPF, DKIM, ...).
-      // hz owns each (name, type) set: it publishes exactly these values and
-      // replaces any others at that name/type. Declare all values for a name
-      // together. Name may be an FQDN, the zone apex ("@"), or a relative label.
-      "records": [
-        { "name": "app.example.com", "type": "TXT", "value": "google-site-verification=XXdummyTokenXX", "ttl": 3600 }
-      ]
-    }
-  ],
-
-  // Services - unified service definitions
-  // Each service configures: internal DNS (dnsmasq), external DNS (Route53), proxy (HAProxy)
-  "services": [
-    {
-      "name": "grafana",
-      "domain": "grafana.example.com",
-      "internal_dns": { "ip": "10.100.0.1" },
-      "external_dns": { "ip": "", "ttl": 300 },
-      "proxy": {
-        "backend": "10.100.0.50:3000",
-        "health_check": { "path": "/api/health" }
-      }
-    },
-    {
-      "name": "internal-db",
-      "domain": "db.example.com",
-      "internal_dns": { "ip": "10.100.0.100" }
-    }
-  ],
-
-  // Public IP for HAProxy services (auto-detected if empty)
-  "public_ip": "",
-  "public_ip_interval": 300,
-
-  // === SUBSYSTEM SETTINGS ===
-
-  // DNSMasq (internal DNS for VPN clients)
-  "dnsmasq_enabled": true,
-  "dnsmasq_config_path": "/etc/dnsmasq.d/wg-vpn.conf",
-  "dnsmasq_hosts_path": "/etc/dnsmasq.d/wg-hosts.conf",
-  "upstream_dns": ["1.1.1.1", "8.8.8.8"],
-
-  // Local interface IP for localhost-bound services (auto-detected from eth0 if empty)
-  // When a service backend is "localhost:port", this IP is used in DNS mappings
-  "local_interface": "",
-
-  // HAProxy (reverse proxy for external access)
-  "haproxy_enabled": true,
-  "haproxy_config_path": "/etc/haproxy/haproxy.cfg",
-  "haproxy_http_port": 80,
-  "haproxy_https_port": 443,
-
-  // SSL/Let's Encrypt
-  "ssl_enabled": true,
-  "ssl_cert_dir": "/etc/letsencrypt",
-  "ssl_haproxy_cert_dir": "/etc/haproxy/certs"
-}
-`) + "\n"
-}
-
 // IAMPolicyTemplate returns an IAM policy JSON that grants the minimum permissions
 // needed for Route53 DNS management and Let's Encrypt DNS challenges
 func IAMPolicyTemplate(zoneIDs ...string) string {
@@ -1433,7 +1642,7 @@ Group=root
 # File system isolation. /var/lib/homelab-horizon holds static-site releases
 # (the only writable, non-sensitive place for served files under the sandbox).
 ProtectSystem=strict
-ReadWritePaths=-/etc/wireguard -/etc/dnsmasq.d -/etc/haproxy -/etc/letsencrypt -/etc/systemd/system -/proc/sys/net/ipv4 -/var/lib/haproxy -/var/lib/homelab-horizon -%s
+ReadWritePaths=-/etc/wireguard -/etc/dnsmasq.d -/etc/haproxy -/etc/letsencrypt -/etc/systemd/system -/etc/sysctl.d -/proc/sys/net/ipv4 -/var/lib/haproxy -/var/lib/homelab-horizon -%s
 ProtectHome=read-only
 PrivateTmp=true
 ProtectKernelTunables=true
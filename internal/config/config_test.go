@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -90,6 +91,34 @@ func TestLoadWithComments(t *testing.T) {
 	}
 }
 
+func TestLoadInvalidVPNRange(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, vpnRange := range []string{"10.100.0/24", "10.100.0.0", "not-a-cidr"} {
+		configPath := filepath.Join(tmpDir, "test.json")
+		os.WriteFile(configPath, []byte(`{"vpn_range": "`+vpnRange+`"}`), 0644)
+
+		_, err := Load(configPath)
+		if err == nil {
+			t.Errorf("Expected error for vpn_range %q", vpnRange)
+			continue
+		}
+		if !strings.Contains(err.Error(), vpnRange) {
+			t.Errorf("Expected error to mention %q, got %v", vpnRange, err)
+		}
+	}
+
+	configPath := filepath.Join(tmpDir, "empty.json")
+	os.WriteFile(configPath, []byte(`{"vpn_range": ""}`), 0644)
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Expected empty vpn_range to load, got %v", err)
+	}
+	if cfg.GetWGGatewayIP() != "10.100.0.1" {
+		t.Errorf("Expected default gateway for empty vpn_range, got %s", cfg.GetWGGatewayIP())
+	}
+}
+
 func TestLoadNonExistent(t *testing.T) {
 	cfg, err := Load("/non/existent/config.json")
 	if err != nil {
@@ -163,6 +192,92 @@ func TestEnsureLocalInterface(t *testing.T) {
 	}
 }
 
+func TestGetEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		port     int
+		want     string
+	}{
+		{"vpn.example.com:51820", 51821, "vpn.example.com:51820"},
+		{"vpn.example.com", 51821, "vpn.example.com:51821"},
+		{"vpn.example.com", 0, "vpn.example.com:51820"},
+		{"2001:db8::1", 51821, "[2001:db8::1]:51821"},
+		{"[2001:db8::1]", 51821, "[2001:db8::1]:51821"},
+		{"[2001:db8::1]:4500", 51821, "[2001:db8::1]:4500"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{ServerEndpoint: tt.endpoint}
+		if got := cfg.GetEndpoint(tt.port); got != tt.want {
+			t.Errorf("GetEndpoint(%d) with %q = %q, want %q", tt.port, tt.endpoint, got, tt.want)
+		}
+	}
+
+	// Without server_endpoint the local interface stands in.
+	cfg := &Config{LocalInterfaceName: "does-not-exist0", VPNRange: "10.100.0.0/24"}
+	host, port, err := net.SplitHostPort(cfg.GetEndpoint(51821))
+	if err != nil || host != cfg.DetectLocalInterface() || port != "51821" {
+		t.Errorf("GetEndpoint() fallback = %q:%q (%v), want DetectLocalInterface():51821", host, port, err)
+	}
+}
+
+func TestDetectLocalInterfaceByName(t *testing.T) {
+	// Loopback only carries loopback addresses, which are never picked, so
+	// detection falls back to auto-detection.
+	cfg := &Config{LocalInterfaceName: "lo", VPNRange: "10.100.0.0/24"}
+	if ip := cfg.DetectLocalInterface(); ip == "" || net.ParseIP(ip).IsLoopback() {
+		t.Errorf("DetectLocalInterface() with lo = %q, want a non-loopback fallback", ip)
+	}
+
+	// An unknown interface falls back to auto-detection.
+	cfg.LocalInterfaceName = "does-not-exist0"
+	if ip := cfg.DetectLocalInterface(); ip == "" {
+		t.Error("Expected fallback IP for unknown interface, got empty string")
+	}
+}
+
+func TestPickAddr(t *testing.T) {
+	cidr := func(s string) net.Addr {
+		ip, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		return ipNet
+	}
+	dualStack := []net.Addr{
+		cidr("127.0.0.1/8"),
+		cidr("::1/128"),
+		cidr("fe80::1/64"),
+		cidr("169.254.10.10/16"),
+		cidr("2001:db8::10/64"),
+		cidr("192.168.1.10/24"),
+	}
+
+	tests := []struct {
+		name   string
+		addrs  []net.Addr
+		prefer AddressFamily
+		want   string
+	}{
+		{"prefer v4 on dual stack", dualStack, FamilyIPv4, "192.168.1.10"},
+		{"prefer v6 on dual stack", dualStack, FamilyIPv6, "2001:db8::10"},
+		{"v4 falls back to v6", []net.Addr{cidr("fe80::1/64"), cidr("2001:db8::10/64")}, FamilyIPv4, "2001:db8::10"},
+		{"v6 falls back to v4", []net.Addr{cidr("10.0.0.5/8")}, FamilyIPv6, "10.0.0.5"},
+		{"only loopback and link-local", []net.Addr{cidr("127.0.0.1/8"), cidr("fe80::1/64")}, FamilyIPv4, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if ip := pickAddr(tt.addrs, tt.prefer); ip != nil {
+				got = ip.String()
+			}
+			if got != tt.want {
+				t.Errorf("pickAddr() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestDeriveAllowedIPs(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -179,6 +294,11 @@ func TestDeriveAllowedIPs(t *testing.T) {
 			vpnRange: "192.168.100.0/24",
 			want:     "192.168.100.0/24",
 		},
+		{
+			name:     "IPv6 VPN range",
+			vpnRange: "fd00:100::/64",
+			want:     "fd00:100::/64",
+		},
 	}
 
 	for _, tt := range tests {
@@ -194,6 +314,15 @@ func TestDeriveAllowedIPs(t *testing.T) {
 	}
 }
 
+func TestDeriveAllowedIPsStaysInFamily(t *testing.T) {
+	cfg := &Config{VPNRange: "fd00:100::/64"}
+	for _, cidr := range strings.Split(cfg.DeriveAllowedIPs(), ", ") {
+		if !strings.Contains(cidr, ":") {
+			t.Errorf("Expected only IPv6 networks for an IPv6 VPN range, got %s", cidr)
+		}
+	}
+}
+
 func TestGetAllowedIPs(t *testing.T) {
 	// Explicit AllowedIPs should be returned as-is
 	cfg := &Config{
@@ -201,10 +330,21 @@ func TestGetAllowedIPs(t *testing.T) {
 		AllowedIPs: "10.100.0.0/24, 192.168.1.0/24",
 	}
 
-	got := cfg.GetAllowedIPs()
+	got, err := cfg.GetAllowedIPs()
 	want := "10.100.0.0/24, 192.168.1.0/24"
-	if got != want {
-		t.Errorf("GetAllowedIPs() = %s, want %s", got, want)
+	if err != nil || got != want {
+		t.Errorf("GetAllowedIPs() = %s, %v, want %s", got, err, want)
+	}
+
+	// Messy explicit lists are normalized
+	cfg.AllowedIPs = "10.100.0.0/24,10.100.0.0/24 ,  192.168.1.7/24,"
+	if got, err := cfg.GetAllowedIPs(); err != nil || got != want {
+		t.Errorf("GetAllowedIPs() = %s, %v, want %s", got, err, want)
+	}
+
+	cfg.AllowedIPs = "10.100.0.0/24, 192.168.1.1"
+	if _, err := cfg.GetAllowedIPs(); err == nil {
+		t.Error("GetAllowedIPs() with a bare IP should fail")
 	}
 
 	// Empty AllowedIPs should derive and always include VPN range
@@ -213,12 +353,42 @@ func TestGetAllowedIPs(t *testing.T) {
 		AllowedIPs: "",
 	}
 
-	got2 := cfg2.GetAllowedIPs()
+	got2, err := cfg2.GetAllowedIPs()
+	if err != nil {
+		t.Fatalf("GetAllowedIPs() error = %v", err)
+	}
 	if !strings.Contains(got2, "10.100.0.0/24") {
 		t.Errorf("GetAllowedIPs() = %s, want it to contain 10.100.0.0/24", got2)
 	}
 }
 
+func TestNormalizeCIDRList(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{" 10.0.0.0/8 ,192.168.1.0/24", "10.0.0.0/8, 192.168.1.0/24", false},
+		{"10.0.0.0/8, 10.0.0.0/8, 10.1.2.3/8", "10.0.0.0/8", false},
+		{"fd00:100::1/64, FD00:100::/64", "fd00:100::/64", false},
+		{"0.0.0.0/0, ::/0", "0.0.0.0/0, ::/0", false},
+		{"10.0.0.0/8,,", "10.0.0.0/8", false},
+		{"10.0.0.0/8, nope", "", true},
+		{"10.0.0.0/33", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeCIDRList(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeCIDRList(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeCIDRList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
 func TestGetWGGatewayIP(t *testing.T) {
 	tests := []struct {
 		vpnRange string
@@ -227,6 +397,9 @@ func TestGetWGGatewayIP(t *testing.T) {
 		{"10.100.0.0/24", "10.100.0.1"},
 		{"192.168.100.0/24", "192.168.100.1"},
 		{"10.0.0.0/8", "10.0.0.1"},
+		{"10.100.0.7/24", "10.100.0.1"},
+		{"fd00:100::/64", "fd00:100::1"},
+		{"fd00:100:0:1::/64", "fd00:100:0:1::1"},
 		{"", "10.100.0.1"},
 	}
 
@@ -288,6 +461,55 @@ func TestFindNotFound(t *testing.T) {
 	}
 }
 
+func TestFindExpandsHomeAndEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("HZ_TEST_DIR", "conf")
+
+	configPath := filepath.Join(home, "conf", "config.json")
+	os.MkdirAll(filepath.Dir(configPath), 0755)
+	if err := os.WriteFile(configPath, []byte(`{"listen_addr": ":7070"}`), 0644); err != nil {
+		t.Fatalf("Failed to create test config: %v", err)
+	}
+
+	originalSearchPaths := SearchPaths
+	SearchPaths = []string{"/non/existent/path", "~/$HZ_TEST_DIR/config.json"}
+	defer func() { SearchPaths = originalSearchPaths }()
+
+	path, found := Find()
+	if !found || path != configPath {
+		t.Fatalf("Find() = %q, %v; want %q, true", path, found, configPath)
+	}
+
+	cfg, err := Load("~/${HZ_TEST_DIR}/config.json")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ListenAddr != ":7070" {
+		t.Errorf("Expected ListenAddr :7070 from expanded path, got %s", cfg.ListenAddr)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/hz")
+	t.Setenv("HZ_TEST_NAME", "horizon")
+
+	tests := map[string]string{
+		"/etc/homelab-horizon.json": "/etc/homelab-horizon.json",
+		"~":                         "/home/hz",
+		"~/.config/hz.json":         "/home/hz/.config/hz.json",
+		"$HOME/hz.json":             "/home/hz/hz.json",
+		"/etc/${HZ_TEST_NAME}.json": "/etc/horizon.json",
+		"./~/config.json":           "./~/config.json",
+		"~other/config.json":        "~other/config.json",
+	}
+	for in, want := range tests {
+		if got := ExpandPath(in); got != want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
 func TestLoadInvalidJSON(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "invalid.json")
@@ -436,6 +658,21 @@ func TestDNSProviderConfigValidate(t *testing.T) {
 			config:  DNSProviderConfig{Type: "unknown"},
 			wantErr: true,
 		},
+		{
+			name:    "negative propagation timeout",
+			config:  DNSProviderConfig{Type: DNSProviderCloudflare, CloudflareAPIToken: "token", PropagationTimeoutSeconds: -1},
+			wantErr: true,
+		},
+		{
+			name:    "propagation backoff valid",
+			config:  DNSProviderConfig{Type: DNSProviderCloudflare, CloudflareAPIToken: "token", PropagationBackoff: &PropagationBackoff{Multiplier: 1.5, MaxAttempts: 10}},
+			wantErr: false,
+		},
+		{
+			name:    "propagation backoff shrinking",
+			config:  DNSProviderConfig{Type: DNSProviderCloudflare, CloudflareAPIToken: "token", PropagationBackoff: &PropagationBackoff{Multiplier: 0.5}},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
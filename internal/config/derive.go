@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/iodesystems/homelab-horizon/internal/acme"
 	"github.com/iodesystems/homelab-horizon/internal/haproxy"
 	"github.com/iodesystems/homelab-horizon/internal/letsencrypt"
 	"github.com/iodesystems/homelab-horizon/internal/route53"
@@ -411,24 +412,38 @@ func (c *Config) DeriveSSLDomains() []letsencrypt.DomainConfig {
 			}
 
 			dnsProvider = &letsencrypt.DNSProviderConfig{
-				Type:               letsencrypt.DNSProviderType(providerCfg.Type),
-				AWSAccessKeyID:     providerCfg.AWSAccessKeyID,
-				AWSSecretAccessKey: providerCfg.AWSSecretAccessKey,
-				AWSRegion:          providerCfg.AWSRegion,
-				AWSHostedZoneID:    awsHostedZoneID,
-				AWSProfile:         providerCfg.AWSProfile,
-				NamecomUsername:    providerCfg.NamecomUsername,
-				NamecomAPIToken:    providerCfg.NamecomAPIToken,
-				CloudflareAPIToken: providerCfg.CloudflareAPIToken,
-				CloudflareZoneID:   cloudflareZoneID,
+				Type:                letsencrypt.DNSProviderType(providerCfg.Type),
+				AWSAccessKeyID:      providerCfg.AWSAccessKeyID,
+				AWSSecretAccessKey:  providerCfg.AWSSecretAccessKey,
+				AWSRegion:           providerCfg.AWSRegion,
+				AWSHostedZoneID:     awsHostedZoneID,
+				AWSProfile:          providerCfg.AWSProfile,
+				NamecomUsername:     providerCfg.NamecomUsername,
+				NamecomAPIToken:     providerCfg.NamecomAPIToken,
+				CloudflareAPIToken:  providerCfg.CloudflareAPIToken,
+				CloudflareZoneID:    cloudflareZoneID,
+				CloudflareZoneToken: providerCfg.CloudflareZoneToken,
+				PropagationTimeout:  time.Duration(providerCfg.PropagationTimeoutSeconds) * time.Second,
+				PollingInterval:     time.Duration(providerCfg.PollingIntervalSeconds) * time.Second,
+				PropagationBackoff:  providerCfg.PropagationBackoff.Backoff(),
+			}
+			if providerCfg.Type == DNSProviderDigitalOcean {
+				dnsProvider.DigitalOceanAuthToken = providerCfg.APIToken
+			}
+			if providerCfg.Type == DNSProviderGoogleCloud {
+				dnsProvider.GCloudProject = providerCfg.GCPProject
+				dnsProvider.GCloudServiceAccountFile = providerCfg.GCPServiceAccountJSON
 			}
 		}
 
 		domains = append(domains, letsencrypt.DomainConfig{
-			Domain:      primaryDomain,
-			ExtraSANs:   extraSANs,
-			Email:       zone.SSL.Email,
-			DNSProvider: dnsProvider,
+			Domain:        primaryDomain,
+			ExtraSANs:     extraSANs,
+			Email:         zone.SSL.Email,
+			KeyType:       zone.SSL.KeyType,
+			DNSProvider:   dnsProvider,
+			Challenge:     zone.SSL.Challenge,
+			HTTP01Address: zone.SSL.HTTP01Address,
 		})
 	}
 	return domains
@@ -993,9 +1008,40 @@ func (c *Config) ValidateZone(zone *Zone) error {
 	if zone.SSL != nil && zone.SSL.Enabled && zone.SSL.Email == "" {
 		return &ValidationError{Field: "ssl.email", Message: "email is required for SSL"}
 	}
+	if zone.SSL != nil {
+		switch zone.SSL.Challenge {
+		case "", "dns-01", "http-01":
+		default:
+			return &ValidationError{Field: "ssl.challenge", Message: "challenge must be dns-01 or http-01"}
+		}
+		if zone.SSL.Challenge == "http-01" {
+			for _, sub := range zone.SubZones {
+				if strings.HasPrefix(sub, "*") {
+					return &ValidationError{Field: "ssl.challenge", Message: fmt.Sprintf("wildcard sub-zone %q requires the dns-01 challenge", sub)}
+				}
+			}
+		}
+	}
 	return nil
 }
 
+// HTTP01ChallengeAddress returns the token server address of the first
+// SSL zone that issues over http-01, or "" if none does. HAProxy forwards
+// /.well-known/acme-challenge/ there, so zones behind one HAProxy should
+// share the address.
+func (c *Config) HTTP01ChallengeAddress() string {
+	for _, z := range c.Zones {
+		if z.SSL == nil || !z.SSL.Enabled || z.SSL.Challenge != "http-01" {
+			continue
+		}
+		if z.SSL.HTTP01Address != "" {
+			return z.SSL.HTTP01Address
+		}
+		return acme.DefaultHTTP01Address
+	}
+	return ""
+}
+
 // ValidationError represents a validation error for a specific field
 type ValidationError struct {
 	Field   string
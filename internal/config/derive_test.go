@@ -7,6 +7,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/acme"
 )
 
 func TestGetZoneForDomain(t *testing.T) {
@@ -752,6 +754,26 @@ func TestValidateZone(t *testing.T) {
 			zone:    Zone{Name: "example.com", ZoneID: "Z1234", SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com"}},
 			wantErr: "",
 		},
+		{
+			name:    "SSL with http-01",
+			zone:    Zone{Name: "example.com", ZoneID: "Z1234", SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com", Challenge: "http-01"}},
+			wantErr: "",
+		},
+		{
+			name:    "http-01 with wildcard sub-zone",
+			zone:    Zone{Name: "example.com", ZoneID: "Z1234", SubZones: []string{"", "*"}, SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com", Challenge: "http-01"}},
+			wantErr: "ssl.challenge",
+		},
+		{
+			name:    "http-01 with wildcard subdomain",
+			zone:    Zone{Name: "example.com", ZoneID: "Z1234", SubZones: []string{"*.vpn"}, SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com", Challenge: "http-01"}},
+			wantErr: "ssl.challenge",
+		},
+		{
+			name:    "SSL with unknown challenge",
+			zone:    Zone{Name: "example.com", ZoneID: "Z1234", SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com", Challenge: "tls-alpn-01"}},
+			wantErr: "ssl.challenge",
+		},
 	}
 
 	for _, tt := range tests {
@@ -1039,6 +1061,60 @@ func TestDeriveSSLDomains(t *testing.T) {
 	}
 }
 
+func TestDeriveSSLDomainsGoogleCloud(t *testing.T) {
+	cfg := &Config{
+		Zones: []Zone{{
+			Name:     "example.com",
+			SubZones: []string{"*"},
+			SSL:      &ZoneSSL{Enabled: true, Email: "admin@example.com"},
+			DNSProvider: &DNSProviderConfig{
+				Type:                  DNSProviderGoogleCloud,
+				GCPProject:            "dns-project",
+				GCPServiceAccountJSON: "/etc/homelab/gcloud.json",
+			},
+		}},
+	}
+
+	domains := cfg.DeriveSSLDomains()
+	if len(domains) != 1 || domains[0].DNSProvider == nil {
+		t.Fatalf("Expected 1 SSL domain with a DNS provider, got %+v", domains)
+	}
+	p := domains[0].DNSProvider
+	if p.GCloudProject != "dns-project" || p.GCloudServiceAccountFile != "/etc/homelab/gcloud.json" {
+		t.Errorf("Expected Google Cloud settings to carry over, got %+v", p)
+	}
+}
+
+func TestDeriveSSLDomainsPropagation(t *testing.T) {
+	cfg := &Config{
+		Zones: []Zone{{
+			Name:     "example.com",
+			SubZones: []string{"*"},
+			SSL:      &ZoneSSL{Enabled: true, Email: "admin@example.com"},
+			DNSProvider: &DNSProviderConfig{
+				Type:                      DNSProviderCloudflare,
+				CloudflareAPIToken:        "token",
+				PropagationTimeoutSeconds: 600,
+				PollingIntervalSeconds:    15,
+				PropagationBackoff:        &PropagationBackoff{InitialSeconds: 10, Multiplier: 1.5, MaxWaitSeconds: 900},
+			},
+		}},
+	}
+
+	domains := cfg.DeriveSSLDomains()
+	if len(domains) != 1 || domains[0].DNSProvider == nil {
+		t.Fatalf("Expected 1 SSL domain with a DNS provider, got %+v", domains)
+	}
+	p := domains[0].DNSProvider
+	if p.PropagationTimeout != 10*time.Minute || p.PollingInterval != 15*time.Second {
+		t.Errorf("Expected propagation overrides to carry over, got %v / %v", p.PropagationTimeout, p.PollingInterval)
+	}
+	want := acme.Backoff{Initial: 10 * time.Second, Multiplier: 1.5, MaxWait: 15 * time.Minute}
+	if p.PropagationBackoff == nil || *p.PropagationBackoff != want {
+		t.Errorf("Expected backoff %+v, got %+v", want, p.PropagationBackoff)
+	}
+}
+
 func TestFilterRedundantDomains(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -1135,3 +1211,21 @@ func TestDeriveStaticRoot(t *testing.T) {
 		}
 	})
 }
+
+func TestHTTP01ChallengeAddress(t *testing.T) {
+	cfg := &Config{Zones: []Zone{
+		{Name: "dns.example.com", SSL: &ZoneSSL{Enabled: true}},
+		{Name: "example.com", SSL: &ZoneSSL{Enabled: true, Challenge: "http-01"}},
+	}}
+	if got := cfg.HTTP01ChallengeAddress(); got != acme.DefaultHTTP01Address {
+		t.Errorf("HTTP01ChallengeAddress() = %q, want the default %q", got, acme.DefaultHTTP01Address)
+	}
+	cfg.Zones[1].SSL.HTTP01Address = "127.0.0.1:9000"
+	if got := cfg.HTTP01ChallengeAddress(); got != "127.0.0.1:9000" {
+		t.Errorf("HTTP01ChallengeAddress() = %q, want the zone's address", got)
+	}
+	cfg.Zones[1].SSL.Enabled = false
+	if got := cfg.HTTP01ChallengeAddress(); got != "" {
+		t.Errorf("HTTP01ChallengeAddress() = %q with no http-01 zone, want empty", got)
+	}
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envOverride maps one environment variable onto a Config field.
+type envOverride struct {
+	name  string
+	apply func(c *Config, value string) error
+}
+
+// envOverrides is the set of HORIZON_* variables honored by
+// ApplyEnvOverrides.
+var envOverrides = []envOverride{
+	{"HORIZON_LISTEN_ADDR", setString(func(c *Config) *string { return &c.ListenAddr })},
+	{"HORIZON_ADMIN_TOKEN", setString(func(c *Config) *string { return &c.AdminToken })},
+	{"HORIZON_ADMIN_URL", setString(func(c *Config) *string { return &c.AdminURL })},
+	{"HORIZON_WG_INTERFACE", setString(func(c *Config) *string { return &c.WGInterface })},
+	{"HORIZON_WG_CONFIG_PATH", setString(func(c *Config) *string { return &c.WGConfigPath })},
+	{"HORIZON_SERVER_ENDPOINT", setString(func(c *Config) *string { return &c.ServerEndpoint })},
+	{"HORIZON_VPN_RANGE", setString(func(c *Config) *string { return &c.VPNRange })},
+	{"HORIZON_DNS", setString(func(c *Config) *string { return &c.DNS })},
+	{"HORIZON_ALLOWED_IPS", setString(func(c *Config) *string { return &c.AllowedIPs })},
+	{"HORIZON_LOCAL_INTERFACE", setString(func(c *Config) *string { return &c.LocalInterface })},
+	{"HORIZON_PUBLIC_IP_OVERRIDE", setString(func(c *Config) *string { return &c.PublicIPOverride })},
+	{"HORIZON_UPSTREAM_DNS", func(c *Config, v string) error {
+		c.UpstreamDNS = splitList(v)
+		return nil
+	}},
+	{"HORIZON_DNSMASQ_ENABLED", setBool(func(c *Config) *bool { return &c.DNSMasqEnabled })},
+	{"HORIZON_HAPROXY_ENABLED", setBool(func(c *Config) *bool { return &c.HAProxyEnabled })},
+	{"HORIZON_SSL_ENABLED", setBool(func(c *Config) *bool { return &c.SSLEnabled })},
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ApplyEnvOverrides overlays HORIZON_* environment variables onto c, so a
+// container can be configured without mounting a file. Unset variables leave
+// the field alone; a variable set to "" clears it. Every malformed value is
+// reported, and the valid ones are still applied.
+//
+// Overrides are applied in memory only, but a later Save will persist them
+// like any other field.
+func (c *Config) ApplyEnvOverrides() error {
+	var errs []error
+	for _, o := range envOverrides {
+		v, ok := os.LookupEnv(o.name)
+		if !ok {
+			continue
+		}
+		if err := o.apply(c, strings.TrimSpace(v)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	t.Setenv("HORIZON_LISTEN_ADDR", "0.0.0.0:9090")
+	t.Setenv("HORIZON_WG_INTERFACE", "wg1")
+	t.Setenv("HORIZON_UPSTREAM_DNS", "9.9.9.9, 1.1.1.1,")
+	t.Setenv("HORIZON_HAPROXY_ENABLED", "true")
+	t.Setenv("HORIZON_ALLOWED_IPS", "")
+
+	cfg := Default()
+	cfg.AllowedIPs = "10.0.0.0/8"
+	if err := cfg.ApplyEnvOverrides(); err != nil {
+		t.Fatalf("ApplyEnvOverrides() error = %v", err)
+	}
+
+	if cfg.ListenAddr != "0.0.0.0:9090" {
+		t.Errorf("ListenAddr = %q, want 0.0.0.0:9090", cfg.ListenAddr)
+	}
+	if cfg.WGInterface != "wg1" {
+		t.Errorf("WGInterface = %q, want wg1", cfg.WGInterface)
+	}
+	if !slices.Equal(cfg.UpstreamDNS, []string{"9.9.9.9", "1.1.1.1"}) {
+		t.Errorf("UpstreamDNS = %v", cfg.UpstreamDNS)
+	}
+	if !cfg.HAProxyEnabled {
+		t.Error("Expected HAProxyEnabled to be overridden to true")
+	}
+	if cfg.AllowedIPs != "" {
+		t.Errorf("Expected empty override to clear AllowedIPs, got %q", cfg.AllowedIPs)
+	}
+	if cfg.VPNRange != "10.100.0.0/24" {
+		t.Errorf("Expected unset variables to leave VPNRange alone, got %q", cfg.VPNRange)
+	}
+}
+
+func TestApplyEnvOverridesInvalidBool(t *testing.T) {
+	t.Setenv("HORIZON_SSL_ENABLED", "maybe")
+	t.Setenv("HORIZON_DNSMASQ_ENABLED", "nope")
+	t.Setenv("HORIZON_LISTEN_ADDR", ":7070")
+
+	cfg := Default()
+	err := cfg.ApplyEnvOverrides()
+	if err == nil {
+		t.Fatal("Expected error for invalid boolean")
+	}
+	for _, name := range []string{"HORIZON_SSL_ENABLED", "HORIZON_DNSMASQ_ENABLED"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %s, got %v", name, err)
+		}
+	}
+	if cfg.ListenAddr != ":7070" {
+		t.Error("Expected valid overrides to still be applied")
+	}
+}
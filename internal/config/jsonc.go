@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// lineComments are the comments attached to one JSON line: full-line
+// comments directly above it, and a trailing // comment on it.
+type lineComments struct {
+	leading  []string
+	trailing string
+}
+
+// preserveComments carries the // comments of old over to the equivalent
+// lines of updated, which is freshly marshalled JSON without comments.
+// Comments are keyed by the JSON path of the line they annotate (e.g.
+// "$.zones[0].name"), so they follow their key even when the surrounding
+// content changes. Comments on keys that no longer exist are dropped.
+func preserveComments(old, updated []byte) []byte {
+	oldLines := strings.Split(string(old), "\n")
+	codeLines := make([]string, len(oldLines))
+	commentLines := make([]string, len(oldLines))
+	for i, line := range oldLines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "//") {
+			commentLines[i] = trimmed
+			continue
+		}
+		code, comment := splitInlineComment(line)
+		codeLines[i] = code
+		commentLines[i] = comment
+	}
+
+	comments := make(map[string]*lineComments)
+	var pending, tail []string
+	for i, path := range jsonLinePaths(codeLines) {
+		if path == "" {
+			if strings.HasPrefix(strings.TrimSpace(oldLines[i]), "//") {
+				pending = append(pending, commentLines[i])
+			}
+			continue
+		}
+		if _, seen := comments[path]; seen {
+			pending = nil
+			continue
+		}
+		comments[path] = &lineComments{leading: pending, trailing: commentLines[i]}
+		pending = nil
+	}
+	tail = pending
+
+	newLines := strings.Split(strings.TrimRight(string(updated), "\n"), "\n")
+	var out bytes.Buffer
+	for i, path := range jsonLinePaths(newLines) {
+		line := newLines[i]
+		if c := comments[path]; c != nil {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			for _, l := range c.leading {
+				out.WriteString(indent + l + "\n")
+			}
+			if c.trailing != "" {
+				line += " " + c.trailing
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	for _, l := range tail {
+		out.WriteString(l + "\n")
+	}
+	return out.Bytes()
+}
+
+// hasComments reports whether data contains any // comment outside strings.
+func hasComments(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, comment := splitInlineComment(line); comment != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitInlineComment splits a line into its JSON and its // comment,
+// respecting quoted strings. The comment keeps its leading "//".
+func splitInlineComment(line string) (code, comment string) {
+	stripped := string(stripInlineComment([]byte(line)))
+	if len(stripped) == len(line) {
+		return line, ""
+	}
+	rest := strings.TrimLeft(line[len(stripped):], " \t")
+	if !strings.HasPrefix(rest, "//") {
+		return line, ""
+	}
+	return stripped, rest
+}
+
+// jsonFrame is one open object or array while scanning.
+type jsonFrame struct {
+	array     bool
+	key       string // object: most recent key
+	expectKey bool   // object: next string is a key
+	index     int    // array: current element
+}
+
+// jsonLinePaths returns, for each line of comment-free JSON, the path of the
+// first token on it: "$.key" for a key or value, "$.list[2]" for an array
+// element, and the container's path plus "}" or "]" for a closing line.
+// Lines without tokens get "".
+func jsonLinePaths(lines []string) []string {
+	var stack []*jsonFrame
+	path := func(frames []*jsonFrame) string {
+		var sb strings.Builder
+		sb.WriteString("$")
+		for _, f := range frames {
+			if f.array {
+				sb.WriteString("[" + strconv.Itoa(f.index) + "]")
+			} else if f.key != "" {
+				sb.WriteString("." + f.key)
+			}
+		}
+		return sb.String()
+	}
+
+	paths := make([]string, len(lines))
+	for n, line := range lines {
+		mark := func(p string) {
+			if paths[n] == "" {
+				paths[n] = p
+			}
+		}
+		for i := 0; i < len(line); i++ {
+			var top *jsonFrame
+			if len(stack) > 0 {
+				top = stack[len(stack)-1]
+			}
+			switch c := line[i]; c {
+			case ' ', '\t', '\r', ':':
+			case '"':
+				j := i + 1
+				for j < len(line) && line[j] != '"' {
+					if line[j] == '\\' {
+						j++
+					}
+					j++
+				}
+				if top != nil && !top.array && top.expectKey {
+					key, err := strconv.Unquote(line[i:min(j+1, len(line))])
+					if err != nil {
+						key = line[i+1 : min(j, len(line))]
+					}
+					top.key = key
+					top.expectKey = false
+				}
+				mark(path(stack))
+				i = j
+			case '{', '[':
+				mark(path(stack))
+				stack = append(stack, &jsonFrame{array: c == '[', expectKey: c == '{'})
+			case '}', ']':
+				if top != nil {
+					mark(path(stack[:len(stack)-1]) + string(c))
+					stack = stack[:len(stack)-1]
+				}
+			case ',':
+				if top != nil {
+					if top.array {
+						top.index++
+					} else {
+						top.expectKey = true
+					}
+				}
+			default:
+				mark(path(stack))
+			}
+		}
+	}
+	return paths
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSavePreservesComments(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.jsonc")
+
+	configData := `// Homelab Horizon config
+{
+  // Where the admin UI listens
+  "listen_addr": ":9090",
+  "wg_interface": "testwg", // must match /etc/wireguard/<name>.conf
+  "upstream_dns": [
+    // Quad9 first
+    "9.9.9.9",
+    "1.1.1.1"
+  ],
+  // Removed keys lose their comments
+  "kiosk_url": "https://kiosk.example.com"
+}
+// end of file
+`
+	os.WriteFile(configPath, []byte(configData), 0644)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg.ListenAddr = ":7070"
+	cfg.UpstreamDNS = append(cfg.UpstreamDNS, "8.8.8.8")
+	if err := Save(configPath, cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(configPath)
+	saved := string(data)
+	wants := []string{
+		"// Homelab Horizon config\n{",
+		"  // Where the admin UI listens\n  \"listen_addr\": \":7070\",",
+		`"wg_interface": "testwg", // must match /etc/wireguard/<name>.conf`,
+		"    // Quad9 first\n    \"9.9.9.9\",",
+		"\n// end of file\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(saved, want) {
+			t.Errorf("Saved config missing %q:\n%s", want, saved)
+		}
+	}
+
+	reloaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Reloading saved config failed: %v\n%s", err, saved)
+	}
+	if reloaded.ListenAddr != ":7070" || len(reloaded.UpstreamDNS) != 3 {
+		t.Errorf("Reloaded config lost changes: %+v", reloaded)
+	}
+}
+
+func TestSavePlainJSONHasNoComments(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(configPath, []byte(`{"url": "http://example.com"}`), 0644)
+
+	if err := Save(configPath, Default()); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if hasComments(data) {
+		t.Errorf("Expected plain JSON output, got comments:\n%s", data)
+	}
+}
+
+func TestSaveTemplateRoundTrip(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.jsonc")
+	template, err := Template()
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+	os.WriteFile(configPath, template, 0644)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load(Template) error = %v", err)
+	}
+	if err := Save(configPath, cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := Load(configPath); err != nil {
+		t.Fatalf("Reloading saved template failed: %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(data), "// Issue certificates with Let's Encrypt") {
+		t.Errorf("Expected template comments to survive Save:\n%s", data)
+	}
+}
+
+func TestJSONLinePaths(t *testing.T) {
+	lines := strings.Split(`{
+  "a": 1,
+  "b": {
+    "c": [
+      "x",
+      {"d": true}
+    ]
+  }
+}`, "\n")
+	want := []string{"$", "$.a", "$.b", "$.b.c", "$.b.c[0]", "$.b.c[1]", "$.b.c]", "$.b}", "$}"}
+	got := jsonLinePaths(lines)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d (%q): path = %q, want %q", i, lines[i], got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Merge layers other on top of c: every field that is non-zero in other
+// replaces the one in c, and zero-valued fields leave c alone. This is what
+// a base config plus a per-host override needs.
+//
+// Fields are replaced whole, never merged recursively: a non-nil slice, map
+// or pointer in other wins outright, so an explicit empty list ([] in JSON)
+// clears the base value. The flip side of "zero means unset" is that an
+// override can't set a bool back to false or a number back to 0.
+//
+// other should be decoded into a zero Config rather than via LoadFromJSON,
+// or the defaults filled in there would override everything in c.
+func (c *Config) Merge(other *Config) {
+	if other == nil {
+		return
+	}
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := range src.NumField() {
+		if f := src.Field(i); !f.IsZero() && dst.Field(i).CanSet() {
+			dst.Field(i).Set(f)
+		}
+	}
+}
+
+// FragmentDir returns the conf.d-style directory of fragments Load merges
+// over the config at path: path with its extension replaced by ".d", e.g.
+// /etc/homelab-horizon/config.d for /etc/homelab-horizon/config.json.
+func FragmentDir(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".d"
+}
+
+// loadFragments merges every *.json and *.jsonc file in dir onto cfg in
+// lexical order. Each fragment is decoded into a zero Config, so it only
+// overrides what it mentions. A missing dir is not an error.
+func loadFragments(cfg *Config, dir string) error {
+	entries, err := os.ReadDir(dir) // sorted by filename
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading config fragments: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if ext := filepath.Ext(e.Name()); ext != ".json" && ext != ".jsonc" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data, err = Migrate(stripJSONCComments(data))
+		if err != nil {
+			return fmt.Errorf("parsing config fragment %s: %w", path, err)
+		}
+		var frag Config
+		if err := json.Unmarshal(data, &frag); err != nil {
+			return fmt.Errorf("parsing config fragment %s: %w", path, err)
+		}
+		cfg.Merge(&frag)
+	}
+	return nil
+}
+
+// fragmentLayer records what loadFragments changed, so Save can write the
+// base file back without the fragments folded into it. For every top-level
+// field a fragment overrode it keeps the base file's value and the JSON of
+// the merged value Load returned.
+type fragmentLayer struct {
+	base   map[int]reflect.Value
+	merged map[int][]byte
+}
+
+// newFragmentLayer diffs base (the config before fragments) against merged.
+// It returns nil when the fragments changed nothing.
+func newFragmentLayer(base, merged *Config) *fragmentLayer {
+	var layer *fragmentLayer
+	b := reflect.ValueOf(base).Elem()
+	m := reflect.ValueOf(merged).Elem()
+	for i := range m.NumField() {
+		if !m.Field(i).CanInterface() || reflect.DeepEqual(b.Field(i).Interface(), m.Field(i).Interface()) {
+			continue
+		}
+		data, err := json.Marshal(m.Field(i).Interface())
+		if err != nil {
+			continue
+		}
+		if layer == nil {
+			layer = &fragmentLayer{base: map[int]reflect.Value{}, merged: map[int][]byte{}}
+		}
+		layer.base[i] = b.Field(i)
+		layer.merged[i] = data
+	}
+	return layer
+}
+
+// restoreBase puts the base file's value back into every field of cfg that
+// still holds what a fragment set. Fields edited since Load keep the edit.
+func (l *fragmentLayer) restoreBase(cfg *Config) {
+	if l == nil {
+		return
+	}
+	v := reflect.ValueOf(cfg).Elem()
+	for i, base := range l.base {
+		data, err := json.Marshal(v.Field(i).Interface())
+		if err == nil && string(data) == string(l.merged[i]) {
+			v.Field(i).Set(base)
+		}
+	}
+}
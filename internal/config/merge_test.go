@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := Default()
+	base.Zones = []Zone{{Name: "example.com", ZoneID: "Z1"}}
+	base.UpstreamDNS = []string{"1.1.1.1"}
+
+	var override Config
+	if err := json.Unmarshal([]byte(`{
+		"listen_addr": ":9090",
+		"upstream_dns": ["9.9.9.9"],
+		"zones": [],
+		"dnsmasq_enabled": false
+	}`), &override); err != nil {
+		t.Fatal(err)
+	}
+
+	base.Merge(&override)
+
+	if base.ListenAddr != ":9090" {
+		t.Errorf("ListenAddr = %q, want the override", base.ListenAddr)
+	}
+	if base.WGInterface != "wg0" {
+		t.Errorf("WGInterface = %q, unset fields should keep the base value", base.WGInterface)
+	}
+	if len(base.UpstreamDNS) != 1 || base.UpstreamDNS[0] != "9.9.9.9" {
+		t.Errorf("UpstreamDNS = %v, want the override list", base.UpstreamDNS)
+	}
+	if base.Zones == nil || len(base.Zones) != 0 {
+		t.Errorf("Zones = %v, an explicit [] should clear the base list", base.Zones)
+	}
+	// false is the zero value, so it can't switch a bool off.
+	if !base.DNSMasqEnabled {
+		t.Error("DNSMasqEnabled was overridden by a zero value")
+	}
+
+	before := *base
+	base.Merge(nil)
+	if base.ListenAddr != before.ListenAddr {
+		t.Error("Merge(nil) changed the config")
+	}
+}
+
+func TestLoadFragments(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+	fragDir := filepath.Join(tmpDir, "config.d")
+	os.WriteFile(configPath, []byte(`{"listen_addr": ":9000", "wg_interface": "wg1", "dns": "10.100.0.1"}`), 0644)
+	os.MkdirAll(filepath.Join(fragDir, "nested.json"), 0755)
+	os.WriteFile(filepath.Join(fragDir, "10-net.json"), []byte(`{"wg_interface": "wg2", "vpn_range": "10.50.0.0/24"}`), 0644)
+	os.WriteFile(filepath.Join(fragDir, "20-acme.jsonc"), []byte("{\n  // later files win\n  \"wg_interface\": \"wg3\"\n}\n"), 0644)
+	os.WriteFile(filepath.Join(fragDir, "README.txt"), []byte(`not json`), 0644)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("ListenAddr = %q, want the base file value", cfg.ListenAddr)
+	}
+	if cfg.VPNRange != "10.50.0.0/24" {
+		t.Errorf("VPNRange = %q, want the 10-net.json value", cfg.VPNRange)
+	}
+	if cfg.WGInterface != "wg3" {
+		t.Errorf("WGInterface = %q, want the last fragment to win", cfg.WGInterface)
+	}
+	if cfg.WGConfigPath != "/etc/wireguard/wg0.conf" {
+		t.Errorf("WGConfigPath = %q, want the default", cfg.WGConfigPath)
+	}
+
+	os.WriteFile(filepath.Join(fragDir, "30-bad.json"), []byte(`{"vpn_range": "nope"}`), 0644)
+	if _, err := Load(configPath); err == nil {
+		t.Error("Expected a fragment's invalid vpn_range to be rejected")
+	}
+	os.WriteFile(filepath.Join(fragDir, "30-bad.json"), []byte(`{`), 0644)
+	if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), "30-bad.json") {
+		t.Errorf("Load() error = %v, want it to name the bad fragment", err)
+	}
+}
+
+func TestSaveKeepsFragmentsOutOfBase(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+	fragDir := filepath.Join(tmpDir, "config.d")
+	os.WriteFile(configPath, []byte(`{"listen_addr": ":9000", "wg_interface": "wg1"}`), 0644)
+	os.MkdirAll(fragDir, 0755)
+	os.WriteFile(filepath.Join(fragDir, "10-secrets.json"), []byte(`{"admin_token": "frag-secret", "wg_interface": "wg2"}`), 0600)
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	cfg.ListenAddr = ":9100"
+	if err := Save(configPath, cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "frag-secret") {
+		t.Errorf("Save() wrote the fragment's admin_token into the base file:\n%s", data)
+	}
+	if strings.Contains(string(data), "wg2") || !strings.Contains(string(data), `"wg1"`) {
+		t.Errorf("Save() should keep the base wg_interface:\n%s", data)
+	}
+
+	reloaded, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() after Save error = %v", err)
+	}
+	if reloaded.ListenAddr != ":9100" {
+		t.Errorf("ListenAddr = %q, want the edit saved", reloaded.ListenAddr)
+	}
+	if reloaded.AdminToken != "frag-secret" || reloaded.WGInterface != "wg2" {
+		t.Errorf("AdminToken, WGInterface = %q, %q, want the fragment values", reloaded.AdminToken, reloaded.WGInterface)
+	}
+}
+
+func TestFindFragmentDirOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+	os.MkdirAll(filepath.Join(tmpDir, "config.d"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "config.d", "10-net.json"), []byte(`{"listen_addr": ":7000"}`), 0644)
+
+	originalSearchPaths := SearchPaths
+	SearchPaths = []string{"/non/existent/config.json", configPath}
+	defer func() { SearchPaths = originalSearchPaths }()
+
+	path, found := Find()
+	if !found || path != configPath {
+		t.Fatalf("Find() = %q, %v; want %q via its fragment directory", path, found, configPath)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.ListenAddr != ":7000" {
+		t.Errorf("ListenAddr = %q, want the fragment value over defaults", cfg.ListenAddr)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CurrentVersion is the config schema version this build reads and writes.
+// It must equal len(migrations).
+const CurrentVersion = 1
+
+// migration upgrades a decoded config object by one schema version, in place.
+type migration func(raw map[string]json.RawMessage) error
+
+// migrations[i] upgrades version i to i+1. Append only: files in the wild are
+// at every earlier version.
+var migrations = []migration{
+	migrateServiceDomain, // 0 -> 1
+}
+
+// Migrate upgrades comment-free config JSON to CurrentVersion, applying each
+// pending migration in order and stamping the result with the new version.
+// A missing "version" key means 0, the oldest schema. Files from a newer
+// build are rejected rather than silently losing fields.
+func Migrate(data []byte) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		// A bare null decodes without error but leaves raw nil; treat it as
+		// an empty object so it loads as the defaults.
+		raw = make(map[string]json.RawMessage)
+	}
+	var version int
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("invalid config version: %w", err)
+		}
+	}
+	switch {
+	case version > CurrentVersion:
+		return nil, fmt.Errorf("config version %d is newer than this build supports (%d)", version, CurrentVersion)
+	case version == CurrentVersion:
+		return data, nil
+	}
+
+	for ; version < CurrentVersion; version++ {
+		if err := migrations[version](raw); err != nil {
+			return nil, fmt.Errorf("migrating config from version %d: %w", version, err)
+		}
+	}
+	v, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	raw["version"] = v
+	return json.Marshal(raw)
+}
+
+// migrateServiceDomain folds each service's single "domain" (schema 0) into
+// its "domains" list. Service.UnmarshalJSON still accepts the old key for API
+// payloads, but files are upgraded so Save stops round-tripping it.
+func migrateServiceDomain(raw map[string]json.RawMessage) error {
+	data, ok := raw["services"]
+	if !ok {
+		return nil
+	}
+	var services []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &services); err != nil || services == nil {
+		return err
+	}
+	for _, svc := range services {
+		domain, ok := svc["domain"]
+		if !ok {
+			continue
+		}
+		delete(svc, "domain")
+		var existing []string
+		if d, ok := svc["domains"]; ok {
+			if err := json.Unmarshal(d, &existing); err != nil {
+				return fmt.Errorf("service domains: %w", err)
+			}
+		}
+		var single string
+		if err := json.Unmarshal(domain, &single); err != nil {
+			return fmt.Errorf("service domain: %w", err)
+		}
+		if len(existing) > 0 || single == "" {
+			continue
+		}
+		d, err := json.Marshal([]string{single})
+		if err != nil {
+			return err
+		}
+		svc["domains"] = d
+	}
+	out, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	raw["services"] = out
+	return nil
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsMatchCurrentVersion(t *testing.T) {
+	if len(migrations) != CurrentVersion {
+		t.Fatalf("len(migrations) = %d, CurrentVersion = %d", len(migrations), CurrentVersion)
+	}
+}
+
+func TestLoadMigratesUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	legacy := `{
+  "services": [
+    {"name": "app", "domain": "app.example.com"},
+    {"name": "both", "domain": "old.example.com", "domains": ["new.example.com"]}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("Version = %d, want %d", cfg.Version, CurrentVersion)
+	}
+	if got := cfg.Services[0].Domains; len(got) != 1 || got[0] != "app.example.com" {
+		t.Errorf("services[0].Domains = %v, want [app.example.com]", got)
+	}
+	if got := cfg.Services[1].Domains; len(got) != 1 || got[0] != "new.example.com" {
+		t.Errorf("services[1].Domains = %v, want [new.example.com]", got)
+	}
+
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"version": 1`) {
+		t.Errorf("saved config has no current version:\n%s", data)
+	}
+	if strings.Contains(string(data), `"domain":`) {
+		t.Errorf("saved config still has legacy domain key:\n%s", data)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "current version is untouched",
+			input: `{"version": 1, "services": [{"domain": "x"}]}`,
+			want:  `{"version": 1, "services": [{"domain": "x"}]}`,
+		},
+		{
+			name:  "unversioned is stamped",
+			input: `{"listen_addr": ":9090"}`,
+			want:  `{"listen_addr":":9090","version":1}`,
+		},
+		{
+			name:    "newer version is rejected",
+			input:   `{"version": 99}`,
+			wantErr: "newer than this build",
+		},
+		{
+			name:    "non-numeric version",
+			input:   `{"version": "one"}`,
+			wantErr: "invalid config version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Migrate([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Migrate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Migrate() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFromJSONNull(t *testing.T) {
+	cfg, err := LoadFromJSON([]byte("null"))
+	if err != nil {
+		t.Fatalf("LoadFromJSON(null) error = %v", err)
+	}
+	if cfg.WGInterface != Default().WGInterface {
+		t.Errorf("WGInterface = %q, want the default %q", cfg.WGInterface, Default().WGInterface)
+	}
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// fieldDocs describes each top-level config key for Template and
+// AnnotatedTemplate. Every json field of Config must have an entry;
+// TestFieldDocsComplete enforces it.
+var fieldDocs = map[string]string{
+	"version": "Config schema version; older files are migrated on load",
+
+	"listen_addr": "HTTP server listen address",
+	"admin_token": "Legacy admin token; migrated to the token file on start and cleared",
+	"kiosk_url":   "Public URL of this kiosk (for invite QR codes)",
+	"admin_url":   "Canonical base URL for integration snippets. Empty = self-service domain or request host",
+
+	"wg_interface":      "WireGuard interface name",
+	"wg_config_path":    "Path to WireGuard configuration file",
+	"invites_file":      "File holding outstanding invite tokens",
+	"server_endpoint":   "Public host[:port] clients use to reach WireGuard. No port = wg ListenPort, empty = LAN IP",
+	"server_public_key": "WireGuard server public key. Empty = read from wg_config_path",
+	"vpn_range":         "VPN client address range (CIDR)",
+	"dns":               "DNS server pushed to VPN clients",
+	"allowed_ips":       "AllowedIPs for client configs. Empty = VPN range + local network",
+
+	"zones": "DNS zones and their providers / SSL settings.\n" +
+		"records are static records hz publishes on DNS sync (verification, SPF, DKIM, ...).\n" +
+		"hz owns each (name, type) set: it publishes exactly these values and replaces any\n" +
+		"others at that name/type. Name may be an FQDN, the zone apex (\"@\"), or a relative label",
+	"services": "Services routed through DNS and HAProxy. Each one configures internal DNS\n" +
+		"(dnsmasq), external DNS (the zone's provider) and the reverse proxy (HAProxy)",
+
+	"public_ip":              "Cached auto-detected public IP. Managed by hz",
+	"public_ip_override":     "Static public IP used instead of auto-detection",
+	"public_ip_last_checked": "Unix time of the last public IP detection. Managed by hz",
+	"public_ip_interval":     "Seconds between public IP checks (0 = disabled)",
+	"public_ip_max_age":      "Seconds before a cached public IP is too stale to publish (0 = 3600)",
+
+	"dnsmasq_enabled":         "Manage dnsmasq for VPN DNS",
+	"dnsmasq_config_path":     "Path to the generated dnsmasq config",
+	"dnsmasq_hosts_path":      "Path to the generated dnsmasq hosts file",
+	"dnsmasq_peer_hosts_path": "Path to the addn-hosts file of WireGuard peer names. Empty = off",
+	"dnsmasq_interfaces":      "Additional interfaces for dnsmasq (beyond the WireGuard interface)",
+	"upstream_dns":            "Upstream DNS servers for dnsmasq",
+	"local_interface":         "Local interface IP for localhost-bound services. Empty = auto-detect",
+	"local_interface_name":    "NIC to detect local_interface from (e.g. \"eth0\"). Empty = default route",
+
+	"last_local_iface":       "Interface the last interface sync reconciled against. Managed by hz",
+	"last_lan_cidr":          "LAN CIDR the last interface sync reconciled against. Managed by hz",
+	"blessed_iptables_rules": "External iptables rules the reconciler leaves alone",
+	"last_published_records": "Records last published to DNS providers. Managed by hz",
+	"dns_drift_blocked":      "DNS publishing halted after out-of-band provider changes. Managed by hz",
+	"dns_drift_detail":       "The drift that set dns_drift_blocked. Managed by hz",
+
+	"haproxy_enabled":     "Manage HAProxy as the reverse proxy",
+	"haproxy_config_path": "Path to the generated HAProxy config",
+	"haproxy_http_port":   "HAProxy HTTP port",
+	"haproxy_https_port":  "HAProxy HTTPS port",
+	"static_serve_port":   "Loopback port for static-folder services",
+
+	"ssl_enabled":            "Issue certificates with Let's Encrypt",
+	"ssl_cert_dir":           "Directory for issued certificates",
+	"ssl_haproxy_cert_dir":   "Directory for HAProxy certificate bundles",
+	"ssl_ca_directory_url":   "ACME directory URL. Empty = Let's Encrypt production",
+	"ssl_post_issue_command": "Shell command run after each issuance or renewal",
+	"ssl_post_issue_webhook": "URL that receives a JSON POST after each issuance or renewal",
+	"ssl_dns_resolvers":      "Nameservers used to check DNS-01 propagation",
+
+	"ntfy_url":             "ntfy topic URL for service alerts",
+	"service_checks":       "Health checks for services",
+	"disabled_auto_checks": "Names of disabled auto-generated checks",
+
+	"vpn_admins":        "VPN client names with admin access via their VPN IP",
+	"vpn_profiles":      "Per-peer routing profile: lan-access (default), full-tunnel or vpn-only",
+	"vpn_mfa_enabled":   "Require TOTP per connect for VPN clients",
+	"vpn_mfa_durations": "Session lengths offered at MFA login, e.g. [\"2h\", \"8h\", \"forever\"]",
+	"vpn_mfa_secrets":   "Peer name -> base32 TOTP secret",
+	"vpn_mfa_sessions":  "Peer name -> session expiry unix time (0 = forever). Managed by hz",
+
+	"wg_peers":  "WireGuard peers, replicated across the fleet. Managed by hz",
+	"ip_bans":   "Banned client IPs",
+	"auto_heal": "Install and configure missing dependencies on startup",
+
+	"peer_id":        "Identity of this instance within the fleet",
+	"config_primary": "This instance is the fleet config primary",
+	"peers":          "Every other instance in the fleet",
+
+	"hosts":             "Declared hosts beyond those derived from the port map",
+	"exporters":         "Prometheus exporter scrape jobs",
+	"scrape_exclusions": "IPs or CIDRs never emitted as scrape targets",
+	"port_exclusions":   "Extra port ranges skipped by port allocation",
+	"scrape_token":      "Read-only bearer for Prometheus discovery. Generated on first use",
+}
+
+// templateSections are headings rendered above the key that opens each
+// group of fields.
+var templateSections = map[string]string{
+	"wg_interface":    "=== LAYER 1: VPN CLIENTS (WireGuard) ===",
+	"zones":           "=== LAYER 2: SERVICES (Split-Horizon DNS) ===",
+	"dnsmasq_enabled": "=== SUBSYSTEM SETTINGS ===",
+	"peer_id":         "=== FLEET ===",
+}
+
+// Template returns the AnnotatedTemplate layout with an example zone and
+// services filled in and HAProxy and SSL turned on, for users to copy from.
+func Template() ([]byte, error) {
+	cfg := Default()
+	cfg.Zones = []Zone{{
+		Name:   "example.com",
+		ZoneID: "Z1234567890ABC",
+		DNSProvider: &DNSProviderConfig{
+			Type:       DNSProviderRoute53,
+			AWSProfile: "default",
+		},
+		SSL: &ZoneSSL{Enabled: true, Email: "admin@example.com"},
+		Records: []DNSRecord{
+			{Name: "app.example.com", Type: "TXT", Value: "google-site-verification=XXdummyTokenXX", TTL: 3600},
+		},
+	}}
+	cfg.Services = []Service{
+		{
+			Name:        "grafana",
+			Domains:     []string{"grafana.example.com"},
+			InternalDNS: &InternalDNS{IP: "10.100.0.1"},
+			ExternalDNS: &ExternalDNS{TTL: 300},
+			Proxy: &ProxyConfig{
+				Backend:     "10.100.0.50:3000",
+				HealthCheck: &HealthCheck{Path: "/api/health"},
+			},
+		},
+		{
+			Name:        "internal-db",
+			Domains:     []string{"db.example.com"},
+			InternalDNS: &InternalDNS{IP: "10.100.0.100"},
+		},
+	}
+	cfg.HAProxyEnabled = true
+	cfg.SSLEnabled = true
+	return renderTemplate(cfg)
+}
+
+// AnnotatedTemplate renders every Config field at its Default() value as
+// JSONC, each key preceded by a // comment from fieldDocs. It is generated,
+// so it always lists the current set of fields — including omitempty ones a
+// plain marshal would drop.
+func AnnotatedTemplate() ([]byte, error) {
+	return renderTemplate(Default())
+}
+
+// renderTemplate writes cfg's fields in struct order, with the headings from
+// templateSections and the comments from fieldDocs.
+func renderTemplate(cfg *Config) ([]byte, error) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	first := true
+	for i := 0; i < t.NumField(); i++ {
+		key := jsonFieldName(t.Field(i))
+		if key == "" {
+			continue
+		}
+		value, err := json.MarshalIndent(v.Field(i).Interface(), "  ", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("marshalling %s: %w", key, err)
+		}
+		if !first {
+			buf.WriteString(",\n\n")
+		}
+		first = false
+		if section := templateSections[key]; section != "" {
+			buf.WriteString("  // " + section + "\n\n")
+		}
+		if doc := fieldDocs[key]; doc != "" {
+			for _, line := range strings.Split(doc, "\n") {
+				buf.WriteString("  // " + line + "\n")
+			}
+		}
+		fmt.Fprintf(&buf, "  %q: %s", key, value)
+	}
+	buf.WriteString("\n}\n")
+	return buf.Bytes(), nil
+}
+
+// WriteTemplate writes AnnotatedTemplate to path, creating parent directories
+// as Save does. It refuses to overwrite an existing file.
+func WriteTemplate(path string) error {
+	data, err := AnnotatedTemplate()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating config directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// jsonFieldName returns the json key of a struct field, or "" if it is
+// unexported or tagged "-".
+func jsonFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFieldDocsComplete(t *testing.T) {
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		if key := jsonFieldName(typ.Field(i)); key != "" && fieldDocs[key] == "" {
+			t.Errorf("fieldDocs has no entry for %q", key)
+		}
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf", "config.jsonc")
+	if err := WriteTemplate(path); err != nil {
+		t.Fatalf("WriteTemplate() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	for _, want := range []string{
+		"  // HTTP server listen address\n  \"listen_addr\": \":8080\",",
+		// omitempty fields are still listed
+		"\"scrape_token\": \"\"",
+		"\"vpn_mfa_enabled\": false",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("template missing %q:\n%s", want, text)
+		}
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(template) error = %v\n%s", err, text)
+	}
+	want := Default()
+	want.path = path
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("template loads as %+v, want Default() %+v", cfg, want)
+	}
+
+	if err := WriteTemplate(path); err == nil {
+		t.Error("WriteTemplate() overwrote an existing file")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	data, err := Template()
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+	annotated, err := AnnotatedTemplate()
+	if err != nil {
+		t.Fatalf("AnnotatedTemplate() error = %v", err)
+	}
+	// Both come from fieldDocs and templateSections.
+	for _, want := range []string{
+		"  // === LAYER 2: SERVICES (Split-Horizon DNS) ===\n\n  // DNS zones",
+		"  // HTTP server listen address\n  \"listen_addr\": \":8080\",",
+	} {
+		if !strings.Contains(string(data), want) || !strings.Contains(string(annotated), want) {
+			t.Errorf("templates missing %q", want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "config.jsonc")
+	os.WriteFile(path, data, 0600)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(Template) error = %v\n%s", err, data)
+	}
+	if len(cfg.Zones) != 1 || cfg.Zones[0].DNSProvider == nil || len(cfg.Services) != 2 || !cfg.SSLEnabled {
+		t.Errorf("Expected the example zone, services and SSL, got %+v", cfg)
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Validate checks the core server and VPN settings for values that would
+// only fail later, when the server binds its listener or brings up
+// WireGuard. Every problem is reported in one joined error so the user can
+// fix them in a single pass.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := validateListenAddr(c.ListenAddr); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateVPNRange(c.VPNRange); err != nil {
+		errs = append(errs, err)
+	}
+	if c.LocalInterface != "" && net.ParseIP(c.LocalInterface) == nil {
+		errs = append(errs, fmt.Errorf("invalid local_interface %q: must be an IP address", c.LocalInterface))
+	}
+	for _, cidr := range strings.Split(c.AllowedIPs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid allowed_ips entry %q: must be a CIDR", cidr))
+		}
+	}
+	for _, r := range c.SSLDNSResolvers {
+		if err := validateResolver(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateListenAddr requires a host:port with a numeric port. The host may
+// be empty to listen on all interfaces.
+func validateListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen_addr %q: must be host:port such as :8080", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid listen_addr %q: port must be 0-65535", addr)
+	}
+	return nil
+}
+
+// validateResolver accepts an IP address with an optional port. Hostnames
+// are refused: looking one up would go through the very resolver the
+// setting is meant to bypass.
+func validateResolver(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid ssl_dns_resolvers entry %q: must be an IP address, optionally with :port", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid ssl_dns_resolvers entry %q: port must be 1-65535", addr)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr []string
+	}{
+		{"valid explicit", func(c *Config) {
+			c.ListenAddr = "127.0.0.1:9090"
+			c.LocalInterface = "192.168.1.10"
+			c.AllowedIPs = "10.100.0.0/24, 192.168.1.0/24"
+		}, nil},
+		{"ipv6 listen", func(c *Config) { c.ListenAddr = "[::1]:8080" }, nil},
+		{"dns resolvers", func(c *Config) {
+			c.SSLDNSResolvers = []string{"1.1.1.1:53", "8.8.8.8", "2606:4700::1111", "[2606:4700::1111]:5353"}
+		}, nil},
+		{"hostname resolver", func(c *Config) { c.SSLDNSResolvers = []string{"one.one.one.one:53"} }, []string{"ssl_dns_resolvers"}},
+		{"resolver bad port", func(c *Config) { c.SSLDNSResolvers = []string{"1.1.1.1:0"} }, []string{"ssl_dns_resolvers"}},
+		{"missing port", func(c *Config) { c.ListenAddr = "localhost" }, []string{"listen_addr"}},
+		{"bad port", func(c *Config) { c.ListenAddr = ":http" }, []string{"listen_addr"}},
+		{"port out of range", func(c *Config) { c.ListenAddr = ":70000" }, []string{"listen_addr"}},
+		{"bad vpn range", func(c *Config) { c.VPNRange = "10.100.0.0" }, []string{"vpn_range"}},
+		{"bad local interface", func(c *Config) { c.LocalInterface = "eth0" }, []string{"local_interface"}},
+		{"bad allowed ip", func(c *Config) { c.AllowedIPs = "10.0.0.0/8,192.168.1.1" }, []string{`"192.168.1.1"`}},
+		{"all at once", func(c *Config) {
+			c.ListenAddr = "nope"
+			c.VPNRange = "nope"
+			c.LocalInterface = "nope"
+			c.AllowedIPs = "nope"
+		}, []string{"listen_addr", "vpn_range", "local_interface", "allowed_ips"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want errors mentioning %v", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce coalesces the burst of events an editor produces for one
+// save (truncate+write, or write-temp+rename) into a single reload.
+const watchDebounce = 250 * time.Millisecond
+
+// Watch reloads the file c was loaded from whenever it changes and passes
+// the fresh config to onChange. A file that fails to parse is logged and
+// skipped, so the caller keeps running on its current config. Watch blocks
+// until ctx is cancelled; it fails straight away when c didn't come from
+// Load.
+//
+// The parent directory is watched rather than the file itself, so the watch
+// survives editors and atomic writers that replace the file via rename.
+func (c *Config) Watch(ctx context.Context, onChange func(*Config)) error {
+	if c.path == "" {
+		return errors.New("config was not loaded from a file")
+	}
+	return watch(ctx, c.path, func(cfg *Config, err error) {
+		if err != nil {
+			slog.Warn("config reload failed, keeping the current config", "err", err)
+			return
+		}
+		onChange(cfg)
+	})
+}
+
+// watch implements Watch. Reload failures, and errors from the watcher
+// itself, come through as onChange(nil, err).
+func watch(ctx context.Context, path string, onChange func(*Config, error)) error {
+	path = filepath.Clean(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating config watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("watching %s: %w", filepath.Dir(path), err)
+	}
+
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			onChange(nil, fmt.Errorf("watching config: %w", err))
+
+		case <-timer.C:
+			// Load falls back to defaults for a missing file; mid-replace
+			// or deleted, that would silently reset everything.
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				onChange(nil, fmt.Errorf("reloading %s: %w", path, err))
+				continue
+			}
+			onChange(cfg, nil)
+		}
+	}
+}
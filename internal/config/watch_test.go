@@ -0,0 +1,124 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeUntil rewrites path with data until results yields a value, since the
+// first write can land before the watch is registered. Each attempt waits
+// out the debounce so repeated writes don't keep postponing the reload.
+func writeUntil[T any](t *testing.T, path, data string, results <-chan T) T {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		os.WriteFile(path, []byte(data), 0644)
+		select {
+		case r := <-results:
+			return r
+		case <-time.After(4 * watchDebounce):
+		case <-deadline:
+			t.Fatalf("Timed out waiting for the watcher after writing %s", data)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte(`{"listen_addr": ":8080"}`), 0644)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan *Config, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- cfg.Watch(ctx, func(c *Config) { results <- c })
+	}()
+
+	if got := writeUntil(t, path, `{"listen_addr": ":9090"}`, results); got.ListenAddr != ":9090" {
+		t.Errorf("Expected reloaded listen addr :9090, got %s", got.ListenAddr)
+	}
+
+	// Two quick writes should coalesce into one reload of the final content.
+	os.WriteFile(path, []byte(`{"listen_addr": ":9191"}`), 0644)
+	os.WriteFile(path, []byte(`{"listen_addr": ":9292"}`), 0644)
+	select {
+	case got := <-results:
+		if got.ListenAddr != ":9292" {
+			t.Errorf("Expected reloaded listen addr :9292, got %s", got.ListenAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for reload")
+	}
+	select {
+	case got := <-results:
+		t.Errorf("Expected writes to be debounced into one reload, got extra %+v", got)
+	case <-time.After(2 * watchDebounce):
+	}
+
+	// A broken file is skipped: the caller keeps its config.
+	os.WriteFile(path, []byte(`{"listen_addr": `), 0644)
+	select {
+	case got := <-results:
+		t.Errorf("Expected a broken file not to be passed on, got %+v", got)
+	case <-time.After(4 * watchDebounce):
+	}
+
+	// Replacing via rename, as atomic writers do, is picked up too.
+	tmp := filepath.Join(dir, ".config.json.tmp")
+	os.WriteFile(tmp, []byte(`{"listen_addr": ":7070"}`), 0644)
+	os.Rename(tmp, path)
+	select {
+	case got := <-results:
+		if got.ListenAddr != ":7070" {
+			t.Errorf("Expected reload after rename, got %s", got.ListenAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for reload after rename")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Watch() returned %v after cancel", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
+
+func TestWatchReportsReloadErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{}`), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 10)
+	go watch(ctx, path, func(cfg *Config, err error) {
+		if cfg != nil {
+			t.Errorf("Expected no config alongside an error, got %+v", cfg)
+		}
+		errs <- err
+	})
+
+	if err := writeUntil(t, path, `{"listen_addr": `, errs); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
+
+func TestWatchNeedsLoadedConfig(t *testing.T) {
+	if err := Default().Watch(context.Background(), func(*Config) {}); err == nil {
+		t.Error("Expected Watch on a config not read from a file to fail")
+	}
+}
@@ -1,33 +1,70 @@
 package dnsmasq
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+	"github.com/iodesystems/homelab-horizon/internal/wireguard"
 )
 
 type DNSMasq struct {
 	mu         sync.Mutex
 	configPath string
 	hostsPath  string
+	peerHosts  string
 	interfaces []string
 	upstream   []string
+
+	fs     system.FileSystem
+	runner system.CommandRunner
+}
+
+// Option configures a DNSMasq built by New.
+type Option func(*DNSMasq)
+
+// WithFileSystem makes the DNSMasq read and write its config, hosts file
+// and service unit through fs instead of the real filesystem.
+func WithFileSystem(fs system.FileSystem) Option {
+	return func(d *DNSMasq) { d.fs = fs }
+}
+
+// WithCommandRunner makes the DNSMasq run systemctl and friends through
+// runner. A system.DryRunCommandRunner turns Start and Reload into a record
+// of the commands that would have run.
+func WithCommandRunner(runner system.CommandRunner) Option {
+	return func(d *DNSMasq) { d.runner = runner }
 }
 
-func New(configPath, hostsPath string, interfaces []string, upstream []string) *DNSMasq {
-	return &DNSMasq{
+// WithPeerHosts makes WriteConfig point dnsmasq at an addn-hosts file at
+// path, which WritePeerHosts keeps filled with the WireGuard peers' names.
+// It must live outside any conf-dir dnsmasq reads, since it is in hosts
+// format rather than config format.
+func WithPeerHosts(path string) Option {
+	return func(d *DNSMasq) { d.peerHosts = path }
+}
+
+func New(configPath, hostsPath string, interfaces []string, upstream []string, opts ...Option) *DNSMasq {
+	d := &DNSMasq{
 		configPath: configPath,
 		hostsPath:  hostsPath,
 		interfaces: interfaces,
 		upstream:   upstream,
+		fs:         &system.RealFileSystem{},
+		runner:     &system.RealCommandRunner{},
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
 }
 
 func (d *DNSMasq) WriteConfig() error {
@@ -35,7 +72,7 @@ func (d *DNSMasq) WriteConfig() error {
 	defer d.mu.Unlock()
 
 	dir := filepath.Dir(d.configPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := d.fs.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
@@ -76,37 +113,64 @@ func (d *DNSMasq) WriteConfig() error {
 		fmt.Fprintf(&config, "conf-file=%s\n", d.hostsPath)
 	}
 
-	if err := os.WriteFile(d.configPath, []byte(config.String()), 0644); err != nil {
+	// Peer names live in a hosts-format file so a SIGHUP picks them up
+	// without a restart (see WritePeerHosts). no-hosts above only skips
+	// /etc/hosts; addn-hosts files are still read.
+	if d.peerHosts != "" {
+		config.WriteString("\n# WireGuard peer names\n")
+		fmt.Fprintf(&config, "addn-hosts=%s\n", d.peerHosts)
+	}
+
+	if err := d.fs.WriteFile(d.configPath, []byte(config.String()), 0644); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 
-	if _, err := os.Stat(d.hostsPath); os.IsNotExist(err) {
-		if err := os.WriteFile(d.hostsPath, []byte("# VPN DNS mappings\n# Generated by wg-self-serve\n# Format: address=/hostname/ip\n"), 0644); err != nil {
+	if !d.fs.Exists(d.hostsPath) {
+		if err := d.fs.WriteFile(d.hostsPath, []byte("# VPN DNS mappings\n# Generated by wg-self-serve\n# Format: address=/hostname/ip\n"), 0644); err != nil {
 			return fmt.Errorf("failed to create hosts file: %w", err)
 		}
 	}
 
+	if d.peerHosts != "" && !d.fs.Exists(d.peerHosts) {
+		if err := d.fs.MkdirAll(filepath.Dir(d.peerHosts), 0755); err != nil {
+			return fmt.Errorf("failed to create peer hosts directory: %w", err)
+		}
+		if err := d.fs.WriteFile(d.peerHosts, RenderPeerHosts(nil, ""), 0644); err != nil {
+			return fmt.Errorf("failed to create peer hosts file: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// WritePeerHosts regenerates the peer hosts file set by WithPeerHosts from
+// peers and SIGHUPs dnsmasq if it changed. It does nothing when no peer
+// hosts file is configured.
+func (d *DNSMasq) WritePeerHosts(ctx context.Context, domain string, peers []wireguard.Peer) (changed bool, err error) {
+	if d.peerHosts == "" {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return WritePeerHosts(ctx, d.fs, d.runner, d.peerHosts, domain, peers)
+}
+
 func (d *DNSMasq) GetMappings() (map[string]string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	mappings := make(map[string]string)
 
-	file, err := os.Open(d.hostsPath)
+	data, err := d.fs.ReadFile(d.hostsPath)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, os.ErrNotExist) {
 			return mappings, nil
 		}
 		return nil, err
 	}
-	defer func() { _ = file.Close() }()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
@@ -122,7 +186,7 @@ func (d *DNSMasq) GetMappings() (map[string]string, error) {
 		}
 	}
 
-	return mappings, scanner.Err()
+	return mappings, nil
 }
 
 func (d *DNSMasq) SetMappings(mappings map[string]string) error {
@@ -133,11 +197,17 @@ func (d *DNSMasq) SetMappings(mappings map[string]string) error {
 	hosts.WriteString("# VPN DNS mappings\n")
 	hosts.WriteString("# Generated by wg-self-serve\n\n")
 
-	for hostname, ip := range mappings {
-		fmt.Fprintf(&hosts, "address=/%s/%s\n", hostname, ip)
+	// Sorted so the same mappings always produce the same file.
+	hostnames := make([]string, 0, len(mappings))
+	for hostname := range mappings {
+		hostnames = append(hostnames, hostname)
+	}
+	slices.Sort(hostnames)
+	for _, hostname := range hostnames {
+		fmt.Fprintf(&hosts, "address=/%s/%s\n", hostname, mappings[hostname])
 	}
 
-	if err := os.WriteFile(d.hostsPath, []byte(hosts.String()), 0644); err != nil {
+	if err := d.fs.WriteFile(d.hostsPath, []byte(hosts.String()), 0644); err != nil {
 		return fmt.Errorf("failed to write hosts file: %w", err)
 	}
 
@@ -164,18 +234,22 @@ func (d *DNSMasq) RemoveMapping(hostname string) error {
 	return d.SetMappings(mappings)
 }
 
+// Reload restarts dnsmasq so it picks up WriteConfig and SetMappings. A
+// restart rather than SIGHUP: the address= mappings live in a conf-file,
+// which dnsmasq only reads at startup. SIGHUP is reserved for the peer
+// hosts file, the one thing it does re-read (see WritePeerHosts).
 func (d *DNSMasq) Reload() error {
 	if err := d.ensureServiceUnit(); err != nil {
 		return err
 	}
-	return systemctlWithJournal("restart", "dnsmasq")
+	return d.systemctlWithJournal("restart", "dnsmasq")
 }
 
 func (d *DNSMasq) Start() error {
 	if err := d.ensureServiceUnit(); err != nil {
 		return err
 	}
-	return systemctlWithJournal("start", "dnsmasq")
+	return d.systemctlWithJournal("start", "dnsmasq")
 }
 
 // ensureServiceUnit creates dnsmasq.service if it doesn't exist.
@@ -183,11 +257,12 @@ func (d *DNSMasq) Start() error {
 // systemd-sysv-generator, which may not run when installed via
 // a transient systemd-run service.
 func (d *DNSMasq) ensureServiceUnit() error {
+	ctx := context.Background()
 	servicePath := "/etc/systemd/system/dnsmasq.service"
 
 	// If a system-provided unit exists (not ours), use it
 	existingContent := ""
-	if out, err := exec.Command("systemctl", "cat", "dnsmasq.service").CombinedOutput(); err == nil {
+	if out, err := d.runner.CombinedOutput(ctx, "systemctl", "cat", "dnsmasq.service"); err == nil {
 		if !strings.Contains(string(out), "managed by homelab-horizon") {
 			return nil
 		}
@@ -195,7 +270,7 @@ func (d *DNSMasq) ensureServiceUnit() error {
 	}
 
 	// Check dnsmasq binary exists
-	dnsmasqBin, err := exec.LookPath("dnsmasq")
+	dnsmasqBin, err := d.runner.LookPath("dnsmasq")
 	if err != nil {
 		return fmt.Errorf("dnsmasq binary not found — install it first")
 	}
@@ -219,11 +294,11 @@ WantedBy=multi-user.target
 		return nil
 	}
 
-	// Create/update our service unit
-	cmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-		"bash", "-c", fmt.Sprintf("cat > %s && systemctl daemon-reload", servicePath))
-	cmd.Stdin = strings.NewReader(serviceContent)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	// Create/update our service unit. The content goes in as an argument
+	// ($2) rather than on stdin so the command is fully described by its
+	// args — which is also what a dry run records.
+	if out, err := d.runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"bash", "-c", `printf '%s' "$2" > "$1" && systemctl daemon-reload`, "bash", servicePath, serviceContent); err != nil {
 		return fmt.Errorf("failed to create dnsmasq.service: %v — %s", err, strings.TrimSpace(string(out)))
 	}
 
@@ -233,14 +308,13 @@ WantedBy=multi-user.target
 // systemctlWithJournal runs a systemctl command and, on failure, pulls recent
 // journal lines for the unit so the caller gets a useful error message.
 // Uses systemd-run to escape ProtectSystem=strict sandbox.
-func systemctlWithJournal(action, unit string) error {
-	cmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-		"systemctl", action, unit)
-	if out, err := cmd.CombinedOutput(); err != nil {
+func (d *DNSMasq) systemctlWithJournal(action, unit string) error {
+	ctx := context.Background()
+	if out, err := d.runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"systemctl", action, unit); err != nil {
 		// Grab last 10 journal lines for context
-		journal := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		journalOut, _ := d.runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
 			"journalctl", "-u", unit, "-n", "10", "--no-pager", "-o", "cat")
-		journalOut, _ := journal.CombinedOutput()
 		detail := strings.TrimSpace(string(out))
 		if len(journalOut) > 0 {
 			detail += "\n" + strings.TrimSpace(string(journalOut))
@@ -259,28 +333,30 @@ type Status struct {
 	ConfigExists      bool
 	Error             string
 	MissingInterfaces []string // Configured interfaces not found in dnsmasq config
+	MissingPeerHosts  bool     // WithPeerHosts is set but the config has no addn-hosts line for it
 }
 
 func (d *DNSMasq) Status() Status {
 	status := Status{}
+	ctx := context.Background()
 
-	cmd := exec.Command("systemctl", "is-active", "dnsmasq")
-	if err := cmd.Run(); err == nil {
+	if err := d.runner.Run(ctx, "systemctl", "is-active", "dnsmasq"); err == nil {
 		status.Running = true
 	}
 
-	cmd = exec.Command("systemctl", "is-enabled", "dnsmasq")
-	if err := cmd.Run(); err == nil {
+	if err := d.runner.Run(ctx, "systemctl", "is-enabled", "dnsmasq"); err == nil {
 		status.Enabled = true
 	}
 
-	if _, err := os.Stat(d.configPath); err == nil {
-		status.ConfigExists = true
-	}
+	status.ConfigExists = d.fs.Exists(d.configPath)
 
 	// Check if all configured interfaces are present in the config file
 	if status.ConfigExists {
 		status.MissingInterfaces = d.checkMissingInterfaces()
+		if d.peerHosts != "" {
+			data, _ := d.fs.ReadFile(d.configPath)
+			status.MissingPeerHosts = !strings.Contains(string(data), "addn-hosts="+d.peerHosts+"\n")
+		}
 	}
 
 	return status
@@ -288,7 +364,7 @@ func (d *DNSMasq) Status() Status {
 
 // checkMissingInterfaces compares configured interfaces against what's in the config file
 func (d *DNSMasq) checkMissingInterfaces() []string {
-	data, err := os.ReadFile(d.configPath)
+	data, err := d.fs.ReadFile(d.configPath)
 	if err != nil {
 		return nil
 	}
@@ -1,10 +1,14 @@
 package dnsmasq
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 func TestGetMappings_ParsesAddressFormat(t *testing.T) {
@@ -207,6 +211,46 @@ func TestWriteConfig(t *testing.T) {
 	}
 }
 
+func TestWriteConfigPeerHosts(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	runner := system.NewDryRunCommandRunner()
+	const peerHosts = "/var/lib/homelab-horizon/wg-peers.hosts"
+	d := New("/etc/dnsmasq.d/wg.conf", "/etc/dnsmasq.d/hosts.conf", []string{"wg0"}, nil,
+		WithFileSystem(fs), WithCommandRunner(runner), WithPeerHosts(peerHosts))
+
+	fs.WriteFile("/etc/dnsmasq.d/wg.conf", []byte("interface=wg0\n"), 0644)
+	if !d.Status().MissingPeerHosts {
+		t.Error("Status() should flag a config without the addn-hosts line")
+	}
+	if err := d.WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig() error = %v", err)
+	}
+	if d.Status().MissingPeerHosts {
+		t.Error("Status() should not flag a freshly written config")
+	}
+	conf, _ := fs.ReadFile("/etc/dnsmasq.d/wg.conf")
+	if !strings.Contains(string(conf), "addn-hosts="+peerHosts+"\n") {
+		t.Errorf("config should point addn-hosts at the peer hosts file:\n%s", conf)
+	}
+	if !fs.Exists(peerHosts) {
+		t.Error("peer hosts file should be created")
+	}
+
+	changed, err := d.WritePeerHosts(context.Background(), "", testPeers)
+	if err != nil || !changed {
+		t.Fatalf("WritePeerHosts() = %v, %v; want true, nil", changed, err)
+	}
+	if got, _ := fs.ReadFile(peerHosts); string(got) != string(RenderPeerHosts(testPeers, "")) {
+		t.Errorf("peer hosts file = %q", got)
+	}
+
+	plain := New("/etc/dnsmasq.d/wg.conf", "/etc/dnsmasq.d/hosts.conf", nil, nil,
+		WithFileSystem(fs), WithCommandRunner(runner))
+	if changed, err := plain.WritePeerHosts(context.Background(), "", testPeers); changed || err != nil {
+		t.Errorf("WritePeerHosts() without WithPeerHosts = %v, %v; want a no-op", changed, err)
+	}
+}
+
 func TestNew(t *testing.T) {
 	d := New("/etc/dnsmasq.d/wg.conf", "/etc/dnsmasq.d/hosts", []string{"wg0"}, []string{"8.8.8.8"})
 
@@ -237,3 +281,50 @@ func TestUpdateUpstream(t *testing.T) {
 		t.Error("config should contain updated upstream 9.9.9.9")
 	}
 }
+
+func TestDryRunReload(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	runner := system.NewDryRunCommandRunner()
+	d := New("/etc/dnsmasq.d/wg.conf", "/etc/dnsmasq.d/hosts", []string{"wg0"}, []string{"1.1.1.1"},
+		WithFileSystem(fs), WithCommandRunner(runner))
+
+	if err := d.WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig() error = %v", err)
+	}
+	if err := d.SetMappings(map[string]string{"b.example.com": "10.0.0.2", "a.example.com": "10.0.0.1"}); err != nil {
+		t.Fatalf("SetMappings() error = %v", err)
+	}
+	if err := d.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	written := fs.GetWrittenFiles()
+	if !strings.Contains(string(written["/etc/dnsmasq.d/wg.conf"]), "interface=wg0") {
+		t.Errorf("config not written to the FileSystem: %q", written["/etc/dnsmasq.d/wg.conf"])
+	}
+	hosts := string(written["/etc/dnsmasq.d/hosts"])
+	if a, b := strings.Index(hosts, "a.example.com"), strings.Index(hosts, "b.example.com"); a < 0 || b < a {
+		t.Errorf("mappings should be written sorted, got %q", hosts)
+	}
+	if runner.CallCount("systemd-run --pipe --wait --service-type=oneshot systemctl restart dnsmasq") != 1 {
+		t.Errorf("Expected the restart to be recorded, got %v", runner.GetRunCommands())
+	}
+
+	if st := d.Status(); !st.ConfigExists || len(st.MissingInterfaces) != 0 {
+		t.Errorf("Status() = %+v, want the in-memory config found", st)
+	}
+}
+
+func TestReloadFailureIncludesJournal(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("systemd-run --pipe --wait --service-type=oneshot systemctl restart dnsmasq", errors.New("exit status 1"))
+	runner.AddOutput("systemd-run --pipe --wait --service-type=oneshot journalctl -u dnsmasq -n 10 --no-pager -o cat",
+		[]byte("dnsmasq: failed to create listening socket\n"))
+	d := New("/etc/dnsmasq.d/wg.conf", "/etc/dnsmasq.d/hosts", nil, nil,
+		WithFileSystem(system.NewDryRunFileSystem()), WithCommandRunner(runner))
+
+	err := d.Reload()
+	if err == nil || !strings.Contains(err.Error(), "failed to create listening socket") {
+		t.Errorf("Reload() error = %v, want the journal tail", err)
+	}
+}
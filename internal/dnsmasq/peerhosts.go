@@ -0,0 +1,134 @@
+package dnsmasq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+	"github.com/iodesystems/homelab-horizon/internal/wireguard"
+)
+
+// RenderPeerHosts renders a dnsmasq addn-hosts file mapping each peer's name
+// to its VPN addresses, so peers can reach each other by name. A peer's VPN
+// addresses are the single-host entries (/32, /128 or bare) of its
+// AllowedIPs; routed subnets are skipped. Names are reduced to a DNS label
+// (lowercase letters, digits and dashes) and, when domain is set, also
+// listed as label.domain. Peers whose name reduces to nothing are left out.
+// Hosts are sorted by label and then by address before duplicates are
+// dropped, so when two peers share a label the one with the lowest address
+// wins and the same peers always produce the same bytes, in any order.
+func RenderPeerHosts(peers []wireguard.Peer, domain string) []byte {
+	type host struct {
+		label string
+		addrs []netip.Addr
+	}
+	var hosts []host
+	for _, p := range peers {
+		label := hostLabel(p.Name)
+		if label == "" {
+			continue
+		}
+		var addrs []netip.Addr
+		for _, entry := range p.AllowedIPs {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				addr, err := netip.ParseAddr(entry)
+				if err != nil {
+					continue
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			if prefix.IsSingleIP() {
+				addrs = append(addrs, prefix.Addr())
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		hosts = append(hosts, host{label: label, addrs: addrs})
+	}
+	slices.SortFunc(hosts, func(a, b host) int {
+		if c := strings.Compare(a.label, b.label); c != 0 {
+			return c
+		}
+		return slices.CompareFunc(a.addrs, b.addrs, netip.Addr.Compare)
+	})
+	hosts = slices.CompactFunc(hosts, func(a, b host) bool { return a.label == b.label })
+
+	domain = strings.Trim(domain, ".")
+	var b bytes.Buffer
+	b.WriteString("# WireGuard peer hosts\n# Generated by homelab-horizon — do not edit\n")
+	for _, h := range hosts {
+		names := h.label
+		if domain != "" {
+			names += " " + h.label + "." + domain
+		}
+		for _, a := range h.addrs {
+			fmt.Fprintf(&b, "%s %s\n", a, names)
+		}
+	}
+	return b.Bytes()
+}
+
+// hostLabel turns a peer name into a DNS label: "Alice's Phone" becomes
+// "alice-s-phone". Returns "" when nothing usable is left.
+func hostLabel(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	label := strings.TrimSuffix(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// WritePeerHosts writes RenderPeerHosts(peers, domain) to path through fs and
+// signals dnsmasq to re-read it. When the file already holds exactly that
+// content nothing is written or signalled; changed reports whether it was.
+func WritePeerHosts(ctx context.Context, fs system.FileSystem, runner system.CommandRunner, path, domain string, peers []wireguard.Peer) (changed bool, err error) {
+	data := RenderPeerHosts(peers, domain)
+	if old, err := fs.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return false, nil
+	}
+	if err := fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("creating peer hosts directory: %w", err)
+	}
+	if err := system.WriteFileAtomic(fs, path, data, 0644); err != nil {
+		return false, fmt.Errorf("writing peer hosts: %w", err)
+	}
+	if err := signalReload(ctx, runner); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// signalReload sends SIGHUP to the dnsmasq main process, which makes it
+// clear its cache and re-read its hosts files without dropping queries.
+// Like systemctlWithJournal it goes through systemd-run to escape the
+// ProtectSystem=strict sandbox.
+func signalReload(ctx context.Context, runner system.CommandRunner) error {
+	out, err := runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"systemctl", "kill", "--signal=HUP", "--kill-whom=main", "dnsmasq")
+	if err != nil {
+		detail := strings.TrimSpace(string(out))
+		if detail == "" {
+			detail = err.Error()
+		}
+		return fmt.Errorf("signalling dnsmasq to reload: %s", detail)
+	}
+	return nil
+}
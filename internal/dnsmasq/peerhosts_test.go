@@ -0,0 +1,78 @@
+package dnsmasq
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+	"github.com/iodesystems/homelab-horizon/internal/wireguard"
+)
+
+var testPeers = []wireguard.Peer{
+	{Name: "Bob's Laptop", AllowedIPs: []string{"10.100.0.3/32", "fd00:100::3/128"}},
+	{Name: "alice", AllowedIPs: []string{"10.100.0.2/32"}},
+	{Name: "site", AllowedIPs: []string{"10.100.0.4/32", "192.168.50.0/24"}},
+	{Name: "gateway", AllowedIPs: []string{"192.168.60.0/24"}}, // no host address
+	{Name: "ALICE", AllowedIPs: []string{"10.100.0.9/32"}},     // duplicate label
+	{Name: "!!!", AllowedIPs: []string{"10.100.0.10/32"}},      // no usable label
+}
+
+func TestRenderPeerHosts(t *testing.T) {
+	want := "# WireGuard peer hosts\n# Generated by homelab-horizon — do not edit\n" +
+		"10.100.0.2 alice alice.vpn.example.com\n" +
+		"10.100.0.3 bob-s-laptop bob-s-laptop.vpn.example.com\n" +
+		"fd00:100::3 bob-s-laptop bob-s-laptop.vpn.example.com\n" +
+		"10.100.0.4 site site.vpn.example.com\n"
+	if got := string(RenderPeerHosts(testPeers, "vpn.example.com.")); got != want {
+		t.Errorf("RenderPeerHosts() =\n%s\nwant\n%s", got, want)
+	}
+
+	reversed := slices.Clone(testPeers)
+	slices.Reverse(reversed)
+	if got := string(RenderPeerHosts(reversed, "vpn.example.com")); got != want {
+		t.Errorf("Reversed peers: RenderPeerHosts() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderPeerHostsDuplicateLabel(t *testing.T) {
+	alice := wireguard.Peer{Name: "alice", AllowedIPs: []string{"10.100.0.7/32"}}
+	other := wireguard.Peer{Name: "Alice", AllowedIPs: []string{"10.100.0.5/32"}}
+	want := "# WireGuard peer hosts\n# Generated by homelab-horizon — do not edit\n" +
+		"10.100.0.5 alice\n"
+	for _, peers := range [][]wireguard.Peer{{alice, other}, {other, alice}} {
+		if got := string(RenderPeerHosts(peers, "")); got != want {
+			t.Errorf("RenderPeerHosts(%s, %s) =\n%s\nwant the lowest address to win:\n%s", peers[0].Name, peers[1].Name, got, want)
+		}
+	}
+}
+
+func TestWritePeerHosts(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	runner := system.NewDryRunCommandRunner()
+	const path = "/etc/dnsmasq.d/peers.hosts"
+	reload := "systemd-run --pipe --wait --service-type=oneshot systemctl kill --signal=HUP --kill-whom=main dnsmasq"
+
+	changed, err := WritePeerHosts(context.Background(), fs, runner, path, "", testPeers)
+	if err != nil || !changed {
+		t.Fatalf("WritePeerHosts() = %v, %v; want true, nil", changed, err)
+	}
+	if got, _ := fs.ReadFile(path); string(got) != string(RenderPeerHosts(testPeers, "")) {
+		t.Errorf("hosts file = %q", got)
+	}
+	if runner.CallCount(reload) != 1 {
+		t.Errorf("Expected one reload signal, ran %v", runner.GetRunCommands())
+	}
+
+	changed, err = WritePeerHosts(context.Background(), fs, runner, path, "", testPeers)
+	if err != nil || changed || runner.CallCount(reload) != 1 {
+		t.Errorf("Unchanged peers: changed=%v err=%v reloads=%d; want no write or signal", changed, err, runner.CallCount(reload))
+	}
+
+	runner.AddError(reload, errors.New("exit status 1"))
+	changed, err = WritePeerHosts(context.Background(), fs, runner, path, "", testPeers[:1])
+	if err == nil || !changed {
+		t.Errorf("Failed signal: changed=%v err=%v; want true and an error", changed, err)
+	}
+}
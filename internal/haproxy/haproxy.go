@@ -2,16 +2,18 @@ package haproxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"net"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // Backend represents a HAProxy backend service
@@ -67,14 +69,43 @@ type HAProxy struct {
 	configPath  string
 	statsSocket string
 	backends    []Backend
+
+	// acmeChallenge is the HTTP-01 token server (host:port) the HTTP
+	// frontend forwards /.well-known/acme-challenge/ to; "" = none.
+	acmeChallenge string
+
+	fs     system.FileSystem
+	runner system.CommandRunner
+}
+
+// Option configures a HAProxy built by New.
+type Option func(*HAProxy)
+
+// WithFileSystem makes the HAProxy write haproxy.cfg and its error pages,
+// and read the SSL cert dir, through fs instead of the real filesystem.
+func WithFileSystem(fs system.FileSystem) Option {
+	return func(h *HAProxy) { h.fs = fs }
+}
+
+// WithCommandRunner makes the HAProxy run haproxy and systemctl through
+// runner. A system.DryRunCommandRunner turns Reload into a record of the
+// validate-then-reload commands that would have run.
+func WithCommandRunner(runner system.CommandRunner) Option {
+	return func(h *HAProxy) { h.runner = runner }
 }
 
 // New creates a new HAProxy manager
-func New(configPath, statsSocket string) *HAProxy {
-	return &HAProxy{
+func New(configPath, statsSocket string, opts ...Option) *HAProxy {
+	h := &HAProxy{
 		configPath:  configPath,
 		statsSocket: statsSocket,
+		fs:          &system.RealFileSystem{},
+		runner:      &system.RealCommandRunner{},
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // SetBackends sets the backends list, ordered by routing specificity so all
@@ -84,6 +115,16 @@ func (h *HAProxy) SetBackends(backends []Backend) {
 	h.backends = sortBackendsBySpecificity(backends)
 }
 
+// SetACMEChallengeServer makes the HTTP frontend forward
+// /.well-known/acme-challenge/ to the HTTP-01 token server at addr
+// (host:port; an empty host means 127.0.0.1). "" removes the route.
+func (h *HAProxy) SetACMEChallengeServer(addr string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	h.acmeChallenge = addr
+}
+
 // GetBackends returns the backends list
 func (h *HAProxy) GetBackends() []Backend {
 	return h.backends
@@ -100,21 +141,18 @@ type Status struct {
 // GetStatus returns current HAProxy status
 func (h *HAProxy) GetStatus() Status {
 	status := Status{}
+	ctx := context.Background()
 
 	// Check if config exists
-	if _, err := os.Stat(h.configPath); err == nil {
-		status.ConfigExists = true
-	}
+	status.ConfigExists = h.fs.Exists(h.configPath)
 
 	// Check if haproxy is running
-	cmd := exec.Command("systemctl", "is-active", "haproxy")
-	if err := cmd.Run(); err == nil {
+	if err := h.runner.Run(ctx, "systemctl", "is-active", "haproxy"); err == nil {
 		status.Running = true
 	}
 
 	// Get version
-	cmd = exec.Command("haproxy", "-v")
-	if out, err := cmd.Output(); err == nil {
+	if out, err := h.runner.Output(ctx, "haproxy", "-v"); err == nil {
 		lines := strings.Split(string(out), "\n")
 		if len(lines) > 0 {
 			status.Version = strings.TrimSpace(lines[0])
@@ -287,18 +325,18 @@ func (h *HAProxy) WriteConfig(httpPort, httpsPort int, ssl *SSLConfig) error {
 	// Ensure directory exists
 	dir := strings.TrimSuffix(h.configPath, "/haproxy.cfg")
 	if dir != h.configPath {
-		if err := os.MkdirAll(dir, 0755); err != nil {
+		if err := h.fs.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("mkdir %s: %w", dir, err)
 		}
 	}
 
 	// Write default 503 error page (overrides vanilla HAProxy; per-service pages override this)
 	errorsDir := dir + "/errors"
-	if err := os.MkdirAll(errorsDir, 0755); err == nil {
-		_ = os.WriteFile(errorsDir+"/503.http", []byte(default503Page), 0644)
+	if err := h.fs.MkdirAll(errorsDir, 0755); err == nil {
+		_ = h.fs.WriteFile(errorsDir+"/503.http", []byte(default503Page), 0644)
 	}
 
-	return os.WriteFile(h.configPath, []byte(config), 0644)
+	return h.fs.WriteFile(h.configPath, []byte(config), 0644)
 }
 
 func (h *HAProxy) generateConfig(httpPort, httpsPort int, ssl *SSLConfig) string {
@@ -361,7 +399,7 @@ listen stats
 	sslEnabled := false
 	var sslExact, sslSuffix []string
 	if ssl != nil && ssl.Enabled && ssl.CertDir != "" {
-		sslExact, sslSuffix, sslEnabled = certRedirectPatterns(ssl.CertDir)
+		sslExact, sslSuffix, sslEnabled = certRedirectPatterns(h.fs, ssl.CertDir)
 	}
 
 	// Whether to emit the local_access ACL: any internal-only backend (whole-
@@ -386,6 +424,7 @@ listen stats
     acl has_horizon_header hdr(X-Homelab-Horizon-Check) -m found
     http-request return status 200 content-type "text/plain" string "OK" if is_router_check has_horizon_header
 `, httpPort)
+		h.writeACMEChallengeACL(&sb)
 
 		// Add local_access ACL if any backend is internal-only or metrics-restricted
 		if needLocalAccess {
@@ -408,7 +447,11 @@ listen stats
 				fmt.Fprintf(&sb, "    acl ssl_host hdr_end(host) -i %s\n", strings.Join(sslSuffix, " "))
 			}
 			sb.WriteString("    # Only redirect to HTTPS for hosts with SSL certificates\n")
-			sb.WriteString("    redirect scheme https code 301 if ssl_host !is_router_check\n")
+			if h.acmeChallenge != "" {
+				sb.WriteString("    redirect scheme https code 301 if ssl_host !is_router_check !acme_challenge\n")
+			} else {
+				sb.WriteString("    redirect scheme https code 301 if ssl_host !is_router_check\n")
+			}
 		}
 
 		// Add backend ACLs and routing to HTTP frontend (for non-SSL domains)
@@ -437,6 +480,7 @@ listen stats
 				fmt.Fprintf(&sb, "    http-request deny deny_status 403 if host_%s { path %s } !local_access\n", aclName, b.MetricsPath)
 			}
 		}
+		h.writeACMEChallengeRoute(&sb)
 		for _, b := range backends {
 			aclName := sanitizeName(b.Name)
 			fmt.Fprintf(&sb, "    use_backend %s_backend if host_%s\n", aclName, aclName)
@@ -525,6 +569,7 @@ listen stats
     # Router check endpoint - returns 200 OK directly (requires special header to avoid conflicts)
     http-request return status 200 content-type "text/plain" string "OK" if { path /router-check } { hdr(X-Homelab-Horizon-Check) -m found }
 `, httpPort)
+		h.writeACMEChallengeACL(&sb)
 
 		// Add local_access ACL if any backend is internal-only or metrics-restricted
 		if needLocalAccess {
@@ -558,6 +603,7 @@ listen stats
 				fmt.Fprintf(&sb, "    http-request deny deny_status 403 if host_%s { path %s } !local_access\n", aclName, b.MetricsPath)
 			}
 		}
+		h.writeACMEChallengeRoute(&sb)
 		for _, b := range backends {
 			aclName := sanitizeName(b.Name)
 			fmt.Fprintf(&sb, "    use_backend %s_backend if host_%s\n", aclName, aclName)
@@ -566,6 +612,11 @@ listen stats
 	}
 
 	// Backend definitions
+	if h.acmeChallenge != "" {
+		sb.WriteString("backend acme_challenge_backend\n")
+		sb.WriteString("    mode http\n")
+		fmt.Fprintf(&sb, "    server acme %s\n\n", h.acmeChallenge)
+	}
 	for _, b := range backends {
 		aclName := sanitizeName(b.Name)
 		fmt.Fprintf(&sb, "backend %s_backend\n", aclName)
@@ -618,6 +669,24 @@ listen stats
 	return sb.String()
 }
 
+// writeACMEChallengeACL declares the acme_challenge ACL in the HTTP
+// frontend when an HTTP-01 token server is set.
+func (h *HAProxy) writeACMEChallengeACL(sb *strings.Builder) {
+	if h.acmeChallenge == "" {
+		return
+	}
+	sb.WriteString("    # ACME HTTP-01 challenges go to the token server (it only listens during issuance)\n")
+	sb.WriteString("    acl acme_challenge path_beg /.well-known/acme-challenge/\n")
+}
+
+// writeACMEChallengeRoute routes acme_challenge ahead of the service
+// backends, so a service's host match can't swallow the token request.
+func (h *HAProxy) writeACMEChallengeRoute(sb *strings.Builder) {
+	if h.acmeChallenge != "" {
+		sb.WriteString("    use_backend acme_challenge_backend if acme_challenge\n")
+	}
+}
+
 // SanitizeName converts a service name to a safe HAProxy identifier
 func SanitizeName(name string) string {
 	return sanitizeName(name)
@@ -692,8 +761,8 @@ func domainToACLPattern(domain string) string {
 // (*.x) become suffix matches (.x). found reports whether any cert file exists
 // (i.e. whether SSL should be considered enabled). Results are sorted for
 // deterministic config output.
-func certRedirectPatterns(certDir string) (exact, suffix []string, found bool) {
-	entries, err := os.ReadDir(certDir)
+func certRedirectPatterns(fs system.FileSystem, certDir string) (exact, suffix []string, found bool) {
+	entries, err := fs.ReadDir(certDir)
 	if err != nil {
 		return nil, nil, false
 	}
@@ -704,7 +773,7 @@ func certRedirectPatterns(certDir string) (exact, suffix []string, found bool) {
 			continue
 		}
 		found = true
-		names := certDNSNames(filepath.Join(certDir, e.Name()))
+		names := certDNSNames(fs, filepath.Join(certDir, e.Name()))
 		if len(names) == 0 {
 			// Cert couldn't be parsed (or has no SANs): fall back to the filename
 			// so redirect coverage isn't silently lost. The filename is the primary
@@ -739,8 +808,8 @@ func certRedirectPatterns(certDir string) (exact, suffix []string, found bool) {
 
 // certDNSNames parses the leaf certificate from a PEM bundle (fullchain+key) and
 // returns its DNS SANs. Returns nil if the file can't be read or parsed.
-func certDNSNames(path string) []string {
-	data, err := os.ReadFile(path)
+func certDNSNames(fs system.FileSystem, path string) []string {
+	data, err := fs.ReadFile(path)
 	if err != nil {
 		return nil
 	}
@@ -763,26 +832,36 @@ func certDNSNames(path string) []string {
 
 // Reload reloads HAProxy configuration
 func (h *HAProxy) Reload() error {
+	ctx := context.Background()
+
 	// Validate config first
-	cmd := exec.Command("haproxy", "-c", "-f", h.configPath)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := h.runner.CombinedOutput(ctx, "haproxy", "-c", "-f", h.configPath); err != nil {
 		return fmt.Errorf("config validation failed: %s", string(out))
 	}
 
 	// Reload
-	cmd = exec.Command("systemctl", "reload", "haproxy")
-	if err := cmd.Run(); err != nil {
+	if err := h.runner.Run(ctx, "systemctl", "reload", "haproxy"); err != nil {
 		// Try restart if reload fails
-		cmd = exec.Command("systemctl", "restart", "haproxy")
-		return cmd.Run()
+		return h.runner.Run(ctx, "systemctl", "restart", "haproxy")
+	}
+	return nil
+}
+
+// Apply writes the config generated from the current backends and reloads
+// HAProxy so it takes effect. Nothing is reloaded if the write fails.
+func (h *HAProxy) Apply(httpPort, httpsPort int, ssl *SSLConfig) error {
+	if err := h.WriteConfig(httpPort, httpsPort, ssl); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := h.Reload(); err != nil {
+		return fmt.Errorf("reload: %w", err)
 	}
 	return nil
 }
 
 // Start starts HAProxy
 func (h *HAProxy) Start() error {
-	cmd := exec.Command("systemctl", "start", "haproxy")
-	return cmd.Run()
+	return h.runner.Run(context.Background(), "systemctl", "start", "haproxy")
 }
 
 // SetServerState sends a state change command to the HAProxy admin socket.
@@ -7,12 +7,15 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // writeTestCert writes a self-signed leaf cert with the given DNS SANs to path.
@@ -328,6 +331,40 @@ func TestGenerateConfig_SSLDisabled(t *testing.T) {
 	}
 }
 
+func TestGenerateConfig_ACMEChallengeRoute(t *testing.T) {
+	h := New("/etc/haproxy/haproxy.cfg", "/run/haproxy/admin.sock")
+	h.SetBackends([]Backend{{Name: "app", DomainMatch: "app.example.com", Server: "192.168.1.10:8080"}})
+
+	if config := h.GenerateConfig(80, 443, nil); strings.Contains(config, "acme_challenge") {
+		t.Error("config without an HTTP-01 server should not route acme_challenge")
+	}
+
+	h.SetACMEChallengeServer(":8402")
+	config := h.GenerateConfig(80, 443, nil)
+	for _, want := range []string{
+		"acl acme_challenge path_beg /.well-known/acme-challenge/",
+		"backend acme_challenge_backend",
+		"server acme 127.0.0.1:8402",
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config missing %q", want)
+		}
+	}
+	acme := strings.Index(config, "use_backend acme_challenge_backend if acme_challenge")
+	app := strings.Index(config, "use_backend app_backend")
+	if acme < 0 || app < acme {
+		t.Errorf("acme_challenge should route before the service backends:\n%s", config)
+	}
+
+	// With certs in place the HTTPS redirect must leave challenges on HTTP.
+	tempDir := t.TempDir()
+	writeTestCert(t, filepath.Join(tempDir, "app.example.com.pem"), []string{"app.example.com"})
+	config = h.GenerateConfig(80, 443, &SSLConfig{Enabled: true, CertDir: tempDir})
+	if !strings.Contains(config, "redirect scheme https code 301 if ssl_host !is_router_check !acme_challenge") {
+		t.Errorf("HTTPS redirect should skip acme_challenge:\n%s", config)
+	}
+}
+
 func TestGenerateConfig_CustomPorts(t *testing.T) {
 	h := New("/etc/haproxy/haproxy.cfg", "/run/haproxy/admin.sock")
 	h.SetBackends(nil)
@@ -564,3 +601,61 @@ func TestGetBackends(t *testing.T) {
 		t.Errorf("backends not set correctly: %+v", got)
 	}
 }
+
+func TestDryRunApply(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	runner := system.NewDryRunCommandRunner()
+	h := New("/etc/haproxy/haproxy.cfg", "/run/haproxy/admin.sock",
+		WithFileSystem(fs), WithCommandRunner(runner))
+	h.SetBackends([]Backend{{Name: "grafana", DomainMatches: []string{"grafana.example.com"}, Server: "10.0.0.5:3000"}})
+
+	if err := h.Apply(80, 443, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	written := fs.GetWrittenFiles()
+	if !strings.Contains(string(written["/etc/haproxy/haproxy.cfg"]), "backend grafana_backend") {
+		t.Errorf("config not written to the FileSystem: %q", written["/etc/haproxy/haproxy.cfg"])
+	}
+	if _, ok := written["/etc/haproxy/errors/503.http"]; !ok {
+		t.Error("default 503 page not written to the FileSystem")
+	}
+	if err := runner.AssertSequence([]string{
+		"haproxy -c -f /etc/haproxy/haproxy.cfg",
+		"systemctl reload haproxy",
+	}); err != nil {
+		t.Error(err)
+	}
+	if n := runner.CallCount("systemctl restart haproxy"); n != 0 {
+		t.Errorf("restart ran %d times after a successful reload", n)
+	}
+}
+
+func TestApplyFallsBackToRestart(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("systemctl reload haproxy", errors.New("exit status 1"))
+	h := New("/etc/haproxy/haproxy.cfg", "/run/haproxy/admin.sock",
+		WithFileSystem(system.NewDryRunFileSystem()), WithCommandRunner(runner))
+
+	if err := h.Apply(80, 443, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if runner.CallCount("systemctl restart haproxy") != 1 {
+		t.Errorf("Expected a restart after the failed reload, got %v", runner.GetRunCommands())
+	}
+}
+
+func TestApplySkipsReloadOnInvalidConfig(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("haproxy -c -f /etc/haproxy/haproxy.cfg", errors.New("exit status 1"))
+	h := New("/etc/haproxy/haproxy.cfg", "/run/haproxy/admin.sock",
+		WithFileSystem(system.NewDryRunFileSystem()), WithCommandRunner(runner))
+
+	err := h.Apply(80, 443, nil)
+	if err == nil || !strings.Contains(err.Error(), "config validation failed") {
+		t.Errorf("Apply() error = %v, want a validation failure", err)
+	}
+	if n := runner.CountMatching("systemctl .*"); n != 0 {
+		t.Errorf("systemctl ran %d times after validation failed", n)
+	}
+}
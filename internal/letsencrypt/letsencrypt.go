@@ -1,26 +1,31 @@
 package letsencrypt
 
 import (
-	"crypto/x509"
-	"encoding/pem"
+	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/go-acme/lego/v4/certificate"
+
 	"github.com/iodesystems/homelab-horizon/internal/acme"
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // DNSProviderType identifies the DNS provider for ACME challenges
 type DNSProviderType string
 
 const (
-	DNSProviderRoute53    DNSProviderType = "route53"
-	DNSProviderNamecom    DNSProviderType = "namecom"
-	DNSProviderCloudflare DNSProviderType = "cloudflare"
+	DNSProviderRoute53      DNSProviderType = "route53"
+	DNSProviderNamecom      DNSProviderType = "namecom"
+	DNSProviderCloudflare   DNSProviderType = "cloudflare"
+	DNSProviderDigitalOcean DNSProviderType = "digitalocean"
+	DNSProviderGCloud       DNSProviderType = "googlecloud"
 )
 
 // DNSProviderConfig holds provider-specific credentials (copied from config to avoid import cycle)
@@ -39,8 +44,21 @@ type DNSProviderConfig struct {
 	NamecomAPIToken string
 
 	// Cloudflare
-	CloudflareAPIToken string
-	CloudflareZoneID   string
+	CloudflareAPIToken  string
+	CloudflareZoneID    string
+	CloudflareZoneToken string
+
+	// DigitalOcean
+	DigitalOceanAuthToken string
+
+	// Google Cloud DNS
+	GCloudProject            string
+	GCloudServiceAccountFile string
+
+	// DNS-01 propagation overrides; zero/nil keeps the provider's default
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+	PropagationBackoff *acme.Backoff
 }
 
 // DomainConfig holds configuration for a single domain (or multiple SANs)
@@ -48,7 +66,13 @@ type DomainConfig struct {
 	Domain      string   // Primary domain (e.g., "*.example.com")
 	ExtraSANs   []string // Additional SANs (e.g., "*.vpn.example.com", "vpn.example.com")
 	Email       string
+	KeyType     string             // certificate key algorithm (ec256, ec384, rsa2048, rsa4096); empty = ec256
 	DNSProvider *DNSProviderConfig // DNS provider configuration
+
+	// Challenge is "dns-01" (default) or "http-01"; with http-01 DNSProvider
+	// may be nil and the token is served on HTTP01Address.
+	Challenge     string
+	HTTP01Address string
 }
 
 // Config holds Let's Encrypt configuration
@@ -57,6 +81,21 @@ type Config struct {
 	CertDir        string // where certs are stored
 	HAProxyCertDir string // directory for combined haproxy certs
 	Staging        bool   // use Let's Encrypt staging environment
+	CADirectoryURL string // custom ACME directory (overrides Staging); empty = Let's Encrypt
+
+	// DNSResolvers are the nameservers ("ip" or "ip:port") used to check
+	// DNS-01 propagation; empty uses the system resolver.
+	DNSResolvers []string
+
+	// PostIssueCommand and PostIssueWebhook run after every successful
+	// issuance or renewal (see acme.PostIssueHook).
+	PostIssueCommand string
+	PostIssueWebhook string
+
+	// DryRun rehearses issuance: providers are still created, but the CA is
+	// never contacted and a self-signed placeholder is written to an
+	// in-memory filesystem instead of CertDir.
+	DryRun bool
 }
 
 // DomainStatus represents the current state of SSL certificate for a domain
@@ -79,10 +118,17 @@ type Status struct {
 	Domains       []DomainStatus
 }
 
+// PostIssueHookTimeout bounds a post-issue hook run, command and webhook
+// together, so a hung hook can't stall renewals.
+const PostIssueHookTimeout = 2 * time.Minute
+
 // Manager handles Let's Encrypt operations
 type Manager struct {
 	config Config
 	acme   *acme.Client
+	fs     system.FileSystem
+	runner system.CommandRunner
+	client *http.Client
 }
 
 // New creates a new Let's Encrypt manager
@@ -97,13 +143,39 @@ func New(cfg Config) *Manager {
 	// Create ACME client with account storage in cert dir
 	accountDir := filepath.Join(cfg.CertDir, "accounts")
 	acmeClient := acme.NewClient(accountDir, cfg.Staging)
+	acmeClient.SetCADirectoryURL(cfg.CADirectoryURL)
+	acmeClient.SetDNSResolvers(cfg.DNSResolvers)
+
+	var fs system.FileSystem = &system.RealFileSystem{}
+	if cfg.DryRun {
+		fs = system.NewDryRunFileSystem()
+		acmeClient.SetDryRun(true)
+		acmeClient.SetFileSystem(fs)
+	}
 
 	return &Manager{
 		config: cfg,
 		acme:   acmeClient,
+		fs:     fs,
+		runner: &system.RealCommandRunner{},
+		client: &http.Client{Timeout: 30 * time.Second},
 	}
 }
 
+// SetFileSystem replaces the filesystem used for all certificate and account
+// persistence — issuance, renewal checks, status and HAProxy packaging.
+// New uses the real filesystem, or an in-memory one in dry-run mode.
+func (m *Manager) SetFileSystem(fs system.FileSystem) {
+	m.fs = fs
+	m.acme.SetFileSystem(fs)
+}
+
+// SetCommandRunner replaces the runner the post-issue command goes through.
+// New uses the real one.
+func (m *Manager) SetCommandRunner(runner system.CommandRunner) {
+	m.runner = runner
+}
+
 // GetStatus returns the current SSL status
 func (m *Manager) GetStatus() Status {
 	status := Status{
@@ -139,17 +211,13 @@ func (m *Manager) GetDomainStatus(d DomainConfig) DomainStatus {
 	ds.CertPath = filepath.Join(m.config.CertDir, "live", domain, "fullchain.pem")
 	ds.KeyPath = filepath.Join(m.config.CertDir, "live", domain, "privkey.pem")
 
-	if _, err := os.Stat(ds.CertPath); err == nil {
-		if _, err := os.Stat(ds.KeyPath); err == nil {
-			ds.CertExists = true
-		}
+	if m.fs.Exists(ds.CertPath) && m.fs.Exists(ds.KeyPath) {
+		ds.CertExists = true
 	}
 
 	// Check HAProxy combined cert
 	ds.HAProxyCertPath = filepath.Join(m.config.HAProxyCertDir, domain+".pem")
-	if _, err := os.Stat(ds.HAProxyCertPath); err == nil {
-		ds.HAProxyCertReady = true
-	}
+	ds.HAProxyCertReady = m.fs.Exists(ds.HAProxyCertPath)
 
 	// Get expiry info
 	if ds.CertExists {
@@ -180,23 +248,36 @@ func (m *Manager) RequestCertForDomainWithLog(d DomainConfig, logFn LogFunc) err
 	}
 
 	// Get DNS provider config
-	if d.DNSProvider == nil {
+	useHTTP01 := acme.ChallengeType(d.Challenge) == acme.ChallengeHTTP01
+	if d.DNSProvider == nil && !useHTTP01 {
 		return fmt.Errorf("no DNS provider configured for domain %s", d.Domain)
 	}
 	providerCfg := d.DNSProvider
+	if providerCfg == nil {
+		providerCfg = &DNSProviderConfig{}
+	}
 
 	// Convert to acme.DNSProviderConfig
 	acmeProviderCfg := &acme.DNSProviderConfig{
-		Type:               acme.DNSProviderType(providerCfg.Type),
-		AWSAccessKeyID:     providerCfg.AWSAccessKeyID,
-		AWSSecretAccessKey: providerCfg.AWSSecretAccessKey,
-		AWSRegion:          providerCfg.AWSRegion,
-		AWSHostedZoneID:    providerCfg.AWSHostedZoneID,
-		AWSProfile:         providerCfg.AWSProfile,
-		NamecomUsername:    providerCfg.NamecomUsername,
-		NamecomAPIToken:    providerCfg.NamecomAPIToken,
-		CloudflareAPIToken: providerCfg.CloudflareAPIToken,
-		CloudflareZoneID:   providerCfg.CloudflareZoneID,
+		Type:                     acme.DNSProviderType(providerCfg.Type),
+		AWSAccessKeyID:           providerCfg.AWSAccessKeyID,
+		AWSSecretAccessKey:       providerCfg.AWSSecretAccessKey,
+		AWSRegion:                providerCfg.AWSRegion,
+		AWSHostedZoneID:          providerCfg.AWSHostedZoneID,
+		AWSProfile:               providerCfg.AWSProfile,
+		NamecomUsername:          providerCfg.NamecomUsername,
+		NamecomAPIToken:          providerCfg.NamecomAPIToken,
+		CloudflareAPIToken:       providerCfg.CloudflareAPIToken,
+		CloudflareZoneID:         providerCfg.CloudflareZoneID,
+		CloudflareZoneToken:      providerCfg.CloudflareZoneToken,
+		DigitalOceanAuthToken:    providerCfg.DigitalOceanAuthToken,
+		GCloudProject:            providerCfg.GCloudProject,
+		GCloudServiceAccountFile: providerCfg.GCloudServiceAccountFile,
+		Challenge:                acme.ChallengeType(d.Challenge),
+		HTTP01Address:            d.HTTP01Address,
+		PropagationTimeout:       providerCfg.PropagationTimeout,
+		PollingInterval:          providerCfg.PollingInterval,
+		PropagationBackoff:       providerCfg.PropagationBackoff,
 	}
 
 	// Build the SAN list: exactly the configured domains — primary plus extra
@@ -207,42 +288,64 @@ func (m *Manager) RequestCertForDomainWithLog(d DomainConfig, logFn LogFunc) err
 	domains = append(domains, d.ExtraSANs...)
 
 	// Request certificate using ACME client
-	certs, err := m.acme.ObtainCertificate(d.Email, domains, acmeProviderCfg, logFn)
+	certs, err := m.acme.ObtainCertificate(d.Email, domains, acme.KeyType(d.KeyType), acmeProviderCfg, logFn)
 	if err != nil {
 		return fmt.Errorf("failed to obtain certificate: %w", err)
 	}
 
-	// Save certificates in certbot-compatible layout
+	// Save certificates in certbot-compatible layout, plus the combined PEM
+	// HAProxy serves.
 	baseDomain := strings.TrimPrefix(d.Domain, "*.")
 	certDir := filepath.Join(m.config.CertDir, "live", baseDomain)
 
-	if err := os.MkdirAll(certDir, 0700); err != nil {
-		return fmt.Errorf("creating cert directory: %w", err)
+	if logFn != nil {
+		logFn("Saving certificate and packaging for HAProxy...")
 	}
 
-	// Save fullchain.pem (certificate)
-	if err := os.WriteFile(filepath.Join(certDir, "fullchain.pem"), certs.Certificate, 0600); err != nil {
-		return fmt.Errorf("writing certificate: %w", err)
+	fullChain := filepath.Join(certDir, "fullchain.pem")
+	if err := acme.SaveCertificate(m.fs, certs, acme.CertPaths{
+		FullChain:  fullChain,
+		PrivateKey: filepath.Join(certDir, "privkey.pem"),
+		Issuer:     filepath.Join(certDir, "chain.pem"),
+		Combined:   filepath.Join(m.config.HAProxyCertDir, baseDomain+".pem"),
+	}); err != nil {
+		return err
 	}
 
-	// Save privkey.pem (private key)
-	if err := os.WriteFile(filepath.Join(certDir, "privkey.pem"), certs.PrivateKey, 0600); err != nil {
-		return fmt.Errorf("writing private key: %w", err)
-	}
+	m.runPostIssueHook(certs, domains, fullChain, logFn)
+	return nil
+}
 
-	// Save issuer cert if available
-	if certs.IssuerCertificate != nil {
-		if err := os.WriteFile(filepath.Join(certDir, "chain.pem"), certs.IssuerCertificate, 0600); err != nil {
-			return fmt.Errorf("writing issuer certificate: %w", err)
+// runPostIssueHook runs the configured post-issue hook through m.runner,
+// giving up after PostIssueHookTimeout. The certificate is already saved, so
+// a failing hook is logged rather than returned: the issuance itself
+// succeeded.
+func (m *Manager) runPostIssueHook(certs *certificate.Resource, domains []string, certPath string, logFn LogFunc) {
+	hook := acme.PostIssueHook{Command: m.config.PostIssueCommand, WebhookURL: m.config.PostIssueWebhook}
+	if hook.Empty() {
+		return
+	}
+	if m.config.DryRun {
+		if logFn != nil {
+			logFn("DRY RUN: skipping post-issue hook")
 		}
+		return
+	}
+	ev, err := acme.NewIssuedEvent(certs, domains, certPath)
+	if err != nil {
+		slog.Warn("post-issue hook: reading new certificate", "domain", ev.Domain, "err", err)
 	}
-
 	if logFn != nil {
-		logFn("Packaging certificate for HAProxy...")
+		logFn("Running post-issue hook...")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), PostIssueHookTimeout)
+	defer cancel()
+	if err := hook.Run(ctx, m.runner, m.client, ev); err != nil {
+		slog.Warn("post-issue hook failed", "domain", ev.Domain, "err", err)
+		if logFn != nil {
+			logFn(fmt.Sprintf("⚠ Post-issue hook failed (certificate was saved): %v", err))
+		}
 	}
-
-	// Package cert for HAProxy
-	return m.PackageForHAProxyDomain(d.Domain)
 }
 
 // NeedsRenewal reports whether a domain's certificate expires within the given
@@ -252,22 +355,9 @@ func (m *Manager) NeedsRenewal(d DomainConfig, withinDays int) bool {
 	baseDomain := strings.TrimPrefix(d.Domain, "*.")
 	certPath := filepath.Join(m.config.CertDir, "live", baseDomain, "fullchain.pem")
 
-	data, err := os.ReadFile(certPath)
-	if err != nil {
-		return true // cert doesn't exist
-	}
-
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return true
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return true
-	}
-
-	return time.Until(cert.NotAfter) < time.Duration(withinDays)*24*time.Hour
+	// A cert we can't parse is as good as none — reissue it.
+	renew, err := acme.ShouldRenewFS(m.fs, certPath, time.Duration(withinDays)*24*time.Hour)
+	return err != nil || renew
 }
 
 // PackageForHAProxyDomain combines cert and key into a single PEM for HAProxy
@@ -277,24 +367,24 @@ func (m *Manager) PackageForHAProxyDomain(domain string) error {
 	keyPath := filepath.Join(m.config.CertDir, "live", baseDomain, "privkey.pem")
 
 	// Read cert and key
-	cert, err := os.ReadFile(certPath)
+	cert, err := m.fs.ReadFile(certPath)
 	if err != nil {
 		return fmt.Errorf("reading cert: %w", err)
 	}
-	key, err := os.ReadFile(keyPath)
+	key, err := m.fs.ReadFile(keyPath)
 	if err != nil {
 		return fmt.Errorf("reading key: %w", err)
 	}
 
 	// Ensure HAProxy cert dir exists
-	if err := os.MkdirAll(m.config.HAProxyCertDir, 0700); err != nil {
+	if err := m.fs.MkdirAll(m.config.HAProxyCertDir, 0700); err != nil {
 		return fmt.Errorf("creating haproxy cert dir: %w", err)
 	}
 
 	// Write combined PEM (cert + key)
-	combined := append(cert, key...)
+	combined := acme.CombinedPEM(cert, key)
 	outPath := filepath.Join(m.config.HAProxyCertDir, baseDomain+".pem")
-	if err := os.WriteFile(outPath, combined, 0600); err != nil {
+	if err := m.fs.WriteFile(outPath, combined, 0600); err != nil {
 		return fmt.Errorf("writing combined cert: %w", err)
 	}
 
@@ -327,7 +417,7 @@ func (m *Manager) PruneHAProxyCerts() int {
 		valid[base+".pem"] = true
 	}
 
-	entries, err := os.ReadDir(m.config.HAProxyCertDir)
+	entries, err := m.fs.ReadDir(m.config.HAProxyCertDir)
 	if err != nil {
 		return 0 // dir may not exist yet; nothing to prune
 	}
@@ -338,7 +428,7 @@ func (m *Manager) PruneHAProxyCerts() int {
 			continue
 		}
 		path := filepath.Join(m.config.HAProxyCertDir, name)
-		if err := os.Remove(path); err != nil {
+		if err := m.fs.Remove(path); err != nil {
 			slog.Warn("failed to remove orphaned haproxy cert", "file", path, "err", err)
 			continue
 		}
@@ -438,7 +528,7 @@ func (m *Manager) GetCertInfoForDomain(domain string) (*CertInfo, error) {
 	baseDomain := strings.TrimPrefix(domain, "*.")
 	certPath := filepath.Join(m.config.CertDir, "live", baseDomain, "fullchain.pem")
 
-	if _, err := os.Stat(certPath); err != nil {
+	if !m.fs.Exists(certPath) {
 		return nil, fmt.Errorf("certificate not found: %s", certPath)
 	}
 
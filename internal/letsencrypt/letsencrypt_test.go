@@ -1,6 +1,7 @@
 package letsencrypt
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -12,21 +13,39 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 // writeSelfSignedCert creates a self-signed cert in the expected directory
 // layout (certDir/live/<baseDomain>/fullchain.pem) with the given NotAfter.
 func writeSelfSignedCert(t *testing.T, certDir, domain string, notAfter time.Time) {
 	t.Helper()
+	dir := liveDir(certDir, domain)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, keyPEM := selfSignedCert(t, domain, notAfter)
+	os.WriteFile(filepath.Join(dir, "fullchain.pem"), certPEM, 0600)
+	os.WriteFile(filepath.Join(dir, "privkey.pem"), keyPEM, 0600)
+}
+
+// liveDir is certDir/live/<baseDomain>, where a wildcard's base drops "*.".
+func liveDir(certDir, domain string) string {
 	baseDomain := domain
 	if len(baseDomain) > 2 && baseDomain[:2] == "*." {
 		baseDomain = baseDomain[2:]
 	}
-	dir := filepath.Join(certDir, "live", baseDomain)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		t.Fatal(err)
-	}
+	return filepath.Join(certDir, "live", baseDomain)
+}
 
+// selfSignedCert returns a PEM certificate and key for domain expiring at
+// notAfter.
+func selfSignedCert(t *testing.T, domain string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Fatal(err)
@@ -43,12 +62,9 @@ func writeSelfSignedCert(t *testing.T, certDir, domain string, notAfter time.Tim
 		t.Fatal(err)
 	}
 
-	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
 	keyDER, _ := x509.MarshalECPrivateKey(key)
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
-
-	os.WriteFile(filepath.Join(dir, "fullchain.pem"), certPEM, 0600)
-	os.WriteFile(filepath.Join(dir, "privkey.pem"), keyPEM, 0600)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
 }
 
 func TestPruneOrphanedHAProxyCerts(t *testing.T) {
@@ -118,3 +134,126 @@ func TestNeedsRenewal(t *testing.T) {
 		t.Error("cert expiring in 60d should NOT need renewal within 30d window")
 	}
 }
+
+func TestValidCertInMemory(t *testing.T) {
+	certDir := filepath.Join(t.TempDir(), "letsencrypt")
+	haproxyDir := filepath.Join(t.TempDir(), "haproxy")
+	m := New(Config{CertDir: certDir, HAProxyCertDir: haproxyDir})
+	fs := system.NewDryRunFileSystem()
+	m.SetFileSystem(fs)
+
+	domain := DomainConfig{Domain: "*.example.com", Email: "admin@example.com"}
+	if !m.NeedsRenewal(domain, 30) {
+		t.Error("missing cert should need renewal")
+	}
+
+	certPEM, keyPEM := selfSignedCert(t, domain.Domain, time.Now().Add(60*24*time.Hour))
+	dir := liveDir(certDir, domain.Domain)
+	fs.AddFile(filepath.Join(dir, "fullchain.pem"), certPEM)
+	fs.AddFile(filepath.Join(dir, "privkey.pem"), keyPEM)
+
+	if m.NeedsRenewal(domain, 30) {
+		t.Error("in-memory cert valid for 60d should skip issuance")
+	}
+	if ds := m.GetDomainStatus(domain); !ds.CertExists || ds.HAProxyCertReady {
+		t.Errorf("GetDomainStatus() CertExists=%v HAProxyCertReady=%v; want true, false", ds.CertExists, ds.HAProxyCertReady)
+	}
+
+	if err := m.PackageForHAProxyDomain(domain.Domain); err != nil {
+		t.Fatalf("PackageForHAProxyDomain() error = %v", err)
+	}
+	combined := filepath.Join(haproxyDir, "example.com.pem")
+	if len(fs.GetWrittenFiles()[combined]) == 0 {
+		t.Errorf("expected combined PEM at %s", combined)
+	}
+	if !m.GetDomainStatus(domain).HAProxyCertReady {
+		t.Error("HAProxy cert should be ready after packaging")
+	}
+	if _, err := os.Stat(haproxyDir); err == nil {
+		t.Error("packaging touched the real disk")
+	}
+}
+
+func TestRequestCertDryRun(t *testing.T) {
+	certDir := t.TempDir()
+	haproxyDir := t.TempDir()
+	m := New(Config{CertDir: certDir, HAProxyCertDir: haproxyDir, DryRun: true, PostIssueCommand: "false"})
+
+	err := m.RequestCertForDomain(DomainConfig{
+		Domain:      "*.example.com",
+		ExtraSANs:   []string{"example.com"},
+		Email:       "admin@example.com",
+		DNSProvider: &DNSProviderConfig{Type: DNSProviderDigitalOcean, DigitalOceanAuthToken: "do-token"},
+	})
+	if err != nil {
+		t.Fatalf("RequestCertForDomain() error = %v", err)
+	}
+
+	written := m.fs.(*system.DryRunFileSystem).GetWrittenFiles()
+	for _, p := range []string{
+		filepath.Join(certDir, "live", "example.com", "fullchain.pem"),
+		filepath.Join(certDir, "live", "example.com", "privkey.pem"),
+		filepath.Join(haproxyDir, "example.com.pem"),
+	} {
+		if len(written[p]) == 0 {
+			t.Errorf("expected dry-run write to %s", p)
+		}
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("%s was written to disk in dry run", p)
+		}
+	}
+}
+
+// deadlineRunner records the deadline of the context each command ran with.
+type deadlineRunner struct {
+	*system.DryRunCommandRunner
+	deadline time.Time
+}
+
+func (r *deadlineRunner) WithOpts(opts system.RunOpts) system.CommandRunner {
+	return deadlineOptsRunner{r.DryRunCommandRunner.WithOpts(opts), r}
+}
+
+type deadlineOptsRunner struct {
+	system.CommandRunner
+	r *deadlineRunner
+}
+
+func (d deadlineOptsRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	d.r.deadline, _ = ctx.Deadline()
+	return d.CommandRunner.Output(ctx, name, args...)
+}
+
+func TestRunPostIssueHook(t *testing.T) {
+	certPEM, _ := selfSignedCert(t, "example.com", time.Now().Add(90*24*time.Hour))
+	m := New(Config{CertDir: t.TempDir(), PostIssueCommand: "systemctl reload haproxy"})
+	runner := &deadlineRunner{DryRunCommandRunner: system.NewDryRunCommandRunner()}
+	m.SetCommandRunner(runner)
+
+	start := time.Now()
+	m.runPostIssueHook(&certificate.Resource{Certificate: certPEM}, []string{"example.com"}, "/etc/letsencrypt/live/example.com/fullchain.pem", nil)
+
+	if runner.CallCount("sh -c systemctl reload haproxy") != 1 {
+		t.Fatalf("hook did not run through the injected runner: %v", runner.GetRunCommands())
+	}
+	if runner.deadline.IsZero() || runner.deadline.After(start.Add(PostIssueHookTimeout+time.Second)) {
+		t.Errorf("hook ran with deadline %v, want within %v", runner.deadline, PostIssueHookTimeout)
+	}
+}
+
+func TestRequestCertHTTP01WithoutDNSProvider(t *testing.T) {
+	m := New(Config{CertDir: t.TempDir(), HAProxyCertDir: t.TempDir(), DryRun: true})
+
+	err := m.RequestCertForDomain(DomainConfig{
+		Domain:    "www.example.com",
+		Email:     "admin@example.com",
+		Challenge: "http-01",
+	})
+	if err != nil {
+		t.Fatalf("RequestCertForDomain() error = %v", err)
+	}
+
+	if err := m.RequestCertForDomain(DomainConfig{Domain: "www.example.com", Email: "admin@example.com"}); err == nil {
+		t.Error("Expected error for dns-01 without a DNS provider")
+	}
+}
@@ -2,25 +2,53 @@ package qr
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"os/exec"
 )
 
+// ErrUnavailable is returned by GeneratePNG and GenerateANSI when qrencode
+// is not installed.
+var ErrUnavailable = errors.New("qrencode not installed (apt install qrencode)")
+
 // GenerateSVG generates an SVG QR code using qrencode
 // Returns a placeholder SVG with install instructions if qrencode is not available
 func GenerateSVG(data string, size int) string {
-	if !Available() {
+	output, err := encode(data, "-t", "SVG", "-s", "8", "-m", "2")
+	if err != nil {
 		return notAvailableSVG(size)
 	}
 
-	cmd := exec.Command("qrencode", "-t", "SVG", "-o", "-", "-s", "8", "-m", "2")
+	return string(output)
+}
+
+// GeneratePNG encodes data (typically a WireGuard client config) as a PNG
+// QR code suitable for scanning with a mobile client.
+func GeneratePNG(data string) ([]byte, error) {
+	return encode(data, "-t", "PNG", "-s", "8", "-m", "2")
+}
+
+// GenerateANSI renders data as a QR code made of Unicode half blocks with
+// ANSI colors, for printing a scannable code in a terminal.
+func GenerateANSI(data string) (string, error) {
+	output, err := encode(data, "-t", "ANSIUTF8", "-m", "2")
+	return string(output), err
+}
+
+// encode pipes data through qrencode with the given output options.
+func encode(data string, args ...string) ([]byte, error) {
+	if !Available() {
+		return nil, ErrUnavailable
+	}
+
+	cmd := exec.Command("qrencode", append(args, "-o", "-")...)
 	cmd.Stdin = bytes.NewBufferString(data)
 
 	output, err := cmd.Output()
 	if err != nil {
-		return notAvailableSVG(size)
+		return nil, fmt.Errorf("qrencode: %w", err)
 	}
-
-	return string(output)
+	return output, nil
 }
 
 // Available checks if qrencode is installed
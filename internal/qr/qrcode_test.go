@@ -1,6 +1,8 @@
 package qr
 
 import (
+	"bytes"
+	"errors"
 	"strings"
 	"testing"
 )
@@ -53,3 +55,35 @@ func TestGenerateSVG_FallbackWhenUnavailable(t *testing.T) {
 		}
 	}
 }
+
+func TestGeneratePNG(t *testing.T) {
+	png, err := GeneratePNG("[Interface]\nPrivateKey = test\n")
+	if !Available() {
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected ErrUnavailable without qrencode, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("GeneratePNG() error = %v", err)
+	}
+	if !bytes.HasPrefix(png, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Error("should return PNG data")
+	}
+}
+
+func TestGenerateANSI(t *testing.T) {
+	out, err := GenerateANSI("test data")
+	if !Available() {
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf("expected ErrUnavailable without qrencode, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("GenerateANSI() error = %v", err)
+	}
+	if !strings.Contains(out, "\x1b[") {
+		t.Error("should contain ANSI escape sequences")
+	}
+}
@@ -638,7 +638,7 @@ func (s *Server) handleAPIVPNPeers(w http.ResponseWriter, r *http.Request) {
 		pr := apitypes.PeerResp{
 			Name:       p.Name,
 			PublicKey:  p.PublicKey,
-			AllowedIPs: p.AllowedIPs,
+			AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 			Profile:    s.cfg().GetPeerProfile(p.Name),
 		}
 		if status, ok := ifaceStatus.Peers[p.PublicKey]; ok {
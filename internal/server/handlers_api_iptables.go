@@ -34,7 +34,7 @@ func (s *Server) buildClassifierInputs() (
 		for _, p := range s.wg.GetPeers() {
 			peers = append(peers, iptables.PeerInput{
 				Name:       p.Name,
-				AllowedIPs: p.AllowedIPs,
+				AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 			})
 		}
 		if addr := s.wg.GetAddress(); addr != "" {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/iodesystems/homelab-horizon/internal/apitypes"
 	"github.com/iodesystems/homelab-horizon/internal/config"
@@ -645,6 +646,9 @@ func (s *Server) handleAPIRequestCert(w http.ResponseWriter, r *http.Request) {
 			AWSProfile:         providerCfg.AWSProfile,
 			NamecomUsername:    providerCfg.NamecomUsername,
 			NamecomAPIToken:    providerCfg.NamecomAPIToken,
+			PropagationTimeout: time.Duration(providerCfg.PropagationTimeoutSeconds) * time.Second,
+			PollingInterval:    time.Duration(providerCfg.PollingIntervalSeconds) * time.Second,
+			PropagationBackoff: providerCfg.PropagationBackoff.Backoff(),
 		}
 	}
 
@@ -113,17 +113,18 @@ func (s *Server) handleAPIAddZone(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name               string `json:"name"`
-		ZoneID             string `json:"zoneId"`
-		ProviderType       string `json:"providerType"`
-		SSLEmail           string `json:"sslEmail"`
-		AWSProfile         string `json:"awsProfile"`
-		AWSAccessKeyID     string `json:"awsAccessKeyId"`
-		AWSSecretAccessKey string `json:"awsSecretAccessKey"`
-		AWSRegion          string `json:"awsRegion"`
-		NamecomUsername    string `json:"namecomUsername"`
-		NamecomAPIToken    string `json:"namecomApiToken"`
-		CloudflareAPIToken string `json:"cloudflareApiToken"`
+		Name                string `json:"name"`
+		ZoneID              string `json:"zoneId"`
+		ProviderType        string `json:"providerType"`
+		SSLEmail            string `json:"sslEmail"`
+		AWSProfile          string `json:"awsProfile"`
+		AWSAccessKeyID      string `json:"awsAccessKeyId"`
+		AWSSecretAccessKey  string `json:"awsSecretAccessKey"`
+		AWSRegion           string `json:"awsRegion"`
+		NamecomUsername     string `json:"namecomUsername"`
+		NamecomAPIToken     string `json:"namecomApiToken"`
+		CloudflareAPIToken  string `json:"cloudflareApiToken"`
+		CloudflareZoneToken string `json:"cloudflareZoneToken"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
@@ -160,9 +161,10 @@ func (s *Server) handleAPIAddZone(w http.ResponseWriter, r *http.Request) {
 		}
 	case config.DNSProviderCloudflare:
 		dnsProvider = &config.DNSProviderConfig{
-			Type:               config.DNSProviderCloudflare,
-			CloudflareAPIToken: strings.TrimSpace(req.CloudflareAPIToken),
-			CloudflareZoneID:   strings.TrimSpace(req.ZoneID),
+			Type:                config.DNSProviderCloudflare,
+			CloudflareAPIToken:  strings.TrimSpace(req.CloudflareAPIToken),
+			CloudflareZoneID:    strings.TrimSpace(req.ZoneID),
+			CloudflareZoneToken: strings.TrimSpace(req.CloudflareZoneToken),
 		}
 	default:
 		writeJSONError(w, http.StatusBadRequest, "Unknown DNS provider type: "+string(providerType))
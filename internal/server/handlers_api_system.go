@@ -57,13 +57,23 @@ func (s *Server) handleAPISystemHealth(w http.ResponseWriter, r *http.Request) {
 	// "Running" for wg is iface-up (checked via `wg show <iface>`), not a
 	// systemd unit — wg-quick exits immediately after bringing the iface up.
 	if wg.Installed {
-		sysStatus := s.wg.CheckSystem(cfg.VPNRange)
+		sysStatus := s.wg.CheckSystem(r.Context(), cfg.VPNRange)
 		wg.Running = sysStatus.InterfaceUp
 		wg.Extras = map[string]any{
-			"interface_up":  sysStatus.InterfaceUp,
-			"ip_forwarding": sysStatus.IPForwarding,
-			"masquerading":  sysStatus.Masquerading,
+			"interface_up":      sysStatus.InterfaceUp,
+			"interface_exists":  sysStatus.InterfaceExists,
+			"interface_addrs":   sysStatus.InterfaceAddrs,
+			"ip_forwarding":     sysStatus.IPForwarding,
+			"masquerading":      sysStatus.Masquerading,
+			"dual_stack":        sysStatus.DualStack,
+			"ipv6_forwarding":   sysStatus.IPv6Forwarding,
+			"ipv6_masquerading": sysStatus.IPv6Masquerading,
 		}
+		rules := make(map[string]bool, len(sysStatus.Rules))
+		for _, r := range sysStatus.Rules {
+			rules[r.String()] = r.Present
+		}
+		wg.Extras["iptables_rules"] = rules
 		if sysStatus.InterfaceError != "" {
 			wg.Errors = append(wg.Errors, "interface: "+sysStatus.InterfaceError)
 		}
@@ -73,6 +83,15 @@ func (s *Server) handleAPISystemHealth(w http.ResponseWriter, r *http.Request) {
 		if sysStatus.MasqError != "" {
 			wg.Errors = append(wg.Errors, "masquerade: "+sysStatus.MasqError)
 		}
+		// IPv6 gaps only break a tunnel that actually carries IPv6.
+		if sysStatus.DualStack {
+			if sysStatus.IPv6ForwardingError != "" {
+				wg.Errors = append(wg.Errors, "ipv6 forwarding: "+sysStatus.IPv6ForwardingError)
+			}
+			if sysStatus.IPv6MasqError != "" {
+				wg.Errors = append(wg.Errors, "ipv6 masquerade: "+sysStatus.IPv6MasqError)
+			}
+		}
 	}
 	resp.Components = append(resp.Components, wg)
 
@@ -149,9 +168,14 @@ func (s *Server) handleAPISystemHealth(w http.ResponseWriter, r *http.Request) {
 	le := apitypes.ComponentHealth{Name: "letsencrypt"}
 	if cfg.SSLEnabled {
 		leMgr := letsencrypt.New(letsencrypt.Config{
-			Domains:        cfg.DeriveSSLDomains(),
-			CertDir:        cfg.SSLCertDir,
-			HAProxyCertDir: cfg.SSLHAProxyCertDir,
+			Domains:          cfg.DeriveSSLDomains(),
+			CertDir:          cfg.SSLCertDir,
+			HAProxyCertDir:   cfg.SSLHAProxyCertDir,
+			CADirectoryURL:   cfg.SSLCADirectoryURL,
+			DNSResolvers:     cfg.SSLDNSResolvers,
+			PostIssueCommand: cfg.SSLPostIssueCommand,
+			PostIssueWebhook: cfg.SSLPostIssueWebhook,
+			DryRun:           s.dryRun,
 		})
 		leStatus := leMgr.GetStatus()
 		le.Installed = leStatus.LegoAvailable
@@ -40,7 +40,8 @@ func systemdRun(args ...string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
-// POST /api/v1/system/fix/ip-forwarding — sysctl net.ipv4.ip_forward=1
+// POST /api/v1/system/fix/ip-forwarding — sysctl net.ipv4.ip_forward=1, persisted
+// in /etc/sysctl.d
 func (s *Server) handleAPISystemFixIPForwarding(w http.ResponseWriter, r *http.Request) {
 	if !s.isAdmin(r) {
 		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
@@ -50,7 +51,7 @@ func (s *Server) handleAPISystemFixIPForwarding(w http.ResponseWriter, r *http.R
 		writeJSONError(w, http.StatusMethodNotAllowed, "POST required")
 		return
 	}
-	if err := wireguard.EnableIPForwarding(); err != nil {
+	if _, err := s.wg.EnableIPForwarding(r.Context()); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -115,7 +116,7 @@ func (s *Server) handleAPISystemFixWGRules(w http.ResponseWriter, r *http.Reques
 	}
 	postUp := wireguard.ExpectedPostUp(outIface)
 	postDown := wireguard.ExpectedPostDown(outIface)
-	if err := s.wg.UpdateInterfaceRules(postUp, postDown); err != nil {
+	if err := s.wg.UpdateInterfaceRules([]string{postUp}, []string{postDown}); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, "update rules: "+err.Error())
 		return
 	}
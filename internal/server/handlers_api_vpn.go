@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -81,6 +82,7 @@ func (s *Server) handleAPIAddPeer(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("wg.Reload", "err", err)
 	}
 	s.rebuildWGForwardChain()
+	s.syncPeerHosts()
 
 	clientConfig := s.generateClientConfig(privKey, strings.TrimSuffix(clientIP, "/32"), profile)
 
@@ -133,17 +135,15 @@ func (s *Server) handleAPIEditPeer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Extract primary IP (first /32) from current AllowedIPs
-	currentIPs := strings.Split(peer.AllowedIPs, ",")
 	var primaryIP string
-	for _, ip := range currentIPs {
-		ip = strings.TrimSpace(ip)
+	for _, ip := range peer.AllowedIPs {
 		if strings.HasSuffix(ip, "/32") {
 			primaryIP = ip
 			break
 		}
 	}
-	if primaryIP == "" {
-		primaryIP = strings.TrimSpace(currentIPs[0])
+	if primaryIP == "" && len(peer.AllowedIPs) > 0 {
+		primaryIP = peer.AllowedIPs[0]
 	}
 
 	extraIPs := strings.TrimSpace(req.ExtraIPs)
@@ -185,6 +185,7 @@ func (s *Server) handleAPIEditPeer(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("wg.Reload", "err", err)
 	}
 	s.rebuildWGForwardChain()
+	s.syncPeerHosts()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
@@ -216,7 +217,7 @@ func (s *Server) handleAPIDeletePeer(w http.ResponseWriter, r *http.Request) {
 	// Look up peer name before removing so we can clean up profile
 	peer := s.wg.GetPeerByPublicKey(req.PublicKey)
 
-	if err := s.wg.RemovePeer(req.PublicKey); err != nil {
+	if _, err := s.wg.RemovePeer(req.PublicKey); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -241,6 +242,7 @@ func (s *Server) handleAPIDeletePeer(w http.ResponseWriter, r *http.Request) {
 		slog.Warn("wg.Reload", "err", err)
 	}
 	s.rebuildWGForwardChain()
+	s.syncPeerHosts()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
@@ -318,6 +320,7 @@ func (s *Server) handleAPIReloadWG(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.rebuildWGForwardChain()
+	s.syncPeerHosts()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true})
@@ -373,6 +376,7 @@ func (s *Server) handleAPISetPeerProfile(w http.ResponseWriter, r *http.Request)
 // when fleet peers have VPNRange configured (site-to-site topology).
 func (s *Server) generateClientConfig(clientPrivKey, clientIP, profile string) string {
 	cfg := s.cfg()
+	endpoint := cfg.GetEndpoint(s.wg.GetListenPort())
 
 	// Check if any fleet peer has VPNRange — if so, multi-site mode.
 	var sites []wireguard.SitePeer
@@ -390,7 +394,7 @@ func (s *Server) generateClientConfig(clientPrivKey, clientIP, profile string) s
 		// Multi-site: add local site as a peer too.
 		sites = append([]wireguard.SitePeer{{
 			PublicKey:  cfg.ServerPublicKey,
-			Endpoint:   cfg.ServerEndpoint,
+			Endpoint:   endpoint,
 			AllowedIPs: cfg.VPNRange,
 		}}, sites...)
 		return wireguard.GenerateMultiSiteClientConfig(clientPrivKey, clientIP, cfg.DNS, sites)
@@ -398,12 +402,24 @@ func (s *Server) generateClientConfig(clientPrivKey, clientIP, profile string) s
 
 	// Single-site: use the original generator with profile-based AllowedIPs.
 	return wireguard.GenerateClientConfig(
-		clientPrivKey, clientIP,
-		cfg.ServerPublicKey, cfg.ServerEndpoint,
+		clientPrivKey, clientIP, cfg.VPNRange,
+		cfg.ServerPublicKey, endpoint,
 		cfg.DNS, cfg.GetAllowedIPsForProfile(profile),
 	)
 }
 
+// syncPeerHosts rewrites dnsmasq's peer hosts file from the current peers so
+// their names resolve over the VPN. Call it after any change to peer names or
+// addresses.
+func (s *Server) syncPeerHosts() {
+	if !s.cfg().DNSMasqEnabled {
+		return
+	}
+	if _, err := s.dns.WritePeerHosts(context.Background(), "", s.wg.GetPeers()); err != nil {
+		slog.Warn("dnsmasq peer hosts", "err", err)
+	}
+}
+
 // rebuildWGForwardChain rebuilds the iptables WG-FORWARD chain based on current peers and profiles.
 func (s *Server) rebuildWGForwardChain() {
 	cfg := s.cfg()
@@ -499,15 +515,14 @@ func (s *Server) handleAPIGetPeerConfig(w http.ResponseWriter, r *http.Request)
 
 	// Extract primary IP
 	primaryIP := ""
-	for _, part := range strings.Split(peer.AllowedIPs, ",") {
-		part = strings.TrimSpace(part)
-		if strings.HasSuffix(part, "/32") {
-			primaryIP = strings.TrimSuffix(part, "/32")
+	for _, entry := range peer.AllowedIPs {
+		if strings.HasSuffix(entry, "/32") {
+			primaryIP = strings.TrimSuffix(entry, "/32")
 			break
 		}
 	}
-	if primaryIP == "" {
-		primaryIP = strings.Split(strings.TrimSpace(peer.AllowedIPs), "/")[0]
+	if primaryIP == "" && len(peer.AllowedIPs) > 0 {
+		primaryIP = strings.Split(peer.AllowedIPs[0], "/")[0]
 	}
 
 	profile := s.cfg().GetPeerProfile(peer.Name)
@@ -579,15 +594,14 @@ func (s *Server) handleAPIRekeyPeer(w http.ResponseWriter, r *http.Request) {
 
 	// Extract primary IP
 	primaryIP := ""
-	for _, part := range strings.Split(peer.AllowedIPs, ",") {
-		part = strings.TrimSpace(part)
-		if strings.HasSuffix(part, "/32") {
-			primaryIP = strings.TrimSuffix(part, "/32")
+	for _, entry := range peer.AllowedIPs {
+		if strings.HasSuffix(entry, "/32") {
+			primaryIP = strings.TrimSuffix(entry, "/32")
 			break
 		}
 	}
-	if primaryIP == "" {
-		primaryIP = strings.Split(strings.TrimSpace(peer.AllowedIPs), "/")[0]
+	if primaryIP == "" && len(peer.AllowedIPs) > 0 {
+		primaryIP = strings.Split(peer.AllowedIPs[0], "/")[0]
 	}
 
 	profile := s.cfg().GetPeerProfile(peer.Name)
@@ -221,12 +221,8 @@ func (s *Server) handleDeployMaintPage(w http.ResponseWriter, r *http.Request, s
 
 	s.syncHAProxyBackends()
 	ssl := &haproxy.SSLConfig{Enabled: s.cfg().SSLEnabled, CertDir: s.cfg().SSLHAProxyCertDir}
-	if err := s.haproxy.WriteConfig(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, ssl); err != nil {
-		http.Error(w, "failed to write haproxy config: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if err := s.haproxy.Reload(); err != nil {
-		http.Error(w, "failed to reload haproxy: "+err.Error(), http.StatusInternalServerError)
+	if err := s.haproxy.Apply(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, ssl); err != nil {
+		http.Error(w, "failed to apply haproxy config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -253,12 +249,8 @@ func (s *Server) handleDeploySwap(w http.ResponseWriter, svcIdx int) {
 		Enabled: s.cfg().SSLEnabled,
 		CertDir: s.cfg().SSLHAProxyCertDir,
 	}
-	if err := s.haproxy.WriteConfig(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, ssl); err != nil {
-		http.Error(w, "failed to write haproxy config: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if err := s.haproxy.Reload(); err != nil {
-		http.Error(w, "failed to reload haproxy: "+err.Error(), http.StatusInternalServerError)
+	if err := s.haproxy.Apply(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, ssl); err != nil {
+		http.Error(w, "failed to apply haproxy config: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
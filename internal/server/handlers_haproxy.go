@@ -25,4 +25,5 @@ func (s *Server) syncHAProxyBackends() {
 	}
 	// Derive HAProxy backends from services
 	s.haproxy.SetBackends(s.cfg().DeriveHAProxyBackends())
+	s.haproxy.SetACMEChallengeServer(s.cfg().HTTP01ChallengeAddress())
 }
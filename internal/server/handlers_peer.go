@@ -14,10 +14,12 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/iodesystems/homelab-horizon/internal/config"
 	"github.com/iodesystems/homelab-horizon/internal/iptables"
+	"github.com/iodesystems/homelab-horizon/internal/wireguard"
 )
 
 // PeerPingResponse is returned by GET /api/peer/ping.
@@ -256,7 +258,7 @@ func (s *Server) snapshotWGPeers() []config.WGPeer {
 		wgPeers[i] = config.WGPeer{
 			Name:       p.Name,
 			PublicKey:  p.PublicKey,
-			AllowedIPs: p.AllowedIPs,
+			AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 		}
 	}
 	return wgPeers
@@ -287,7 +289,7 @@ func (s *Server) applyWGPeersFromConfig(cfg *config.Config) {
 	for _, p := range current {
 		if _, ok := desiredByKey[p.PublicKey]; !ok {
 			slog.Info("peer-sync: removing WG peer", "name", p.Name, "pubkey_prefix", p.PublicKey[:8])
-			if err := s.wg.RemovePeer(p.PublicKey); err != nil {
+			if _, err := s.wg.RemovePeer(p.PublicKey); err != nil {
 				slog.Error("peer-sync: remove WG peer failed", "name", p.Name, "err", err)
 			}
 			changed = true
@@ -306,7 +308,7 @@ func (s *Server) applyWGPeersFromConfig(cfg *config.Config) {
 			// Check if name or AllowedIPs changed.
 			for _, cur := range current {
 				if cur.PublicKey == desired.PublicKey {
-					if cur.Name != desired.Name || cur.AllowedIPs != desired.AllowedIPs {
+					if cur.Name != desired.Name || !slices.Equal(cur.AllowedIPs, wireguard.SplitAllowedIPs(desired.AllowedIPs)) {
 						slog.Info("peer-sync: updating WG peer", "name", desired.Name)
 						if err := s.wg.UpdatePeer(desired.PublicKey, desired.Name, desired.AllowedIPs); err != nil {
 							slog.Error("peer-sync: update WG peer failed", "name", desired.Name, "err", err)
@@ -324,6 +326,7 @@ func (s *Server) applyWGPeersFromConfig(cfg *config.Config) {
 			slog.Error("peer-sync: WG reload after peer sync failed", "err", err)
 		}
 		s.rebuildWGForwardChain()
+		s.syncPeerHosts()
 	}
 }
 
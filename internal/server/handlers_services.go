@@ -38,19 +38,21 @@ func (s *Server) syncServices() {
 		if s.cfg().SSLEnabled {
 			sslConfig = &haproxy.SSLConfig{Enabled: true, CertDir: s.cfg().SSLHAProxyCertDir}
 		}
-		if err := s.haproxy.WriteConfig(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, sslConfig); err != nil {
-			slog.Warn("haproxy.WriteConfig", "err", err)
-		}
-		if err := s.haproxy.Reload(); err != nil {
-			slog.Warn("haproxy.Reload", "err", err)
+		if err := s.haproxy.Apply(s.cfg().HAProxyHTTPPort, s.cfg().HAProxyHTTPSPort, sslConfig); err != nil {
+			slog.Warn("haproxy.Apply", "err", err)
 		}
 	}
 
 	// Update Let's Encrypt
 	s.letsencrypt = letsencrypt.New(letsencrypt.Config{
-		Domains:        s.cfg().DeriveSSLDomains(),
-		CertDir:        s.cfg().SSLCertDir,
-		HAProxyCertDir: s.cfg().SSLHAProxyCertDir,
+		Domains:          s.cfg().DeriveSSLDomains(),
+		CertDir:          s.cfg().SSLCertDir,
+		HAProxyCertDir:   s.cfg().SSLHAProxyCertDir,
+		CADirectoryURL:   s.cfg().SSLCADirectoryURL,
+		DNSResolvers:     s.cfg().SSLDNSResolvers,
+		PostIssueCommand: s.cfg().SSLPostIssueCommand,
+		PostIssueWebhook: s.cfg().SSLPostIssueWebhook,
+		DryRun:           s.dryRun,
 	})
 }
 
@@ -482,9 +484,14 @@ func (s *Server) runSyncInternal(log SyncLogger, cancelCh <-chan struct{}) {
 		log.Step("Checking SSL certificates...")
 
 		s.letsencrypt = letsencrypt.New(letsencrypt.Config{
-			Domains:        sslDomains,
-			CertDir:        s.cfg().SSLCertDir,
-			HAProxyCertDir: s.cfg().SSLHAProxyCertDir,
+			Domains:          sslDomains,
+			CertDir:          s.cfg().SSLCertDir,
+			HAProxyCertDir:   s.cfg().SSLHAProxyCertDir,
+			CADirectoryURL:   s.cfg().SSLCADirectoryURL,
+			DNSResolvers:     s.cfg().SSLDNSResolvers,
+			PostIssueCommand: s.cfg().SSLPostIssueCommand,
+			PostIssueWebhook: s.cfg().SSLPostIssueWebhook,
+			DryRun:           s.dryRun,
 		})
 
 		// Request/verify each zone's certificate concurrently. A single cert's
@@ -632,6 +639,7 @@ func (s *Server) runSyncInternal(log SyncLogger, cancelCh <-chan struct{}) {
 		}
 		backends := s.cfg().DeriveHAProxyBackends()
 		s.haproxy.SetBackends(backends)
+		s.haproxy.SetACMEChallengeServer(s.cfg().HTTP01ChallengeAddress())
 		log.Info(fmt.Sprintf("  Configured %d backends", len(backends)))
 
 		var sslConfig *haproxy.SSLConfig
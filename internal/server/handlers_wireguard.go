@@ -239,6 +239,7 @@ func (s *Server) handleInvite(w http.ResponseWriter, r *http.Request) {
 			slog.Warn("wg.Reload", "err", err)
 		}
 		s.rebuildWGForwardChain()
+		s.syncPeerHosts()
 		if err := s.removeInvite(token); err != nil {
 			slog.Warn("removeInvite", "err", err)
 		}
@@ -7,8 +7,13 @@ import (
 func (s *Server) syncLetsEncrypt() {
 	// Derive SSL domains from zones
 	s.letsencrypt = letsencrypt.New(letsencrypt.Config{
-		Domains:        s.cfg().DeriveSSLDomains(),
-		CertDir:        s.cfg().SSLCertDir,
-		HAProxyCertDir: s.cfg().SSLHAProxyCertDir,
+		Domains:          s.cfg().DeriveSSLDomains(),
+		CertDir:          s.cfg().SSLCertDir,
+		HAProxyCertDir:   s.cfg().SSLHAProxyCertDir,
+		CADirectoryURL:   s.cfg().SSLCADirectoryURL,
+		DNSResolvers:     s.cfg().SSLDNSResolvers,
+		PostIssueCommand: s.cfg().SSLPostIssueCommand,
+		PostIssueWebhook: s.cfg().SSLPostIssueWebhook,
+		DryRun:           s.dryRun,
 	})
 }
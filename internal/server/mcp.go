@@ -188,7 +188,7 @@ func (m *MCPServer) handleGetStatus(_ context.Context, _ mcp.CallToolRequest) (*
 		pi := peerInfo{
 			Name:       p.Name,
 			PublicKey:  p.PublicKey,
-			AllowedIPs: p.AllowedIPs,
+			AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 		}
 		if status, ok := ifaceStatus.Peers[p.PublicKey]; ok {
 			pi.Endpoint = status.Endpoint
@@ -215,7 +215,7 @@ func (s *Server) peerSyncSnapshot() PeerSyncStatusSnapshot {
 //   - ListenAddr, WGInterface, WGConfigPath, ServerEndpoint, ServerPublicKey
 //   - PublicIP / PublicIPOverride / PublicIPLastChecked
 //     (each peer manages its own A record / public IP detection)
-//   - LocalInterface (host-specific)
+//   - LocalInterface / LocalInterfaceName (host-specific)
 //   - AdminToken (host-local secret)
 func mergeRemoteIntoLocal(remote, local *config.Config) *config.Config {
 	out := *remote // shallow copy of remote (shared state)
@@ -235,6 +235,7 @@ func mergeRemoteIntoLocal(remote, local *config.Config) *config.Config {
 	out.PublicIPOverride = local.PublicIPOverride
 	out.PublicIPLastChecked = local.PublicIPLastChecked
 	out.LocalInterface = local.LocalInterface
+	out.LocalInterfaceName = local.LocalInterfaceName
 	out.AdminToken = local.AdminToken
 
 	// Host-local iface/CIDR memory — each peer has its own default route,
@@ -28,6 +28,7 @@ func TestMergeRemoteIntoLocal(t *testing.T) {
 		PublicIPOverride:    "203.0.113.50",
 		PublicIPLastChecked: 1700000000,
 		LocalInterface:      "192.168.2.1",
+		LocalInterfaceName:  "eth1",
 		AdminToken:          "local-secret",
 		Services:            []config.Service{{Name: "old-service"}},
 		IPBans:              []config.IPBan{{IP: "10.0.0.99", Reason: "local-ban"}},
@@ -46,6 +47,7 @@ func TestMergeRemoteIntoLocal(t *testing.T) {
 		PublicIPOverride:    "203.0.113.99",
 		PublicIPLastChecked: 1700009999,
 		LocalInterface:      "192.168.1.1",
+		LocalInterfaceName:  "enp3s0",
 		AdminToken:          "remote-secret", // must NOT clobber
 		Services: []config.Service{
 			{Name: "grafana", Domains: []string{"grafana.example.com"}},
@@ -102,6 +104,9 @@ func TestMergeRemoteIntoLocal(t *testing.T) {
 	if merged.LocalInterface != "192.168.2.1" {
 		t.Errorf("LocalInterface clobbered: %s", merged.LocalInterface)
 	}
+	if merged.LocalInterfaceName != "eth1" {
+		t.Errorf("LocalInterfaceName clobbered: %s", merged.LocalInterfaceName)
+	}
 	if merged.AdminToken != "local-secret" {
 		t.Errorf("AdminToken clobbered: %s", merged.AdminToken)
 	}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os/exec"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/iodesystems/homelab-horizon/internal/config"
@@ -73,9 +74,9 @@ func (s *Server) reconcileIPTables() {
 	//
 	// Detection is conservative: bypass token AND no WG-FORWARD reference. A
 	// custom admin PostUp that already mentions WG-FORWARD is left untouched.
-	if isLegacyBypassPostUp(s.wg.GetPostUp()) {
+	if isLegacyBypassPostUp(strings.Join(s.wg.GetPostUp(), "; ")) {
 		slog.Info("iptables-sync: migrating legacy bypass PostUp to chain-based form", "iface", newIface)
-		if err := s.wg.UpdateInterfaceRules(wireguard.ExpectedPostUp(newIface), wireguard.ExpectedPostDown(newIface)); err != nil {
+		if err := s.wg.UpdateInterfaceRules([]string{wireguard.ExpectedPostUp(newIface)}, []string{wireguard.ExpectedPostDown(newIface)}); err != nil {
 			slog.Warn("iptables-sync: migrate wg0.conf failed", "err", err)
 		}
 		// Strip live legacy rules. Loop because the bypass and the state-form
@@ -99,7 +100,7 @@ func (s *Server) reconcileIPTables() {
 	for _, p := range s.wg.GetPeers() {
 		peers = append(peers, iptables.PeerInput{
 			Name:       p.Name,
-			AllowedIPs: p.AllowedIPs,
+			AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 		})
 	}
 
@@ -167,9 +168,15 @@ func (s *Server) reconcileIPTables() {
 		oldUp := s.wg.GetPostUp()
 		oldDown := s.wg.GetPostDown()
 		repl := "-o " + newIface + " -j MASQUERADE"
-		newUp := masqIfaceRe.ReplaceAllString(oldUp, repl)
-		newDown := masqIfaceRe.ReplaceAllString(oldDown, repl)
-		if newUp != oldUp || newDown != oldDown {
+		newUp := make([]string, len(oldUp))
+		for i, cmd := range oldUp {
+			newUp[i] = masqIfaceRe.ReplaceAllString(cmd, repl)
+		}
+		newDown := make([]string, len(oldDown))
+		for i, cmd := range oldDown {
+			newDown[i] = masqIfaceRe.ReplaceAllString(cmd, repl)
+		}
+		if !slices.Equal(newUp, oldUp) || !slices.Equal(newDown, oldDown) {
 			if err := s.wg.UpdateInterfaceRules(newUp, newDown); err != nil {
 				slog.Warn("iptables-sync: rewrite wg0.conf PostUp/Down failed", "err", err)
 			}
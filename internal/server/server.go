@@ -351,7 +351,7 @@ func NewWithConfig(cfg *config.Config, configPath string, dryRun bool, version s
 				cfg.WGPeers[i] = config.WGPeer{
 					Name:       p.Name,
 					PublicKey:  p.PublicKey,
-					AllowedIPs: p.AllowedIPs,
+					AllowedIPs: strings.Join(p.AllowedIPs, ", "),
 				}
 			}
 			slog.Info("migrated WG peers into config.json", "count", len(wgPeers))
@@ -370,18 +370,28 @@ func NewWithConfig(cfg *config.Config, configPath string, dryRun bool, version s
 
 	// Build list of interfaces for dnsmasq: WG interface + any additional configured interfaces
 	dnsInterfaces := append([]string{cfg.WGInterface}, cfg.DNSMasqInterfaces...)
-	dns := dnsmasq.New(cfg.DNSMasqConfigPath, cfg.DNSMasqHostsPath, dnsInterfaces, cfg.UpstreamDNS)
+	dns := dnsmasq.New(cfg.DNSMasqConfigPath, cfg.DNSMasqHostsPath, dnsInterfaces, cfg.UpstreamDNS,
+		dnsmasq.WithFileSystem(fs), dnsmasq.WithCommandRunner(runner),
+		dnsmasq.WithPeerHosts(cfg.DNSMasqPeerHostsPath))
 
 	// Initialize HAProxy with backends derived from services
-	hap := haproxy.New(cfg.HAProxyConfigPath, "/run/haproxy/admin.sock")
+	hap := haproxy.New(cfg.HAProxyConfigPath, "/run/haproxy/admin.sock",
+		haproxy.WithFileSystem(fs), haproxy.WithCommandRunner(runner))
 	hap.SetBackends(cfg.DeriveHAProxyBackends())
+	hap.SetACMEChallengeServer(cfg.HTTP01ChallengeAddress())
 
 	// Initialize Let's Encrypt manager with domains derived from zones
 	le := letsencrypt.New(letsencrypt.Config{
-		Domains:        cfg.DeriveSSLDomains(),
-		CertDir:        cfg.SSLCertDir,
-		HAProxyCertDir: cfg.SSLHAProxyCertDir,
+		Domains:          cfg.DeriveSSLDomains(),
+		CertDir:          cfg.SSLCertDir,
+		HAProxyCertDir:   cfg.SSLHAProxyCertDir,
+		CADirectoryURL:   cfg.SSLCADirectoryURL,
+		DNSResolvers:     cfg.SSLDNSResolvers,
+		PostIssueCommand: cfg.SSLPostIssueCommand,
+		PostIssueWebhook: cfg.SSLPostIssueWebhook,
+		DryRun:           dryRun,
 	})
+	le.SetCommandRunner(runner)
 
 	// Initialize service monitor
 	mon := monitor.New(cfg)
@@ -1119,9 +1129,9 @@ func (s *Server) ensureServicesRunning() {
 	if s.cfg().DNSMasqEnabled {
 		dnsStatus := s.dns.Status()
 
-		// Regenerate config if interfaces are missing
-		if len(dnsStatus.MissingInterfaces) > 0 {
-			slog.Warn("dnsmasq config stale: missing interfaces", "missing", dnsStatus.MissingInterfaces)
+		// Regenerate config if interfaces or the peer hosts file are missing
+		if len(dnsStatus.MissingInterfaces) > 0 || dnsStatus.MissingPeerHosts {
+			slog.Warn("dnsmasq config stale", "missing_interfaces", dnsStatus.MissingInterfaces, "missing_peer_hosts", dnsStatus.MissingPeerHosts)
 			if err := s.dns.WriteConfig(); err != nil {
 				slog.Error("failed to regenerate dnsmasq config", "err", err)
 			} else {
@@ -1150,6 +1160,7 @@ func (s *Server) ensureServicesRunning() {
 		} else {
 			slog.Debug("dnsmasq running")
 		}
+		s.syncPeerHosts()
 	}
 
 	// Ensure HAProxy is running if enabled
@@ -1320,10 +1331,16 @@ func (s *Server) certRenewalSweep() {
 	// Build a fresh LE manager from current config so domain list is up to
 	// date (config may have changed via pull loop since startup).
 	le := letsencrypt.New(letsencrypt.Config{
-		Domains:        sslDomains,
-		CertDir:        cfg.SSLCertDir,
-		HAProxyCertDir: cfg.SSLHAProxyCertDir,
+		Domains:          sslDomains,
+		CertDir:          cfg.SSLCertDir,
+		HAProxyCertDir:   cfg.SSLHAProxyCertDir,
+		CADirectoryURL:   cfg.SSLCADirectoryURL,
+		DNSResolvers:     cfg.SSLDNSResolvers,
+		PostIssueCommand: cfg.SSLPostIssueCommand,
+		PostIssueWebhook: cfg.SSLPostIssueWebhook,
+		DryRun:           s.dryRun,
 	})
+	le.SetCommandRunner(s.runner)
 
 	renewedAny := false
 	for _, domain := range sslDomains {
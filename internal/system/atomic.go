@@ -0,0 +1,34 @@
+package system
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces path with data so that readers, and a crash
+// mid-write, see either the old file or the new one, never a truncated mix.
+// The data goes to a temp file in the same directory (so the rename stays on
+// one filesystem), is chmod'd to perm regardless of umask, and is then
+// renamed over path. The temp file is removed on failure.
+func WriteFileAtomic(fs FileSystem, path string, data []byte, perm os.FileMode) error {
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%x.tmp", filepath.Base(path), suffix))
+
+	if err := fs.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := fs.Chmod(tmp, perm); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+	if err := fs.Rename(tmp, path); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
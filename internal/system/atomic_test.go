@@ -0,0 +1,47 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicDryRun(t *testing.T) {
+	fs := NewDryRunFileSystem()
+	if err := WriteFileAtomic(fs, "/etc/haproxy/haproxy.cfg", []byte("global\n"), 0640); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	written := fs.GetWrittenFiles()
+	if len(written) != 1 || string(written["/etc/haproxy/haproxy.cfg"]) != "global\n" {
+		t.Errorf("written = %v, want only the final path", written)
+	}
+	if mode, _ := fs.GetFileMode("/etc/haproxy/haproxy.cfg"); mode != 0640 {
+		t.Errorf("mode = %o, want 0640", mode)
+	}
+}
+
+func TestWriteFileAtomicReal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wg0.conf")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(&RealFileSystem{}, path, []byte("new"), 0600); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Fatalf("ReadFile = %q, %v; want \"new\"", data, err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %o, want 0600", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("dir has %d entries, want no leftover temp file", len(entries))
+	}
+}
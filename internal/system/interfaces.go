@@ -1,10 +1,18 @@
 package system
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,7 +23,11 @@ type FileSystem interface {
 	Stat(path string) (os.FileInfo, error)
 	Exists(path string) bool
 	Remove(path string) error
+	Rename(oldpath, newpath string) error
 	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(path string) ([]os.DirEntry, error)
+	Chmod(path string, mode os.FileMode) error
+	Open(path string) (io.ReadCloser, error)
 }
 
 type CommandRunner interface {
@@ -24,14 +36,43 @@ type CommandRunner interface {
 	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
 	Start(ctx context.Context, name string, args ...string) (Process, error)
 	LookPath(file string) (string, error)
+	// WithOpts returns a runner that executes commands with opts, replacing
+	// any options the receiver already carries.
+	WithOpts(opts RunOpts) CommandRunner
+}
+
+// RunOpts customizes how a command is executed. The zero value runs the
+// command in the current directory with the current environment.
+type RunOpts struct {
+	// Dir is the working directory; empty means the caller's.
+	Dir string
+	// Env holds extra KEY=value pairs appended to the current environment.
+	// Later entries win over inherited ones with the same key.
+	Env []string
 }
 
 type Process interface {
 	Wait() error
+	// Kill sends SIGKILL; see Signal for anything gentler.
 	Kill() error
+	// Signal delivers sig, e.g. syscall.SIGTERM for a graceful stop or
+	// syscall.SIGHUP to make a daemon reload.
+	Signal(sig os.Signal) error
+	// Pid is the OS process ID, for logging and correlating with journald.
+	Pid() int
 	StdinPipe() (io.WriteCloser, error)
+	// StdoutPipe and StderrPipe stream the process's output, starting with
+	// anything it wrote before the call. Each stream can be claimed once.
 	StdoutPipe() (io.Reader, error)
 	StderrPipe() (io.Reader, error)
+	// CombinedPipe claims both streams and merges them line by line, in
+	// the order the lines were written.
+	CombinedPipe() (io.Reader, error)
+	// Truncated reports how many bytes of each stream were dropped because
+	// the process wrote more than 64 KiB before the stream was claimed.
+	// The pipes deliver the first 64 KiB and then pick up live output, so
+	// nonzero counts mean a gap at that point.
+	Truncated() (stdout, stderr int)
 }
 
 type RealFileSystem struct{}
@@ -57,55 +98,151 @@ func (fs *RealFileSystem) Remove(path string) error {
 	return os.Remove(path)
 }
 
+func (fs *RealFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
 func (fs *RealFileSystem) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
-type RealCommandRunner struct{}
+func (fs *RealFileSystem) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
 
-func (r *RealCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+func (fs *RealFileSystem) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (fs *RealFileSystem) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+type RealCommandRunner struct {
+	opts RunOpts
+}
+
+// waitDelay bounds how long Wait blocks on I/O after the process is killed,
+// so a child that inherited our pipes can't keep a cancelled call hanging.
+const waitDelay = 5 * time.Second
+
+func (r *RealCommandRunner) command(ctx context.Context, name string, args ...string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, name, args...)
-	return cmd.Run()
+	cmd.WaitDelay = waitDelay
+	cmd.Dir = r.opts.Dir
+	if len(r.opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), r.opts.Env...)
+	}
+	return cmd
+}
+
+func (r *RealCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	return r.command(ctx, name, args...).Run()
 }
 
 func (r *RealCommandRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, name, args...)
-	return cmd.Output()
+	return r.command(ctx, name, args...).Output()
 }
 
 func (r *RealCommandRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
-	cmd := exec.CommandContext(ctx, name, args...)
-	return cmd.CombinedOutput()
+	return r.command(ctx, name, args...).CombinedOutput()
 }
 
+// Start launches the command and ties its lifetime to ctx: once ctx is done
+// the process is killed and reaped, even if the caller never calls Wait.
 func (r *RealCommandRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
-	cmd := exec.CommandContext(ctx, name, args...)
+	return start(ctx, r.command(ctx, name, args...))
+}
+
+func start(ctx context.Context, cmd *exec.Cmd) (Process, error) {
+	p := &realProcess{cmd: cmd, done: make(chan struct{})}
+	cmd.Stdout = &p.stdout
+	cmd.Stderr = &p.stderr
 	err := cmd.Start()
 	if err != nil {
 		return nil, err
 	}
-	return &realProcess{cmd: cmd}, nil
+	// Reap in the background so output pipes see EOF when the process
+	// exits, even if the caller reads to the end before calling Wait.
+	go p.wait()
+	if ctx.Done() != nil {
+		go p.watch(ctx)
+	}
+	return p, nil
 }
 
 func (r *RealCommandRunner) LookPath(file string) (string, error) {
 	return exec.LookPath(file)
 }
 
+func (r *RealCommandRunner) WithOpts(opts RunOpts) CommandRunner {
+	return &RealCommandRunner{opts: opts}
+}
+
+// RunWithTimeout runs a command through r, cancelling it if it hasn't
+// finished within d.
+func RunWithTimeout(ctx context.Context, r CommandRunner, d time.Duration, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	err := r.Run(ctx, name, args...)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s: timed out after %s: %w", name, d, context.DeadlineExceeded)
+	}
+	return err
+}
+
 type realProcess struct {
 	cmd *exec.Cmd
 	mu  sync.Mutex
+
+	stdout, stderr outStream
+	pipes          []*io.PipeWriter // claimed readers, closed once output ends
+
+	waitOnce sync.Once
+	waitErr  error
+	done     chan struct{}
+}
+
+func (p *realProcess) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		p.cmd.Process.Kill()
+		p.wait()
+	case <-p.done:
+	}
+}
+
+func (p *realProcess) wait() {
+	p.waitOnce.Do(func() {
+		p.waitErr = p.cmd.Wait()
+		p.stdout.close()
+		p.stderr.close()
+		p.mu.Lock()
+		for _, pw := range p.pipes {
+			pw.Close()
+		}
+		p.pipes = nil
+		p.mu.Unlock()
+		close(p.done)
+	})
 }
 
 func (p *realProcess) Wait() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.cmd.Wait()
+	p.wait()
+	return p.waitErr
 }
 
 func (p *realProcess) Kill() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return p.cmd.Process.Kill()
+	return p.Signal(os.Kill)
+}
+
+func (p *realProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *realProcess) Pid() int {
+	return p.cmd.Process.Pid
 }
 
 func (p *realProcess) StdinPipe() (io.WriteCloser, error) {
@@ -114,16 +251,61 @@ func (p *realProcess) StdinPipe() (io.WriteCloser, error) {
 	return p.cmd.StdinPipe()
 }
 
+func (p *realProcess) Truncated() (stdout, stderr int) {
+	return p.stdout.truncated(), p.stderr.truncated()
+}
+
 func (p *realProcess) StdoutPipe() (io.Reader, error) {
+	return p.pipe(&p.stdout)
+}
+
+func (p *realProcess) StderrPipe() (io.Reader, error) {
+	return p.pipe(&p.stderr)
+}
+
+func (p *realProcess) pipe(s *outStream) (io.Reader, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.cmd.StdoutPipe()
+	pr, pw := io.Pipe()
+	backlog, done, err := s.claim(pw)
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		return bytes.NewReader(backlog), nil
+	}
+	p.pipes = append(p.pipes, pw)
+	return io.MultiReader(bytes.NewReader(backlog), pr), nil
 }
 
-func (p *realProcess) StderrPipe() (io.Reader, error) {
+// CombinedPipe merges stdout and stderr line by line. Output written before
+// the call can't be ordered across the two streams, so that part comes as
+// stdout's backlog followed by stderr's.
+func (p *realProcess) CombinedPipe() (io.Reader, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return p.cmd.StderrPipe()
+	// Claims only happen under p.mu, so checking first keeps a failed call
+	// from leaving stdout claimed by a pipe nobody reads.
+	if p.stdout.isClaimed() || p.stderr.isClaimed() {
+		return nil, errStreamClaimed
+	}
+	pr, pw := io.Pipe()
+	var mu sync.Mutex
+
+	outBacklog, outDone, err := p.stdout.claim(&lineWriter{mu: &mu, w: pw})
+	if err != nil {
+		return nil, err
+	}
+	errBacklog, errDone, err := p.stderr.claim(&lineWriter{mu: &mu, w: pw})
+	if err != nil {
+		return nil, err
+	}
+	backlog := bytes.NewReader(append(outBacklog, errBacklog...))
+	if outDone && errDone {
+		return backlog, nil
+	}
+	p.pipes = append(p.pipes, pw)
+	return io.MultiReader(backlog, pr), nil
 }
 
 type DryRunFileSystem struct {
@@ -133,6 +315,9 @@ type DryRunFileSystem struct {
 	created map[string]bool
 	removed map[string]bool
 	mkdirs  map[string]bool
+	renamed map[string]string
+	modes   map[string]os.FileMode
+	mtimes  map[string]time.Time // set by AddFile, WriteFile and SetModTime
 }
 
 func NewDryRunFileSystem() *DryRunFileSystem {
@@ -142,6 +327,9 @@ func NewDryRunFileSystem() *DryRunFileSystem {
 		created: make(map[string]bool),
 		removed: make(map[string]bool),
 		mkdirs:  make(map[string]bool),
+		renamed: make(map[string]string),
+		modes:   make(map[string]os.FileMode),
+		mtimes:  make(map[string]time.Time),
 	}
 }
 
@@ -149,6 +337,10 @@ func (fs *DryRunFileSystem) ReadFile(path string) ([]byte, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if fs.removed[path] {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
 	if data, exists := fs.written[path]; exists {
 		return data, nil
 	}
@@ -160,10 +352,34 @@ func (fs *DryRunFileSystem) ReadFile(path string) ([]byte, error) {
 	return os.ReadFile(path)
 }
 
+// Open streams the same content ReadFile would return. In-memory files are
+// served from memory; anything else is opened on the real disk.
+func (fs *DryRunFileSystem) Open(path string) (io.ReadCloser, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.removed[path] {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	if data, exists := fs.written[path]; exists {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	if data, exists := fs.files[path]; exists {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return os.Open(path)
+}
+
 func (fs *DryRunFileSystem) WriteFile(path string, data []byte, perm os.FileMode) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	fs.written[path] = data
+	fs.modes[path] = perm
+	fs.mtimes[path] = time.Now()
+	delete(fs.removed, path)
 	return nil
 }
 
@@ -171,8 +387,16 @@ func (fs *DryRunFileSystem) Stat(path string) (os.FileInfo, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	if _, exists := fs.files[path]; exists {
-		return &mockFileInfo{path: path, isDir: false}, nil
+	if fs.removed[path] {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+
+	if data, exists := fs.written[path]; exists {
+		return &mockFileInfo{path: path, isDir: false, mode: fs.modes[path], size: int64(len(data)), modTime: fs.mtimes[path]}, nil
+	}
+
+	if data, exists := fs.files[path]; exists {
+		return &mockFileInfo{path: path, isDir: false, mode: fs.modes[path], size: int64(len(data)), modTime: fs.mtimes[path]}, nil
 	}
 
 	if _, exists := fs.created[path]; exists {
@@ -190,6 +414,14 @@ func (fs *DryRunFileSystem) Exists(path string) bool {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if fs.removed[path] {
+		return false
+	}
+
+	if _, exists := fs.written[path]; exists {
+		return true
+	}
+
 	if _, exists := fs.files[path]; exists {
 		return true
 	}
@@ -213,6 +445,59 @@ func (fs *DryRunFileSystem) Remove(path string) error {
 	return nil
 }
 
+// Rename moves oldpath's content, mode and mod time to newpath and hides
+// oldpath. A source that exists only on the real disk is read into memory
+// under the new name; one that exists nowhere is an os.ErrNotExist error.
+func (fs *DryRunFileSystem) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	_, inWritten := fs.written[oldpath]
+	_, inFiles := fs.files[oldpath]
+	inMemory := inWritten || inFiles || fs.created[oldpath] || fs.mkdirs[oldpath]
+	if fs.removed[oldpath] || !inMemory {
+		if fs.removed[oldpath] {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+		}
+		info, err := os.Stat(oldpath)
+		if err != nil {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+		if !info.IsDir() {
+			data, err := os.ReadFile(oldpath)
+			if err != nil {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+			}
+			fs.written[newpath] = data
+			fs.modes[newpath] = info.Mode().Perm()
+			fs.mtimes[newpath] = info.ModTime()
+		}
+	}
+
+	fs.renamed[oldpath] = newpath
+	if data, exists := fs.written[oldpath]; exists {
+		fs.written[newpath] = data
+		delete(fs.written, oldpath)
+	}
+	if data, exists := fs.files[oldpath]; exists {
+		fs.files[newpath] = data
+		delete(fs.files, oldpath)
+	}
+	if mode, exists := fs.modes[oldpath]; exists {
+		fs.modes[newpath] = mode
+		delete(fs.modes, oldpath)
+	}
+	if mtime, exists := fs.mtimes[oldpath]; exists {
+		fs.mtimes[newpath] = mtime
+		delete(fs.mtimes, oldpath)
+	}
+	delete(fs.created, oldpath)
+	delete(fs.removed, newpath)
+	fs.created[newpath] = true
+	fs.removed[oldpath] = true
+	return nil
+}
+
 func (fs *DryRunFileSystem) MkdirAll(path string, perm os.FileMode) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -220,10 +505,95 @@ func (fs *DryRunFileSystem) MkdirAll(path string, perm os.FileMode) error {
 	return nil
 }
 
+func (fs *DryRunFileSystem) Chmod(path string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.modes[path] = mode
+	return nil
+}
+
+// GetFileMode returns the mode path was last written or chmod'd with.
+func (fs *DryRunFileSystem) GetFileMode(path string) (os.FileMode, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	mode, exists := fs.modes[path]
+	return mode, exists
+}
+
+// ReadDir lists path's direct children: entries on the real disk merged with
+// everything written, created or mkdir'd under path, minus removed paths.
+// A file written deeper down (path/a/b.txt) shows up as directory "a".
+// Entries are sorted by name, like os.ReadDir.
+func (fs *DryRunFileSystem) ReadDir(path string) ([]os.DirEntry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir := filepath.Clean(path)
+	children := make(map[string]bool) // name -> isDir
+
+	realEntries, realErr := os.ReadDir(path)
+	for _, e := range realEntries {
+		if !fs.removed[filepath.Join(dir, e.Name())] {
+			children[e.Name()] = e.IsDir()
+		}
+	}
+
+	dirExists := false
+	add := func(p string, isDir bool) {
+		p = filepath.Clean(p)
+		if fs.removed[p] {
+			return
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+		dirExists = true
+		if rel == "." {
+			return
+		}
+		name, _, nested := strings.Cut(rel, string(filepath.Separator))
+		children[name] = children[name] || isDir || nested
+	}
+	for p := range fs.files {
+		add(p, false)
+	}
+	for p := range fs.written {
+		add(p, false)
+	}
+	for p := range fs.created {
+		add(p, false)
+	}
+	for p := range fs.mkdirs {
+		add(p, true)
+	}
+
+	if realErr != nil && !dirExists {
+		return nil, realErr
+	}
+
+	entries := make([]os.DirEntry, 0, len(children))
+	for name, isDir := range children {
+		entries = append(entries, &mockDirEntry{name: name, isDir: isDir})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
 func (fs *DryRunFileSystem) AddFile(path string, data []byte) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 	fs.files[path] = data
+	fs.mtimes[path] = time.Now()
+	delete(fs.removed, path)
+}
+
+// SetModTime overrides the mod time Stat reports for path, e.g. to make a
+// seeded certificate look old enough to renew.
+func (fs *DryRunFileSystem) SetModTime(path string, t time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mtimes[path] = t
 }
 
 func (fs *DryRunFileSystem) GetWrittenFiles() map[string][]byte {
@@ -246,6 +616,16 @@ func (fs *DryRunFileSystem) GetRemovedFiles() map[string]bool {
 	return result
 }
 
+func (fs *DryRunFileSystem) GetRenamedFiles() map[string]string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	result := make(map[string]string)
+	for k, v := range fs.renamed {
+		result[k] = v
+	}
+	return result
+}
+
 func (fs *DryRunFileSystem) GetCreatedDirs() map[string]bool {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -259,102 +639,285 @@ func (fs *DryRunFileSystem) GetCreatedDirs() map[string]bool {
 type DryRunCommandRunner struct {
 	mu     sync.Mutex
 	ran    []string
+	args   [][]string // ran[i] as passed, before joining
+	opts   []RunOpts  // what ran[i] ran with
 	output map[string][]byte
 	errors map[string]error
+	rules  []matchRule
+	procs  map[string]procOutput
+	pids   int // processes started so far, for fake PIDs
+}
+
+// mockPIDBase offsets the fake PIDs DryRunCommandRunner hands out so they
+// don't look like low system PIDs.
+const mockPIDBase = 10000
+
+// matchRule is a stub registered with AddOutputMatch or AddErrorMatch.
+type matchRule struct {
+	re     *regexp.Regexp
+	output []byte
+	err    error
 }
 
 func NewDryRunCommandRunner() *DryRunCommandRunner {
 	return &DryRunCommandRunner{
 		ran:    make([]string, 0),
+		args:   make([][]string, 0),
+		opts:   make([]RunOpts, 0),
 		output: make(map[string][]byte),
 		errors: make(map[string]error),
+		procs:  make(map[string]procOutput),
 	}
 }
 
 func (r *DryRunCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	_, err := r.exec(RunOpts{}, name, args)
+	return err
+}
+
+func (r *DryRunCommandRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return r.exec(RunOpts{}, name, args)
+}
+
+func (r *DryRunCommandRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return r.exec(RunOpts{}, name, args)
+}
+
+func (r *DryRunCommandRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+	return r.start(RunOpts{}, name, args)
+}
+
+func (r *DryRunCommandRunner) LookPath(file string) (string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.record(RunOpts{}, "lookpath:", []string{file})
+	return "/usr/bin/" + file, nil
+}
 
-	cmd := append([]string{name}, args...)
-	cmdStr := commandString(cmd)
-	r.ran = append(r.ran, cmdStr)
+// WithOpts returns a runner that records opts with each command it runs.
+// It shares the receiver's stubs and run log.
+func (r *DryRunCommandRunner) WithOpts(opts RunOpts) CommandRunner {
+	return &dryRunOptsRunner{r: r, opts: opts}
+}
 
-	if err, exists := r.errors[cmdStr]; exists {
-		return err
-	}
+func (r *DryRunCommandRunner) exec(opts RunOpts, name string, args []string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	return nil
+	cmdStr := r.record(opts, name, args)
+
+	output, err := r.lookup(cmdStr)
+	if err != nil {
+		return nil, err
+	}
+	if output == nil {
+		return []byte{}, nil
+	}
+	return output, nil
 }
 
-func (r *DryRunCommandRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+func (r *DryRunCommandRunner) start(opts RunOpts, name string, args []string) (Process, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	cmd := append([]string{name}, args...)
-	cmdStr := commandString(cmd)
-	r.ran = append(r.ran, cmdStr)
+	cmdStr := r.record(opts, name, args)
 
-	if err, exists := r.errors[cmdStr]; exists {
+	if _, err := r.lookup(cmdStr); err != nil {
 		return nil, err
 	}
 
-	if output, exists := r.output[cmdStr]; exists {
-		return output, nil
-	}
+	r.pids++
+	return &mockProcess{procOutput: r.procs[cmdStr], pid: mockPIDBase + r.pids}, nil
+}
 
-	return []byte{}, nil
+// dryRunOptsRunner is a DryRunCommandRunner bound to a set of RunOpts.
+type dryRunOptsRunner struct {
+	r    *DryRunCommandRunner
+	opts RunOpts
 }
 
-func (r *DryRunCommandRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
-	return r.Output(ctx, name, args...)
+func (d *dryRunOptsRunner) Run(ctx context.Context, name string, args ...string) error {
+	_, err := d.r.exec(d.opts, name, args)
+	return err
 }
 
-func (r *DryRunCommandRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+func (d *dryRunOptsRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return d.r.exec(d.opts, name, args)
+}
+
+func (d *dryRunOptsRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return d.r.exec(d.opts, name, args)
+}
+
+func (d *dryRunOptsRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+	return d.r.start(d.opts, name, args)
+}
+
+func (d *dryRunOptsRunner) LookPath(file string) (string, error) {
+	return d.r.LookPath(file)
+}
+
+func (d *dryRunOptsRunner) WithOpts(opts RunOpts) CommandRunner {
+	return d.r.WithOpts(opts)
+}
+
+func (r *DryRunCommandRunner) AddOutput(command string, output []byte) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.output[command] = output
+}
+
+func (r *DryRunCommandRunner) AddError(command string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[command] = err
+}
 
+// AddOutputMatch returns output for any command whose full command string
+// matches the regular expression pattern, e.g. "wg show .*". Exact stubs
+// from AddOutput/AddError win; otherwise rules are tried in the order they
+// were added. Panics if pattern does not compile.
+func (r *DryRunCommandRunner) AddOutputMatch(pattern string, output []byte) {
+	r.addRule(pattern, matchRule{output: output})
+}
+
+// AddErrorMatch is AddOutputMatch for errors.
+func (r *DryRunCommandRunner) AddErrorMatch(pattern string, err error) {
+	r.addRule(pattern, matchRule{err: err})
+}
+
+func (r *DryRunCommandRunner) addRule(pattern string, rule matchRule) {
+	rule.re = regexp.MustCompile("^(?:" + pattern + ")$")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule)
+}
+
+// AddProcessOutput sets what the Process returned by Start(command) streams
+// on its stdout and stderr pipes, and the error its Wait returns.
+func (r *DryRunCommandRunner) AddProcessOutput(command string, stdout, stderr []byte, waitErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.procs[command] = procOutput{stdout: stdout, stderr: stderr, waitErr: waitErr}
+}
+
+// record appends a command to the run log and returns its display string.
+// Caller holds r.mu.
+func (r *DryRunCommandRunner) record(opts RunOpts, name string, args []string) string {
 	cmd := append([]string{name}, args...)
 	cmdStr := commandString(cmd)
 	r.ran = append(r.ran, cmdStr)
+	r.args = append(r.args, cmd)
+	r.opts = append(r.opts, opts)
+	return cmdStr
+}
 
+// lookup returns the stubbed output or error for cmdStr. Caller holds r.mu.
+func (r *DryRunCommandRunner) lookup(cmdStr string) ([]byte, error) {
 	if err, exists := r.errors[cmdStr]; exists {
 		return nil, err
 	}
+	if output, exists := r.output[cmdStr]; exists {
+		return output, nil
+	}
+	for _, rule := range r.rules {
+		if rule.re.MatchString(cmdStr) {
+			return rule.output, rule.err
+		}
+	}
+	return nil, nil
+}
 
-	return &mockProcess{}, nil
+func (r *DryRunCommandRunner) GetRunCommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ran
 }
 
-func (r *DryRunCommandRunner) LookPath(file string) (string, error) {
+// GetRunCommandArgs returns each recorded command as its name followed by
+// its arguments, in the same order as GetRunCommands. Unlike the joined
+// strings, it keeps arguments that contain spaces intact.
+func (r *DryRunCommandRunner) GetRunCommandArgs() [][]string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.ran = append(r.ran, "lookpath: "+file)
-	return "/usr/bin/" + file, nil
+	result := make([][]string, len(r.args))
+	for i, cmd := range r.args {
+		result[i] = slices.Clone(cmd)
+	}
+	return result
 }
 
-func (r *DryRunCommandRunner) AddOutput(command string, output []byte) {
+// CountMatching returns how many recorded commands match the regular
+// expression pattern, anchored the same way as AddOutputMatch. Panics if
+// pattern does not compile.
+func (r *DryRunCommandRunner) CountMatching(pattern string) int {
+	re := regexp.MustCompile("^(?:" + pattern + ")$")
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.output[command] = output
+	n := 0
+	for _, cmd := range r.ran {
+		if re.MatchString(cmd) {
+			n++
+		}
+	}
+	return n
 }
 
-func (r *DryRunCommandRunner) AddError(command string, err error) {
+// CallCount returns how many times exactly command ran.
+func (r *DryRunCommandRunner) CallCount(command string) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.errors[command] = err
+	n := 0
+	for _, cmd := range r.ran {
+		if cmd == command {
+			n++
+		}
+	}
+	return n
 }
 
-func (r *DryRunCommandRunner) GetRunCommands() []string {
+// AssertSequence checks that the commands in seq ran in that relative
+// order. Other commands may run before, between or after them. The error
+// names the first command that's missing from the sequence.
+func (r *DryRunCommandRunner) AssertSequence(seq []string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.ran
+
+	i := 0
+	for _, cmd := range r.ran {
+		if i < len(seq) && cmd == seq[i] {
+			i++
+		}
+	}
+	switch {
+	case i == len(seq):
+		return nil
+	case i == 0:
+		return fmt.Errorf("command %q never ran", seq[0])
+	default:
+		return fmt.Errorf("command %q (step %d of %d) did not run after %q", seq[i], i+1, len(seq), seq[i-1])
+	}
+}
+
+// GetRunOpts returns the options each recorded command ran with, in the
+// same order as GetRunCommands. Commands run without WithOpts have the zero
+// RunOpts.
+func (r *DryRunCommandRunner) GetRunOpts() []RunOpts {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.opts)
 }
 
 func (r *DryRunCommandRunner) Clear() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.ran = r.ran[:0]
+	r.args = r.args[:0]
+	r.opts = r.opts[:0]
 	r.output = make(map[string][]byte)
 	r.errors = make(map[string]error)
+	r.rules = nil
+	r.procs = make(map[string]procOutput)
 }
 
 func commandString(cmd []string) string {
@@ -366,21 +929,86 @@ func commandString(cmd []string) string {
 }
 
 type mockFileInfo struct {
-	path  string
-	isDir bool
+	path    string
+	isDir   bool
+	mode    os.FileMode // 0 reports 0644
+	size    int64
+	modTime time.Time
 }
 
 func (fi *mockFileInfo) Name() string       { return fi.path }
-func (fi *mockFileInfo) Size() int64        { return 0 }
-func (fi *mockFileInfo) Mode() os.FileMode  { return 0644 }
-func (fi *mockFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *mockFileInfo) Size() int64        { return fi.size }
+func (fi *mockFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *mockFileInfo) Sys() any           { return nil }
 func (fi *mockFileInfo) IsDir() bool        { return fi.isDir }
 
-type mockProcess struct{}
+func (fi *mockFileInfo) Mode() os.FileMode {
+	if fi.mode != 0 {
+		return fi.mode
+	}
+	return 0644
+}
 
-func (p *mockProcess) Wait() error                        { return nil }
-func (p *mockProcess) Kill() error                        { return nil }
+type mockDirEntry struct {
+	name  string
+	isDir bool
+}
+
+func (e *mockDirEntry) Name() string { return e.name }
+func (e *mockDirEntry) IsDir() bool  { return e.isDir }
+func (e *mockDirEntry) Type() os.FileMode {
+	if e.isDir {
+		return os.ModeDir
+	}
+	return 0
+}
+func (e *mockDirEntry) Info() (os.FileInfo, error) {
+	return &mockFileInfo{path: e.name, isDir: e.isDir}, nil
+}
+
+// procOutput is what AddProcessOutput registers for a command.
+type procOutput struct {
+	stdout  []byte
+	stderr  []byte
+	waitErr error
+}
+
+// mockProcess is the Process handed out by DryRunCommandRunner.Start. Its
+// pipes replay the data registered with AddProcessOutput, and it records
+// the last signal sent so tests can assert on it via LastSignal.
+type mockProcess struct {
+	procOutput
+
+	pid int
+
+	mu         sync.Mutex
+	lastSignal os.Signal
+}
+
+func (p *mockProcess) Wait() error                        { return p.waitErr }
+func (p *mockProcess) Pid() int                           { return p.pid }
+func (p *mockProcess) Kill() error                        { return p.Signal(os.Kill) }
 func (p *mockProcess) StdinPipe() (io.WriteCloser, error) { return nil, nil }
-func (p *mockProcess) StdoutPipe() (io.Reader, error)     { return nil, nil }
-func (p *mockProcess) StderrPipe() (io.Reader, error)     { return nil, nil }
+func (p *mockProcess) StdoutPipe() (io.Reader, error)     { return bytes.NewReader(p.stdout), nil }
+func (p *mockProcess) StderrPipe() (io.Reader, error)     { return bytes.NewReader(p.stderr), nil }
+func (p *mockProcess) Truncated() (stdout, stderr int)    { return 0, 0 }
+
+// CombinedPipe replays stdout followed by stderr; the mock has no timing to
+// interleave by.
+func (p *mockProcess) CombinedPipe() (io.Reader, error) {
+	return io.MultiReader(bytes.NewReader(p.stdout), bytes.NewReader(p.stderr)), nil
+}
+
+func (p *mockProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastSignal = sig
+	return nil
+}
+
+// LastSignal returns the most recent signal sent with Signal or Kill, or nil.
+func (p *mockProcess) LastSignal() os.Signal {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastSignal
+}
@@ -2,7 +2,16 @@ package system
 
 import (
 	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestDryRunFileSystem(t *testing.T) {
@@ -68,6 +77,280 @@ func TestDryRunFileSystem(t *testing.T) {
 	}
 }
 
+func TestDryRunFileSystemRename(t *testing.T) {
+	fs := NewDryRunFileSystem()
+
+	fs.WriteFile("/etc/wg0.conf.tmp", []byte("new config"), 0600)
+	if err := fs.Rename("/etc/wg0.conf.tmp", "/etc/wg0.conf"); err != nil {
+		t.Fatalf("Unexpected error renaming file: %v", err)
+	}
+
+	if !fs.Exists("/etc/wg0.conf") {
+		t.Error("Expected renamed path to exist")
+	}
+
+	data, err := fs.ReadFile("/etc/wg0.conf")
+	if err != nil {
+		t.Fatalf("Unexpected error reading renamed file: %v", err)
+	}
+	if string(data) != "new config" {
+		t.Errorf("Expected renamed content, got %q", data)
+	}
+
+	written := fs.GetWrittenFiles()
+	if _, exists := written["/etc/wg0.conf.tmp"]; exists {
+		t.Error("Expected temp path to be gone from written files")
+	}
+
+	renamed := fs.GetRenamedFiles()
+	if renamed["/etc/wg0.conf.tmp"] != "/etc/wg0.conf" {
+		t.Errorf("Expected rename to be recorded, got %v", renamed)
+	}
+
+	fs.AddFile("/old.txt", []byte("content"))
+	fs.Rename("/old.txt", "/new.txt")
+	if fs.Exists("/old.txt") {
+		t.Error("Expected old path to no longer exist")
+	}
+	if !fs.Exists("/new.txt") {
+		t.Error("Expected new path to exist")
+	}
+}
+
+func TestDryRunFileSystemRenameHidesSource(t *testing.T) {
+	fs := NewDryRunFileSystem()
+	fs.WriteFile("/etc/wg0.conf.tmp", []byte("new config"), 0600)
+	fs.Rename("/etc/wg0.conf.tmp", "/etc/wg0.conf")
+
+	if !fs.GetRemovedFiles()["/etc/wg0.conf.tmp"] {
+		t.Error("Expected the source to be recorded as removed")
+	}
+	if _, err := fs.ReadFile("/etc/wg0.conf.tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadFile(source) error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDryRunFileSystemRenameRealFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "wg0.conf")
+	if err := os.WriteFile(src, []byte("on disk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewDryRunFileSystem()
+
+	dst := filepath.Join(dir, "wg0.conf.bak")
+	if err := fs.Rename(src, dst); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	data, err := fs.ReadFile(dst)
+	if err != nil || string(data) != "on disk" {
+		t.Errorf("ReadFile(dst) = %q, %v; want the real file's content", data, err)
+	}
+	if mode, _ := fs.GetFileMode(dst); mode != 0600 {
+		t.Errorf("mode = %o, want 0600 from the real file", mode)
+	}
+	if fs.Exists(src) {
+		t.Error("Expected the source to be hidden")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("dry run touched the real file: %v", err)
+	}
+}
+
+func TestDryRunFileSystemRenameMissingSource(t *testing.T) {
+	fs := NewDryRunFileSystem()
+	dst := filepath.Join(t.TempDir(), "new")
+
+	err := fs.Rename(filepath.Join(t.TempDir(), "missing"), dst)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Rename(missing) error = %v, want ErrNotExist", err)
+	}
+	if fs.Exists(dst) || len(fs.GetRenamedFiles()) != 0 {
+		t.Error("failed Rename left a trace")
+	}
+}
+
+func TestDryRunFileSystemRemoveHidesFile(t *testing.T) {
+	fs := NewDryRunFileSystem()
+
+	fs.AddFile("/added.txt", []byte("added"))
+	fs.WriteFile("/written.txt", []byte("written"), 0644)
+
+	realPath := filepath.Join(t.TempDir(), "real.txt")
+	if err := os.WriteFile(realPath, []byte("real"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/added.txt", "/written.txt", realPath} {
+		if !fs.Exists(path) {
+			t.Errorf("Expected %s to exist before Remove", path)
+		}
+		fs.Remove(path)
+
+		if fs.Exists(path) {
+			t.Errorf("Expected %s not to exist after Remove", path)
+		}
+		if _, err := fs.ReadFile(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected ErrNotExist reading %s after Remove, got %v", path, err)
+		}
+		if _, err := fs.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected ErrNotExist from Stat on %s after Remove, got %v", path, err)
+		}
+	}
+
+	if _, err := os.Stat(realPath); err != nil {
+		t.Error("Expected real file to be left on disk")
+	}
+
+	fs.WriteFile("/written.txt", []byte("rewritten"), 0644)
+	data, err := fs.ReadFile("/written.txt")
+	if err != nil || string(data) != "rewritten" {
+		t.Errorf("Expected rewrite after Remove to be readable, got %q, %v", data, err)
+	}
+}
+
+func TestDryRunFileSystemOpen(t *testing.T) {
+	tmpDir := t.TempDir()
+	onDisk := filepath.Join(tmpDir, "chain.pem")
+	os.WriteFile(onDisk, []byte("disk"), 0644)
+
+	fs := NewDryRunFileSystem()
+	fs.WriteFile("/etc/written.pem", []byte("written"), 0600)
+	fs.AddFile("/etc/added.pem", []byte("added"))
+
+	for path, want := range map[string]string{
+		"/etc/written.pem": "written",
+		"/etc/added.pem":   "added",
+		onDisk:             "disk",
+	} {
+		rc, err := fs.Open(path)
+		if err != nil {
+			t.Fatalf("Open(%s) error = %v", path, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != want {
+			t.Errorf("Open(%s) read %q, want %q", path, data, want)
+		}
+	}
+
+	fs.Remove(onDisk)
+	if _, err := fs.Open(onDisk); !os.IsNotExist(err) {
+		t.Errorf("Expected removed file to be hidden from Open, got %v", err)
+	}
+}
+
+func TestDryRunFileSystemModes(t *testing.T) {
+	fs := NewDryRunFileSystem()
+
+	fs.WriteFile("/etc/wireguard/wg0.conf", []byte("[Interface]"), 0600)
+	if mode, ok := fs.GetFileMode("/etc/wireguard/wg0.conf"); !ok || mode != 0600 {
+		t.Errorf("Expected WriteFile perm 0600 to be recorded, got %o, %v", mode, ok)
+	}
+	if info, _ := fs.Stat("/etc/wireguard/wg0.conf"); info.Mode() != 0600 {
+		t.Errorf("Expected Stat to report 0600, got %o", info.Mode())
+	}
+
+	fs.Chmod("/etc/wireguard/wg0.conf", 0640)
+	if mode, _ := fs.GetFileMode("/etc/wireguard/wg0.conf"); mode != 0640 {
+		t.Errorf("Expected Chmod to override mode, got %o", mode)
+	}
+
+	fs.Rename("/etc/wireguard/wg0.conf", "/etc/wireguard/wg1.conf")
+	if mode, ok := fs.GetFileMode("/etc/wireguard/wg1.conf"); !ok || mode != 0640 {
+		t.Errorf("Expected mode to follow Rename, got %o, %v", mode, ok)
+	}
+	if _, ok := fs.GetFileMode("/etc/wireguard/wg0.conf"); ok {
+		t.Error("Expected no mode for the old name after Rename")
+	}
+
+	if _, ok := fs.GetFileMode("/never/written"); ok {
+		t.Error("Expected no mode for an untouched path")
+	}
+}
+
+func TestDryRunFileSystemStatSizeAndModTime(t *testing.T) {
+	fs := NewDryRunFileSystem()
+
+	before := time.Now()
+	fs.AddFile("/etc/letsencrypt/cert.pem", []byte("seeded"))
+	fs.WriteFile("/etc/haproxy/certs/site.pem", []byte("written!"), 0600)
+
+	for path, size := range map[string]int64{
+		"/etc/letsencrypt/cert.pem":   6,
+		"/etc/haproxy/certs/site.pem": 8,
+	} {
+		info, err := fs.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if info.Size() != size {
+			t.Errorf("Stat(%s).Size() = %d, want %d", path, info.Size(), size)
+		}
+		if info.ModTime().Before(before) {
+			t.Errorf("Stat(%s).ModTime() = %v, want >= %v", path, info.ModTime(), before)
+		}
+	}
+
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	fs.SetModTime("/etc/letsencrypt/cert.pem", old)
+	fs.Rename("/etc/letsencrypt/cert.pem", "/etc/letsencrypt/cert.pem.bak")
+	info, err := fs.Stat("/etc/letsencrypt/cert.pem.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("ModTime after Rename = %v, want %v", info.ModTime(), old)
+	}
+}
+
+func TestDryRunFileSystemReadDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "on-disk.conf"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "stale.conf"), []byte("x"), 0644)
+
+	fs := NewDryRunFileSystem()
+	fs.WriteFile(filepath.Join(tmpDir, "peers", "alice.conf"), []byte("a"), 0600)
+	fs.WriteFile(filepath.Join(tmpDir, "wg0.conf"), []byte("w"), 0600)
+	fs.AddFile(filepath.Join(tmpDir, "added.pem"), []byte("p"))
+	fs.MkdirAll(filepath.Join(tmpDir, "certs"), 0700)
+	fs.WriteFile(tmpDir+"-sibling/other.conf", []byte("o"), 0600)
+	fs.Remove(filepath.Join(tmpDir, "stale.conf"))
+
+	entries, err := fs.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	want := []struct {
+		name  string
+		isDir bool
+	}{
+		{"added.pem", false},
+		{"certs", true},
+		{"on-disk.conf", false},
+		{"peers", true},
+		{"wg0.conf", false},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %d: %v", len(want), len(entries), entries)
+	}
+	for i, w := range want {
+		if entries[i].Name() != w.name || entries[i].IsDir() != w.isDir {
+			t.Errorf("entry %d = %s (dir=%v), want %s (dir=%v)",
+				i, entries[i].Name(), entries[i].IsDir(), w.name, w.isDir)
+		}
+	}
+
+	peers, err := fs.ReadDir(filepath.Join(tmpDir, "peers"))
+	if err != nil || len(peers) != 1 || peers[0].Name() != "alice.conf" {
+		t.Errorf("Expected virtual dir to list alice.conf, got %v, %v", peers, err)
+	}
+
+	if _, err := fs.ReadDir("/non/existent/dir"); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error for unknown dir, got %v", err)
+	}
+}
+
 func TestDryRunCommandRunner(t *testing.T) {
 	runner := NewDryRunCommandRunner()
 
@@ -125,6 +408,43 @@ func TestDryRunCommandRunner(t *testing.T) {
 	}
 }
 
+func TestDryRunCommandRunnerMatch(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	ctx := context.Background()
+
+	runner.AddOutputMatch("wg show .*", []byte("generic"))
+	runner.AddErrorMatch("wg .*", errors.New("wg failed"))
+	runner.AddOutput("wg show wg0 dump", []byte("exact"))
+
+	output, err := runner.Output(ctx, "wg", "show", "wg0", "dump")
+	if err != nil || string(output) != "exact" {
+		t.Errorf("Expected exact stub to win, got %q, %v", output, err)
+	}
+
+	output, err = runner.Output(ctx, "wg", "show", "wg1", "peers")
+	if err != nil || string(output) != "generic" {
+		t.Errorf("Expected first matching rule, got %q, %v", output, err)
+	}
+
+	if err := runner.Run(ctx, "wg", "syncconf", "wg0", "/dev/stdin"); err == nil || err.Error() != "wg failed" {
+		t.Errorf("Expected error rule to match, got %v", err)
+	}
+	if _, err := runner.Start(ctx, "wg", "set", "wg0"); err == nil {
+		t.Error("Expected error rule to apply to Start")
+	}
+
+	// Patterns are anchored to the whole command string.
+	if err := runner.Run(ctx, "sudo", "wg", "show"); err != nil {
+		t.Errorf("Expected unanchored prefix not to match, got %v", err)
+	}
+
+	runner.Clear()
+	output, err = runner.Output(ctx, "wg", "show", "wg1", "peers")
+	if err != nil || len(output) != 0 {
+		t.Errorf("Expected Clear to drop rules, got %q, %v", output, err)
+	}
+}
+
 func TestRealFileSystem(t *testing.T) {
 	fs := &RealFileSystem{}
 
@@ -230,6 +550,117 @@ func TestMockProcess(t *testing.T) {
 	}
 }
 
+func TestDryRunCommandRunnerProcessOutput(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	ctx := context.Background()
+
+	waitErr := errors.New("exit status 1")
+	runner.AddProcessOutput("journalctl -f", []byte("line 1\nline 2\n"), []byte("warning\n"), waitErr)
+
+	p, err := runner.Start(ctx, "journalctl", "-f")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	stdout, _ := p.StdoutPipe()
+	stderr, _ := p.StderrPipe()
+	if out, _ := io.ReadAll(stdout); string(out) != "line 1\nline 2\n" {
+		t.Errorf("Expected stdout data, got %q", out)
+	}
+	if out, _ := io.ReadAll(stderr); string(out) != "warning\n" {
+		t.Errorf("Expected stderr data, got %q", out)
+	}
+	if err := p.Wait(); err != waitErr {
+		t.Errorf("Expected configured Wait error, got %v", err)
+	}
+
+	// Unregistered commands still get empty pipes and a clean exit.
+	p, _ = runner.Start(ctx, "sleep", "1")
+	stdout, _ = p.StdoutPipe()
+	if out, _ := io.ReadAll(stdout); len(out) != 0 {
+		t.Errorf("Expected empty stdout, got %q", out)
+	}
+	if err := p.Wait(); err != nil {
+		t.Errorf("Expected nil Wait error, got %v", err)
+	}
+}
+
+func TestDryRunCommandRunnerArgs(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	ctx := context.Background()
+	rule := "iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE"
+	runner.Run(ctx, "sh", "-c", rule)
+	runner.Output(ctx, "wg", "show", "wg0")
+
+	want := [][]string{{"sh", "-c", rule}, {"wg", "show", "wg0"}}
+	got := runner.GetRunCommandArgs()
+	if !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Fatalf("GetRunCommandArgs() = %q, want %q", got, want)
+	}
+	if cmds := runner.GetRunCommands(); len(cmds) != len(got) {
+		t.Errorf("GetRunCommands() has %d entries, GetRunCommandArgs() %d", len(cmds), len(got))
+	}
+
+	got[0][2] = "mutated"
+	if runner.GetRunCommandArgs()[0][2] != rule {
+		t.Error("GetRunCommandArgs() should return a copy")
+	}
+
+	runner.Clear()
+	if got := runner.GetRunCommandArgs(); len(got) != 0 {
+		t.Errorf("GetRunCommandArgs() after Clear = %q, want empty", got)
+	}
+}
+
+func TestDryRunCommandRunnerCallCounts(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	ctx := context.Background()
+	runner.Run(ctx, "systemctl", "reload", "dnsmasq")
+	runner.Run(ctx, "systemctl", "reload", "haproxy")
+	runner.Run(ctx, "systemctl", "reload", "dnsmasq")
+
+	if got := runner.CallCount("systemctl reload dnsmasq"); got != 2 {
+		t.Errorf("CallCount(dnsmasq) = %d, want 2", got)
+	}
+	if got := runner.CallCount("systemctl reload"); got != 0 {
+		t.Errorf("CallCount(prefix) = %d, want 0", got)
+	}
+	if got := runner.CountMatching("systemctl reload .*"); got != 3 {
+		t.Errorf("CountMatching(reload .*) = %d, want 3", got)
+	}
+	// Patterns are anchored: a bare substring doesn't match.
+	if got := runner.CountMatching("reload"); got != 0 {
+		t.Errorf("CountMatching(reload) = %d, want 0", got)
+	}
+}
+
+func TestDryRunCommandRunnerAssertSequence(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	ctx := context.Background()
+	runner.Run(ctx, "sysctl", "-w", "net.ipv4.ip_forward=1")
+	runner.Run(ctx, "iptables", "-L")
+	runner.Run(ctx, "iptables", "-A", "FORWARD", "-i", "wg0", "-j", "ACCEPT")
+	runner.Run(ctx, "wg-quick", "up", "wg0")
+
+	if err := runner.AssertSequence([]string{
+		"sysctl -w net.ipv4.ip_forward=1",
+		"iptables -A FORWARD -i wg0 -j ACCEPT",
+		"wg-quick up wg0",
+	}); err != nil {
+		t.Errorf("AssertSequence() = %v, want nil", err)
+	}
+	if err := runner.AssertSequence(nil); err != nil {
+		t.Errorf("AssertSequence(nil) = %v, want nil", err)
+	}
+
+	err := runner.AssertSequence([]string{"wg-quick up wg0", "sysctl -w net.ipv4.ip_forward=1"})
+	if err == nil || !strings.Contains(err.Error(), "step 2 of 2") {
+		t.Errorf("AssertSequence() out of order = %v, want step 2 failure", err)
+	}
+	if err := runner.AssertSequence([]string{"wg-quick down wg0"}); err == nil {
+		t.Error("AssertSequence() with a command that never ran should fail")
+	}
+}
+
 func TestRealProcess(t *testing.T) {
 	runner := &RealCommandRunner{}
 
@@ -238,11 +669,326 @@ func TestRealProcess(t *testing.T) {
 		t.Fatalf("Failed to start sleep process: %v", err)
 	}
 
+	if proc.Pid() <= 0 {
+		t.Errorf("Expected a real PID, got %d", proc.Pid())
+	}
+
 	if err := proc.Wait(); err != nil {
 		t.Errorf("Process wait failed: %v", err)
 	}
 }
 
+func TestRealProcessPipes(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	proc, err := runner.Start(context.Background(), "sh", "-c", "echo out; echo err >&2")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	stdout, err := proc.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe() error = %v", err)
+	}
+	if out, _ := io.ReadAll(stdout); string(out) != "out\n" {
+		t.Errorf("stdout = %q, want %q", out, "out\n")
+	}
+	if _, err := proc.StdoutPipe(); err == nil {
+		t.Error("Expected error claiming stdout twice")
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	// Output written before the claim, even after exit, is still delivered.
+	stderr, err := proc.StderrPipe()
+	if err != nil {
+		t.Fatalf("StderrPipe() error = %v", err)
+	}
+	if out, _ := io.ReadAll(stderr); string(out) != "err\n" {
+		t.Errorf("stderr = %q, want %q", out, "err\n")
+	}
+}
+
+func TestRealProcessTruncatedBacklog(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	// 100 KiB on stdout before anyone reads it; stderr stays small.
+	proc, err := runner.Start(context.Background(), "sh", "-c", "head -c 102400 /dev/zero; echo err >&2")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	stdout, _ := proc.StdoutPipe()
+	out, _ := io.ReadAll(stdout)
+	if len(out) != maxBacklog {
+		t.Errorf("Expected %d bytes of backlog, got %d", maxBacklog, len(out))
+	}
+	if o, e := proc.Truncated(); o != 102400-maxBacklog || e != 0 {
+		t.Errorf("Truncated() = %d, %d; want %d, 0", o, e, 102400-maxBacklog)
+	}
+}
+
+func TestRealProcessCombinedPipe(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	script := "echo out1; sleep 0.1; echo err1 >&2; sleep 0.1; echo out2; sleep 0.1; printf err2 >&2"
+	proc, err := runner.Start(context.Background(), "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	combined, err := proc.CombinedPipe()
+	if err != nil {
+		t.Fatalf("CombinedPipe() error = %v", err)
+	}
+	out, _ := io.ReadAll(combined)
+	if want := "out1\nerr1\nout2\nerr2"; string(out) != want {
+		t.Errorf("CombinedPipe() = %q, want %q", out, want)
+	}
+	if err := proc.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if _, err := proc.StderrPipe(); err == nil {
+		t.Error("Expected error claiming stderr after CombinedPipe")
+	}
+}
+
+func TestMockProcessCombinedPipe(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	runner.AddProcessOutput("wg-quick up wg0", []byte("[#] ip link add wg0\n"), []byte("RTNETLINK answers: File exists\n"), nil)
+
+	p, _ := runner.Start(context.Background(), "wg-quick", "up", "wg0")
+	r, err := p.CombinedPipe()
+	if err != nil {
+		t.Fatalf("CombinedPipe() error = %v", err)
+	}
+	if out, _ := io.ReadAll(r); string(out) != "[#] ip link add wg0\nRTNETLINK answers: File exists\n" {
+		t.Errorf("CombinedPipe() = %q", out)
+	}
+}
+
+func TestMockProcessPid(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	p1, _ := runner.Start(context.Background(), "sleep", "1")
+	p2, _ := runner.Start(context.Background(), "sleep", "1")
+	if p1.Pid() <= 0 || p2.Pid() <= 0 {
+		t.Errorf("Expected positive fake PIDs, got %d and %d", p1.Pid(), p2.Pid())
+	}
+	if p1.Pid() == p2.Pid() {
+		t.Errorf("Expected distinct fake PIDs, both were %d", p1.Pid())
+	}
+}
+
+func TestRealCommandRunnerOpts(t *testing.T) {
+	runner := &RealCommandRunner{}
+	dir := t.TempDir()
+	inDir := runner.WithOpts(RunOpts{Dir: dir})
+	withEnv := runner.WithOpts(RunOpts{Env: []string{"HORIZON_TEST_VAR=hello"}})
+
+	out, err := inDir.Output(context.Background(), "pwd")
+	if err != nil {
+		t.Fatalf("Output failed: %v", err)
+	}
+	got, _ := filepath.EvalSymlinks(strings.TrimSpace(string(out)))
+	want, _ := filepath.EvalSymlinks(dir)
+	if got != want {
+		t.Errorf("Expected working dir %s, got %s", want, got)
+	}
+
+	out, err = withEnv.Output(context.Background(), "sh", "-c", "echo $HORIZON_TEST_VAR:$PATH")
+	if err != nil {
+		t.Fatalf("Output failed: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "hello:") || strings.TrimSpace(string(out)) == "hello:" {
+		t.Errorf("Expected custom env plus inherited PATH, got %q", out)
+	}
+
+	if err := inDir.Run(context.Background(), "true"); err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+
+	out, err = withEnv.CombinedOutput(context.Background(), "sh", "-c", "echo $HORIZON_TEST_VAR >&2")
+	if err != nil || strings.TrimSpace(string(out)) != "hello" {
+		t.Errorf("Expected stderr with custom env, got %q, %v", out, err)
+	}
+
+	p, err := inDir.Start(context.Background(), "pwd")
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	stdout, _ := p.StdoutPipe()
+	out, _ = io.ReadAll(stdout)
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if got, _ := filepath.EvalSymlinks(strings.TrimSpace(string(out))); got != want {
+		t.Errorf("Expected started process in %s, got %s", want, got)
+	}
+}
+
+func TestDryRunCommandRunnerOpts(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	opts := RunOpts{Dir: "/etc/letsencrypt", Env: []string{"CF_DNS_API_TOKEN=secret"}}
+	ctx := context.Background()
+
+	if err := runner.WithOpts(opts).Run(ctx, "lego", "renew"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	runner.AddOutput("lego list", []byte("example.com"))
+	out, err := runner.WithOpts(opts).CombinedOutput(ctx, "lego", "list")
+	if err != nil || string(out) != "example.com" {
+		t.Errorf("Expected stubbed output, got %q, %v", out, err)
+	}
+	if _, err := runner.WithOpts(RunOpts{Dir: "/srv"}).Start(ctx, "lego", "renew"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	runner.Run(ctx, "lego", "renew")
+
+	want := []string{"lego renew", "lego list", "lego renew", "lego renew"}
+	if cmds := runner.GetRunCommands(); !slices.Equal(cmds, want) {
+		t.Errorf("Expected %v, got %v", want, cmds)
+	}
+
+	// Each run keeps its own options, even when the command repeats.
+	got := runner.GetRunOpts()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d recorded opts, got %d", len(want), len(got))
+	}
+	for i, dir := range []string{opts.Dir, opts.Dir, "/srv", ""} {
+		if got[i].Dir != dir {
+			t.Errorf("run %d: expected dir %q, got %+v", i, dir, got[i])
+		}
+	}
+	if !slices.Equal(got[0].Env, opts.Env) || got[3].Env != nil {
+		t.Errorf("Expected env only on the runs given it, got %+v", got)
+	}
+}
+
+func TestRealProcessKilledOnCancel(t *testing.T) {
+	runner := &RealCommandRunner{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	proc, err := runner.Start(ctx, "sleep", "30")
+	if err != nil {
+		t.Fatalf("Failed to start sleep process: %v", err)
+	}
+
+	cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected error from killed process")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Process was not killed after context cancellation")
+	}
+}
+
+func TestRealProcessKillDuringWait(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	proc, err := runner.Start(context.Background(), "sleep", "30")
+	if err != nil {
+		t.Fatalf("Failed to start sleep process: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+
+	time.Sleep(50 * time.Millisecond)
+	if err := proc.Kill(); err != nil {
+		t.Fatalf("Kill failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after Kill")
+	}
+}
+
+func TestRealProcessSignal(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	proc, err := runner.Start(context.Background(), "sleep", "30")
+	if err != nil {
+		t.Fatalf("Failed to start sleep process: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("Expected ExitError, got %v", err)
+		}
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGTERM {
+			t.Errorf("Expected process to die from SIGTERM, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after SIGTERM")
+	}
+}
+
+func TestMockProcessSignal(t *testing.T) {
+	runner := NewDryRunCommandRunner()
+	p, err := runner.Start(context.Background(), "dnsmasq", "-k")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	mp := p.(*mockProcess)
+
+	if sig := mp.LastSignal(); sig != nil {
+		t.Errorf("Expected no signal yet, got %v", sig)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("Signal() error = %v", err)
+	}
+	if sig := mp.LastSignal(); sig != syscall.SIGHUP {
+		t.Errorf("LastSignal() = %v, want SIGHUP", sig)
+	}
+	if err := p.Kill(); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+	if sig := mp.LastSignal(); sig != os.Kill {
+		t.Errorf("LastSignal() after Kill = %v, want %v", sig, os.Kill)
+	}
+
+	// Each Start gets its own process.
+	p2, _ := runner.Start(context.Background(), "dnsmasq", "-k")
+	if sig := p2.(*mockProcess).LastSignal(); sig != nil {
+		t.Errorf("Expected fresh process to have no signal, got %v", sig)
+	}
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	runner := &RealCommandRunner{}
+
+	start := time.Now()
+	err := RunWithTimeout(context.Background(), runner, 100*time.Millisecond, "sleep", "30")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunWithTimeout took %s, expected it to return promptly", elapsed)
+	}
+
+	if err := RunWithTimeout(context.Background(), runner, 5*time.Second, "true"); err != nil {
+		t.Errorf("Expected fast command to succeed, got %v", err)
+	}
+}
+
 type testError struct {
 	msg string
 }
@@ -0,0 +1,86 @@
+package system
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoggingCommandRunner wraps a CommandRunner and reports every command it
+// executes to logFn with how long it took and the error it returned (nil on
+// success). LookPath is passed through unlogged.
+type LoggingCommandRunner struct {
+	runner CommandRunner
+	logFn  func(cmd string, elapsed time.Duration, err error)
+}
+
+func NewLoggingCommandRunner(runner CommandRunner, logFn func(cmd string, elapsed time.Duration, err error)) *LoggingCommandRunner {
+	return &LoggingCommandRunner{runner: runner, logFn: logFn}
+}
+
+func (r *LoggingCommandRunner) log(start time.Time, err error, name string, args []string) {
+	r.logFn(commandString(append([]string{name}, args...)), time.Since(start), err)
+}
+
+func (r *LoggingCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	start := time.Now()
+	err := r.runner.Run(ctx, name, args...)
+	r.log(start, err, name, args)
+	return err
+}
+
+func (r *LoggingCommandRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	start := time.Now()
+	out, err := r.runner.Output(ctx, name, args...)
+	r.log(start, err, name, args)
+	return out, err
+}
+
+func (r *LoggingCommandRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	start := time.Now()
+	out, err := r.runner.CombinedOutput(ctx, name, args...)
+	r.log(start, err, name, args)
+	return out, err
+}
+
+// Start logs the launch itself. If it succeeds, the returned Process logs
+// again when Wait returns, with the elapsed time since launch and the exit
+// error.
+func (r *LoggingCommandRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+	start := time.Now()
+	p, err := r.runner.Start(ctx, name, args...)
+	r.log(start, err, name, args)
+	if err != nil {
+		return nil, err
+	}
+	return &loggingProcess{
+		Process: p,
+		cmd:     commandString(append([]string{name}, args...)),
+		start:   start,
+		logFn:   r.logFn,
+	}, nil
+}
+
+func (r *LoggingCommandRunner) LookPath(file string) (string, error) {
+	return r.runner.LookPath(file)
+}
+
+// WithOpts returns a LoggingCommandRunner around the wrapped runner's
+// WithOpts, logging to the same logFn.
+func (r *LoggingCommandRunner) WithOpts(opts RunOpts) CommandRunner {
+	return &LoggingCommandRunner{runner: r.runner.WithOpts(opts), logFn: r.logFn}
+}
+
+type loggingProcess struct {
+	Process
+	cmd   string
+	start time.Time
+	logFn func(cmd string, elapsed time.Duration, err error)
+	once  sync.Once
+}
+
+func (p *loggingProcess) Wait() error {
+	err := p.Process.Wait()
+	p.once.Do(func() { p.logFn(p.cmd, time.Since(p.start), err) })
+	return err
+}
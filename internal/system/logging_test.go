@@ -0,0 +1,76 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type loggedCommand struct {
+	cmd string
+	err error
+}
+
+func TestLoggingCommandRunner(t *testing.T) {
+	inner := NewDryRunCommandRunner()
+	failed := errors.New("exit status 1")
+	inner.AddError("wg syncconf wg0 /dev/stdin", failed)
+	inner.AddOutput("wg show wg0", []byte("interface: wg0"))
+
+	var logged []loggedCommand
+	var r CommandRunner = NewLoggingCommandRunner(inner, func(cmd string, elapsed time.Duration, err error) {
+		if elapsed < 0 {
+			t.Errorf("negative elapsed time for %s", cmd)
+		}
+		logged = append(logged, loggedCommand{cmd, err})
+	})
+	ctx := context.Background()
+
+	if err := r.Run(ctx, "wg", "syncconf", "wg0", "/dev/stdin"); err != failed {
+		t.Errorf("Expected wrapped error to pass through, got %v", err)
+	}
+	out, err := r.Output(ctx, "wg", "show", "wg0")
+	if err != nil || string(out) != "interface: wg0" {
+		t.Errorf("Expected wrapped output to pass through, got %q, %v", out, err)
+	}
+	r.CombinedOutput(ctx, "ip", "link")
+	r.WithOpts(RunOpts{Dir: "/tmp"}).Run(ctx, "make")
+	p, err := r.Start(ctx, "tail", "-f", "log")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	p.Wait()
+	srv := r.WithOpts(RunOpts{Dir: "/srv"})
+	srv.CombinedOutput(ctx, "ip", "addr")
+	p, err = srv.Start(ctx, "tail", "-f", "err")
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	p.Wait()
+	r.LookPath("wg")
+
+	want := []loggedCommand{
+		{"wg syncconf wg0 /dev/stdin", failed},
+		{"wg show wg0", nil},
+		{"ip link", nil},
+		{"make", nil},
+		{"tail -f log", nil}, // launch
+		{"tail -f log", nil}, // wait
+		{"ip addr", nil},
+		{"tail -f err", nil}, // launch
+		{"tail -f err", nil}, // wait
+	}
+	if len(logged) != len(want) {
+		t.Fatalf("Expected %d log entries, got %d: %v", len(want), len(logged), logged)
+	}
+	for i := range want {
+		if logged[i] != want[i] {
+			t.Errorf("log entry %d = %v, want %v", i, logged[i], want[i])
+		}
+	}
+
+	if opts := inner.GetRunOpts(); opts[3].Dir != "/tmp" || opts[5].Dir != "/srv" {
+		t.Errorf("Expected RunOpts to reach the wrapped runner, got %+v", opts)
+	}
+}
@@ -0,0 +1,128 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// maxBacklog caps how much output a started process may produce before its
+// stream is claimed. Anything beyond is dropped, so a caller that never
+// reads can't stall the child or grow memory without bound; the dropped
+// byte count is reported by Process.Truncated.
+const maxBacklog = 64 << 10
+
+var errStreamClaimed = errors.New("output stream already claimed")
+
+// outStream is the io.Writer a realProcess's stdout or stderr is copied
+// into. Until a reader claims it, output is kept in a bounded backlog; once
+// claimed, the backlog is handed to the reader and later writes go to sink.
+// exec.Cmd's copy goroutine is the only writer, and it has finished by the
+// time cmd.Wait returns, so close never races a Write.
+type outStream struct {
+	mu      sync.Mutex
+	backlog []byte
+	sink    io.Writer // nil until claimed
+	claimed bool
+	closed  bool
+	dropped int // bytes that didn't fit in the backlog
+}
+
+func (s *outStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	sink := s.sink
+	if sink == nil {
+		if !s.claimed {
+			n := min(len(p), maxBacklog-len(s.backlog))
+			s.backlog = append(s.backlog, p[:n]...)
+			s.dropped += len(p) - n
+		}
+		s.mu.Unlock()
+		return len(p), nil
+	}
+	s.mu.Unlock()
+
+	// A reader that went away must not fail the process's Wait.
+	_, _ = sink.Write(p)
+	return len(p), nil
+}
+
+// claim hands the stream to a reader. It returns the output written so far
+// and, unless the process has already exited, routes later output to sink.
+// done reports whether the stream is already closed, in which case sink is
+// never written to.
+func (s *outStream) claim(sink io.Writer) (backlog []byte, done bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claimed {
+		return nil, false, errStreamClaimed
+	}
+	s.claimed = true
+	backlog, s.backlog = s.backlog, nil
+	if !s.closed {
+		s.sink = sink
+	}
+	return backlog, s.closed, nil
+}
+
+// truncated returns how many bytes were dropped from the backlog.
+func (s *outStream) truncated() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *outStream) isClaimed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claimed
+}
+
+// close marks the end of output, flushing a line-buffered sink.
+func (s *outStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if lw, ok := s.sink.(*lineWriter); ok {
+		lw.flush()
+	}
+}
+
+// lineWriter forwards whole lines to a writer shared with other
+// lineWriters, so lines from different streams never interleave mid-line.
+type lineWriter struct {
+	mu  *sync.Mutex // shared; serializes writes to w
+	w   io.Writer
+	buf []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := l.emit(l.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush writes a trailing partial line.
+func (l *lineWriter) flush() {
+	if len(l.buf) > 0 {
+		_ = l.emit(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *lineWriter) emit(line []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(line)
+	return err
+}
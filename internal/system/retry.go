@@ -0,0 +1,83 @@
+package system
+
+import (
+	"context"
+	"time"
+)
+
+// RetryCommandRunner wraps a CommandRunner and retries Run, Output and
+// CombinedOutput when they return an error. Commands run at most Retries+1
+// times; the wait before retry n (from 0) is BaseDelay * 2^n. Waiting stops
+// as soon as ctx is done, in which case the last command error is returned.
+// Start and LookPath are passed through.
+type RetryCommandRunner struct {
+	runner    CommandRunner
+	Retries   int
+	BaseDelay time.Duration
+
+	// sleep waits for d or until ctx is done; overridden in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewRetryCommandRunner(runner CommandRunner, retries int, baseDelay time.Duration) *RetryCommandRunner {
+	return &RetryCommandRunner{runner: runner, Retries: retries, BaseDelay: baseDelay, sleep: sleepCtx}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func retry[T any](ctx context.Context, r *RetryCommandRunner, fn func() (T, error)) (T, error) {
+	out, err := fn()
+	delay := r.BaseDelay
+	for i := 0; err != nil && i < r.Retries; i++ {
+		if ctx.Err() != nil || r.sleep(ctx, delay) != nil {
+			break
+		}
+		delay *= 2
+		out, err = fn()
+	}
+	return out, err
+}
+
+func (r *RetryCommandRunner) Run(ctx context.Context, name string, args ...string) error {
+	_, err := retry(ctx, r, func() (struct{}, error) {
+		return struct{}{}, r.runner.Run(ctx, name, args...)
+	})
+	return err
+}
+
+func (r *RetryCommandRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return retry(ctx, r, func() ([]byte, error) {
+		return r.runner.Output(ctx, name, args...)
+	})
+}
+
+func (r *RetryCommandRunner) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return retry(ctx, r, func() ([]byte, error) {
+		return r.runner.CombinedOutput(ctx, name, args...)
+	})
+}
+
+func (r *RetryCommandRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+	return r.runner.Start(ctx, name, args...)
+}
+
+func (r *RetryCommandRunner) LookPath(file string) (string, error) {
+	return r.runner.LookPath(file)
+}
+
+// WithOpts returns a RetryCommandRunner with the same policy around the
+// wrapped runner's WithOpts.
+func (r *RetryCommandRunner) WithOpts(opts RunOpts) CommandRunner {
+	c := *r
+	c.runner = r.runner.WithOpts(opts)
+	return &c
+}
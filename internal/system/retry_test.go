@@ -0,0 +1,116 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyRunner fails the first failures calls, then succeeds.
+type flakyRunner struct {
+	*DryRunCommandRunner
+	failures int
+	calls    int
+}
+
+func (f *flakyRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return nil, errors.New("transient")
+	}
+	return []byte("ok"), nil
+}
+
+func (f *flakyRunner) Run(ctx context.Context, name string, args ...string) error {
+	_, err := f.Output(ctx, name, args...)
+	return err
+}
+
+func newTestRetryRunner(inner CommandRunner, retries int) (*RetryCommandRunner, *[]time.Duration) {
+	var delays []time.Duration
+	r := NewRetryCommandRunner(inner, retries, 100*time.Millisecond)
+	r.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return r, &delays
+}
+
+func TestRetryCommandRunnerRecovers(t *testing.T) {
+	inner := &flakyRunner{DryRunCommandRunner: NewDryRunCommandRunner(), failures: 2}
+	r, delays := newTestRetryRunner(inner, 3)
+
+	out, err := r.Output(context.Background(), "wg", "syncconf", "wg0", "/dev/stdin")
+	if err != nil || string(out) != "ok" {
+		t.Fatalf("Output() = %q, %v; want ok, nil", out, err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", inner.calls)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}
+	if len(*delays) != len(want) || (*delays)[0] != want[0] || (*delays)[1] != want[1] {
+		t.Errorf("Expected backoff %v, got %v", want, *delays)
+	}
+}
+
+func TestRetryCommandRunnerGivesUp(t *testing.T) {
+	inner := &flakyRunner{DryRunCommandRunner: NewDryRunCommandRunner(), failures: 10}
+	r, _ := newTestRetryRunner(inner, 2)
+
+	if err := r.Run(context.Background(), "dig", "example.com"); err == nil {
+		t.Fatal("Expected the last error after exhausting retries")
+	}
+	if inner.calls != 3 {
+		t.Errorf("Expected 1 attempt + 2 retries, got %d calls", inner.calls)
+	}
+}
+
+func TestRetryCommandRunnerNoRetryOnSuccess(t *testing.T) {
+	inner := NewDryRunCommandRunner()
+	r, delays := newTestRetryRunner(inner, 3)
+
+	if err := r.Run(context.Background(), "true"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(inner.GetRunCommands()) != 1 || len(*delays) != 0 {
+		t.Errorf("Expected a single attempt, got %v", inner.GetRunCommands())
+	}
+}
+
+func TestRetryCommandRunnerStopsOnCancel(t *testing.T) {
+	inner := &flakyRunner{DryRunCommandRunner: NewDryRunCommandRunner(), failures: 10}
+	r := NewRetryCommandRunner(inner, 5, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := r.Output(ctx, "wg", "show"); err == nil || err.Error() != "transient" {
+		t.Errorf("Expected last command error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected cancellation to interrupt the backoff wait")
+	}
+	if inner.calls != 1 {
+		t.Errorf("Expected no retries after cancel, got %d calls", inner.calls)
+	}
+}
+
+func TestRetryCommandRunnerWithOpts(t *testing.T) {
+	inner := NewDryRunCommandRunner()
+	inner.AddError("lego renew", errors.New("transient"))
+	r, delays := newTestRetryRunner(inner, 2)
+
+	if err := r.WithOpts(RunOpts{Dir: "/srv"}).Run(context.Background(), "lego", "renew"); err == nil {
+		t.Fatal("Expected the last error back")
+	}
+	if len(*delays) != 2 {
+		t.Errorf("Expected the bound runner to keep the retry policy, got delays %v", *delays)
+	}
+	for i, opts := range inner.GetRunOpts() {
+		if opts.Dir != "/srv" {
+			t.Errorf("attempt %d ran with %+v, want Dir /srv", i, opts)
+		}
+	}
+}
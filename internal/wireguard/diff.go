@@ -0,0 +1,144 @@
+package wireguard
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each hunk.
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' (keep), '-' (delete from a) or
+// '+' (insert from b). ai/bi are the 0-based positions in a and b at which
+// the op applies.
+type diffOp struct {
+	kind   byte
+	text   string
+	ai, bi int
+}
+
+// unifiedDiff renders a unified diff (diff -u style) turning a into b.
+// Returns "" when the inputs are identical.
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	ops := diffLines(a, b)
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-diffContext, 0)
+		end := changes[i] + diffContext + 1
+		j := i + 1
+		for j < len(changes) && changes[j]-diffContext <= end {
+			end = changes[j] + diffContext + 1
+			j++
+		}
+		end = min(end, len(ops))
+
+		hunk := ops[start:end]
+		aCount, bCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		// diff -u numbers an empty side by the line before it.
+		aStart, bStart := hunk[0].ai, hunk[0].bi
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range hunk {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.text)
+			sb.WriteByte('\n')
+		}
+		i = j
+	}
+
+	return sb.String()
+}
+
+// diffLines computes a line edit script from a to b. The common prefix and
+// suffix are peeled off first so the LCS table only covers the changed
+// middle — config edits are usually a handful of lines in a large file.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', text: a[i], ai: i, bi: i})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(ma), len(mb)
+
+	// lcs[i][j] = length of the LCS of ma[i:] and mb[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && ma[i] == mb[j]:
+			ops = append(ops, diffOp{kind: ' ', text: ma[i], ai: prefix + i, bi: prefix + j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', text: ma[i], ai: prefix + i, bi: prefix + j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', text: mb[j], ai: prefix + i, bi: prefix + j})
+			j++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ai, bi := len(a)-suffix+k, len(b)-suffix+k
+		ops = append(ops, diffOp{kind: ' ', text: a[ai], ai: ai, bi: bi})
+	}
+	return ops
+}
+
+// splitLines splits file content into lines, ignoring a final trailing newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,95 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportFormat selects the encoding ExportPeers writes.
+type ExportFormat string
+
+const (
+	ExportCSV  ExportFormat = "csv"
+	ExportJSON ExportFormat = "json"
+)
+
+// ExportedPeer is one peer as ExportPeers writes it. The JSON field names
+// are part of the export format and must not change.
+type ExportedPeer struct {
+	Name       string `json:"name"`
+	PublicKey  string `json:"public_key"`
+	AllowedIPs string `json:"allowed_ips"` // comma-separated, as in the config file
+	// LatestHandshake is only filled in when stats are requested, and stays
+	// nil for a peer that has never completed a handshake.
+	LatestHandshake *time.Time `json:"latest_handshake,omitempty"`
+}
+
+// ExportPeers writes the configured peers to out in file order. CSV has a
+// name,public_key,allowed_ips header and reads back with ImportPeersCSV,
+// with or without stats; JSON is an array of ExportedPeer. With withStats,
+// each peer's latest handshake from `wg show <iface> dump` is added (a
+// latest_handshake CSV column in RFC 3339 UTC, empty if never), and a
+// failed dump fails the export rather than silently dropping the column.
+func (w *WGConfig) ExportPeers(ctx context.Context, out io.Writer, format ExportFormat, withStats bool) error {
+	if format != ExportCSV && format != ExportJSON {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	w.mu.RLock()
+	peers := make([]ExportedPeer, 0, len(w.peers))
+	for _, p := range w.peers {
+		peers = append(peers, ExportedPeer{Name: p.Name, PublicKey: p.PublicKey, AllowedIPs: strings.Join(p.AllowedIPs, ", ")})
+	}
+	var stats []PeerStats
+	var err error
+	if withStats {
+		stats, err = w.peerStats(ctx)
+	}
+	w.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("reading peer stats: %w", err)
+	}
+
+	handshakes := make(map[string]time.Time, len(stats))
+	for _, s := range stats {
+		if !s.LatestHandshake.IsZero() {
+			handshakes[s.PublicKey] = s.LatestHandshake.UTC()
+		}
+	}
+	for i := range peers {
+		if hs, ok := handshakes[peers[i].PublicKey]; ok {
+			peers[i].LatestHandshake = &hs
+		}
+	}
+
+	if format == ExportJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(peers)
+	}
+
+	cw := csv.NewWriter(out)
+	header := []string{"name", "public_key", "allowed_ips"}
+	if withStats {
+		header = append(header, "latest_handshake")
+	}
+	cw.Write(header)
+	for _, p := range peers {
+		row := []string{p.Name, p.PublicKey, p.AllowedIPs}
+		if withStats {
+			hs := ""
+			if p.LatestHandshake != nil {
+				hs = p.LatestHandshake.Format(time.RFC3339)
+			}
+			row = append(row, hs)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
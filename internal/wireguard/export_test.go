@@ -0,0 +1,125 @@
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+const exportConf = "[Interface]\n\n" +
+	"[Peer]\n# alice\nPublicKey = YWxpY2VrZXk=\nAllowedIPs = 10.100.0.2/32\n\n" +
+	"[Peer]\n# bob\nPublicKey = Ym9ia2V5\nAllowedIPs = 10.100.0.3/32, 192.168.50.0/24\n"
+
+func TestExportPeersJSON(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(testDump))
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0", WithCommandRunner(runner))
+	if err := cfg.parse([]byte(exportConf)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.ExportPeers(context.Background(), &buf, ExportJSON, false); err != nil {
+		t.Fatalf("ExportPeers() error = %v", err)
+	}
+	if strings.Contains(buf.String(), "latest_handshake") || runner.CallCount("wg show wg0 dump") != 0 {
+		t.Errorf("Stats not requested, but export ran wg or included them:\n%s", buf.String())
+	}
+	var plain []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != 2 || plain[1]["name"] != "bob" || plain[1]["allowed_ips"] != "10.100.0.3/32, 192.168.50.0/24" || plain[0]["public_key"] != "YWxpY2VrZXk=" {
+		t.Errorf("unexpected export: %v", plain)
+	}
+
+	buf.Reset()
+	if err := cfg.ExportPeers(context.Background(), &buf, ExportJSON, true); err != nil {
+		t.Fatalf("ExportPeers(stats) error = %v", err)
+	}
+	var peers []ExportedPeer
+	if err := json.Unmarshal(buf.Bytes(), &peers); err != nil {
+		t.Fatal(err)
+	}
+	if peers[0].LatestHandshake == nil || peers[0].LatestHandshake.Unix() != 1700000000 {
+		t.Errorf("alice handshake = %v, want 1700000000", peers[0].LatestHandshake)
+	}
+	if peers[1].LatestHandshake != nil {
+		t.Errorf("bob never handshook, got %v", peers[1].LatestHandshake)
+	}
+
+	runner.AddError("wg show wg0 dump", errors.New("Unable to access interface"))
+	if err := cfg.ExportPeers(context.Background(), &buf, ExportJSON, true); err == nil {
+		t.Error("Expected a failed dump to fail a stats export")
+	}
+	if err := cfg.ExportPeers(context.Background(), &buf, "yaml", false); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestExportPeersCSV(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(testDump))
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0", WithCommandRunner(runner))
+	if err := cfg.parse([]byte(exportConf)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.ExportPeers(context.Background(), &buf, ExportCSV, true); err != nil {
+		t.Fatalf("ExportPeers() error = %v", err)
+	}
+	want := "name,public_key,allowed_ips,latest_handshake\n" +
+		"alice,YWxpY2VrZXk=,10.100.0.2/32,2023-11-14T22:13:20Z\n" +
+		"bob,Ym9ia2V5,\"10.100.0.3/32, 192.168.50.0/24\",\n"
+	if buf.String() != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestExportPeersCSVRoundTrip(t *testing.T) {
+	alice, bob := testKey(t), testKey(t)
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(strings.ReplaceAll(testDump, "YWxpY2VrZXk=", alice)))
+	src := NewConfig("/etc/wireguard/wg0.conf", "wg0", WithCommandRunner(runner))
+	conf := fmt.Sprintf("[Interface]\n\n[Peer]\n# alice\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n\n"+
+		"[Peer]\n# bob\nPublicKey = %s\nAllowedIPs = 10.100.0.3/32, 192.168.50.0/24\n", alice, bob)
+	if err := src.parse([]byte(conf)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, withStats := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := src.ExportPeers(context.Background(), &buf, ExportCSV, withStats); err != nil {
+			t.Fatal(err)
+		}
+		if withStats && !strings.Contains(buf.String(), "2023-11-14T22:13:20Z") {
+			t.Fatalf("stats export has no handshake column:\n%s", buf.String())
+		}
+		peers, err := NewConfig("/etc/wireguard/wg1.conf", "wg1").ImportPeersCSV(&buf)
+		if err != nil {
+			t.Fatalf("ImportPeersCSV() withStats=%v error = %v", withStats, err)
+		}
+		orig := src.GetPeers()
+		if len(peers) != len(orig) {
+			t.Fatalf("withStats=%v: round trip got %+v, want %+v", withStats, peers, orig)
+		}
+		for i := range orig {
+			if !samePeer(peers[i], orig[i]) {
+				t.Errorf("withStats=%v: peer %d = %+v, want %+v", withStats, i, peers[i], orig[i])
+			}
+		}
+	}
+
+	// Without the header announcing it, a fourth column is still an error.
+	extra := "bob," + bob + ",10.100.0.3/32,2023-11-14T22:13:20Z\n"
+	if _, err := NewConfig("/etc/wireguard/wg1.conf", "wg1").ImportPeersCSV(strings.NewReader(extra)); err == nil {
+		t.Error("Expected a 4-field row without a latest_handshake header to be rejected")
+	}
+}
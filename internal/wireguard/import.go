@@ -0,0 +1,142 @@
+package wireguard
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// ImportPeersCSV parses peers from CSV rows of name,publickey,ip and checks
+// them against the loaded config and each other: every name and public key
+// must be unused, every key valid, and every address must parse, stay off
+// the server's own interface Address and clear of the AllowedIPs of
+// existing and earlier imported peers. Peers in #disabled# blocks count as
+// existing, since enabling one later would clash. The ip field may hold
+// several comma-separated entries (quoted), and a bare address is taken as a
+// single host (/32 or /128). Blank lines, lines starting with # and a
+// leading name,publickey,ip header row are skipped. A header ending in a
+// latest_handshake column, as ExportPeers writes with stats, allows that
+// fourth column on every row; its value is ignored.
+//
+// Nothing is written: apply the result with AddPeer, or ReplacePeers with
+// the new peers appended to GetPeers. Every bad row is reported in the
+// joined error, prefixed with its line number; the peers from the good rows
+// are returned alongside it.
+func (w *WGConfig) ImportPeersCSV(r io.Reader) ([]Peer, error) {
+	others := append(w.GetPeers(), w.GetDisabledPeers()...)
+	serverPub, _ := w.GetServerPublicKey()
+	var serverAddrs []netip.Addr
+	for _, entry := range SplitAllowedIPs(w.GetAddress()) {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			serverAddrs = append(serverAddrs, prefix.Addr())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			serverAddrs = append(serverAddrs, addr)
+		}
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	columns := []string{"name", "publickey", "ip"}
+	var imported []Peer
+	var errs []error
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// csv.ParseError already carries the line; anything else
+			// leaves the rest of the input unreadable.
+			errs = append(errs, err)
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				continue
+			}
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if first && isImportHeader(record) {
+			if len(record) == 4 && strings.EqualFold(strings.TrimSpace(record[3]), "latest_handshake") {
+				columns = append(columns, "latest_handshake")
+			}
+			continue
+		}
+		if len(record) != len(columns) {
+			errs = append(errs, fmt.Errorf("line %d: expected %d fields (%s), got %d", line, len(columns), strings.Join(columns, ","), len(record)))
+			continue
+		}
+
+		p, err := importRow(record[:3], serverPub, serverAddrs, others)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		imported = append(imported, p)
+		others = append(others, p)
+	}
+	return imported, errors.Join(errs...)
+}
+
+// isImportHeader reports whether record is a name,publickey,ip header.
+func isImportHeader(record []string) bool {
+	return len(record) >= 2 && strings.EqualFold(strings.TrimSpace(record[0]), "name") &&
+		strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(record[1]), "_", ""), "publickey")
+}
+
+// importRow builds and checks the peer for one name,publickey,ip record.
+// others holds the peers it must not collide with, serverAddrs the addresses
+// of the server's own interface.
+func importRow(record []string, serverPub string, serverAddrs []netip.Addr, others []Peer) (Peer, error) {
+	name, key, ips := strings.TrimSpace(record[0]), strings.TrimSpace(record[1]), record[2]
+	if name == "" {
+		return Peer{}, errors.New("empty name")
+	}
+	if !ValidatePublicKey(key) {
+		return Peer{}, fmt.Errorf("peer %s: invalid public key %q", name, key)
+	}
+	if key == serverPub {
+		return Peer{}, fmt.Errorf("peer %s uses the server's own public key", name)
+	}
+
+	var entries []string
+	for _, entry := range SplitAllowedIPs(ips) {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			entry = netip.PrefixFrom(addr, addr.BitLen()).String()
+		}
+		entries = append(entries, entry)
+	}
+	p := Peer{Name: name, PublicKey: key, AllowedIPs: entries}
+	if len(entries) == 0 {
+		return Peer{}, fmt.Errorf("peer %s: no IP", name)
+	}
+	prefixes, err := peerPrefixes(p)
+	if err != nil {
+		return Peer{}, err
+	}
+	for _, prefix := range prefixes {
+		for _, addr := range serverAddrs {
+			if prefix.Contains(addr) {
+				return Peer{}, fmt.Errorf("peer %s: %s covers the server's own address %s", name, prefix, addr)
+			}
+		}
+	}
+
+	for _, q := range others {
+		if q.Name == name {
+			return Peer{}, fmt.Errorf("peer %s: name already used", name)
+		}
+		if q.PublicKey == key {
+			return Peer{}, fmt.Errorf("peer %s: public key already used by %s", name, peerLabel(&q))
+		}
+		if err := routeOverlap(p, q); err != nil {
+			return Peer{}, err
+		}
+	}
+	return p, nil
+}
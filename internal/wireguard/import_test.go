@@ -0,0 +1,105 @@
+package wireguard
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+	_, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestImportPeersCSV(t *testing.T) {
+	alice := testKey(t)
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	conf := fmt.Sprintf("[Interface]\nAddress = 10.100.0.1/24\n\n[Peer]\n# alice\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n", alice)
+	if err := cfg.parse([]byte(conf)); err != nil {
+		t.Fatal(err)
+	}
+
+	bob, carol, site := testKey(t), testKey(t), testKey(t)
+	csvData := strings.Join([]string{
+		"name,publickey,ip",
+		"bob," + bob + ",10.100.0.3",
+		"# migrated from the old box",
+		"site," + site + `,"10.100.0.4/32, 192.168.50.0/24"`,
+		"",
+		"carol," + carol + ",10.100.0.5/32",
+	}, "\n")
+
+	peers, err := cfg.ImportPeersCSV(strings.NewReader(csvData))
+	if err != nil {
+		t.Fatalf("ImportPeersCSV() error = %v", err)
+	}
+	want := []Peer{
+		{Name: "bob", PublicKey: bob, AllowedIPs: []string{"10.100.0.3/32"}},
+		{Name: "site", PublicKey: site, AllowedIPs: []string{"10.100.0.4/32", "192.168.50.0/24"}},
+		{Name: "carol", PublicKey: carol, AllowedIPs: []string{"10.100.0.5/32"}},
+	}
+	if len(peers) != len(want) {
+		t.Fatalf("got %+v, want %+v", peers, want)
+	}
+	for i := range want {
+		if !samePeer(peers[i], want[i]) {
+			t.Errorf("peer %d = %+v, want %+v", i, peers[i], want[i])
+		}
+	}
+	if len(cfg.GetPeers()) != 1 {
+		t.Error("ImportPeersCSV must not apply the peers itself")
+	}
+}
+
+func TestImportPeersCSVRowErrors(t *testing.T) {
+	alice, dave := testKey(t), testKey(t)
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	conf := fmt.Sprintf("[Interface]\nAddress = 10.100.0.1/24, fd00:100::1/64\n\n[Peer]\n# alice\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n"+
+		"\n#disabled# [Peer]\n#disabled# # dave\n#disabled# PublicKey = %s\n#disabled# AllowedIPs = 10.100.0.20/32\n", alice, dave)
+	if err := cfg.parse([]byte(conf)); err != nil {
+		t.Fatal(err)
+	}
+
+	good, dup := testKey(t), testKey(t)
+	csvData := strings.Join([]string{
+		"good," + good + ",10.100.0.10",            // 1
+		"badkey,not-a-key,10.100.0.11",             // 2
+		"clash-ip," + testKey(t) + ",10.100.0.2",   // 3: alice's address
+		"clash-key," + alice + ",10.100.0.12",      // 4: alice's key
+		"dup," + dup + ",10.100.0.13",              // 5
+		"dup-again," + dup + ",10.100.0.14",        // 6: key from line 5
+		"overlap," + testKey(t) + ",10.100.0.8/29", // 7: contains line 1's IP
+		"short," + testKey(t),                      // 8
+		"badip," + testKey(t) + ",10.100.0.300",    // 9
+		"," + testKey(t) + ",10.100.0.15",          // 10
+		"gw," + testKey(t) + ",10.100.0.1",         // 11: the server's Address
+		"gw6," + testKey(t) + ",fd00:100::1",       // 12: the server's IPv6 Address
+		"good," + testKey(t) + ",10.100.0.16",      // 13: name from line 1
+		"alice," + testKey(t) + ",10.100.0.17",     // 14: alice's name
+		"dave," + testKey(t) + ",10.100.0.18",      // 15: disabled dave's name
+		"dave-key," + dave + ",10.100.0.19",        // 16: disabled dave's key
+		"dave-ip," + testKey(t) + ",10.100.0.20",   // 17: disabled dave's address
+	}, "\n")
+
+	peers, err := cfg.ImportPeersCSV(strings.NewReader(csvData))
+	if err == nil {
+		t.Fatal("Expected row errors")
+	}
+	if len(peers) != 2 || peers[0].Name != "good" || peers[1].Name != "dup" {
+		t.Errorf("Expected the good rows back, got %+v", peers)
+	}
+	for _, line := range []int{2, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17} {
+		if !strings.Contains(err.Error(), fmt.Sprintf("line %d:", line)) {
+			t.Errorf("Expected an error for line %d, got:\n%v", line, err)
+		}
+	}
+	for _, line := range []int{1, 5} {
+		if strings.Contains(err.Error(), fmt.Sprintf("line %d:", line)) {
+			t.Errorf("Line %d is valid but was reported:\n%v", line, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// DefaultLockTimeout is how long Lock waits for another holder when no
+// timeout is given.
+const DefaultLockTimeout = 10 * time.Second
+
+// lockPollInterval is how often Lock retries a contended lock.
+const lockPollInterval = 50 * time.Millisecond
+
+// ErrLockTimeout is returned when the config lock stays held past the
+// timeout, e.g. by a hung process.
+var ErrLockTimeout = errors.New("timed out waiting for config lock")
+
+// FileLock is a held advisory lock on a config file.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes an exclusive flock on "<config>.lock" next to the config file,
+// so separate processes (or separate WGConfig values) editing the same
+// wg0.conf take turns. It gives up with ErrLockTimeout after timeout
+// (DefaultLockTimeout if <= 0). The lock is released by Unlock, or by the
+// kernel if the process dies, so a crash never leaves it stuck.
+//
+// The lock file is opened with os rather than w.fs: flock needs a real file
+// descriptor, which system.FileSystem doesn't expose.
+func (w *WGConfig) Lock(timeout time.Duration) (*FileLock, error) {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	path := w.path + ".lock"
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return &FileLock{f: f}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return nil, errors.Join(fmt.Errorf("locking %s: %w", path, err), f.Close())
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Join(fmt.Errorf("%s: %w", path, ErrLockTimeout), f.Close())
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Unlock releases the lock. The lock file itself is left in place: removing
+// it would let a waiter lock an unlinked file while a newcomer locks a new
+// one.
+func (l *FileLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// WithLock runs a Load-modify-Save sequence under the config lock: it takes
+// the lock, reloads the file so fn sees any edits made by the previous
+// holder, runs fn and releases the lock. A missing config file is not an
+// error, so WithLock can also guard the first write. A failure to release
+// the lock is joined to fn's error.
+func (w *WGConfig) WithLock(timeout time.Duration, fn func() error) (err error) {
+	lock, err := w.Lock(timeout)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, lock.Unlock()) }()
+
+	if err := w.Load(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return fn()
+}
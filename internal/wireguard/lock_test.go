@@ -0,0 +1,82 @@
+package wireguard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	a := NewConfig(path, "wg0")
+	b := NewConfig(path, "wg0")
+
+	lock, err := a.Lock(time.Second)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	start := time.Now()
+	if _, err := b.Lock(150 * time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("contended Lock() error = %v, want ErrLockTimeout", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("contended Lock() returned after %v, before the timeout", waited)
+	}
+
+	// A waiter picks the lock up once it's released.
+	got := make(chan error, 1)
+	go func() {
+		l, err := b.Lock(2 * time.Second)
+		if err == nil {
+			err = l.Unlock()
+		}
+		got <- err
+	}()
+	time.Sleep(2 * lockPollInterval)
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if err := <-got; err != nil {
+		t.Fatalf("Lock() after release error = %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Errorf("second Unlock() error = %v, want nil", err)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	cfg := NewConfig(path, "wg0")
+
+	// Missing file: fn still runs.
+	ran := false
+	if err := cfg.WithLock(time.Second, func() error { ran = true; return nil }); err != nil || !ran {
+		t.Fatalf("WithLock() on missing file = %v, ran = %v", err, ran)
+	}
+
+	// Edits made by someone else are loaded before fn runs.
+	conf := "[Interface]\nPrivateKey = cGFzc3dvcmQ=\nAddress = 10.100.0.1/24\n"
+	if err := os.WriteFile(path, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := cfg.WithLock(time.Second, func() error {
+		if got := cfg.GetAddress(); got != "10.100.0.1/24" {
+			t.Errorf("GetAddress() inside WithLock = %q", got)
+		}
+		return errors.New("boom")
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("WithLock() error = %v, want fn's error", err)
+	}
+
+	// The lock was released despite fn failing.
+	lock, err := cfg.Lock(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Lock() after WithLock error = %v", err)
+	}
+	lock.Unlock()
+}
@@ -0,0 +1,58 @@
+package wireguard
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	stats, err := parseDump(testDump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]string{"YWxpY2VrZXk=": `alice "laptop"`}
+	now := time.Unix(1700000090, 0)
+
+	var b strings.Builder
+	if err := FormatMetrics(&b, "wg0", stats, names, now); err != nil {
+		t.Fatalf("FormatMetrics() error = %v", err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE wireguard_peers gauge\nwireguard_peers{interface=\"wg0\"} 2\n",
+		`wireguard_peer_receive_bytes_total{interface="wg0",public_key="YWxpY2VrZXk=",name="alice \"laptop\""} 1024`,
+		`wireguard_peer_transmit_bytes_total{interface="wg0",public_key="Ym9ia2V5",name=""} 0`,
+		`wireguard_peer_last_handshake_seconds{interface="wg0",public_key="YWxpY2VrZXk=",name="alice \"laptop\""} 90`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `wireguard_peer_last_handshake_seconds{interface="wg0",public_key="Ym9ia2V5"`) {
+		t.Errorf("never-handshook peer has a handshake series:\n%s", out)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(testDump))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+	if err := cfg.parse([]byte("[Interface]\n\n[Peer]\n# bob\nPublicKey = Ym9ia2V5\nAllowedIPs = 10.100.0.3/32\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := cfg.WriteMetrics(context.Background(), &b); err != nil {
+		t.Fatalf("WriteMetrics() error = %v", err)
+	}
+	if !strings.Contains(b.String(), `public_key="Ym9ia2V5",name="bob"`) {
+		t.Errorf("peer name from the config missing:\n%s", b.String())
+	}
+}
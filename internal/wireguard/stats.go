@@ -0,0 +1,136 @@
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PeerStats is one peer's runtime counters from `wg show <iface> dump`.
+type PeerStats struct {
+	PublicKey       string
+	Endpoint        string // empty if the peer has never connected
+	AllowedIPs      string
+	LatestHandshake time.Time // zero if the peer has never completed a handshake
+	TransferRx      int64
+	TransferTx      int64
+}
+
+// PeerState is a traffic-light classification of a peer's last handshake.
+type PeerState string
+
+const (
+	PeerOnline  PeerState = "online"
+	PeerIdle    PeerState = "idle"
+	PeerOffline PeerState = "offline"
+)
+
+// StateThresholds bound how old a peer's latest handshake may be for it to
+// still count as online or idle. Anything older, or a peer that never
+// handshook, is offline.
+type StateThresholds struct {
+	Online time.Duration
+	Idle   time.Duration
+}
+
+// DefaultStateThresholds: WireGuard re-handshakes every 2 minutes while
+// traffic flows, so under 3 minutes means an active tunnel.
+var DefaultStateThresholds = StateThresholds{Online: 3 * time.Minute, Idle: 30 * time.Minute}
+
+// State classifies the peer by the age of its latest handshake at now.
+func (s PeerStats) State(now time.Time, t StateThresholds) PeerState {
+	if s.LatestHandshake.IsZero() {
+		return PeerOffline
+	}
+	age := now.Sub(s.LatestHandshake)
+	switch {
+	case age < t.Online:
+		return PeerOnline
+	case age < t.Idle:
+		return PeerIdle
+	default:
+		return PeerOffline
+	}
+}
+
+// ClassifyPeers returns each peer's State keyed by public key.
+func ClassifyPeers(stats []PeerStats, now time.Time, t StateThresholds) map[string]PeerState {
+	states := make(map[string]PeerState, len(stats))
+	for _, s := range stats {
+		states[s.PublicKey] = s.State(now, t)
+	}
+	return states
+}
+
+// GetPeerStats returns live per-peer stats for the interface, in the order
+// wg reports them. Fails if the interface is down or wg is unavailable.
+func (w *WGConfig) GetPeerStats(ctx context.Context) ([]PeerStats, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.peerStats(ctx)
+}
+
+// peerStats runs `wg show <iface> dump`. Caller must hold w.mu.
+func (w *WGConfig) peerStats(ctx context.Context) ([]PeerStats, error) {
+	out, err := w.runner.Output(ctx, "wg", "show", w.iface, "dump")
+	if err != nil {
+		return nil, fmt.Errorf("wg show %s dump: %w", w.iface, err)
+	}
+	return parseDump(string(out))
+}
+
+// parseDump parses `wg show <iface> dump` output. The first line describes
+// the interface (private-key, public-key, listen-port, fwmark) and is
+// skipped; each following line is a tab-separated peer record:
+//
+//	public-key preshared-key endpoint allowed-ips latest-handshake transfer-rx transfer-tx persistent-keepalive
+func parseDump(out string) ([]PeerStats, error) {
+	lines := splitLines(out)
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	var stats []PeerStats
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("wg dump line %d: expected 8 fields, got %d", i+2, len(fields))
+		}
+
+		handshake, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wg dump line %d: latest-handshake: %w", i+2, err)
+		}
+		rx, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wg dump line %d: transfer-rx: %w", i+2, err)
+		}
+		tx, err := strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("wg dump line %d: transfer-tx: %w", i+2, err)
+		}
+
+		st := PeerStats{
+			PublicKey:  fields[0],
+			Endpoint:   dumpValue(fields[2]),
+			AllowedIPs: dumpValue(fields[3]),
+			TransferRx: rx,
+			TransferTx: tx,
+		}
+		if handshake > 0 {
+			st.LatestHandshake = time.Unix(handshake, 0)
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// dumpValue maps wg's "(none)" placeholder to an empty string.
+func dumpValue(v string) string {
+	if v == "(none)" {
+		return ""
+	}
+	return v
+}
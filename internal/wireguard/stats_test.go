@@ -0,0 +1,114 @@
+package wireguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+const testDump = "cHJpdmF0ZWtleQ==\tcHVibGlja2V5\t51820\toff\n" +
+	"YWxpY2VrZXk=\t(none)\t203.0.113.5:51820\t10.100.0.2/32\t1700000000\t1024\t2048\t25\n" +
+	"Ym9ia2V5\t(none)\t(none)\t10.100.0.3/32\t0\t0\t0\toff\n"
+
+func TestParseDump(t *testing.T) {
+	stats, err := parseDump(testDump)
+	if err != nil {
+		t.Fatalf("parseDump() error = %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 peers (header skipped), got %d", len(stats))
+	}
+
+	alice := stats[0]
+	if alice.PublicKey != "YWxpY2VrZXk=" || alice.Endpoint != "203.0.113.5:51820" {
+		t.Errorf("alice = %+v", alice)
+	}
+	if !alice.LatestHandshake.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("alice handshake = %v", alice.LatestHandshake)
+	}
+	if alice.TransferRx != 1024 || alice.TransferTx != 2048 {
+		t.Errorf("alice transfer = %d/%d, want 1024/2048", alice.TransferRx, alice.TransferTx)
+	}
+
+	bob := stats[1]
+	if bob.Endpoint != "" {
+		t.Errorf("Expected empty endpoint for never-connected peer, got %q", bob.Endpoint)
+	}
+	if !bob.LatestHandshake.IsZero() {
+		t.Errorf("Expected zero handshake for never-connected peer, got %v", bob.LatestHandshake)
+	}
+
+	if _, err := parseDump("header\nshort\tline\n"); err == nil {
+		t.Error("Expected error for truncated peer line")
+	}
+}
+
+func TestGetPeerStats(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(testDump))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+
+	stats, err := cfg.GetPeerStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetPeerStats() error = %v", err)
+	}
+	if len(stats) != 2 {
+		t.Errorf("Expected 2 peers, got %d", len(stats))
+	}
+
+	cmds := runner.GetRunCommands()
+	if len(cmds) != 1 || cmds[0] != "wg show wg0 dump" {
+		t.Errorf("Expected a single wg show dump, got %v", cmds)
+	}
+}
+
+func TestPeerState(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name      string
+		handshake time.Time
+		want      PeerState
+	}{
+		{"never", time.Time{}, PeerOffline},
+		{"just now", now.Add(-10 * time.Second), PeerOnline},
+		{"clock skew", now.Add(time.Minute), PeerOnline},
+		{"at online threshold", now.Add(-3 * time.Minute), PeerIdle},
+		{"idle", now.Add(-10 * time.Minute), PeerIdle},
+		{"at idle threshold", now.Add(-30 * time.Minute), PeerOffline},
+		{"long gone", now.Add(-48 * time.Hour), PeerOffline},
+	}
+	for _, tt := range tests {
+		s := PeerStats{PublicKey: "k", LatestHandshake: tt.handshake}
+		if got := s.State(now, DefaultStateThresholds); got != tt.want {
+			t.Errorf("%s: State() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	custom := StateThresholds{Online: time.Minute, Idle: 2 * time.Minute}
+	if got := (PeerStats{LatestHandshake: now.Add(-90 * time.Second)}).State(now, custom); got != PeerIdle {
+		t.Errorf("custom thresholds: State() = %s, want idle", got)
+	}
+}
+
+func TestClassifyPeers(t *testing.T) {
+	stats, err := parseDump(testDump)
+	if err != nil {
+		t.Fatalf("parseDump() error = %v", err)
+	}
+	now := stats[0].LatestHandshake.Add(time.Minute)
+
+	states := ClassifyPeers(stats, now, DefaultStateThresholds)
+	if len(states) != len(stats) {
+		t.Fatalf("Expected %d states, got %d", len(stats), len(states))
+	}
+	if states[stats[0].PublicKey] != PeerOnline {
+		t.Errorf("Expected recently handshaken peer online, got %s", states[stats[0].PublicKey])
+	}
+	if states[stats[1].PublicKey] != PeerOffline {
+		t.Errorf("Expected never-connected peer offline, got %s", states[stats[1].PublicKey])
+	}
+}
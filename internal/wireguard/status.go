@@ -0,0 +1,76 @@
+package wireguard
+
+import (
+	"context"
+	"time"
+)
+
+// Status is a point-in-time snapshot of the tunnel for monitoring: the host
+// checks from CheckSystem plus each peer's live counters. It marshals to
+// JSON as-is, e.g. for a /status endpoint.
+type Status struct {
+	Interface string       `json:"interface"`
+	CheckedAt time.Time    `json:"checked_at"`
+	System    SystemStatus `json:"system"`
+	Peers     []StatusPeer `json:"peers"`
+	// PeersError is set when `wg show dump` failed (typically the interface
+	// is down); Peers is then empty.
+	PeersError string `json:"peers_error,omitempty"`
+}
+
+// StatusPeer is one peer's PeerStats in JSON form, with its config name
+// and State under DefaultStateThresholds.
+type StatusPeer struct {
+	PublicKey       string     `json:"public_key"`
+	Name            string     `json:"name,omitempty"` // "" if not in the config file
+	Endpoint        string     `json:"endpoint,omitempty"`
+	AllowedIPs      string     `json:"allowed_ips,omitempty"`
+	LatestHandshake *time.Time `json:"latest_handshake,omitempty"` // nil if never
+	TransferRx      int64      `json:"transfer_rx_bytes"`
+	TransferTx      int64      `json:"transfer_tx_bytes"`
+	State           PeerState  `json:"state"`
+}
+
+// Status runs CheckSystem and `wg show <iface> dump` and combines them. A
+// failed dump is reported in PeersError rather than failing the whole
+// snapshot, so a down interface still yields the host checks.
+func (w *WGConfig) Status(ctx context.Context, vpnRange string) Status {
+	st := Status{
+		Interface: w.iface,
+		System:    w.CheckSystem(ctx, vpnRange),
+		Peers:     []StatusPeer{},
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	now := time.Now()
+	st.CheckedAt = now
+	stats, err := w.peerStats(ctx)
+	if err != nil {
+		st.PeersError = err.Error()
+		return st
+	}
+
+	names := make(map[string]string, len(w.peers))
+	for _, p := range w.peers {
+		names[p.PublicKey] = p.Name
+	}
+	for _, s := range stats {
+		peer := StatusPeer{
+			PublicKey:  s.PublicKey,
+			Name:       names[s.PublicKey],
+			Endpoint:   s.Endpoint,
+			AllowedIPs: s.AllowedIPs,
+			TransferRx: s.TransferRx,
+			TransferTx: s.TransferTx,
+			State:      s.State(now, DefaultStateThresholds),
+		}
+		if !s.LatestHandshake.IsZero() {
+			hs := s.LatestHandshake.UTC()
+			peer.LatestHandshake = &hs
+		}
+		st.Peers = append(st.Peers, peer)
+	}
+	return st
+}
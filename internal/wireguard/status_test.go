@@ -0,0 +1,78 @@
+package wireguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
+)
+
+func TestStatus(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("wg show wg0 dump", []byte(testDump))
+	runner.AddOutput("ip addr show wg0", []byte("4: wg0: <POINTOPOINT,UP>\n    inet 10.100.0.1/24 scope global wg0\n"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+	if err := cfg.parse([]byte("[Interface]\n\n[Peer]\n# alice\nPublicKey = YWxpY2VrZXk=\nAllowedIPs = 10.100.0.2/32\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	st := cfg.Status(context.Background(), "10.100.0.0/24")
+	if st.PeersError != "" {
+		t.Fatalf("PeersError = %q", st.PeersError)
+	}
+	if len(st.Peers) != 2 {
+		t.Fatalf("Peers = %+v, want 2", st.Peers)
+	}
+	alice, bob := st.Peers[0], st.Peers[1]
+	if alice.Name != "alice" || alice.TransferRx != 1024 || alice.LatestHandshake == nil {
+		t.Errorf("alice = %+v", alice)
+	}
+	if bob.Name != "" || bob.LatestHandshake != nil || bob.State != PeerOffline {
+		t.Errorf("bob = %+v", bob)
+	}
+	if !st.System.InterfaceExists {
+		t.Error("System.InterfaceExists = false, want CheckSystem results included")
+	}
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	for _, want := range []string{
+		`"interface":"wg0"`,
+		`"interface_addrs":["10.100.0.1/24"]`,
+		`"name":"alice"`,
+		`"latest_handshake":"2023-11-14T22:13:20Z"`,
+		`"transfer_tx_bytes":2048`,
+		`"state":"offline"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON missing %s:\n%s", want, data)
+		}
+	}
+	if strings.Count(string(data), `"latest_handshake"`) != 1 {
+		t.Errorf("never-handshook peer should omit latest_handshake:\n%s", data)
+	}
+}
+
+func TestStatusInterfaceDown(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("wg show wg0 dump", errors.New("Unable to access interface: No such device"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+
+	st := cfg.Status(context.Background(), "10.100.0.0/24")
+	if st.PeersError == "" {
+		t.Error("PeersError empty for a failed dump")
+	}
+	data, _ := json.Marshal(st)
+	if !strings.Contains(string(data), `"peers":[]`) {
+		t.Errorf("peers should marshal as an empty list:\n%s", data)
+	}
+}
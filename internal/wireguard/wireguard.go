@@ -3,19 +3,72 @@ package wireguard
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 type Peer struct {
-	PublicKey  string
-	AllowedIPs string
-	Name       string
+	PublicKey           string
+	AllowedIPs          []string // one entry per address or subnet, e.g. "10.100.0.2/32"
+	Name                string
+	PresharedKey        string
+	Endpoint            string
+	PersistentKeepalive int // seconds; 0 = off
+
+	extra []extraLine // lines Load doesn't model, for render
+}
+
+// extraLine is a config line the parser doesn't model (FwMark, Table,
+// SaveConfig, comments...), kept so render can write it back where it was.
+// after is the key of the last recognized line before it in the same
+// section; "" means directly after the section header.
+type extraLine struct {
+	after string
+	text  string
+}
+
+// looseBlock is a run of lines after a [Peer] section that belongs to no
+// live peer: a peer DisableInactive commented out, or comments set off from
+// the peer above by a blank line. They're kept apart from w.peers so that
+// rewriting the peer list never takes them along with a neighbour.
+type looseBlock struct {
+	after    string // public key of the live peer it followed; "" = [Interface]
+	gap      bool   // a blank line came before it
+	lines    []string
+	disabled bool
+	peer     Peer // the commented-out peer's fields, for disabled blocks
+}
+
+// Interface is the [Interface] section as WGConfig models it. Zero values
+// mean unset; lines the parser doesn't model are kept by ReplaceInterface.
+type Interface struct {
+	PrivateKey string
+	Address    string // comma-separated CIDRs
+	ListenPort int
+	DNS        string
+	MTU        int
+	PostUp     []string
+	PostDown   []string
 }
 
 // PeerStatus contains live status from wg show
@@ -36,48 +89,216 @@ type InterfaceStatus struct {
 	Peers     map[string]PeerStatus // keyed by public key
 }
 
+// WGConfig is safe for concurrent use. mu guards the parsed fields and
+// serializes writes to the config file: readers take the read lock,
+// anything that mutates state or writes the file takes the write lock.
 type WGConfig struct {
-	mu           sync.Mutex
+	mu           sync.RWMutex
 	path         string
 	iface        string
 	privateKey   string
 	address      string
-	listenPort   string
-	postUp       string
-	postDown     string
+	listenPort   int // 0 = unset (wg picks a random port)
+	dns          string
+	mtu          int
+	postUp       []string
+	postDown     []string
 	peers        []Peer
-	rawInterface []string
+	preamble     []string    // lines before [Interface], kept for render
+	unknownLines []extraLine // [Interface] lines Load doesn't model
+	loose        []looseBlock
+	dryRun       bool
+	fs           system.FileSystem
+	runner       system.CommandRunner
+
+	backups int // timestamped copies writeFile keeps; 0 = none
+}
+
+// DefaultBackups is how many previous versions of the config file a new
+// WGConfig keeps (see SetBackupCount).
+const DefaultBackups = 5
+
+// Option configures a WGConfig at construction; see NewConfig.
+type Option func(*WGConfig)
+
+// WithFileSystem makes the WGConfig read and write its config file (and
+// /proc) through fs, e.g. a system.DryRunFileSystem in tests.
+func WithFileSystem(fs system.FileSystem) Option {
+	return func(w *WGConfig) { w.fs = fs }
+}
+
+// WithCommandRunner makes the WGConfig run wg, ip, iptables and
+// systemd-run through runner, e.g. a system.DryRunCommandRunner in tests.
+func WithCommandRunner(runner system.CommandRunner) Option {
+	return func(w *WGConfig) { w.runner = runner }
 }
 
-func NewConfig(path, iface string) *WGConfig {
-	return &WGConfig{
-		path:  path,
-		iface: iface,
+// WithDryRun makes bulk operations such as DisableInactive and Reconcile
+// report what they would change without rewriting the config file.
+func WithDryRun(dryRun bool) Option {
+	return func(w *WGConfig) { w.dryRun = dryRun }
+}
+
+// NewConfig returns a WGConfig for the config file at path and interface
+// iface. It uses the real filesystem and commands unless opts say otherwise.
+func NewConfig(path, iface string, opts ...Option) *WGConfig {
+	w := &WGConfig{
+		path:    path,
+		iface:   iface,
+		fs:      &system.RealFileSystem{},
+		runner:  &system.RealCommandRunner{},
+		backups: DefaultBackups,
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	return w
+}
+
+// SetBackupCount sets how many timestamped backups of the config file
+// (wg0.conf.<time>.bak, next to it) are kept. Before every rewrite the
+// current file is copied to a new backup and the oldest beyond n are
+// deleted, so a config that breaks the tunnel can be rolled back by hand.
+// n <= 0 disables backups.
+func (w *WGConfig) SetBackupCount(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.backups = max(n, 0)
 }
 
 func (w *WGConfig) Load() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	data, err := os.ReadFile(w.path)
+	data, err := w.fs.ReadFile(w.path)
 	if err != nil {
 		return err
 	}
+	return w.parse(data)
+}
 
+// parse replaces the parsed fields with the contents of data. Caller must
+// hold w.mu or own w exclusively.
+func (w *WGConfig) parse(data []byte) error {
+	w.privateKey, w.address, w.listenPort, w.dns, w.mtu = "", "", 0, "", 0
+	w.postUp = nil
+	w.postDown = nil
 	w.peers = nil
-	w.rawInterface = nil
+	w.preamble = nil
+	w.unknownLines = nil
+	w.loose = nil
 
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	var currentPeer *Peer
 	inInterface := false
+	// lastKey is the most recent recognized key in the current section;
+	// unrecognized lines are anchored to it (see extraLine).
+	lastKey := ""
+	// gap is set by a blank line; inLoose while the last line went to a
+	// loose block. trailing buffers comments that may turn out to be loose:
+	// they are if the section ends before another line of the peer.
+	gap, inLoose := false, false
+	var trailing []string
+	trailingGap := false
+	// anchors[i] is the index in w.peers of the peer w.loose[i] follows, or
+	// -1 for [Interface]; resolved to public keys once every peer is read.
+	var anchors []int
+
+	// keep records a line Load doesn't model in whichever section it's in.
+	keep := func(raw string) {
+		switch {
+		case currentPeer != nil:
+			currentPeer.extra = append(currentPeer.extra, extraLine{after: lastKey, text: raw})
+		case inInterface:
+			w.unknownLines = append(w.unknownLines, extraLine{after: lastKey, text: raw})
+		default:
+			w.preamble = append(w.preamble, raw)
+		}
+	}
+	addLoose := func(b looseBlock) {
+		w.loose = append(w.loose, b)
+		if currentPeer != nil {
+			anchors = append(anchors, len(w.peers))
+		} else {
+			anchors = append(anchors, -1)
+		}
+	}
+	// flushTrailing settles the buffered comments: loose at the end of a
+	// section, the current peer's own if more of the peer follows.
+	flushTrailing := func(endOfSection bool) {
+		if len(trailing) == 0 {
+			return
+		}
+		if endOfSection {
+			addLoose(looseBlock{gap: trailingGap, lines: trailing})
+		} else {
+			for _, raw := range trailing {
+				keep(raw)
+			}
+		}
+		trailing = nil
+	}
 
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if line == "" {
+			gap = true
+			continue
+		}
+
+		// Disabled peers are commented out wholesale; wg-quick ignores them
+		// and so does the parser, but they're kept as loose blocks for render.
+		if strings.HasPrefix(line, disabledPrefix) && (currentPeer != nil || inInterface) {
+			flushTrailing(true)
+			body := strings.TrimSpace(strings.TrimPrefix(line, disabledPrefix))
+			if body == "[Peer]" || !inLoose || !w.loose[len(w.loose)-1].disabled {
+				addLoose(looseBlock{gap: gap, disabled: true})
+			}
+			b := &w.loose[len(w.loose)-1]
+			b.lines = append(b.lines, raw)
+			switch {
+			case strings.HasPrefix(body, "PublicKey"):
+				b.peer.PublicKey = extractValue(body)
+			case strings.HasPrefix(body, "AllowedIPs"):
+				b.peer.AllowedIPs = append(b.peer.AllowedIPs, SplitAllowedIPs(extractValue(body))...)
+			case strings.HasPrefix(body, "#") && b.peer.Name == "":
+				b.peer.Name = strings.TrimPrefix(body, "# ")
+			}
+			gap, inLoose = false, true
+			continue
+		}
+		if strings.HasPrefix(line, disabledPrefix) {
+			keep(raw)
+			continue
+		}
+
+		// Comments after a blank line or a disabled block may trail the
+		// peer rather than belong to it; hold them until that's known.
+		if strings.HasPrefix(line, "#") && (len(trailing) > 0 || inLoose ||
+			currentPeer != nil && gap && (lastKey != "" || currentPeer.Name != "")) {
+			if len(trailing) == 0 {
+				trailingGap = gap
+			} else if gap {
+				trailing = append(trailing, "")
+			}
+			trailing = append(trailing, raw)
+			gap = false
+			continue
+		}
+
+		if line == "[Interface]" || line == "[Peer]" {
+			flushTrailing(true)
+		} else {
+			flushTrailing(false)
+		}
+		gap, inLoose = false, false
 
 		if line == "[Interface]" {
 			inInterface = true
 			currentPeer = nil
+			lastKey = ""
 			continue
 		}
 
@@ -87,42 +308,117 @@ func (w *WGConfig) Load() error {
 			}
 			currentPeer = &Peer{}
 			inInterface = false
+			lastKey = ""
 			continue
 		}
 
 		if inInterface {
-			w.rawInterface = append(w.rawInterface, scanner.Text())
 			if strings.HasPrefix(line, "PrivateKey") {
 				w.privateKey = extractValue(line)
+				lastKey = "PrivateKey"
 			} else if strings.HasPrefix(line, "Address") {
 				w.address = extractValue(line)
+				lastKey = "Address"
 			} else if strings.HasPrefix(line, "ListenPort") {
-				w.listenPort = extractValue(line)
+				port, err := strconv.Atoi(extractValue(line))
+				if err != nil || port < 1 || port > 65535 {
+					return fmt.Errorf("invalid ListenPort %q: must be a port number 1-65535", extractValue(line))
+				}
+				w.listenPort = port
+				lastKey = "ListenPort"
+			} else if strings.HasPrefix(line, "DNS") {
+				w.dns = extractValue(line)
+				lastKey = "DNS"
+			} else if strings.HasPrefix(line, "MTU") {
+				mtu, err := strconv.Atoi(extractValue(line))
+				if err != nil || mtu < 0 || mtu > 65535 {
+					return fmt.Errorf("invalid MTU %q", extractValue(line))
+				}
+				w.mtu = mtu
+				lastKey = "MTU"
 			} else if strings.HasPrefix(line, "PostUp") {
-				w.postUp = extractValue(line)
+				w.postUp = append(w.postUp, extractValue(line))
+				lastKey = "PostUp"
 			} else if strings.HasPrefix(line, "PostDown") {
-				w.postDown = extractValue(line)
+				w.postDown = append(w.postDown, extractValue(line))
+				lastKey = "PostDown"
+			} else {
+				keep(raw)
 			}
+			continue
 		}
 
 		if currentPeer != nil {
 			if strings.HasPrefix(line, "PublicKey") {
 				currentPeer.PublicKey = extractValue(line)
+				lastKey = "PublicKey"
 			} else if strings.HasPrefix(line, "AllowedIPs") {
-				currentPeer.AllowedIPs = extractValue(line)
+				currentPeer.AllowedIPs = append(currentPeer.AllowedIPs, SplitAllowedIPs(extractValue(line))...)
+				lastKey = "AllowedIPs"
+			} else if strings.HasPrefix(line, "PresharedKey") {
+				currentPeer.PresharedKey = extractValue(line)
+				if !ValidatePresharedKey(currentPeer.PresharedKey) {
+					return fmt.Errorf("invalid PresharedKey for peer %s", peerLabel(currentPeer))
+				}
+				lastKey = "PresharedKey"
+			} else if strings.HasPrefix(line, "Endpoint") {
+				currentPeer.Endpoint = extractValue(line)
+				lastKey = "Endpoint"
+			} else if strings.HasPrefix(line, "PersistentKeepalive") {
+				keepalive, err := parseKeepalive(extractValue(line))
+				if err != nil {
+					return fmt.Errorf("peer %s: %w", peerLabel(currentPeer), err)
+				}
+				currentPeer.PersistentKeepalive = keepalive
+				lastKey = "PersistentKeepalive"
 			} else if strings.HasPrefix(line, "#") && currentPeer.Name == "" {
 				currentPeer.Name = strings.TrimPrefix(line, "# ")
+			} else {
+				keep(raw)
 			}
+			continue
 		}
+
+		keep(raw)
 	}
 
+	flushTrailing(true)
 	if currentPeer != nil {
 		w.peers = append(w.peers, *currentPeer)
 	}
+	for i, a := range anchors {
+		if a >= 0 && a < len(w.peers) {
+			w.loose[i].after = w.peers[a].PublicKey
+		}
+	}
 
 	return scanner.Err()
 }
 
+// parseKeepalive parses a PersistentKeepalive value: seconds, or "off".
+func parseKeepalive(v string) (int, error) {
+	if v == "off" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 65535 {
+		return 0, fmt.Errorf("invalid PersistentKeepalive %q", v)
+	}
+	return n, nil
+}
+
+// peerLabel identifies a peer in error messages by name, falling back to
+// its public key (which may not be parsed yet).
+func peerLabel(p *Peer) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	if p.PublicKey != "" {
+		return p.PublicKey
+	}
+	return "(unnamed)"
+}
+
 func extractValue(line string) string {
 	parts := strings.SplitN(line, "=", 2)
 	if len(parts) == 2 {
@@ -132,90 +428,123 @@ func extractValue(line string) string {
 }
 
 func (w *WGConfig) GetPeers() []Peer {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	peers := make([]Peer, len(w.peers))
-	copy(peers, w.peers)
+	for i, p := range w.peers {
+		peers[i] = p.clone()
+	}
 	return peers
 }
 
 // GetPeerByPublicKey returns the peer with the given public key
 func (w *WGConfig) GetPeerByPublicKey(publicKey string) *Peer {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 
 	for _, p := range w.peers {
 		if p.PublicKey == publicKey {
-			return &Peer{
-				PublicKey:  p.PublicKey,
-				AllowedIPs: p.AllowedIPs,
-				Name:       p.Name,
-			}
+			p = p.clone()
+			return &p
 		}
 	}
 	return nil
 }
 
-// GetPeerByIP returns the peer with the given IP address (without CIDR suffix)
+// GetPeerByIP returns the peer whose AllowedIPs contain the given IP address
+// (without CIDR suffix). Every entry counts, so either address of a
+// dual-stack peer finds it, as does any address inside a subnet a
+// site-to-site peer routes. When several peers match, the most specific
+// entry wins, so a /32 client is found even inside a routed /24.
 func (w *WGConfig) GetPeerByIP(ip string) *Peer {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 
-	for _, p := range w.peers {
-		// AllowedIPs is typically "10.100.0.2/32" - extract just the IP
-		peerIP := strings.Split(p.AllowedIPs, "/")[0]
-		if peerIP == ip {
-			return &Peer{
-				PublicKey:  p.PublicKey,
-				AllowedIPs: p.AllowedIPs,
-				Name:       p.Name,
+	target := net.ParseIP(ip)
+	if target == nil {
+		return nil
+	}
+	var best *Peer
+	bestBits := -1
+	for i := range w.peers {
+		for _, entry := range w.peers[i].AllowedIPs {
+			if !strings.Contains(entry, "/") {
+				if net.ParseIP(entry).Equal(target) && bestBits < 128 {
+					best, bestBits = &w.peers[i], 128
+				}
+				continue
+			}
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil || !network.Contains(target) {
+				continue
+			}
+			// Compare v4 and v6 matches by host bits left over, not raw length.
+			ones, bits := network.Mask.Size()
+			if spec := 128 - (bits - ones); spec > bestBits {
+				best, bestBits = &w.peers[i], spec
 			}
 		}
 	}
-	return nil
+	if best == nil {
+		return nil
+	}
+	p := best.clone()
+	return &p
 }
 
 func (w *WGConfig) GetServerPublicKey() (string, error) {
-	if w.privateKey == "" {
+	w.mu.RLock()
+	privateKey := w.privateKey
+	w.mu.RUnlock()
+	if privateKey == "" {
 		return "", fmt.Errorf("no private key loaded")
 	}
 
-	cmd := exec.Command("wg", "pubkey")
-	cmd.Stdin = strings.NewReader(w.privateKey)
-	out, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(out)), nil
+	return PublicKeyFromPrivate(privateKey)
 }
 
 func (w *WGConfig) AddPeer(name, publicKey, allowedIP string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	entries := SplitAllowedIPs(allowedIP)
+	requested := make(map[string]bool)
+	for _, entry := range entries {
+		requested[entryIP(entry)] = true
+	}
 	for _, p := range w.peers {
 		if p.PublicKey == publicKey {
 			return fmt.Errorf("peer with public key already exists")
 		}
-		if p.AllowedIPs == allowedIP {
-			return fmt.Errorf("peer with IP already exists")
+		for _, entry := range p.AllowedIPs {
+			if requested[entryIP(entry)] {
+				return fmt.Errorf("peer with IP already exists")
+			}
+		}
+	}
+	candidate := Peer{Name: name, PublicKey: publicKey, AllowedIPs: entries}
+	if _, err := peerPrefixes(candidate); err != nil {
+		return err
+	}
+	for _, p := range w.peers {
+		if err := routeOverlap(candidate, p); err != nil {
+			return err
 		}
 	}
 
-	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0600)
+	data, err := w.fs.ReadFile(w.path)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = f.Close() }()
 
 	peerBlock := fmt.Sprintf("\n[Peer]\n# %s\nPublicKey = %s\nAllowedIPs = %s\n", name, publicKey, allowedIP)
-	if _, err := f.WriteString(peerBlock); err != nil {
+	if err := w.writeFile(append(data, peerBlock...)); err != nil {
 		return err
 	}
 
 	w.peers = append(w.peers, Peer{
 		PublicKey:  publicKey,
-		AllowedIPs: allowedIP,
+		AllowedIPs: entries,
 		Name:       name,
 	})
 
@@ -226,7 +555,7 @@ func (w *WGConfig) UpdatePeer(publicKey, name, allowedIPs string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	data, err := os.ReadFile(w.path)
+	data, err := w.fs.ReadFile(w.path)
 	if err != nil {
 		return err
 	}
@@ -291,7 +620,7 @@ func (w *WGConfig) UpdatePeer(publicKey, name, allowedIPs string) error {
 	}
 
 	output := strings.Join(result, "\n")
-	if err := os.WriteFile(w.path, []byte(output), 0600); err != nil {
+	if err := w.writeFile([]byte(output)); err != nil {
 		return err
 	}
 
@@ -299,7 +628,7 @@ func (w *WGConfig) UpdatePeer(publicKey, name, allowedIPs string) error {
 	for i := range w.peers {
 		if w.peers[i].PublicKey == publicKey {
 			w.peers[i].Name = name
-			w.peers[i].AllowedIPs = allowedIPs
+			w.peers[i].AllowedIPs = SplitAllowedIPs(allowedIPs)
 			break
 		}
 	}
@@ -312,7 +641,7 @@ func (w *WGConfig) ReplacePeerKey(oldPubKey, newPubKey string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	data, err := os.ReadFile(w.path)
+	data, err := w.fs.ReadFile(w.path)
 	if err != nil {
 		return err
 	}
@@ -332,224 +661,1125 @@ func (w *WGConfig) ReplacePeerKey(oldPubKey, newPubKey string) error {
 		return fmt.Errorf("peer not found")
 	}
 
-	if err := os.WriteFile(w.path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
-		return err
-	}
+	if err := w.writeFile([]byte(strings.Join(lines, "\n"))); err != nil {
+		return err
+	}
+
+	for i := range w.peers {
+		if w.peers[i].PublicKey == oldPubKey {
+			w.peers[i].PublicKey = newPubKey
+			break
+		}
+	}
+
+	return nil
+}
+
+// RemovePeer removes every [Peer] block whose PublicKey or "# name" comment
+// matches identifier, leaving the [Interface] section and all other peers
+// untouched. Returns the number of peers removed; an identifier that matches
+// nothing is an error rather than a silent no-op, and so is an empty one,
+// which would otherwise match every peer without a name or key.
+func (w *WGConfig) RemovePeer(identifier string) (int, error) {
+	if identifier == "" {
+		return 0, errors.New("peer identifier is empty")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := w.fs.ReadFile(w.path)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []string
+	removed := 0
+	for _, b := range splitBlocks(strings.Split(string(data), "\n")) {
+		if b.peer && (b.publicKey == identifier || b.name == identifier) {
+			removed++
+			kept = append(kept, trailingComments(b.lines)...)
+			continue
+		}
+		kept = append(kept, b.lines...)
+	}
+
+	if removed == 0 {
+		return 0, fmt.Errorf("peer not found: %s", identifier)
+	}
+
+	output := strings.TrimRight(strings.Join(kept, "\n"), "\n") + "\n"
+	if err := w.writeFile([]byte(output)); err != nil {
+		return 0, err
+	}
+	return removed, w.parse([]byte(output))
+}
+
+// trailingComments returns the comments at the end of a [Peer] block that a
+// blank line sets off from it — the lines Load keeps as a loose block rather
+// than as the peer's own — with the blank lines around them.
+func trailingComments(lines []string) []string {
+	last := -1 // last line that is neither blank nor a comment
+	for i, line := range lines {
+		if t := strings.TrimSpace(line); t != "" && !strings.HasPrefix(t, "#") {
+			last = i
+		}
+	}
+	for i := last + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			continue
+		}
+		for _, line := range lines[i:] {
+			if strings.TrimSpace(line) != "" {
+				return lines[i:]
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// ReplacePeers swaps the whole peer list for peers and rewrites the config
+// file, for reconciling against an external inventory in one step instead of
+// a loop of AddPeer/RemovePeer. The set is validated first (see
+// validatePeerSet) and nothing changes if any peer is rejected. Unmodeled
+// lines of a peer whose public key survives are carried over.
+func (w *WGConfig) ReplacePeers(peers []Peer) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := validatePeerSet(peers); err != nil {
+		return err
+	}
+	return w.commitPeers(peers)
+}
+
+// commitPeers replaces w.peers with peers (keeping each surviving peer's
+// unmodeled lines) and writes the file, restoring the old state if the
+// write fails. Loose blocks stay whatever happens to their neighbours (see
+// reanchorLoose). Caller must hold w.mu and have validated peers.
+func (w *WGConfig) commitPeers(peers []Peer) error {
+	extra := make(map[string][]extraLine, len(w.peers))
+	for _, p := range w.peers {
+		extra[p.PublicKey] = p.extra
+	}
+	next := make([]Peer, len(peers))
+	live := make(map[string]bool, len(peers))
+	for i, p := range peers {
+		p.extra = extra[p.PublicKey]
+		next[i] = p
+		live[p.PublicKey] = true
+	}
+
+	old, oldLoose := w.peers, w.loose
+	w.loose = reanchorLoose(w.loose, w.peers, live)
+	w.peers = next
+	if err := w.writeFile([]byte(w.render())); err != nil {
+		w.peers, w.loose = old, oldLoose
+		return err
+	}
+	return nil
+}
+
+// reanchorLoose moves each loose block that follows a peer about to go onto
+// the nearest surviving peer above it in old (or the [Interface] section),
+// so it keeps its place in the file, set off by a blank line. Disabled
+// blocks whose key is in live are dropped: that peer is live again.
+func reanchorLoose(loose []looseBlock, old []Peer, live map[string]bool) []looseBlock {
+	pos := make(map[string]int, len(old))
+	for i, p := range old {
+		pos[p.PublicKey] = i
+	}
+	out := make([]looseBlock, 0, len(loose))
+	for _, l := range loose {
+		if l.disabled && live[l.peer.PublicKey] {
+			continue
+		}
+		if l.after != "" && !live[l.after] {
+			after := ""
+			if i, ok := pos[l.after]; ok {
+				for i--; i >= 0; i-- {
+					if live[old[i].PublicKey] {
+						after = old[i].PublicKey
+						break
+					}
+				}
+			}
+			l.after, l.gap = after, true
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// GetDisabledPeers returns the peers DisableInactive commented out, as far as
+// their blocks can be read: Name, PublicKey and AllowedIPs.
+func (w *WGConfig) GetDisabledPeers() []Peer {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.disabledPeers()
+}
+
+// disabledPeers is GetDisabledPeers for callers holding w.mu.
+func (w *WGConfig) disabledPeers() []Peer {
+	var peers []Peer
+	for _, l := range w.loose {
+		if l.disabled && l.peer.PublicKey != "" {
+			peers = append(peers, l.peer)
+		}
+	}
+	return peers
+}
+
+// validatePeerSet checks a complete peer list: well-formed, unique public
+// keys, valid PresharedKeys and keepalives, parseable AllowedIPs and no two
+// peers routing overlapping addresses. Every problem is in the joined error.
+func validatePeerSet(peers []Peer) error {
+	var errs []error
+	seen := make(map[string]bool, len(peers))
+	for i, p := range peers {
+		switch {
+		case !ValidatePublicKey(p.PublicKey):
+			errs = append(errs, fmt.Errorf("peer %s: invalid PublicKey", peerLabel(&p)))
+		case seen[p.PublicKey]:
+			errs = append(errs, fmt.Errorf("peer %s: duplicate PublicKey", peerLabel(&p)))
+		}
+		seen[p.PublicKey] = true
+		if p.PresharedKey != "" && !ValidatePresharedKey(p.PresharedKey) {
+			errs = append(errs, fmt.Errorf("invalid PresharedKey for peer %s", peerLabel(&p)))
+		}
+		if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+			errs = append(errs, fmt.Errorf("peer %s: invalid PersistentKeepalive %d", peerLabel(&p), p.PersistentKeepalive))
+		}
+		if len(p.AllowedIPs) == 0 {
+			errs = append(errs, fmt.Errorf("peer %s: no AllowedIPs", peerLabel(&p)))
+		}
+		if _, err := peerPrefixes(p); err != nil {
+			errs = append(errs, err)
+		}
+		for _, q := range peers[i+1:] {
+			if err := routeOverlap(p, q); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reconcile brings the peer set in line with desired, matching peers by
+// public key: keys only in desired are added, keys missing from it are
+// removed, and peers whose modeled fields differ are updated. Surviving
+// peers keep their place in the file and new ones are appended in desired
+// order. A desired key that matches a disabled block re-enables that peer:
+// it's reported as added and its commented-out block is replaced by the
+// live one, never kept alongside it. The returned slices say exactly what
+// changed (updated holds the new values); running it again with the same
+// desired set changes nothing and leaves the file untouched. Under
+// WithDryRun(true) the changes are reported but not written.
+func (w *WGConfig) Reconcile(desired []Peer) (added, removed, updated []Peer, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := validatePeerSet(desired); err != nil {
+		return nil, nil, nil, err
+	}
+
+	want := make(map[string]Peer, len(desired))
+	for _, p := range desired {
+		want[p.PublicKey] = p
+	}
+	next := make([]Peer, 0, len(desired))
+	have := make(map[string]bool, len(w.peers))
+	for _, p := range w.peers {
+		have[p.PublicKey] = true
+		d, ok := want[p.PublicKey]
+		if !ok {
+			removed = append(removed, p)
+			continue
+		}
+		if !samePeer(p, d) {
+			updated = append(updated, d)
+		}
+		next = append(next, d)
+	}
+	for _, p := range desired {
+		if !have[p.PublicKey] {
+			added = append(added, p)
+			next = append(next, p)
+		}
+	}
+
+	if len(added)+len(removed)+len(updated) == 0 || w.dryRun {
+		return added, removed, updated, nil
+	}
+	if err := w.commitPeers(next); err != nil {
+		return nil, nil, nil, err
+	}
+	return added, removed, updated, nil
+}
+
+// samePeer reports whether a and b agree on every field render writes.
+func samePeer(a, b Peer) bool {
+	return a.PublicKey == b.PublicKey && a.Name == b.Name &&
+		slices.Equal(a.AllowedIPs, b.AllowedIPs) && a.PresharedKey == b.PresharedKey &&
+		a.Endpoint == b.Endpoint && a.PersistentKeepalive == b.PersistentKeepalive
+}
+
+// GetInterface returns the modeled [Interface] fields.
+func (w *WGConfig) GetInterface() Interface {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return Interface{
+		PrivateKey: w.privateKey,
+		Address:    w.address,
+		ListenPort: w.listenPort,
+		DNS:        w.dns,
+		MTU:        w.mtu,
+		PostUp:     slices.Clone(w.postUp),
+		PostDown:   slices.Clone(w.postDown),
+	}
+}
+
+// ReplaceInterface swaps the [Interface] section for iface and rewrites the
+// config file. The PrivateKey must be well-formed and must not belong to an
+// existing peer, Address must be a list of CIDRs, and ListenPort and MTU must
+// be in range; nothing changes if any check fails.
+func (w *WGConfig) ReplaceInterface(iface Interface) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var errs []error
+	if !ValidatePublicKey(iface.PrivateKey) {
+		errs = append(errs, fmt.Errorf("invalid PrivateKey: must be 32 bytes, base64-encoded"))
+	} else if pub, err := PublicKeyFromPrivate(iface.PrivateKey); err != nil {
+		errs = append(errs, err)
+	} else {
+		for _, p := range w.peers {
+			if p.PublicKey == pub {
+				errs = append(errs, fmt.Errorf("peer %s uses the server's own public key", peerLabel(&p)))
+			}
+		}
+	}
+	for _, entry := range SplitAllowedIPs(iface.Address) {
+		if _, err := netip.ParsePrefix(entry); err != nil {
+			errs = append(errs, fmt.Errorf("invalid Address entry %q", entry))
+		}
+	}
+	if iface.ListenPort < 0 || iface.ListenPort > 65535 {
+		errs = append(errs, fmt.Errorf("invalid ListenPort %d", iface.ListenPort))
+	}
+	if iface.MTU < 0 || iface.MTU > 65535 {
+		errs = append(errs, fmt.Errorf("invalid MTU %d", iface.MTU))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	restore := w.setInterface(iface.PrivateKey, iface.Address, iface.ListenPort, iface.DNS, iface.MTU,
+		slices.Clone(iface.PostUp), slices.Clone(iface.PostDown))
+	if err := w.writeFile([]byte(w.render())); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// setInterface sets the modeled [Interface] fields and returns a func that
+// puts the previous values back. Caller must hold w.mu.
+func (w *WGConfig) setInterface(privateKey, address string, listenPort int, dns string, mtu int, postUp, postDown []string) (restore func()) {
+	oldKey, oldAddr, oldPort, oldDNS, oldMTU := w.privateKey, w.address, w.listenPort, w.dns, w.mtu
+	oldUp, oldDown := w.postUp, w.postDown
+	w.privateKey, w.address, w.listenPort, w.dns, w.mtu = privateKey, address, listenPort, dns, mtu
+	w.postUp, w.postDown = postUp, postDown
+	return func() {
+		w.privateKey, w.address, w.listenPort, w.dns, w.mtu = oldKey, oldAddr, oldPort, oldDNS, oldMTU
+		w.postUp, w.postDown = oldUp, oldDown
+	}
+}
+
+// configBlock is one section of a raw wg config file: the lines from a
+// section header up to (not including) the next one. The first block holds
+// anything before the first header. Peer blocks carry the PublicKey and the
+// "# name" comment the same way Load reads them.
+type configBlock struct {
+	lines     []string
+	peer      bool
+	disabled  bool
+	publicKey string
+	name      string
+}
+
+// splitBlocks groups config file lines into sections so callers can drop or
+// rewrite whole [Peer] blocks without disturbing the surrounding text.
+func splitBlocks(lines []string) []configBlock {
+	blocks := []configBlock{{}}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch trimmed {
+		case "[Interface]", "[Peer]":
+			blocks = append(blocks, configBlock{peer: trimmed == "[Peer]"})
+		case disabledPrefix + "[Peer]":
+			blocks = append(blocks, configBlock{disabled: true})
+		}
+		b := &blocks[len(blocks)-1]
+		b.lines = append(b.lines, line)
+		if b.disabled {
+			trimmed = strings.TrimPrefix(trimmed, disabledPrefix)
+		} else if !b.peer {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "PublicKey") {
+			b.publicKey = extractValue(trimmed)
+		} else if strings.HasPrefix(trimmed, "#") && b.name == "" {
+			b.name = strings.TrimPrefix(trimmed, "# ")
+		}
+	}
+	return blocks
+}
+
+// disabledPrefix marks the lines of a peer block that DisableInactive has
+// commented out. wg-quick treats them as comments; Load skips them.
+const disabledPrefix = "#disabled# "
+
+// DisableInactive comments out every peer whose latest handshake (per
+// `wg show <iface> dump`) is older than olderThan, including
+// peers that have never completed a handshake. Peers the live interface
+// doesn't know about are left alone — without handshake data there is
+// nothing to judge them by. Returns the peers that were disabled; in
+// dry-run mode it returns the peers that would be, without writing.
+func (w *WGConfig) DisableInactive(ctx context.Context, olderThan time.Duration) ([]Peer, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats, err := w.peerStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	handshakes := make(map[string]time.Time, len(stats))
+	for _, st := range stats {
+		handshakes[st.PublicKey] = st.LatestHandshake
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	stale := make(map[string]bool)
+	var disabled []Peer
+	for _, p := range w.peers {
+		last, ok := handshakes[p.PublicKey]
+		if !ok {
+			continue
+		}
+		if last.IsZero() || last.Before(cutoff) {
+			stale[p.PublicKey] = true
+			disabled = append(disabled, p)
+		}
+	}
+
+	if len(disabled) == 0 || w.dryRun {
+		return disabled, nil
+	}
+
+	if err := w.disablePeers(stale); err != nil {
+		return nil, err
+	}
+	return disabled, nil
+}
+
+// disablePeers comments out the [Peer] blocks for the given public keys and
+// reloads the result, so they move from the peer list to the disabled
+// blocks. Caller must hold w.mu.
+func (w *WGConfig) disablePeers(keys map[string]bool) error {
+	data, err := w.fs.ReadFile(w.path)
+	if err != nil {
+		return err
+	}
+
+	var result []string
+	for _, b := range splitBlocks(strings.Split(string(data), "\n")) {
+		if b.peer && keys[b.publicKey] {
+			for _, line := range b.lines {
+				if strings.TrimSpace(line) == "" {
+					result = append(result, line)
+				} else {
+					result = append(result, disabledPrefix+line)
+				}
+			}
+			continue
+		}
+		result = append(result, b.lines...)
+	}
+
+	output := []byte(strings.Join(result, "\n"))
+	if err := w.writeFile(output); err != nil {
+		return err
+	}
+	return w.parse(output)
+}
+
+// ErrRangeExhausted is returned by GetNextIP when every assignable address
+// in the VPN range is taken.
+var ErrRangeExhausted = errors.New("no available IPs in range")
+
+// GetNextIP returns the lowest free host address in vpnRange as a
+// single-host CIDR (/32 for IPv4, /128 for IPv6). The network address and
+// the gateway (network+1) are always reserved, as is the IPv4 broadcast
+// address. Every entry of the interface Address counts as used, as does
+// every address covered by a peer's AllowedIPs, so dual-stack peers reserve
+// their address in both families and a subnet routed to a peer inside the
+// range is never handed out. Disabled peers keep theirs too, so re-enabling
+// one never clashes with a peer added since. Returns ErrRangeExhausted when
+// nothing is left.
+func (w *WGConfig) GetNextIP(vpnRange string) (string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	r, err := w.rangeUsage(vpnRange)
+	if err != nil {
+		return "", err
+	}
+	next, ok := r.next()
+	if !ok {
+		return "", ErrRangeExhausted
+	}
+	return r.addr(next), nil
+}
+
+// UsageSummary is the address usage of a VPN range, as reported by Usage.
+// Address counts cover assignable hosts only (see GetNextIP), within the
+// low 64 bits of host space for IPv6.
+type UsageSummary struct {
+	PeerCount     int
+	UsedAddresses uint64
+	FreeAddresses uint64
+	NextIP        string // "" when the range is exhausted
+}
+
+// Usage summarizes how much of vpnRange the interface and its peers use
+// (disabled peers' addresses included, as GetNextIP reserves them), along
+// with the address GetNextIP would hand out next.
+func (w *WGConfig) Usage(vpnRange string) (UsageSummary, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	r, err := w.rangeUsage(vpnRange)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+	u := UsageSummary{PeerCount: len(w.peers)}
+	for _, s := range r.used {
+		lo, hi := max(s.lo, 2), min(s.hi, r.last)
+		if lo <= hi {
+			u.UsedAddresses += hi - lo + 1
+		}
+	}
+	if r.last >= 2 {
+		u.FreeAddresses = r.last - 1 - u.UsedAddresses
+	}
+	if next, ok := r.next(); ok {
+		u.NextIP = r.addr(next)
+	}
+	return u, nil
+}
+
+// addrRange is a VPN range with the host offsets already in use in it.
+type addrRange struct {
+	base   net.IP
+	suffix string       // "/32" or "/128"
+	last   uint64       // highest assignable offset
+	used   []offsetSpan // sorted by lo, non-overlapping
+}
+
+// offsetSpan is an inclusive run of host offsets.
+type offsetSpan struct {
+	lo, hi uint64
+}
+
+// rangeUsage collects the host offsets of vpnRange that the interface
+// Address and the peers' AllowedIPs occupy. A peer prefix larger than a
+// single host takes every offset it covers. Caller must hold w.mu.
+func (w *WGConfig) rangeUsage(vpnRange string) (addrRange, error) {
+	_, ipnet, err := net.ParseCIDR(vpnRange)
+	if err != nil {
+		return addrRange{}, err
+	}
+	network, err := netip.ParsePrefix(vpnRange)
+	if err != nil {
+		return addrRange{}, err
+	}
+	network = network.Masked()
+
+	r := addrRange{suffix: "/32", base: ipnet.IP.To4()}
+	if r.base == nil {
+		r.suffix = "/128"
+		r.base = ipnet.IP.To16()
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	hostBits := bits - ones
+	r.last = uint64(math.MaxUint64)
+	if hostBits < 64 {
+		r.last = uint64(1)<<hostBits - 1
+	}
+	if len(r.base) == net.IPv4len && r.last > 0 {
+		r.last-- // broadcast
+	}
+
+	var used []offsetSpan
+	for _, entry := range SplitAllowedIPs(w.address) {
+		if off, ok := hostOffset(ipnet, r.base, entryIP(entry)); ok {
+			used = append(used, offsetSpan{off, off})
+		}
+	}
+	addPeer := func(p Peer) {
+		prefixes, _ := peerPrefixes(p)
+		for _, prefix := range prefixes {
+			if s, ok := prefixSpan(ipnet, network, r.base, prefix); ok {
+				used = append(used, s)
+			}
+		}
+	}
+	for _, p := range w.peers {
+		addPeer(p)
+	}
+	for _, p := range w.disabledPeers() {
+		addPeer(p)
+	}
+
+	slices.SortFunc(used, func(a, b offsetSpan) int { return cmp.Compare(a.lo, b.lo) })
+	for _, s := range used {
+		if n := len(r.used); n > 0 && (r.used[n-1].hi == math.MaxUint64 || s.lo <= r.used[n-1].hi+1) {
+			r.used[n-1].hi = max(r.used[n-1].hi, s.hi)
+			continue
+		}
+		r.used = append(r.used, s)
+	}
+	return r, nil
+}
+
+// prefixSpan returns the host offsets of network that prefix covers: all of
+// them when prefix contains the whole network. Like hostOffset it only
+// reaches the low 64 bits of host space.
+func prefixSpan(ipnet *net.IPNet, network netip.Prefix, base net.IP, prefix netip.Prefix) (offsetSpan, bool) {
+	if !prefix.Overlaps(network) {
+		return offsetSpan{}, false
+	}
+	if prefix.Bits() <= network.Bits() {
+		return offsetSpan{0, math.MaxUint64}, true
+	}
+	lo, ok := hostOffset(ipnet, base, prefix.Addr().String())
+	if !ok {
+		return offsetSpan{}, false
+	}
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 64 || lo > math.MaxUint64-(uint64(1)<<hostBits-1) {
+		return offsetSpan{lo, math.MaxUint64}, true
+	}
+	return offsetSpan{lo, lo + uint64(1)<<hostBits - 1}, true
+}
+
+// next returns the lowest free offset at or above 2. It walks the used
+// spans in order rather than the range, so cost scales with the number of
+// peers, not the size of the range: a /8 is as cheap as a /24.
+func (r addrRange) next() (uint64, bool) {
+	next := uint64(2)
+	for _, s := range r.used {
+		if s.lo > next {
+			break
+		}
+		if s.hi >= next {
+			if s.hi == math.MaxUint64 {
+				return 0, false
+			}
+			next = s.hi + 1
+		}
+	}
+	return next, next <= r.last
+}
+
+// addr formats offset off as a single-host CIDR.
+func (r addrRange) addr(off uint64) string {
+	return addOffset(r.base, off).String() + r.suffix
+}
+
+// hostOffset returns ip's offset from base within ipnet. Only offsets that
+// fit in the low 64 bits are reported; anything beyond is out of reach of
+// GetNextIP's scan and can't collide with a candidate.
+func hostOffset(ipnet *net.IPNet, base net.IP, addr string) (uint64, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil || !ipnet.Contains(ip) {
+		return 0, false
+	}
+	if len(base) == net.IPv4len {
+		return uint64(binary.BigEndian.Uint32(ip.To4()) - binary.BigEndian.Uint32(base)), true
+	}
+	ip = ip.To16()
+	if !bytes.Equal(ip[:8], base[:8]) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(ip[8:]) - binary.BigEndian.Uint64(base[8:]), true
+}
+
+// addOffset returns base+off. The offset is applied to the low 32 bits of an
+// IPv4 address or the low 64 bits of an IPv6 one, which is all the host
+// space GetNextIP will ever scan.
+func addOffset(base net.IP, off uint64) net.IP {
+	ip := make(net.IP, len(base))
+	copy(ip, base)
+	if len(ip) == net.IPv4len {
+		binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(ip)+uint32(off))
+	} else {
+		binary.BigEndian.PutUint64(ip[8:], binary.BigEndian.Uint64(ip[8:])+off)
+	}
+	return ip
+}
+
+// clone returns a copy of p that shares no slices with it, so callers can't
+// change WGConfig's state through a returned peer.
+func (p Peer) clone() Peer {
+	p.AllowedIPs = slices.Clone(p.AllowedIPs)
+	return p
+}
 
-	for i := range w.peers {
-		if w.peers[i].PublicKey == oldPubKey {
-			w.peers[i].PublicKey = newPubKey
-			break
+// SplitAllowedIPs splits a comma-separated wg address list such as
+// "10.100.0.2/32, fd00::2/128" into entries, dropping blanks.
+func SplitAllowedIPs(s string) []string {
+	var entries []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			entries = append(entries, part)
 		}
 	}
+	return entries
+}
 
-	return nil
+// ValidatePeerRoutes checks that no two peers claim overlapping AllowedIPs,
+// which would make WireGuard's cryptokey routing pick one of them silently.
+// Every overlapping pair and every entry that isn't a CIDR or IP is
+// reported in the joined error.
+func (w *WGConfig) ValidatePeerRoutes() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var errs []error
+	for i, p := range w.peers {
+		if _, err := peerPrefixes(p); err != nil {
+			errs = append(errs, err)
+		}
+		for _, q := range w.peers[i+1:] {
+			if err := routeOverlap(p, q); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func (w *WGConfig) RemovePeer(publicKey string) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+// ValidateKeys checks the [Interface] PrivateKey is a well-formed
+// Curve25519 key and that no peer was given the server's own public key —
+// the usual result of pasting the wrong half of a key pair.
+func (w *WGConfig) ValidateKeys() error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 
-	data, err := os.ReadFile(w.path)
+	if w.privateKey == "" {
+		return fmt.Errorf("no private key loaded")
+	}
+	if !ValidatePublicKey(w.privateKey) {
+		return fmt.Errorf("invalid PrivateKey: must be 32 bytes, base64-encoded")
+	}
+	serverPub, err := PublicKeyFromPrivate(w.privateKey)
 	if err != nil {
 		return err
 	}
 
-	lines := strings.Split(string(data), "\n")
-	var result []string
-	skip := false
-	found := false
-
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-
-		if trimmed == "[Peer]" {
-			skip = false
+	var errs []error
+	for _, p := range w.peers {
+		if p.PublicKey == serverPub {
+			errs = append(errs, fmt.Errorf("peer %s uses the server's own public key", peerLabel(&p)))
 		}
+	}
+	return errors.Join(errs...)
+}
 
-		if skip {
-			continue
+// peerPrefixes parses a peer's AllowedIPs. A bare address counts as a
+// single-host prefix. Entries that don't parse are left out of the result
+// and reported in the error.
+func peerPrefixes(p Peer) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	var errs []error
+	for _, entry := range p.AllowedIPs {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				errs = append(errs, fmt.Errorf("peer %s: invalid AllowedIPs entry %q", peerLabel(&p), entry))
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
 		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, errors.Join(errs...)
+}
 
-		if strings.HasPrefix(trimmed, "PublicKey") && extractValue(trimmed) == publicKey {
-			skip = true
-			found = true
-			for len(result) > 0 {
-				last := strings.TrimSpace(result[len(result)-1])
-				if last == "[Peer]" || strings.HasPrefix(last, "#") || last == "" {
-					result = result[:len(result)-1]
-				} else {
-					break
-				}
+// routeOverlap reports the first AllowedIPs overlap between two distinct
+// peers. Unparsable entries are skipped; peerPrefixes reports those.
+func routeOverlap(a, b Peer) error {
+	if a.PublicKey == b.PublicKey {
+		return nil
+	}
+	pa, _ := peerPrefixes(a)
+	pb, _ := peerPrefixes(b)
+	for _, x := range pa {
+		for _, y := range pb {
+			if x.Overlaps(y) {
+				return fmt.Errorf("AllowedIPs overlap: %s (%s) and %s (%s)", peerLabel(&a), x, peerLabel(&b), y)
 			}
-			continue
 		}
-
-		result = append(result, line)
 	}
+	return nil
+}
 
-	if !found {
-		return fmt.Errorf("peer not found")
+// entryIP returns the address part of an AllowedIPs entry in canonical form
+// ("fd00:0::2/128" -> "fd00::2"), or the raw text if it doesn't parse.
+func entryIP(entry string) string {
+	addr := strings.Split(entry, "/")[0]
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
 	}
+	return addr
+}
 
-	output := strings.TrimRight(strings.Join(result, "\n"), "\n") + "\n"
-	if err := os.WriteFile(w.path, []byte(output), 0600); err != nil {
-		return err
+// GenerateKeyPair creates a new Curve25519 key pair, base64-encoded the way
+// `wg genkey | wg pubkey` prints them.
+func GenerateKeyPair() (privateKey, publicKey string, err error) {
+	var priv [32]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		return "", "", fmt.Errorf("failed to generate private key: %w", err)
 	}
+	// Clamp like wg genkey so the stored key is the canonical scalar.
+	priv[0] &= 248
+	priv[31] = (priv[31] & 127) | 64
 
-	newPeers := make([]Peer, 0, len(w.peers)-1)
-	for _, p := range w.peers {
-		if p.PublicKey != publicKey {
-			newPeers = append(newPeers, p)
-		}
+	privateKey = base64.StdEncoding.EncodeToString(priv[:])
+	publicKey, err = PublicKeyFromPrivate(privateKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate public key: %w", err)
 	}
-	w.peers = newPeers
+	return privateKey, publicKey, nil
+}
 
-	return nil
+// GeneratePresharedKey returns 32 random bytes, base64-encoded the way
+// `wg genpsk` prints them.
+func GeneratePresharedKey() (string, error) {
+	var psk [32]byte
+	if _, err := rand.Read(psk[:]); err != nil {
+		return "", fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(psk[:]), nil
 }
 
-func (w *WGConfig) GetNextIP(vpnRange string) (string, error) {
+// RotatePresharedKey gives the peer with publicKey a fresh PresharedKey
+// (adding one if it had none) and rewrites the config file. The new key is
+// returned: the client's config needs the same value before the next
+// handshake succeeds.
+func (w *WGConfig) RotatePresharedKey(publicKey string) (string, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	_, ipnet, err := net.ParseCIDR(vpnRange)
+	i := slices.IndexFunc(w.peers, func(p Peer) bool { return p.PublicKey == publicKey })
+	if i < 0 {
+		return "", fmt.Errorf("peer not found")
+	}
+	psk, err := GeneratePresharedKey()
 	if err != nil {
 		return "", err
 	}
 
-	usedIPs := make(map[string]bool)
-	if w.address != "" {
-		ip := strings.Split(w.address, "/")[0]
-		usedIPs[ip] = true
-	}
-	for _, p := range w.peers {
-		ip := strings.Split(p.AllowedIPs, "/")[0]
-		usedIPs[ip] = true
+	old := w.peers[i].PresharedKey
+	w.peers[i].PresharedKey = psk
+	if err := w.writeFile([]byte(w.render())); err != nil {
+		w.peers[i].PresharedKey = old
+		return "", err
 	}
+	return psk, nil
+}
 
-	ip := ipnet.IP.To4()
-	if ip == nil {
-		return "", fmt.Errorf("only IPv4 supported")
+// PublicKeyFromPrivate derives the base64 public key for a base64 private
+// key, like `wg pubkey`.
+func PublicKeyFromPrivate(priv string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(priv))
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
 	}
-
-	for i := 2; i < 255; i++ {
-		candidate := net.IPv4(ip[0], ip[1], ip[2], byte(i)).String()
-		if !usedIPs[candidate] {
-			return candidate + "/32", nil
-		}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
 	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
 
-	return "", fmt.Errorf("no available IPs in range")
+// Reload loads the config file as it stands on disk into the running
+// interface, the same way Apply does but without writing the in-memory
+// config first, so edits made to the file by hand are picked up rather
+// than overwritten.
+func (w *WGConfig) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncConf(context.Background())
 }
 
-func GenerateKeyPair() (privateKey, publicKey string, err error) {
-	privCmd := exec.Command("wg", "genkey")
-	privOut, err := privCmd.Output()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate private key: %w", err)
-	}
-	privateKey = strings.TrimSpace(string(privOut))
+// Apply writes the in-memory config (see Save) and loads it into the running
+// interface with `wg syncconf`, which adds, updates and removes peers
+// without disturbing sessions that didn't change. Only when this wg can't
+// syncconf (not installed, or too old for the subcommand) does it fall back
+// to wg-quick down/up, which drops every tunnel.
+func (w *WGConfig) Apply(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	pubCmd := exec.Command("wg", "pubkey")
-	pubCmd.Stdin = strings.NewReader(privateKey)
-	pubOut, err := pubCmd.Output()
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate public key: %w", err)
+	if err := w.writeFile([]byte(w.render())); err != nil {
+		return err
 	}
-	publicKey = strings.TrimSpace(string(pubOut))
-
-	return privateKey, publicKey, nil
+	return w.syncConf(ctx)
 }
 
-func (w *WGConfig) Reload() error {
-	cmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-		"bash", "-c", fmt.Sprintf("wg syncconf %s <(wg-quick strip %s)", w.iface, w.iface))
-	if out, err := cmd.CombinedOutput(); err != nil {
-		restartCmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-			"bash", "-c", fmt.Sprintf("wg-quick down %s; wg-quick up %s", w.iface, w.iface))
-		if out2, err2 := restartCmd.CombinedOutput(); err2 != nil {
-			return fmt.Errorf("wg reload failed: %v — %s; restart also failed: %v — %s", err, string(out), err2, string(out2))
+// syncConf runs syncconf (or the down/up fallback) through systemd-run so
+// it escapes the service's ProtectSystem=strict sandbox. Caller must hold
+// w.mu.
+func (w *WGConfig) syncConf(ctx context.Context) error {
+	if _, err := w.runner.LookPath("wg"); err == nil {
+		// The interface and path go in as positional parameters, not into
+		// the script, so the shell never interprets them.
+		out, err := w.runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+			"bash", "-c", `wg syncconf "$1" <(wg-quick strip "$2")`, "bash", w.iface, w.path)
+		if err == nil {
+			return nil
 		}
+		if !strings.Contains(string(out), "Invalid subcommand") {
+			return fmt.Errorf("wg syncconf failed: %v — %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	out, err := w.runner.CombinedOutput(ctx, "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"bash", "-c", fmt.Sprintf("wg-quick down %s; wg-quick up %s", w.iface, w.iface))
+	if err != nil {
+		return fmt.Errorf("wg-quick restart failed: %v — %s", err, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
 
 func (w *WGConfig) InterfaceUp() error {
-	cmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-		"wg-quick", "up", w.iface)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := w.runner.CombinedOutput(context.Background(), "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"wg-quick", "up", w.iface); err != nil {
 		return fmt.Errorf("wg-quick up failed: %v — %s", err, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
 
 func (w *WGConfig) InterfaceDown() error {
-	cmd := exec.Command("systemd-run", "--pipe", "--wait", "--service-type=oneshot",
-		"wg-quick", "down", w.iface)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := w.runner.CombinedOutput(context.Background(), "systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"wg-quick", "down", w.iface); err != nil {
 		return fmt.Errorf("wg-quick down failed: %v — %s", err, strings.TrimSpace(string(out)))
 	}
 	return nil
 }
 
 type SystemStatus struct {
-	InterfaceUp     bool
-	IPForwarding    bool
-	Masquerading    bool
-	InterfaceError  string
-	ForwardingError string
-	MasqError       string
+	InterfaceUp     bool   `json:"interface_up"`
+	IPForwarding    bool   `json:"ip_forwarding"`
+	Masquerading    bool   `json:"masquerading"`
+	InterfaceError  string `json:"interface_error,omitempty"`
+	ForwardingError string `json:"forwarding_error,omitempty"`
+	MasqError       string `json:"masq_error,omitempty"`
+
+	// InterfaceExists reports whether the link is present at all, so setup
+	// can tell "create it" (wg-quick up) apart from "it exists but wg isn't
+	// configured on it". InterfaceAddrs are its addresses in CIDR form, as
+	// listed by `ip addr show`.
+	InterfaceExists bool     `json:"interface_exists"`
+	InterfaceAddrs  []string `json:"interface_addrs"`
+
+	// Rules lists each iptables rule ExpectedPostUp installs and whether
+	// `iptables -C` found it, so setup can add only the missing ones.
+	Rules []RuleStatus `json:"rules"`
+
+	// The IPv6 checks mirror IPForwarding and Masquerading using
+	// net.ipv6.conf.all.forwarding and ip6tables, and are reported
+	// independently of the IPv4 ones. DualStack is set when the interface
+	// Address includes an IPv6 prefix; on an IPv4-only tunnel the IPv6
+	// results are informational.
+	DualStack           bool   `json:"dual_stack"`
+	IPv6Forwarding      bool   `json:"ipv6_forwarding"`
+	IPv6Masquerading    bool   `json:"ipv6_masquerading"`
+	IPv6ForwardingError string `json:"ipv6_forwarding_error,omitempty"`
+	IPv6MasqError       string `json:"ipv6_masq_error,omitempty"`
 }
 
-func (w *WGConfig) CheckSystem(vpnRange string) SystemStatus {
+// RuleStatus is one iptables rule and whether it is currently installed.
+type RuleStatus struct {
+	Table   string   `json:"table"` // "nat" or "filter"
+	Chain   string   `json:"chain"` // e.g. "POSTROUTING"
+	Spec    []string `json:"spec"`  // rule match/target args, e.g. -o eth0 -j MASQUERADE
+	Present bool     `json:"present"`
+}
+
+func (r RuleStatus) String() string {
+	return fmt.Sprintf("-t %s %s %s", r.Table, r.Chain, strings.Join(r.Spec, " "))
+}
+
+// ExpectedRules returns the rules ExpectedPostUp installs for wgInterface,
+// unchecked. The MASQUERADE rule is omitted when outIface is unknown.
+func ExpectedRules(wgInterface, outIface string) []RuleStatus {
+	rules := []RuleStatus{
+		{Table: "filter", Chain: "FORWARD", Spec: []string{"-i", wgInterface, "-j", forwardChainName}},
+		{Table: "filter", Chain: "FORWARD", Spec: []string{"-o", wgInterface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}},
+	}
+	if outIface != "" {
+		rules = append(rules, RuleStatus{Table: "nat", Chain: "POSTROUTING", Spec: []string{"-o", outIface, "-j", "MASQUERADE"}})
+	}
+	return rules
+}
+
+// checkRules runs `iptables -t <table> -C <chain> <spec>` for each rule.
+func (w *WGConfig) checkRules(ctx context.Context, rules []RuleStatus) []RuleStatus {
+	for i, r := range rules {
+		args := append([]string{"-t", r.Table, "-C", r.Chain}, r.Spec...)
+		rules[i].Present = w.runner.Run(ctx, "iptables", args...) == nil
+	}
+	return rules
+}
+
+// CheckSystem reports whether the interface, IP forwarding and the iptables
+// rules WireGuard needs are in place. Every command goes through the
+// CommandRunner with ctx, so a caller's timeout or cancellation stops a
+// hung wg/ip/iptables call. The interface Address is copied under the read
+// lock first, so the commands run without holding it.
+func (w *WGConfig) CheckSystem(ctx context.Context, vpnRange string) SystemStatus {
+	w.mu.RLock()
+	address := w.address
+	w.mu.RUnlock()
+
 	status := SystemStatus{}
 
-	cmd := exec.Command("wg", "show", w.iface)
-	if err := cmd.Run(); err != nil {
+	if err := w.runner.Run(ctx, "wg", "show", w.iface); err != nil {
 		status.InterfaceError = err.Error()
 	} else {
 		status.InterfaceUp = true
 	}
 
-	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
-	if err != nil {
-		status.ForwardingError = err.Error()
-	} else if strings.TrimSpace(string(data)) == "1" {
-		status.IPForwarding = true
-	} else {
-		status.ForwardingError = "IP forwarding disabled"
+	if out, err := w.runner.Output(ctx, "ip", "addr", "show", w.iface); err == nil {
+		status.InterfaceExists = true
+		status.InterfaceAddrs = parseIPAddrShow(string(out))
 	}
 
+	status.IPForwarding, status.ForwardingError = w.checkForwarding(ipForwardProcPath, "IP forwarding disabled")
+	status.IPv6Forwarding, status.IPv6ForwardingError = w.checkForwarding(ipv6ForwardProcPath, "IPv6 forwarding disabled")
+
 	// Check for masquerade rule matching what PostUp creates: -o <outIface> -j MASQUERADE
 	// Also accept the legacy -s <vpnRange> form in case it was added manually.
 	outIface := detectDefaultInterface()
-	if outIface != "" {
-		cmd = exec.Command("iptables", "-t", "nat", "-C", "POSTROUTING", "-o", outIface, "-j", "MASQUERADE")
-		if err := cmd.Run(); err != nil {
-			// Fall back to checking legacy source-based rule
-			cmd = exec.Command("iptables", "-t", "nat", "-C", "POSTROUTING", "-s", vpnRange, "-j", "MASQUERADE")
-			if err := cmd.Run(); err != nil {
-				status.MasqError = "Masquerade rule not found"
-			} else {
-				status.Masquerading = true
-			}
-		} else {
-			status.Masquerading = true
-		}
+	status.Rules = w.checkRules(ctx, ExpectedRules(w.iface, outIface))
+	masq := func(cmd string, match ...string) bool {
+		args := append([]string{"-t", "nat", "-C", "POSTROUTING"}, match...)
+		return w.runner.Run(ctx, cmd, append(args, "-j", "MASQUERADE")...) == nil
+	}
+	if (outIface != "" && masq("iptables", "-o", outIface)) || masq("iptables", "-s", vpnRange) {
+		status.Masquerading = true
 	} else {
-		cmd = exec.Command("iptables", "-t", "nat", "-C", "POSTROUTING", "-s", vpnRange, "-j", "MASQUERADE")
-		if err := cmd.Run(); err != nil {
-			status.MasqError = "Masquerade rule not found"
-		} else {
-			status.Masquerading = true
-		}
+		status.MasqError = "Masquerade rule not found"
+	}
+
+	// The IPv6 legacy form matches the interface's own IPv6 prefixes, since
+	// vpnRange is IPv4.
+	v6Ranges := ipv6Prefixes(address)
+	status.DualStack = len(v6Ranges) > 0
+	found := outIface != "" && masq("ip6tables", "-o", outIface)
+	for _, r := range v6Ranges {
+		found = found || masq("ip6tables", "-s", r)
+	}
+	if found {
+		status.IPv6Masquerading = true
+	} else {
+		status.IPv6MasqError = "IPv6 masquerade rule not found"
 	}
 
 	return status
 }
 
-func EnableIPForwarding() error {
-	return os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644)
+// checkForwarding reads a forwarding sysctl from /proc, returning whether it
+// is on and, if not, why.
+func (w *WGConfig) checkForwarding(procPath, disabled string) (bool, string) {
+	data, err := w.fs.ReadFile(procPath)
+	if err != nil {
+		return false, err.Error()
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		return false, disabled
+	}
+	return true, ""
+}
+
+// ipv6Prefixes returns the IPv6 networks of an interface Address, masked to
+// their prefix (fd00:100::1/64 becomes fd00:100::/64).
+func ipv6Prefixes(address string) []string {
+	var out []string
+	for _, a := range SplitAllowedIPs(address) {
+		p, err := netip.ParsePrefix(a)
+		if err != nil || !p.Addr().Is6() || p.Addr().Is4In6() {
+			continue
+		}
+		out = append(out, p.Masked().String())
+	}
+	return out
+}
+
+// parseIPAddrShow extracts the inet/inet6 addresses from `ip addr show`:
+//
+//	4: wg0: <POINTOPOINT,NOARP,UP,LOWER_UP> mtu 1420 qdisc noqueue state UNKNOWN
+//	    link/none
+//	    inet 10.100.0.1/24 scope global wg0
+//	       valid_lft forever preferred_lft forever
+func parseIPAddrShow(out string) []string {
+	var addrs []string
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[0] == "inet" || fields[0] == "inet6") {
+			addrs = append(addrs, fields[1])
+		}
+	}
+	return addrs
+}
+
+const (
+	ipForwardProcPath   = "/proc/sys/net/ipv4/ip_forward"
+	ipv6ForwardProcPath = "/proc/sys/net/ipv6/conf/all/forwarding"
+	// ipForwardSysctlPath persists forwarding across reboots. The 99- prefix
+	// sorts it after distro defaults that might turn forwarding back off.
+	ipForwardSysctlPath = "/etc/sysctl.d/99-homelab-horizon.conf"
+	ipForwardSysctl     = "net.ipv4.ip_forward=1\n"
+)
+
+// EnableIPForwarding turns on IPv4 forwarding now (sysctl -w) and writes a
+// sysctl.d drop-in so it survives a reboot. Each half is skipped when it's
+// already in place; changed reports whether anything was done.
+func (w *WGConfig) EnableIPForwarding(ctx context.Context) (changed bool, err error) {
+	if data, err := w.fs.ReadFile(ipForwardProcPath); err != nil || strings.TrimSpace(string(data)) != "1" {
+		if out, err := w.runner.CombinedOutput(ctx, "sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
+			return false, fmt.Errorf("sysctl -w net.ipv4.ip_forward=1: %v — %s", err, strings.TrimSpace(string(out)))
+		}
+		changed = true
+	}
+
+	if data, err := w.fs.ReadFile(ipForwardSysctlPath); err != nil || string(data) != ipForwardSysctl {
+		if err := w.fs.MkdirAll(filepath.Dir(ipForwardSysctlPath), 0755); err != nil {
+			return changed, err
+		}
+		if err := w.fs.WriteFile(ipForwardSysctlPath, []byte(ipForwardSysctl), 0644); err != nil {
+			return changed, fmt.Errorf("writing %s: %w", ipForwardSysctlPath, err)
+		}
+		changed = true
+	}
+	return changed, nil
 }
 
 func AddMasqueradeRule(vpnRange string) error {
@@ -577,21 +1807,274 @@ func RemoveMasqueradeRule(outIface string) {
 }
 
 func (w *WGConfig) GetAddress() string {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	w.mu.RLock()
+	defer w.mu.RUnlock()
 	return w.address
 }
 
-func (w *WGConfig) GetPostUp() string {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	return w.postUp
+// GetDNS returns the [Interface] DNS line: comma-separated resolver IPs
+// and/or search domains, or "" if unset.
+func (w *WGConfig) GetDNS() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.dns
+}
+
+// GetListenPort returns the [Interface] ListenPort, or 0 if unset.
+func (w *WGConfig) GetListenPort() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.listenPort
+}
+
+// GetMTU returns the [Interface] MTU, or 0 if unset (wg-quick picks one).
+func (w *WGConfig) GetMTU() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.mtu
+}
+
+// GetPostUp returns every PostUp command in file order. wg-quick runs each
+// line in turn, so a config may carry several.
+func (w *WGConfig) GetPostUp() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return slices.Clone(w.postUp)
+}
+
+// GetPostDown returns every PostDown command in file order.
+func (w *WGConfig) GetPostDown() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return slices.Clone(w.postDown)
+}
+
+// Render serializes the in-memory config in wg-quick format: the [Interface]
+// fields horizon models followed by one [Peer] block per peer, in the same
+// layout AddPeer appends.
+func (w *WGConfig) Render() string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.render()
+}
+
+func (w *WGConfig) render() string {
+	var b strings.Builder
+	for _, line := range w.preamble {
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("[Interface]\n")
+	iw := sectionWriter{b: &b, extra: w.unknownLines}
+	iw.field("")
+	iw.field("PrivateKey", w.privateKey)
+	iw.field("Address", w.address)
+	if w.listenPort > 0 {
+		iw.field("ListenPort", strconv.Itoa(w.listenPort))
+	}
+	iw.field("DNS", w.dns)
+	if w.mtu > 0 {
+		iw.field("MTU", strconv.Itoa(w.mtu))
+	}
+	iw.field("PostUp", w.postUp...)
+	iw.field("PostDown", w.postDown...)
+	iw.finish()
+	w.renderLoose(&b, func(after string) bool { return after == "" })
+
+	live := make(map[string]bool, len(w.peers))
+	for _, p := range w.peers {
+		live[p.PublicKey] = true
+		b.WriteString("\n[Peer]\n")
+		if p.Name != "" {
+			fmt.Fprintf(&b, "# %s\n", p.Name)
+		}
+		pw := sectionWriter{b: &b, extra: p.extra}
+		pw.field("")
+		pw.field("PublicKey", p.PublicKey)
+		pw.field("PresharedKey", p.PresharedKey)
+		pw.field("AllowedIPs", strings.Join(p.AllowedIPs, ", "))
+		pw.field("Endpoint", p.Endpoint)
+		if p.PersistentKeepalive > 0 {
+			pw.field("PersistentKeepalive", strconv.Itoa(p.PersistentKeepalive))
+		}
+		pw.finish()
+		if p.PublicKey != "" {
+			w.renderLoose(&b, func(after string) bool { return after == p.PublicKey })
+		}
+	}
+	// A block whose anchor peer is gone goes last rather than nowhere;
+	// commitPeers normally re-anchors them first.
+	w.renderLoose(&b, func(after string) bool { return after != "" && !live[after] })
+	return b.String()
+}
+
+// renderLoose writes the loose blocks whose anchor matches, in file order.
+func (w *WGConfig) renderLoose(b *strings.Builder, match func(after string) bool) {
+	for _, l := range w.loose {
+		if !match(l.after) {
+			continue
+		}
+		if l.gap {
+			b.WriteString("\n")
+		}
+		for _, line := range l.lines {
+			b.WriteString(line + "\n")
+		}
+	}
+}
+
+// writeField writes a "Key = value" line, omitting empty values.
+func writeField(b *strings.Builder, key, value string) {
+	if value != "" {
+		fmt.Fprintf(b, "%s = %s\n", key, value)
+	}
+}
+
+// sectionWriter writes one section's fields and puts each unmodeled line
+// back after the key it followed in the original file. field("") emits the
+// lines that came straight after the header.
+type sectionWriter struct {
+	b     *strings.Builder
+	extra []extraLine
+	done  map[string]bool
+}
+
+func (s *sectionWriter) field(key string, values ...string) {
+	wrote := key == ""
+	for _, v := range values {
+		if v != "" {
+			writeField(s.b, key, v)
+			wrote = true
+		}
+	}
+	if !wrote {
+		return
+	}
+	if s.done == nil {
+		s.done = make(map[string]bool)
+	}
+	s.done[key] = true
+	for _, e := range s.extra {
+		if e.after == key {
+			s.b.WriteString(e.text + "\n")
+		}
+	}
+}
+
+// finish writes any lines whose anchor key is no longer set, so clearing a
+// field never drops its neighbours.
+func (s *sectionWriter) finish() {
+	for _, e := range s.extra {
+		if !s.done[e.after] {
+			s.b.WriteString(e.text + "\n")
+		}
+	}
 }
 
-func (w *WGConfig) GetPostDown() string {
+// Save writes the in-memory config (see Render) to disk atomically, keeping
+// the previous version as a backup (see SetBackupCount).
+func (w *WGConfig) Save() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.postDown
+	return w.writeFile([]byte(w.render()))
+}
+
+// writeFile replaces the config file atomically (see system.WriteFileAtomic),
+// so a crash mid-write never leaves a truncated wg0.conf that keeps the
+// tunnel from coming back up. Caller must hold w.mu.
+func (w *WGConfig) writeFile(data []byte) error {
+	if err := w.backup(data); err != nil {
+		return fmt.Errorf("backing up %s: %w", w.path, err)
+	}
+	return system.WriteFileAtomic(w.fs, w.path, data, 0600)
+}
+
+// backupTimeFormat sorts lexically in time order, which rotation relies on.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// backup copies the current config file to a timestamped .bak and prunes
+// all but the newest w.backups of them. Nothing is copied when the file is
+// missing or already holds the new data, so no-op saves don't rotate good
+// backups away. Caller must hold w.mu.
+func (w *WGConfig) backup(data []byte) error {
+	if w.backups == 0 {
+		return nil
+	}
+	current, err := w.fs.ReadFile(w.path)
+	if os.IsNotExist(err) || (err == nil && bytes.Equal(current, data)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Base(w.path)
+	name := fmt.Sprintf("%s.%s.bak", base, time.Now().UTC().Format(backupTimeFormat))
+	if err := system.WriteFileAtomic(w.fs, filepath.Join(filepath.Dir(w.path), name), current, 0600); err != nil {
+		return err
+	}
+
+	backups, err := w.listBackups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > w.backups {
+		if err := w.fs.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// listBackups returns the paths of the config file's backups, oldest first.
+// Only names backup itself writes count, base.<backupTimeFormat>.bak, so an
+// operator's own wg0.conf.old.bak is never rotated away. Caller must hold
+// w.mu.
+func (w *WGConfig) listBackups() ([]string, error) {
+	dir, base := filepath.Dir(w.path), filepath.Base(w.path)
+	entries, err := w.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, base+".")
+		if stamp, ok = strings.CutSuffix(stamp, ".bak"); !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// Diff returns a unified diff from the config on disk, read through the
+// config's FileSystem, to the in-memory one, so an operator can review
+// which lines an apply will change before confirming. Both sides are parsed
+// and rendered the same way, so only changes in content show up, not
+// formatting that render would normalize anyway. Empty when they match; a
+// missing file diffs as empty.
+func (w *WGConfig) Diff() (string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	data, err := w.fs.ReadFile(w.path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var current []string
+	if len(data) > 0 {
+		disk := &WGConfig{path: w.path, iface: w.iface}
+		if err := disk.parse(data); err != nil {
+			return "", fmt.Errorf("parse %s: %w", w.path, err)
+		}
+		current = splitLines(disk.render())
+	}
+	return unifiedDiff(w.path, w.path+" (in memory)", current, splitLines(w.render())), nil
 }
 
 // ExpectedPostUp returns the PostUp line we'd generate for a new config with
@@ -624,18 +2107,16 @@ func ExpectedPostDown(outIface string) string {
 
 const forwardChainName = "WG-FORWARD"
 
-// peerIP extracts the first /32 IP from a peer's AllowedIPs string
-func peerIP(allowedIPs string) string {
-	for _, part := range strings.Split(allowedIPs, ",") {
-		part = strings.TrimSpace(part)
-		if strings.HasSuffix(part, "/32") {
-			return strings.TrimSuffix(part, "/32")
+// peerIP extracts the first /32 IP from a peer's AllowedIPs
+func peerIP(allowedIPs []string) string {
+	for _, entry := range allowedIPs {
+		if strings.HasSuffix(entry, "/32") {
+			return strings.TrimSuffix(entry, "/32")
 		}
 	}
 	// Fallback: take IP from first entry
-	parts := strings.Split(strings.TrimSpace(allowedIPs), "/")
-	if len(parts) > 0 {
-		return parts[0]
+	if len(allowedIPs) > 0 {
+		return strings.Split(allowedIPs[0], "/")[0]
 	}
 	return ""
 }
@@ -747,12 +2228,14 @@ func RebuildForwardChain(opts ForwardChainOpts) error {
 	return nil
 }
 
-// UpdateInterfaceRules rewrites PostUp and PostDown in the config file, preserving everything else.
-func (w *WGConfig) UpdateInterfaceRules(postUp, postDown string) error {
+// UpdateInterfaceRules rewrites PostUp and PostDown in the config file,
+// preserving everything else. The given lines replace all existing ones of
+// the same key, written where the first of them was.
+func (w *WGConfig) UpdateInterfaceRules(postUp, postDown []string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	data, err := os.ReadFile(w.path)
+	data, err := w.fs.ReadFile(w.path)
 	if err != nil {
 		return err
 	}
@@ -777,13 +2260,17 @@ func (w *WGConfig) UpdateInterfaceRules(postUp, postDown string) error {
 
 		if inInterface {
 			if strings.HasPrefix(trimmed, "PostUp") {
-				result = append(result, "PostUp = "+postUp)
-				replacedUp = true
+				if !replacedUp {
+					result = appendFieldLines(result, "PostUp", postUp)
+					replacedUp = true
+				}
 				continue
 			}
 			if strings.HasPrefix(trimmed, "PostDown") {
-				result = append(result, "PostDown = "+postDown)
-				replacedDown = true
+				if !replacedDown {
+					result = appendFieldLines(result, "PostDown", postDown)
+					replacedDown = true
+				}
 				continue
 			}
 		}
@@ -799,10 +2286,10 @@ func (w *WGConfig) UpdateInterfaceRules(postUp, postDown string) error {
 			final = append(final, line)
 			if !added && strings.HasPrefix(strings.TrimSpace(line), "ListenPort") {
 				if !replacedUp {
-					final = append(final, "PostUp = "+postUp)
+					final = appendFieldLines(final, "PostUp", postUp)
 				}
 				if !replacedDown {
-					final = append(final, "PostDown = "+postDown)
+					final = appendFieldLines(final, "PostDown", postDown)
 				}
 				added = true
 			}
@@ -811,33 +2298,74 @@ func (w *WGConfig) UpdateInterfaceRules(postUp, postDown string) error {
 	}
 
 	output := strings.Join(result, "\n")
-	if err := os.WriteFile(w.path, []byte(output), 0600); err != nil {
+	if err := w.writeFile([]byte(output)); err != nil {
 		return err
 	}
 
-	w.postUp = postUp
-	w.postDown = postDown
+	w.postUp = slices.Clone(postUp)
+	w.postDown = slices.Clone(postDown)
 	return nil
 }
 
-func GenerateClientConfig(clientPrivateKey, clientIP, serverPubKey, serverEndpoint, dns, allowedIPs string) string {
-	clientIPWithMask := clientIP
-	if !strings.Contains(clientIP, "/") {
-		clientIPWithMask = clientIP + "/32"
+// appendFieldLines appends one "Key = value" line per value.
+func appendFieldLines(lines []string, key string, values []string) []string {
+	for _, v := range values {
+		lines = append(lines, key+" = "+v)
 	}
-	clientIPForAddress := strings.TrimSuffix(clientIPWithMask, "/32") + "/24"
+	return lines
+}
+
+// GenerateClientConfig returns a complete wg-quick .conf for a client: its
+// own private key and VPN address, plus a single [Peer] for this server.
+// clientIP may be bare or carry a prefix, and may list an IPv4 and an IPv6
+// address; the Address line gives each the mask of the vpnRange entry that
+// holds it (see clientAddress). An empty dns omits the DNS line so the
+// client keeps its own resolvers.
+func GenerateClientConfig(clientPrivateKey, clientIP, vpnRange, serverPubKey, serverEndpoint, dns, allowedIPs string) string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	writeField(&b, "PrivateKey", clientPrivateKey)
+	writeField(&b, "Address", clientAddress(clientIP, vpnRange))
+	writeField(&b, "DNS", dns)
+	b.WriteString("\n[Peer]\n")
+	writeField(&b, "PublicKey", serverPubKey)
+	writeField(&b, "Endpoint", serverEndpoint)
+	writeField(&b, "AllowedIPs", allowedIPs)
+	writeField(&b, "PersistentKeepalive", "25")
+	return b.String()
+}
 
-	return fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s
-DNS = %s
+// clientAddress renders a client's Address value. Each address in clientIP
+// (any prefix it carries is dropped) gets the mask of the first network in
+// the comma-separated ranges that contains it, so 10.100.0.7 in
+// 10.100.0.0/24 becomes 10.100.0.7/24 and fd00::7 in fd00::/64 becomes
+// fd00::7/64. An address outside every range stays a single host (/32 or
+// /128); an entry that doesn't parse is passed through untouched.
+func clientAddress(clientIP, ranges string) string {
+	var networks []netip.Prefix
+	for _, entry := range SplitAllowedIPs(ranges) {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			networks = append(networks, prefix.Masked())
+		}
+	}
 
-[Peer]
-PublicKey = %s
-Endpoint = %s
-AllowedIPs = %s
-PersistentKeepalive = 25
-`, clientPrivateKey, clientIPForAddress, dns, serverPubKey, serverEndpoint, allowedIPs)
+	var out []string
+	for _, entry := range SplitAllowedIPs(clientIP) {
+		addr, err := netip.ParseAddr(strings.Split(entry, "/")[0])
+		if err != nil {
+			out = append(out, entry)
+			continue
+		}
+		bits := addr.BitLen()
+		for _, network := range networks {
+			if network.Contains(addr) {
+				bits = network.Bits()
+				break
+			}
+		}
+		out = append(out, netip.PrefixFrom(addr, bits).String())
+	}
+	return strings.Join(out, ", ")
 }
 
 // SitePeer describes one site's WireGuard server for multi-site client configs.
@@ -850,17 +2378,18 @@ type SitePeer struct {
 // GenerateMultiSiteClientConfig generates a client config with one [Peer]
 // block per site. Each peer gets its own AllowedIPs (the site's VPN range).
 // Used for site-to-site topologies where the client can reach both sites.
+// As with GenerateClientConfig, an empty dns omits the DNS line.
 func GenerateMultiSiteClientConfig(clientPrivateKey, clientIP, dns string, sites []SitePeer) string {
-	clientIPForAddress := clientIP
-	if !strings.Contains(clientIP, "/") {
-		clientIPForAddress = clientIP + "/24"
-	} else {
-		clientIPForAddress = strings.TrimSuffix(clientIPForAddress, "/32") + "/24"
+	ranges := make([]string, 0, len(sites))
+	for _, site := range sites {
+		ranges = append(ranges, site.AllowedIPs)
 	}
 
 	var b strings.Builder
-	fmt.Fprintf(&b, "[Interface]\nPrivateKey = %s\nAddress = %s\nDNS = %s\n",
-		clientPrivateKey, clientIPForAddress, dns)
+	b.WriteString("[Interface]\n")
+	writeField(&b, "PrivateKey", clientPrivateKey)
+	writeField(&b, "Address", clientAddress(clientIP, strings.Join(ranges, ", ")))
+	writeField(&b, "DNS", dns)
 
 	for _, site := range sites {
 		fmt.Fprintf(&b, "\n[Peer]\nPublicKey = %s\nEndpoint = %s\nAllowedIPs = %s\nPersistentKeepalive = 25\n",
@@ -893,14 +2422,19 @@ func ValidatePublicKey(key string) bool {
 	return matched
 }
 
+// ValidatePresharedKey reports whether key is a well-formed PresharedKey.
+// PSKs are 32 random bytes, base64-encoded exactly like public keys.
+func ValidatePresharedKey(key string) bool {
+	return ValidatePublicKey(key)
+}
+
 // GetInterfaceStatus returns live interface status from wg show
 func (w *WGConfig) GetInterfaceStatus() InterfaceStatus {
 	status := InterfaceStatus{
 		Peers: make(map[string]PeerStatus),
 	}
 
-	cmd := exec.Command("wg", "show", w.iface)
-	out, err := cmd.Output()
+	out, err := w.runner.Output(context.Background(), "wg", "show", w.iface)
 	if err != nil {
 		return status
 	}
@@ -1,10 +1,18 @@
 package wireguard
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/iodesystems/homelab-horizon/internal/system"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -17,6 +25,36 @@ func TestNewConfig(t *testing.T) {
 	}
 }
 
+func TestNewConfigOptions(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	fs.AddFile("/etc/wireguard/wg0.conf", []byte("[Interface]\nAddress = 10.100.0.1/24\nListenPort = 51820\n"))
+	runner := system.NewDryRunCommandRunner()
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0", WithFileSystem(fs), WithCommandRunner(runner))
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() through the FileSystem error = %v", err)
+	}
+	if cfg.GetAddress() != "10.100.0.1/24" {
+		t.Errorf("GetAddress() = %q", cfg.GetAddress())
+	}
+
+	cfg.mtu = 1420
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got := string(fs.GetWrittenFiles()["/etc/wireguard/wg0.conf"]); !strings.Contains(got, "MTU = 1420") {
+		t.Errorf("Save() did not write through the FileSystem, got %q", got)
+	}
+
+	cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if err := cfg.InterfaceUp(); err != nil {
+		t.Fatalf("InterfaceUp() error = %v", err)
+	}
+	if runner.CallCount("wg show wg0") != 1 || runner.CountMatching(`systemd-run .* wg-quick up wg0`) != 1 {
+		t.Errorf("commands bypassed the runner: %v", runner.GetRunCommands())
+	}
+}
+
 func TestLoad(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "wg0.conf")
@@ -56,8 +94,8 @@ AllowedIPs = 10.100.0.3/32
 	if cfg.address != "10.100.0.1/24" {
 		t.Errorf("Expected address 10.100.0.1/24, got %s", cfg.address)
 	}
-	if cfg.listenPort != "51820" {
-		t.Errorf("Expected listenPort 51820, got %s", cfg.listenPort)
+	if cfg.GetListenPort() != 51820 {
+		t.Errorf("Expected listenPort 51820, got %d", cfg.GetListenPort())
 	}
 
 	peers := cfg.GetPeers()
@@ -71,8 +109,8 @@ AllowedIPs = 10.100.0.3/32
 	if peers[0].PublicKey != "YWxpY2VrZXk=" {
 		t.Errorf("Expected first peer key YWxpY2VrZXk=, got %s", peers[0].PublicKey)
 	}
-	if peers[0].AllowedIPs != "10.100.0.2/32" {
-		t.Errorf("Expected first peer AllowedIPs 10.100.0.2/32, got %s", peers[0].AllowedIPs)
+	if !slices.Equal(peers[0].AllowedIPs, []string{"10.100.0.2/32"}) {
+		t.Errorf("Expected first peer AllowedIPs [10.100.0.2/32], got %v", peers[0].AllowedIPs)
 	}
 
 	if peers[1].Name != "bob" {
@@ -105,12 +143,39 @@ AllowedIPs = 10.100.0.2/32
 # bob
 PublicKey = Ym9ia2V5
 AllowedIPs = 10.100.0.3/32
+
+[Peer]
+# site
+PublicKey = c2l0ZWtleQ==
+AllowedIPs = 10.100.0.4/32, 192.168.50.0/24, fd00:50::/64
+
+[Peer]
+# printer
+PublicKey = cHJpbnRlcmtleQ==
+AllowedIPs = 192.168.50.9
 `
 
 	os.WriteFile(configPath, []byte(configData), 0600)
 	cfg := NewConfig(configPath, "wg0")
 	cfg.Load()
 
+	for ip, want := range map[string]string{
+		"10.100.0.4":   "site",
+		"192.168.50.1": "site", // inside the routed LAN
+		"fd00:50::abc": "site",
+		"192.168.50.9": "printer", // the more specific entry wins
+		"192.168.51.1": "",
+		"not-an-ip":    "",
+	} {
+		got := ""
+		if p := cfg.GetPeerByIP(ip); p != nil {
+			got = p.Name
+		}
+		if got != want {
+			t.Errorf("GetPeerByIP(%q) = %q, want %q", ip, got, want)
+		}
+	}
+
 	peer := cfg.GetPeerByIP("10.100.0.2")
 	if peer == nil {
 		t.Fatal("Expected to find peer for 10.100.0.2")
@@ -167,6 +232,245 @@ Address = 10.100.0.1/24
 	}
 }
 
+func TestInterfaceDNSAndMTU(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+DNS = 10.100.0.1, home.lan
+MTU = 1420
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.GetDNS() != "10.100.0.1, home.lan" {
+		t.Errorf("GetDNS() = %q", cfg.GetDNS())
+	}
+	if cfg.GetMTU() != 1420 {
+		t.Errorf("GetMTU() = %d, want 1420", cfg.GetMTU())
+	}
+
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	for _, want := range []string{"DNS = 10.100.0.1, home.lan\n", "MTU = 1420\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Save() dropped %q:\n%s", want, data)
+		}
+	}
+
+	os.WriteFile(configPath, []byte("[Interface]\nMTU = big\n"), 0600)
+	if err := NewConfig(configPath, "wg0").Load(); err == nil {
+		t.Error("Expected error for non-numeric MTU")
+	}
+}
+
+func TestListenPortValidation(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	for _, port := range []string{"abc", "0", "65536", "-1", "51820x"} {
+		os.WriteFile(configPath, []byte("[Interface]\nListenPort = "+port+"\n"), 0600)
+		if err := NewConfig(configPath, "wg0").Load(); err == nil {
+			t.Errorf("Load() accepted ListenPort %q", port)
+		}
+	}
+
+	os.WriteFile(configPath, []byte("[Interface]\nAddress = 10.100.0.1/24\n"), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.GetListenPort() != 0 {
+		t.Errorf("GetListenPort() = %d, want 0 when unset", cfg.GetListenPort())
+	}
+	if strings.Contains(cfg.Render(), "ListenPort") {
+		t.Errorf("Render() wrote an unset ListenPort:\n%s", cfg.Render())
+	}
+}
+
+func TestMultiplePostUpPostDown(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+ListenPort = 51820
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+PostUp = iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE
+PostDown = iptables -D FORWARD -i %i -j ACCEPT
+PostDown = iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	wantUp := []string{"iptables -A FORWARD -i %i -j ACCEPT", "iptables -t nat -A POSTROUTING -o eth0 -j MASQUERADE"}
+	wantDown := []string{"iptables -D FORWARD -i %i -j ACCEPT", "iptables -t nat -D POSTROUTING -o eth0 -j MASQUERADE"}
+	if got := cfg.GetPostUp(); !slices.Equal(got, wantUp) {
+		t.Errorf("GetPostUp() = %q, want %q", got, wantUp)
+	}
+	if got := cfg.GetPostDown(); !slices.Equal(got, wantDown) {
+		t.Errorf("GetPostDown() = %q, want %q", got, wantDown)
+	}
+
+	// Reloading must not accumulate lines.
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetPostUp(); !slices.Equal(got, wantUp) {
+		t.Errorf("GetPostUp() after reload = %q, want %q", got, wantUp)
+	}
+
+	// Save must write every line back, in order.
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if string(data) != configData {
+		t.Errorf("Save() round trip changed the file:\n%s", data)
+	}
+
+	// UpdateInterfaceRules replaces the whole set in place.
+	if err := cfg.UpdateInterfaceRules([]string{"up-1"}, []string{"down-1", "down-2"}); err != nil {
+		t.Fatalf("UpdateInterfaceRules() error = %v", err)
+	}
+	data, _ = os.ReadFile(configPath)
+	want := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+ListenPort = 51820
+PostUp = up-1
+PostDown = down-1
+PostDown = down-2
+`
+	if string(data) != want {
+		t.Errorf("UpdateInterfaceRules() wrote:\n%s\nwant:\n%s", data, want)
+	}
+	if got := cfg.GetPostDown(); !slices.Equal(got, []string{"down-1", "down-2"}) {
+		t.Errorf("GetPostDown() after update = %q", got)
+	}
+}
+
+func TestRenderPreservesUnknownLines(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	configData := `# managed by homelab-horizon
+[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+ListenPort = 51820
+FwMark = 0x1234
+Table = off
+SaveConfig = false
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+
+[Peer]
+# alice
+# laptop, replaced 2024
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+Endpoint = 203.0.113.5:51820
+UnknownPeerOption = yes
+
+[Peer]
+# bob
+PublicKey = Ym9ia2V5
+AllowedIPs = 10.100.0.3/32
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.Render(); got != configData {
+		t.Errorf("Render() round trip:\n%s\nwant:\n%s", got, configData)
+	}
+
+	// Clearing a field keeps the lines anchored to it, at the end of the
+	// section.
+	cfg.peers[0].Endpoint = ""
+	got := cfg.Render()
+	if !strings.Contains(got, "AllowedIPs = 10.100.0.2/32\nUnknownPeerOption = yes\n") {
+		t.Errorf("unknown peer line lost or misplaced after clearing Endpoint:\n%s", got)
+	}
+	if strings.Contains(got, "Endpoint") {
+		t.Errorf("cleared Endpoint still rendered:\n%s", got)
+	}
+}
+
+func TestRenderKeepsDisabledPeers(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+#disabled# [Peer]
+#disabled# # bob
+#disabled# PublicKey = Ym9ia2V5
+#disabled# AllowedIPs = 10.100.0.3/32
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if n := len(cfg.GetPeers()); n != 1 {
+		t.Fatalf("expected 1 live peer, got %d", n)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if string(data) != configData {
+		t.Errorf("Save() dropped or moved disabled peer:\n%s", data)
+	}
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	if !ValidatePublicKey(priv) || !ValidatePublicKey(pub) {
+		t.Errorf("Generated keys don't pass validation: %q / %q", priv, pub)
+	}
+	if derived, err := PublicKeyFromPrivate(priv); err != nil || derived != pub {
+		t.Errorf("PublicKeyFromPrivate(priv) = %q, %v; want %q", derived, err, pub)
+	}
+
+	priv2, _, _ := GenerateKeyPair()
+	if priv2 == priv {
+		t.Error("Expected distinct private keys from successive calls")
+	}
+}
+
+func TestPublicKeyFromPrivate(t *testing.T) {
+	// RFC 7748 section 6.1 test vector (Alice).
+	priv := "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo="
+	want := "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="
+	if got, err := PublicKeyFromPrivate(priv); err != nil || got != want {
+		t.Errorf("PublicKeyFromPrivate() = %q, %v; want %q", got, err, want)
+	}
+
+	for _, bad := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := PublicKeyFromPrivate(bad); err == nil {
+			t.Errorf("PublicKeyFromPrivate(%q) expected error", bad)
+		}
+	}
+}
+
 func TestValidatePublicKey(t *testing.T) {
 	tests := []struct {
 		key   string
@@ -219,6 +523,100 @@ AllowedIPs = 10.100.0.3/32
 	}
 }
 
+func TestUsage(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+PublicKey = Ym9ia2V5
+AllowedIPs = 10.100.0.3/32
+
+[Peer]
+PublicKey = c2l0ZWtleQ==
+AllowedIPs = 192.168.50.0/24
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	got, err := cfg.Usage("10.100.0.0/24")
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	// .2 through .254 are assignable; the site route is outside the range.
+	want := UsageSummary{PeerCount: 3, UsedAddresses: 2, FreeAddresses: 251, NextIP: "10.100.0.4/32"}
+	if got != want {
+		t.Errorf("Usage() = %+v, want %+v", got, want)
+	}
+
+	got, err = cfg.Usage("10.100.0.0/30")
+	if err != nil {
+		t.Fatalf("Usage(/30) error = %v", err)
+	}
+	want = UsageSummary{PeerCount: 3, UsedAddresses: 1, FreeAddresses: 0, NextIP: ""}
+	if got != want {
+		t.Errorf("Usage(/30) = %+v, want %+v", got, want)
+	}
+
+	if _, err := cfg.Usage("not-a-cidr"); err == nil {
+		t.Error("Usage() with invalid range should fail")
+	}
+}
+
+func TestGetNextIPSkipsRoutedSubnets(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	// The site peer routes 10.100.0.4/30 inside the VPN range.
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+PublicKey = c2l0ZWtleQ==
+AllowedIPs = 10.100.0.3/32, 10.100.0.4/30
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	nextIP, err := cfg.GetNextIP("10.100.0.0/24")
+	if err != nil {
+		t.Fatalf("GetNextIP() error = %v", err)
+	}
+	if nextIP != "10.100.0.8/32" {
+		t.Errorf("GetNextIP() = %s, want 10.100.0.8/32", nextIP)
+	}
+
+	got, err := cfg.Usage("10.100.0.0/24")
+	if err != nil {
+		t.Fatalf("Usage() error = %v", err)
+	}
+	want := UsageSummary{PeerCount: 2, UsedAddresses: 6, FreeAddresses: 247, NextIP: "10.100.0.8/32"}
+	if got != want {
+		t.Errorf("Usage() = %+v, want %+v", got, want)
+	}
+
+	// A range the routed subnet covers entirely has nothing left.
+	if _, err := cfg.GetNextIP("10.100.0.4/30"); !errors.Is(err, ErrRangeExhausted) {
+		t.Errorf("GetNextIP(10.100.0.4/30) error = %v, want ErrRangeExhausted", err)
+	}
+}
+
 func TestGetNextIPEmpty(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "wg0.conf")
@@ -243,13 +641,199 @@ Address = 10.100.0.1/24
 
 func TestSystemStatus(t *testing.T) {
 	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
-	status := cfg.CheckSystem("10.100.0.0/24")
+	status := cfg.CheckSystem(context.Background(), "10.100.0.0/24")
 
 	t.Logf("InterfaceUp: %v", status.InterfaceUp)
 	t.Logf("IPForwarding: %v", status.IPForwarding)
 	t.Logf("Masquerading: %v", status.Masquerading)
 }
 
+func TestCheckSystemInterfaceAddrs(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddOutput("ip addr show wg0", []byte(`4: wg0: <POINTOPOINT,NOARP,UP,LOWER_UP> mtu 1420 qdisc noqueue state UNKNOWN group default qlen 1000
+    link/none
+    inet 10.100.0.1/24 scope global wg0
+       valid_lft forever preferred_lft forever
+    inet6 fd00:100::1/64 scope global
+       valid_lft forever preferred_lft forever
+`))
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+
+	status := cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if !status.InterfaceExists {
+		t.Error("Expected InterfaceExists when ip addr show succeeds")
+	}
+	want := []string{"10.100.0.1/24", "fd00:100::1/64"}
+	if len(status.InterfaceAddrs) != 2 || status.InterfaceAddrs[0] != want[0] || status.InterfaceAddrs[1] != want[1] {
+		t.Errorf("InterfaceAddrs = %v, want %v", status.InterfaceAddrs, want)
+	}
+
+	runner.AddError("ip addr show wg1", errors.New(`Device "wg1" does not exist.`))
+	missing := NewConfig("/etc/wireguard/wg1.conf", "wg1")
+	missing.runner = runner
+	if status := missing.CheckSystem(context.Background(), "10.100.0.0/24"); status.InterfaceExists || status.InterfaceAddrs != nil {
+		t.Errorf("Expected missing interface, got exists=%v addrs=%v", status.InterfaceExists, status.InterfaceAddrs)
+	}
+}
+
+func TestCheckSystemRules(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("iptables -t filter -C FORWARD -o wg0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		errors.New("exit status 1"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+	rules := cfg.checkRules(context.Background(), ExpectedRules("wg0", "eth0"))
+
+	want := map[string]bool{
+		"-t filter FORWARD -i wg0 -j WG-FORWARD":                                        true,
+		"-t filter FORWARD -o wg0 -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT": false,
+		"-t nat POSTROUTING -o eth0 -j MASQUERADE":                                      true,
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("Expected %d rules, got %v", len(want), rules)
+	}
+	for _, r := range rules {
+		present, ok := want[r.String()]
+		if !ok {
+			t.Errorf("Unexpected rule %s", r)
+		} else if r.Present != present {
+			t.Errorf("%s: Present = %v, want %v", r, r.Present, present)
+		}
+	}
+
+	if rules := ExpectedRules("wg0", ""); len(rules) != 2 {
+		t.Errorf("Expected MASQUERADE rule to be omitted without an out iface, got %v", rules)
+	}
+
+	status := cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if len(status.Rules) < 2 {
+		t.Errorf("Expected CheckSystem to report rule checks, got %v", status.Rules)
+	}
+}
+
+func TestCheckSystemUsesRunnerAndFS(t *testing.T) {
+	runner := system.NewDryRunCommandRunner()
+	runner.AddError("wg show wg0", errors.New("Unable to access interface"))
+	fs := system.NewDryRunFileSystem()
+	fs.AddFile("/proc/sys/net/ipv4/ip_forward", []byte("1\n"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.runner = runner
+	cfg.fs = fs
+
+	status := cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if status.InterfaceUp || status.InterfaceError == "" {
+		t.Errorf("Expected the runner's wg show failure, got up=%v err=%q", status.InterfaceUp, status.InterfaceError)
+	}
+	if !status.IPForwarding {
+		t.Errorf("Expected ip_forward from the FileSystem, got %q", status.ForwardingError)
+	}
+	if !status.Masquerading {
+		t.Error("Expected the dry-run iptables -C to count as present")
+	}
+	if runner.CountMatching(`iptables -t nat -C POSTROUTING .*`) == 0 {
+		t.Error("Expected iptables checks to go through the runner")
+	}
+}
+
+func TestCheckSystemIPv6(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	fs.AddFile("/proc/sys/net/ipv4/ip_forward", []byte("1\n"))
+	fs.AddFile("/proc/sys/net/ipv6/conf/all/forwarding", []byte("0\n"))
+
+	runner := system.NewDryRunCommandRunner()
+	runner.AddErrorMatch(`ip6tables -t nat -C POSTROUTING -o .* -j MASQUERADE`, errors.New("exit status 1"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0", WithFileSystem(fs), WithCommandRunner(runner))
+	cfg.address = "10.100.0.1/24, fd00:100::1/64"
+
+	status := cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if !status.DualStack {
+		t.Error("Expected DualStack for an interface with an IPv6 address")
+	}
+	if !status.IPForwarding || status.IPv6Forwarding || status.IPv6ForwardingError == "" {
+		t.Errorf("Expected IPv4 forwarding on and IPv6 off, got v4=%v v6=%v (%q)",
+			status.IPForwarding, status.IPv6Forwarding, status.IPv6ForwardingError)
+	}
+	if !status.Masquerading || !status.IPv6Masquerading {
+		t.Errorf("Expected both families masqueraded (v6 via the -s prefix), got v4=%v v6=%v", status.Masquerading, status.IPv6Masquerading)
+	}
+	if runner.CallCount("ip6tables -t nat -C POSTROUTING -s fd00:100::/64 -j MASQUERADE") != 1 {
+		t.Errorf("Expected the IPv6 legacy check against the masked prefix, ran %v", runner.GetRunCommands())
+	}
+
+	runner.AddErrorMatch(`ip6tables .*`, errors.New("exit status 1"))
+	cfg.address = "10.100.0.1/24"
+	status = cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+	if status.DualStack || status.IPv6Masquerading || status.IPv6MasqError == "" {
+		t.Errorf("IPv4-only: DualStack=%v IPv6Masquerading=%v err=%q", status.DualStack, status.IPv6Masquerading, status.IPv6MasqError)
+	}
+	if !status.Masquerading {
+		t.Error("IPv4 masquerading should be unaffected by ip6tables")
+	}
+}
+
+func TestGenerateClientConfig(t *testing.T) {
+	cfg := GenerateClientConfig("client-privkey", "10.100.0.7", "10.100.0.0/24", "server-pubkey",
+		"vpn.example.com:51820", "10.100.0.1", "10.100.0.0/24, 192.168.1.0/24")
+
+	want := `[Interface]
+PrivateKey = client-privkey
+Address = 10.100.0.7/24
+DNS = 10.100.0.1
+
+[Peer]
+PublicKey = server-pubkey
+Endpoint = vpn.example.com:51820
+AllowedIPs = 10.100.0.0/24, 192.168.1.0/24
+PersistentKeepalive = 25
+`
+	if cfg != want {
+		t.Errorf("GenerateClientConfig() =\n%s\nwant\n%s", cfg, want)
+	}
+
+	// The generated file must load back as a valid wg config.
+	path := filepath.Join(t.TempDir(), "client.conf")
+	os.WriteFile(path, []byte(cfg), 0600)
+	wg := NewConfig(path, "client")
+	if err := wg.Load(); err != nil {
+		t.Fatalf("Load(client config) error = %v", err)
+	}
+	if peers := wg.GetPeers(); len(peers) != 1 || peers[0].PublicKey != "server-pubkey" {
+		t.Errorf("Expected one server peer, got %+v", peers)
+	}
+
+	noDNS := GenerateClientConfig("k", "10.100.0.7/32", "10.100.0.0/16", "s", "e:1", "", "0.0.0.0/0")
+	if strings.Contains(noDNS, "DNS") {
+		t.Errorf("Expected no DNS line when dns is empty:\n%s", noDNS)
+	}
+	if !strings.Contains(noDNS, "Address = 10.100.0.7/16") {
+		t.Errorf("Expected /32 client IP to be widened to the /16 VPN range:\n%s", noDNS)
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		clientIP, ranges, want string
+	}{
+		{"10.100.0.7", "10.100.0.0/24", "10.100.0.7/24"},
+		{"10.100.0.7/32", "10.100.0.0/22", "10.100.0.7/22"},
+		{"fd00:100::7/128", "fd00:100::/64", "fd00:100::7/64"},
+		{"10.100.0.7, fd00:100::7", "fd00:100::/64, 10.100.0.0/24", "10.100.0.7/24, fd00:100::7/64"},
+		{"10.100.0.7, fd00:100::7", "10.100.0.0/24", "10.100.0.7/24, fd00:100::7/128"},
+		{"10.200.0.7", "10.100.0.0/24", "10.200.0.7/32"},
+		{"10.0.2.5", "10.0.1.0/24, 10.0.2.0/25", "10.0.2.5/25"},
+		{"not-an-ip", "10.100.0.0/24", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := clientAddress(tt.clientIP, tt.ranges); got != tt.want {
+			t.Errorf("clientAddress(%q, %q) = %q, want %q", tt.clientIP, tt.ranges, got, tt.want)
+		}
+	}
+}
+
 func TestGenerateMultiSiteClientConfig(t *testing.T) {
 	sites := []SitePeer{
 		{PublicKey: "site-a-pubkey", Endpoint: "a.example.com:51820", AllowedIPs: "10.0.1.0/24"},
@@ -280,4 +864,1363 @@ func TestGenerateMultiSiteClientConfig(t *testing.T) {
 	if !strings.Contains(cfg, "10.0.1.2/24") {
 		t.Error("client address not formatted correctly")
 	}
+	if !strings.Contains(cfg, "DNS = 10.0.1.1\n") {
+		t.Error("missing DNS line")
+	}
+
+	noDNS := GenerateMultiSiteClientConfig("client-privkey", "10.0.1.2", "", sites)
+	if strings.Contains(noDNS, "DNS") {
+		t.Errorf("Expected no DNS line for empty dns, got:\n%s", noDNS)
+	}
+}
+
+func TestRemovePeer(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+# bob
+PublicKey = Ym9ia2V5
+AllowedIPs = 10.100.0.3/32
+
+[Peer]
+# carol
+PublicKey = Y2Fyb2xrZXk=
+AllowedIPs = 10.100.0.4/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	// By public key
+	n, err := cfg.RemovePeer("YWxpY2VrZXk=")
+	if err != nil {
+		t.Fatalf("RemovePeer(key) error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("RemovePeer(key) removed %d, want 1", n)
+	}
+
+	// By name
+	n, err = cfg.RemovePeer("bob")
+	if err != nil {
+		t.Fatalf("RemovePeer(name) error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("RemovePeer(name) removed %d, want 1", n)
+	}
+
+	if _, err := cfg.RemovePeer("nobody"); err == nil {
+		t.Error("Expected error removing nonexistent peer")
+	}
+
+	// An unnamed peer has an empty name, so "" must not be taken as a match.
+	unnamed := NewConfig(filepath.Join(tmpDir, "wg1.conf"), "wg1")
+	os.WriteFile(filepath.Join(tmpDir, "wg1.conf"), []byte("[Interface]\n\n[Peer]\nPublicKey = YWxpY2VrZXk=\nAllowedIPs = 10.100.0.2/32\n"), 0600)
+	unnamed.Load()
+	if n, err := unnamed.RemovePeer(""); err == nil || n != 0 || len(unnamed.GetPeers()) != 1 {
+		t.Errorf("RemovePeer(\"\") = %d, %v; want an error and nothing removed", n, err)
+	}
+
+	peers := cfg.GetPeers()
+	if len(peers) != 1 || peers[0].Name != "carol" {
+		t.Fatalf("Expected only carol to remain, got %+v", peers)
+	}
+
+	data, _ := os.ReadFile(configPath)
+	want := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# carol
+PublicKey = Y2Fyb2xrZXk=
+AllowedIPs = 10.100.0.4/32
+`
+	if string(data) != want {
+		t.Errorf("config after RemovePeer =\n%s\nwant\n%s", data, want)
+	}
+
+	// Reloading from disk must agree with in-memory state
+	reloaded := NewConfig(configPath, "wg0")
+	reloaded.Load()
+	if got := reloaded.GetPeers(); len(got) != 1 || got[0].PublicKey != "Y2Fyb2xrZXk=" {
+		t.Errorf("reloaded peers = %+v", got)
+	}
+}
+
+func TestDisablePeers(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+# bob
+PublicKey = Ym9ia2V5
+AllowedIPs = 10.100.0.3/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	cfg.mu.Lock()
+	err := cfg.disablePeers(map[string]bool{"YWxpY2VrZXk=": true})
+	cfg.mu.Unlock()
+	if err != nil {
+		t.Fatalf("disablePeers() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(data), disabledPrefix+"PublicKey = YWxpY2VrZXk=") {
+		t.Errorf("Expected alice to be commented out, got:\n%s", data)
+	}
+
+	reloaded := NewConfig(configPath, "wg0")
+	reloaded.Load()
+	peers := reloaded.GetPeers()
+	if len(peers) != 1 || peers[0].Name != "bob" {
+		t.Fatalf("Expected only bob after reload, got %+v", peers)
+	}
+
+	// Removing a neighbouring peer must leave the disabled block intact.
+	if _, err := reloaded.RemovePeer("bob"); err != nil {
+		t.Fatalf("RemovePeer() error = %v", err)
+	}
+	data, _ = os.ReadFile(configPath)
+	if !strings.Contains(string(data), disabledPrefix+"# alice") {
+		t.Errorf("Disabled block lost after RemovePeer:\n%s", data)
+	}
+}
+
+func TestDiffOutOfBandEdit(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	diff, err := cfg.Diff()
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if diff != "" {
+		t.Errorf("Expected no diff for unchanged config, got:\n%s", diff)
+	}
+
+	// Simulate an out-of-band edit: the in-memory peer set now differs.
+	os.WriteFile(configPath, []byte(configData+"\n[Peer]\n# bob\nPublicKey = Ym9ia2V5\nAllowedIPs = 10.100.0.3/32\n"), 0600)
+
+	diff, err = cfg.Diff()
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	for _, want := range []string{"-[Peer]", "-# bob", "-PublicKey = Ym9ia2V5", "-AllowedIPs = 10.100.0.3/32"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "-# alice") || strings.Contains(diff, "+# alice") {
+		t.Errorf("diff should not touch unchanged peer:\n%s", diff)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	// Spacing render would normalize must not show up in the diff.
+	configData := `[Interface]
+PrivateKey=cGFzc3dvcmQ=
+Address   = 10.100.0.1/24
+
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	diff, err := cfg.Diff()
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if diff != "" {
+		t.Errorf("Expected no diff for unchanged config, got:\n%s", diff)
+	}
+
+	cfg.peers = append(cfg.peers, Peer{Name: "bob", PublicKey: "Ym9ia2V5", AllowedIPs: []string{"10.100.0.3/32"}})
+	diff, err = cfg.Diff()
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	for _, want := range []string{"+[Peer]", "+# bob", "+PublicKey = Ym9ia2V5", "+AllowedIPs = 10.100.0.3/32"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "-# alice") || strings.Contains(diff, "+# alice") {
+		t.Errorf("diff should not touch unchanged peer:\n%s", diff)
+	}
+
+	os.Remove(configPath)
+	diff, err = cfg.Diff()
+	if err != nil {
+		t.Fatalf("Diff() with missing file error = %v", err)
+	}
+	if !strings.Contains(diff, "+[Interface]") {
+		t.Errorf("missing file should diff as empty, got:\n%s", diff)
+	}
+}
+
+func TestLoadPeerOptionalFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# site-b
+PublicKey = YWxpY2VrZXk=
+PresharedKey = YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=
+AllowedIPs = 10.100.0.2/32
+Endpoint = b.example.com:51820
+PersistentKeepalive = 25
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	peer := cfg.GetPeerByPublicKey("YWxpY2VrZXk=")
+	if peer == nil {
+		t.Fatal("Expected to find peer")
+	}
+	if peer.PresharedKey != "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY=" {
+		t.Errorf("PresharedKey = %q", peer.PresharedKey)
+	}
+	if peer.Endpoint != "b.example.com:51820" {
+		t.Errorf("Endpoint = %q", peer.Endpoint)
+	}
+	if peer.PersistentKeepalive != 25 {
+		t.Errorf("PersistentKeepalive = %d, want 25", peer.PersistentKeepalive)
+	}
+
+	if got := cfg.Render(); got != configData {
+		t.Errorf("Render() round-trip =\n%s\nwant\n%s", got, configData)
+	}
+}
+
+func TestLoadInvalidPresharedKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+PresharedKey = not-a-key
+AllowedIPs = 10.100.0.2/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	err := cfg.Load()
+	if err == nil {
+		t.Fatal("Expected error for invalid PresharedKey")
+	}
+	if !strings.Contains(err.Error(), "alice") {
+		t.Errorf("Expected error to name the peer, got %v", err)
+	}
+}
+
+func TestDualStackPeers(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24, fd00::1/64
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32, fd00::2/128
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	peers := cfg.GetPeers()
+	if got := peers[0].AllowedIPs; !slices.Equal(got, []string{"10.100.0.2/32", "fd00::2/128"}) {
+		t.Errorf("AllowedIPs = %v", got)
+	}
+	peers[0].AllowedIPs[0] = "10.100.0.99/32"
+	if got := cfg.GetPeers()[0].AllowedIPs[0]; got != "10.100.0.2/32" {
+		t.Errorf("Expected GetPeers to return a copy, config now has %s", got)
+	}
+
+	for _, ip := range []string{"10.100.0.2", "fd00::2", "fd00:0::2"} {
+		if p := cfg.GetPeerByIP(ip); p == nil || p.Name != "alice" {
+			t.Errorf("GetPeerByIP(%q) = %v, want alice", ip, p)
+		}
+	}
+
+	v4, err := cfg.GetNextIP("10.100.0.0/24")
+	if err != nil || v4 != "10.100.0.3/32" {
+		t.Errorf("GetNextIP(v4) = %s, %v; want 10.100.0.3/32", v4, err)
+	}
+	v6, err := cfg.GetNextIP("fd00::/64")
+	if err != nil || v6 != "fd00::3/128" {
+		t.Errorf("GetNextIP(v6) = %s, %v; want fd00::3/128", v6, err)
+	}
+
+	if err := cfg.AddPeer("bob", "Ym9ia2V5", "10.100.0.3/32, fd00::2/128"); err == nil {
+		t.Error("Expected AddPeer to reject an IPv6 address already in use")
+	}
+}
+
+func TestGetNextIPReservesGatewayAndDetectsExhaustion(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	// No Address line: the gateway must still be skipped.
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	nextIP, err := cfg.GetNextIP("10.100.0.0/30")
+	if err != nil {
+		t.Fatalf("GetNextIP() error = %v", err)
+	}
+	if nextIP != "10.100.0.2/32" {
+		t.Errorf("GetNextIP() = %s, want 10.100.0.2/32", nextIP)
+	}
+
+	if err := cfg.AddPeer("alice", "YWxpY2VrZXk=", nextIP); err != nil {
+		t.Fatalf("AddPeer() error = %v", err)
+	}
+
+	_, err = cfg.GetNextIP("10.100.0.0/30")
+	if !errors.Is(err, ErrRangeExhausted) {
+		t.Errorf("GetNextIP() on full /30 error = %v, want ErrRangeExhausted", err)
+	}
+
+	for _, r := range []string{"10.100.0.0/31", "10.100.0.1/32"} {
+		if _, err := cfg.GetNextIP(r); !errors.Is(err, ErrRangeExhausted) {
+			t.Errorf("GetNextIP(%s) error = %v, want ErrRangeExhausted", r, err)
+		}
+	}
+}
+
+func TestGetNextIPLargeRange(t *testing.T) {
+	cfg := NewConfig("/nonexistent/wg0.conf", "wg0")
+	cfg.address = "10.0.0.1/16"
+	for i := 2; i < 5002; i++ {
+		cfg.peers = append(cfg.peers, Peer{
+			AllowedIPs: []string{fmt.Sprintf("10.0.%d.%d/32", i/256, i%256)},
+		})
+	}
+
+	start := time.Now()
+	nextIP, err := cfg.GetNextIP("10.0.0.0/16")
+	if err != nil {
+		t.Fatalf("GetNextIP() error = %v", err)
+	}
+	if nextIP != "10.0.19.138/32" { // offset 5002
+		t.Errorf("GetNextIP() = %s, want 10.0.19.138/32", nextIP)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("GetNextIP() on /16 took %v", d)
+	}
+
+	// A /8 with no peers is just as cheap.
+	nextIP, err = cfg.GetNextIP("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("GetNextIP(/8) error = %v", err)
+	}
+	if nextIP != "10.0.19.138/32" {
+		t.Errorf("GetNextIP(/8) = %s, want 10.0.19.138/32", nextIP)
+	}
+
+	// Peers outside the range don't count against it.
+	nextIP, err = cfg.GetNextIP("172.16.0.0/12")
+	if err != nil || nextIP != "172.16.0.2/32" {
+		t.Errorf("GetNextIP(172.16/12) = %s, %v; want 172.16.0.2/32", nextIP, err)
+	}
+}
+
+func TestGetPeerByPublicKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+
+	peer := cfg.GetPeerByPublicKey("YWxpY2VrZXk=")
+	if peer == nil {
+		t.Fatal("Expected to find peer by public key")
+	}
+	if peer.Name != "alice" || !slices.Equal(peer.AllowedIPs, []string{"10.100.0.2/32"}) {
+		t.Errorf("GetPeerByPublicKey() = %+v", peer)
+	}
+
+	// The returned peer is a copy; mutating it must not touch the config.
+	peer.Name = "mallory"
+	if got := cfg.GetPeerByPublicKey("YWxpY2VrZXk="); got.Name != "alice" {
+		t.Errorf("GetPeerByPublicKey() leaked internal state, name = %s", got.Name)
+	}
+
+	if cfg.GetPeerByPublicKey("Ym9ia2V5") != nil {
+		t.Error("Expected nil for unknown public key")
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "wg0.conf")
+
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+
+	cfg := NewConfig(configPath, "wg0")
+	cfg.Load()
+	if err := cfg.AddPeer("bob", "Ym9ia2V5", "10.100.0.3/32"); err != nil {
+		t.Fatalf("AddPeer() error = %v", err)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// Backups (wg0.conf.*.bak) are expected; leftover temp files are not.
+	entries, _ := os.ReadDir(tmpDir)
+	var names []string
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".bak") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) != 1 || names[0] != "wg0.conf" {
+		t.Errorf("Expected only wg0.conf after Save, got %v", names)
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(configPath)
+	if string(data) != cfg.Render() {
+		t.Errorf("Saved file doesn't match Render():\n%s", data)
+	}
+}
+
+// noWGRunner is a dry-run runner on a host without the wg binary.
+type noWGRunner struct{ *system.DryRunCommandRunner }
+
+func (r noWGRunner) LookPath(file string) (string, error) {
+	return "", fmt.Errorf("%s: %w", file, exec.ErrNotFound)
+}
+
+func TestApply(t *testing.T) {
+	// A path with a space and shell metacharacters must reach wg-quick as is.
+	dir := filepath.Join(t.TempDir(), "wg conf;$(x)")
+	os.MkdirAll(dir, 0700)
+	configPath := filepath.Join(dir, "wg0.conf")
+	os.WriteFile(configPath, []byte("[Interface]\nPrivateKey = cGFzc3dvcmQ=\nAddress = 10.100.0.1/24\n"), 0600)
+
+	syncArgs := []string{"systemd-run", "--pipe", "--wait", "--service-type=oneshot",
+		"bash", "-c", `wg syncconf "$1" <(wg-quick strip "$2")`, "bash", "wg0", configPath}
+	syncCmd := strings.Join(syncArgs, " ")
+	restartCmd := "systemd-run --pipe --wait --service-type=oneshot bash -c wg-quick down wg0; wg-quick up wg0"
+
+	newConfig := func(runner system.CommandRunner) *WGConfig {
+		cfg := NewConfig(configPath, "wg0")
+		cfg.runner = runner
+		if err := cfg.Load(); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		return cfg
+	}
+
+	t.Run("syncconf", func(t *testing.T) {
+		runner := system.NewDryRunCommandRunner()
+		cfg := newConfig(runner)
+		if err := cfg.AddPeer("alice", "YWxpY2VrZXk=", "10.100.0.2/32"); err != nil {
+			t.Fatalf("AddPeer() error = %v", err)
+		}
+		runner.Clear()
+
+		if err := cfg.Apply(context.Background()); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		if data, _ := os.ReadFile(configPath); !strings.Contains(string(data), "YWxpY2VrZXk=") {
+			t.Error("Expected Apply to write the config before syncing")
+		}
+		cmds := runner.GetRunCommandArgs()
+		if len(cmds) != 2 || !slices.Equal(cmds[1], syncArgs) {
+			t.Errorf("Expected only syncconf, got %q", cmds)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		runner := system.NewDryRunCommandRunner()
+		cfg := newConfig(runner)
+		cfg.mtu = 1280 // in memory only; Reload must not write it
+
+		if err := cfg.Reload(); err != nil {
+			t.Fatalf("Reload() error = %v", err)
+		}
+		if data, _ := os.ReadFile(configPath); strings.Contains(string(data), "MTU") {
+			t.Error("Expected Reload to leave the file alone")
+		}
+		if cmds := runner.GetRunCommands(); len(cmds) != 2 || cmds[1] != syncCmd {
+			t.Errorf("Expected Reload to share Apply's syncconf, got %v", cmds)
+		}
+	})
+
+	t.Run("syncconf failure does not restart", func(t *testing.T) {
+		runner := system.NewDryRunCommandRunner()
+		runner.AddError(syncCmd, errors.New("exit status 1"))
+		cfg := newConfig(runner)
+
+		if err := cfg.Apply(context.Background()); err == nil {
+			t.Fatal("Expected syncconf error to be returned")
+		}
+		for _, cmd := range runner.GetRunCommands() {
+			if cmd == restartCmd {
+				t.Error("Expected no down/up after a syncconf failure")
+			}
+		}
+	})
+
+	t.Run("fallback without wg", func(t *testing.T) {
+		runner := noWGRunner{system.NewDryRunCommandRunner()}
+		cfg := newConfig(runner)
+
+		if err := cfg.Apply(context.Background()); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		cmds := runner.GetRunCommands()
+		if len(cmds) != 1 || cmds[0] != restartCmd {
+			t.Errorf("Expected wg-quick down/up fallback, got %v", cmds)
+		}
+	})
+}
+
+func TestValidatePeerRoutes(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	configData := `[Interface]
+PrivateKey = cGFzc3dvcmQ=
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+# site-b
+PublicKey = c2l0ZWJrZXk=
+AllowedIPs = 10.100.0.3/32, 192.168.50.0/24
+
+[Peer]
+# lan-gateway
+PublicKey = bGFuZ3drZXk=
+AllowedIPs = 192.168.0.0/16
+
+[Peer]
+# typo
+PublicKey = dHlwb2tleQ==
+AllowedIPs = 10.100.0.300/32
+`
+	os.WriteFile(configPath, []byte(configData), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	err := cfg.ValidatePeerRoutes()
+	if err == nil {
+		t.Fatal("Expected overlap and parse errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "site-b (192.168.50.0/24) and lan-gateway (192.168.0.0/16)") {
+		t.Errorf("Expected site-b/lan-gateway overlap, got: %v", msg)
+	}
+	if !strings.Contains(msg, `typo: invalid AllowedIPs entry "10.100.0.300/32"`) {
+		t.Errorf("Expected invalid entry to be reported, got: %v", msg)
+	}
+	if strings.Contains(msg, "alice") {
+		t.Errorf("alice doesn't overlap anyone, got: %v", msg)
+	}
+
+	// A /24 that swallows alice's /32 is refused.
+	if err := cfg.AddPeer("greedy", "Z3JlZWR5a2V5", "10.100.0.0/24"); err == nil {
+		t.Error("Expected AddPeer to reject a range overlapping an existing peer")
+	}
+	if err := cfg.AddPeer("bad", "YmFka2V5", "not-an-ip"); err == nil {
+		t.Error("Expected AddPeer to reject an invalid AllowedIPs entry")
+	}
+	if err := cfg.AddPeer("bob", "Ym9ia2V5", "10.100.0.4/32"); err != nil {
+		t.Errorf("AddPeer() with a free /32 error = %v", err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte("[Interface]\nPrivateKey = cGFzc3dvcmQ=\nAddress = 10.100.0.1/24\n"), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	const n = 20
+	done := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			pub, _, _ := GenerateKeyPair()
+			done <- cfg.AddPeer(fmt.Sprintf("peer%d", i), pub, fmt.Sprintf("10.100.0.%d/32", i+2))
+		}(i)
+		go func() {
+			peers := cfg.GetPeers()
+			for j := range peers {
+				peers[j].Name = "mutated"
+			}
+			cfg.GetPeerByIP("10.100.0.2")
+			cfg.Render()
+			done <- nil
+		}()
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("AddPeer() error = %v", err)
+		}
+	}
+
+	peers := cfg.GetPeers()
+	if len(peers) != n {
+		t.Errorf("Expected %d peers, got %d", n, len(peers))
+	}
+	for _, p := range peers {
+		if p.Name == "mutated" {
+			t.Error("GetPeers() leaked internal state")
+		}
+	}
+
+	reloaded := NewConfig(configPath, "wg0")
+	if err := reloaded.Load(); err != nil || len(reloaded.GetPeers()) != n {
+		t.Errorf("Expected %d peers on disk, got %d (%v)", n, len(reloaded.GetPeers()), err)
+	}
+}
+
+// TestCheckSystemConcurrentReplaceInterface is meant for go test -race:
+// CheckSystem reads the interface Address while ReplaceInterface rewrites it.
+func TestCheckSystemConcurrentReplaceInterface(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	priv, _, _ := GenerateKeyPair()
+	os.WriteFile(configPath, []byte("[Interface]\nPrivateKey = "+priv+"\nAddress = 10.100.0.1/24\n"), 0600)
+	cfg := NewConfig(configPath, "wg0", WithCommandRunner(system.NewDryRunCommandRunner()))
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	const n = 20
+	done := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			iface := cfg.GetInterface()
+			iface.Address = fmt.Sprintf("10.100.0.1/24, fd00:%d::1/64", i)
+			done <- cfg.ReplaceInterface(iface)
+		}(i)
+		go func() {
+			cfg.CheckSystem(context.Background(), "10.100.0.0/24")
+			done <- nil
+		}()
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("ReplaceInterface() error = %v", err)
+		}
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	priv, pub, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	_, otherPub, _ := GenerateKeyPair()
+
+	load := func(t *testing.T, data string) *WGConfig {
+		configPath := filepath.Join(t.TempDir(), "wg0.conf")
+		os.WriteFile(configPath, []byte(data), 0600)
+		cfg := NewConfig(configPath, "wg0")
+		if err := cfg.Load(); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		return cfg
+	}
+
+	ok := load(t, fmt.Sprintf("[Interface]\nPrivateKey = %s\n\n[Peer]\n# alice\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n", priv, otherPub))
+	if err := ok.ValidateKeys(); err != nil {
+		t.Errorf("ValidateKeys() = %v, want nil", err)
+	}
+
+	reused := load(t, fmt.Sprintf("[Interface]\nPrivateKey = %s\n\n[Peer]\n# oops\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n", priv, pub))
+	if err := reused.ValidateKeys(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected error naming the peer with the server key, got %v", err)
+	}
+
+	for _, bad := range []string{"cGFzc3dvcmQ=", "not-base64-at-all-but-exactly-44-chars-long!"} {
+		cfg := load(t, "[Interface]\nPrivateKey = "+bad+"\n")
+		if err := cfg.ValidateKeys(); err == nil {
+			t.Errorf("Expected error for private key %q", bad)
+		}
+	}
+
+	if err := load(t, "[Interface]\nAddress = 10.100.0.1/24\n").ValidateKeys(); err == nil {
+		t.Error("Expected error when no private key is loaded")
+	}
+}
+
+func TestEnableIPForwarding(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	runner := system.NewDryRunCommandRunner()
+	fs.AddFile("/proc/sys/net/ipv4/ip_forward", []byte("0\n"))
+	fs.AddFile("/etc/sysctl.d/99-homelab-horizon.conf", []byte("# stale\n"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.fs = fs
+	cfg.runner = runner
+
+	changed, err := cfg.EnableIPForwarding(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("EnableIPForwarding() = %v, %v; want true, nil", changed, err)
+	}
+	if cmds := runner.GetRunCommands(); len(cmds) != 1 || cmds[0] != "sysctl -w net.ipv4.ip_forward=1" {
+		t.Errorf("Expected a runtime sysctl, got %v", cmds)
+	}
+	if got := string(fs.GetWrittenFiles()["/etc/sysctl.d/99-homelab-horizon.conf"]); got != "net.ipv4.ip_forward=1\n" {
+		t.Errorf("Expected persistent drop-in, got %q", got)
+	}
+
+	// Second run: both halves already in place.
+	fs.AddFile("/proc/sys/net/ipv4/ip_forward", []byte("1\n"))
+	runner.Clear()
+	changed, err = cfg.EnableIPForwarding(context.Background())
+	if err != nil || changed {
+		t.Errorf("Expected idempotent no-op, got %v, %v", changed, err)
+	}
+	if cmds := runner.GetRunCommands(); len(cmds) != 0 {
+		t.Errorf("Expected no commands on a no-op, got %v", cmds)
+	}
+
+	runner.AddError("sysctl -w net.ipv4.ip_forward=1", errors.New("permission denied"))
+	fs.AddFile("/proc/sys/net/ipv4/ip_forward", []byte("0\n"))
+	if _, err := cfg.EnableIPForwarding(context.Background()); err == nil {
+		t.Error("Expected sysctl failure to be returned")
+	}
+}
+
+func TestReplacePeers(t *testing.T) {
+	_, alicePub, _ := GenerateKeyPair()
+	_, bobPub, _ := GenerateKeyPair()
+	_, carolPub, _ := GenerateKeyPair()
+
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = %s
+AllowedIPs = 10.100.0.2/32
+# keep me
+
+[Peer]
+# bob
+PublicKey = %s
+AllowedIPs = 10.100.0.3/32
+`, alicePub, bobPub)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	bad := [][]Peer{
+		{{Name: "x", PublicKey: "not-a-key", AllowedIPs: []string{"10.100.0.9/32"}}},
+		{{Name: "a", PublicKey: carolPub, AllowedIPs: []string{"10.100.0.4/32"}}, {Name: "b", PublicKey: carolPub, AllowedIPs: []string{"10.100.0.5/32"}}},
+		{{Name: "a", PublicKey: alicePub, AllowedIPs: []string{"10.100.0.0/30"}}, {Name: "c", PublicKey: carolPub, AllowedIPs: []string{"10.100.0.2/32"}}},
+		{{Name: "c", PublicKey: carolPub, AllowedIPs: []string{"bogus"}}},
+	}
+	for _, peers := range bad {
+		if err := cfg.ReplacePeers(peers); err == nil {
+			t.Errorf("ReplacePeers(%v) = nil, want error", peers)
+		}
+	}
+	if got := len(cfg.GetPeers()); got != 2 {
+		t.Fatalf("rejected ReplacePeers changed the peer list: %d peers", got)
+	}
+
+	err := cfg.ReplacePeers([]Peer{
+		{Name: "alice", PublicKey: alicePub, AllowedIPs: []string{"10.100.0.2/32"}},
+		{Name: "carol", PublicKey: carolPub, AllowedIPs: []string{"10.100.0.4/32"}, PersistentKeepalive: 25},
+	})
+	if err != nil {
+		t.Fatalf("ReplacePeers() error = %v", err)
+	}
+
+	reloaded := NewConfig(configPath, "wg0")
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	peers := reloaded.GetPeers()
+	if len(peers) != 2 || peers[0].Name != "alice" || peers[1].Name != "carol" || peers[1].PersistentKeepalive != 25 {
+		t.Errorf("reloaded peers = %+v", peers)
+	}
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), bobPub) {
+		t.Errorf("bob still in config:\n%s", data)
+	}
+	if !strings.Contains(string(data), "# keep me") {
+		t.Errorf("alice's unmodeled line was dropped:\n%s", data)
+	}
+}
+
+func TestReplacePeersKeepsLooseBlocks(t *testing.T) {
+	_, alicePub, _ := GenerateKeyPair()
+	_, carolPub, _ := GenerateKeyPair()
+	_, davePub, _ := GenerateKeyPair()
+
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = %s
+AllowedIPs = 10.100.0.2/32
+#disabled# [Peer]
+#disabled# # bob
+#disabled# PublicKey = Ym9ia2V5
+#disabled# AllowedIPs = 10.100.0.3/32
+
+[Peer]
+# carol
+PublicKey = %s
+AllowedIPs = 10.100.0.4/32
+
+# spare addresses: .10-.20
+`, alicePub, carolPub)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := cfg.GetDisabledPeers(); len(got) != 1 || got[0].Name != "bob" || !slices.Equal(got[0].AllowedIPs, []string{"10.100.0.3/32"}) {
+		t.Errorf("GetDisabledPeers() = %+v", got)
+	}
+
+	if err := cfg.ReplacePeers([]Peer{{Name: "dave", PublicKey: davePub, AllowedIPs: []string{"10.100.0.5/32"}}}); err != nil {
+		t.Fatalf("ReplacePeers() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	for _, want := range []string{disabledPrefix + "# bob", disabledPrefix + "PublicKey = Ym9ia2V5", "# spare addresses: .10-.20"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("removing the neighbouring peers dropped %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "# alice") || strings.Contains(string(data), "# carol") {
+		t.Errorf("removed peers still in config:\n%s", data)
+	}
+
+	// The loose blocks survive a reload and another rewrite too.
+	reloaded := NewConfig(configPath, "wg0")
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	if err := reloaded.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	again, _ := os.ReadFile(configPath)
+	if string(again) != string(data) {
+		t.Errorf("Save() after reload changed the file:\n%s\nwant:\n%s", again, data)
+	}
+}
+
+func TestRemovePeerKeepsTrailingComments(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = YWxpY2VrZXk=
+AllowedIPs = 10.100.0.2/32
+# alice's own note
+
+# spare addresses: .10-.20
+`), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if _, err := cfg.RemovePeer("alice"); err != nil {
+		t.Fatalf("RemovePeer() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	if strings.Contains(string(data), "alice's own note") {
+		t.Errorf("the peer's own comment outlived it:\n%s", data)
+	}
+	if !strings.Contains(string(data), "# spare addresses: .10-.20") {
+		t.Errorf("trailing comment removed with the peer above it:\n%s", data)
+	}
+	if !strings.Contains(cfg.Render(), "# spare addresses: .10-.20") {
+		t.Errorf("in-memory state lost the trailing comment:\n%s", cfg.Render())
+	}
+}
+
+func TestDisableInactive(t *testing.T) {
+	alice, bob, carol, dave := testKey(t), testKey(t), testKey(t), testKey(t)
+	conf := fmt.Sprintf("[Interface]\nAddress = 10.100.0.1/24\n\n"+
+		"[Peer]\n# alice\nPublicKey = %s\nAllowedIPs = 10.100.0.2/32\n\n"+
+		"[Peer]\n# bob\nPublicKey = %s\nAllowedIPs = 10.100.0.3/32\n\n"+
+		"[Peer]\n# carol\nPublicKey = %s\nAllowedIPs = 10.100.0.5/32\n\n"+
+		"[Peer]\n# dave\nPublicKey = %s\nAllowedIPs = 10.100.0.6/32\n", alice, bob, carol, dave)
+	// alice handshook a minute ago, bob in 2023, carol never; the
+	// interface doesn't know dave at all.
+	dump := fmt.Sprintf("cHJpdmF0ZWtleQ==\tcHVibGlja2V5\t51820\toff\n"+
+		"%s\t(none)\t203.0.113.5:51820\t10.100.0.2/32\t%d\t1024\t2048\t25\n"+
+		"%s\t(none)\t203.0.113.6:51820\t10.100.0.3/32\t1700000000\t10\t20\toff\n"+
+		"%s\t(none)\t(none)\t10.100.0.5/32\t0\t0\t0\toff\n",
+		alice, time.Now().Add(-time.Minute).Unix(), bob, carol)
+
+	const path = "/etc/wireguard/wg0.conf"
+	setup := func(opts ...Option) (*WGConfig, *system.DryRunFileSystem) {
+		fs := system.NewDryRunFileSystem()
+		fs.AddFile(path, []byte(conf))
+		runner := system.NewDryRunCommandRunner()
+		runner.AddOutput("wg show wg0 dump", []byte(dump))
+		cfg := NewConfig(path, "wg0", append(opts, WithFileSystem(fs), WithCommandRunner(runner))...)
+		if err := cfg.Load(); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		return cfg, fs
+	}
+	names := func(peers []Peer) []string {
+		var out []string
+		for _, p := range peers {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+
+	dry, fs := setup(WithDryRun(true))
+	disabled, err := dry.DisableInactive(context.Background(), 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("dry-run DisableInactive() error = %v", err)
+	}
+	if got := names(disabled); !slices.Equal(got, []string{"bob", "carol"}) {
+		t.Errorf("dry-run DisableInactive() = %v, want [bob carol]", got)
+	}
+	if data, _ := fs.ReadFile(path); string(data) != conf || len(dry.GetPeers()) != 4 {
+		t.Errorf("dry run changed the config:\n%s", data)
+	}
+
+	cfg, fs := setup()
+	disabled, err = cfg.DisableInactive(context.Background(), 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("DisableInactive() error = %v", err)
+	}
+	if got := names(disabled); !slices.Equal(got, []string{"bob", "carol"}) {
+		t.Errorf("DisableInactive() = %v, want [bob carol]", got)
+	}
+	if got := names(cfg.GetPeers()); !slices.Equal(got, []string{"alice", "dave"}) {
+		t.Errorf("live peers = %v, want [alice dave]", got)
+	}
+	if got := names(cfg.GetDisabledPeers()); !slices.Equal(got, []string{"bob", "carol"}) {
+		t.Errorf("disabled peers = %v, want [bob carol]", got)
+	}
+	data, _ := fs.ReadFile(path)
+	if !strings.Contains(string(data), disabledPrefix+"PublicKey = "+bob) {
+		t.Errorf("bob not commented out:\n%s", data)
+	}
+
+	// Disabled peers keep their addresses: the next free one is .4, not
+	// bob's .3.
+	if next, err := cfg.GetNextIP("10.100.0.0/24"); err != nil || next != "10.100.0.4/32" {
+		t.Errorf("GetNextIP() = %q, %v; want 10.100.0.4/32", next, err)
+	}
+
+	// Nothing left to disable.
+	if disabled, err := cfg.DisableInactive(context.Background(), 30*24*time.Hour); err != nil || len(disabled) != 0 {
+		t.Errorf("second DisableInactive() = %v, %v; want nothing", names(disabled), err)
+	}
+}
+
+func TestReconcileReenablesDisabledPeer(t *testing.T) {
+	_, alicePub, _ := GenerateKeyPair()
+	_, bobPub, _ := GenerateKeyPair()
+
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = %s
+AllowedIPs = 10.100.0.2/32
+
+#disabled# [Peer]
+#disabled# # bob
+#disabled# PublicKey = %s
+#disabled# AllowedIPs = 10.100.0.3/32
+`, alicePub, bobPub)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	added, removed, updated, err := cfg.Reconcile([]Peer{
+		{Name: "alice", PublicKey: alicePub, AllowedIPs: []string{"10.100.0.2/32"}},
+		{Name: "bob", PublicKey: bobPub, AllowedIPs: []string{"10.100.0.3/32"}},
+	})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(added) != 1 || added[0].Name != "bob" || len(removed) != 0 || len(updated) != 0 {
+		t.Errorf("Reconcile() = %v, %v, %v", added, removed, updated)
+	}
+	data, _ := os.ReadFile(configPath)
+	if n := strings.Count(string(data), bobPub); n != 1 {
+		t.Errorf("bob's key appears %d times, want once:\n%s", n, data)
+	}
+	if strings.Contains(string(data), disabledPrefix) {
+		t.Errorf("re-enabled peer still has a disabled block:\n%s", data)
+	}
+	if got := cfg.GetDisabledPeers(); len(got) != 0 {
+		t.Errorf("GetDisabledPeers() = %+v after re-enabling", got)
+	}
+}
+
+func TestReplaceInterface(t *testing.T) {
+	priv, pub, _ := GenerateKeyPair()
+	newPriv, _, _ := GenerateKeyPair()
+
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.100.0.1/24
+ListenPort = 51820
+Table = off
+
+[Peer]
+# self
+PublicKey = %s
+AllowedIPs = 10.100.0.2/32
+`, newPriv, pub)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	iface := cfg.GetInterface()
+	if iface.ListenPort != 51820 || iface.Address != "10.100.0.1/24" {
+		t.Fatalf("GetInterface() = %+v", iface)
+	}
+
+	for _, bad := range []Interface{
+		{PrivateKey: "short", Address: "10.100.0.1/24"},
+		{PrivateKey: priv, Address: "10.100.0.1"},
+		{PrivateKey: priv, Address: "10.100.0.1/24", ListenPort: 70000},
+		{PrivateKey: priv, Address: "10.100.0.1/24", MTU: -1},
+	} {
+		if err := cfg.ReplaceInterface(bad); err == nil {
+			t.Errorf("ReplaceInterface(%+v) = nil, want error", bad)
+		}
+	}
+	// The peer's key is priv's public half.
+	if err := cfg.ReplaceInterface(Interface{PrivateKey: priv, Address: "10.100.0.1/24"}); err == nil {
+		t.Error("ReplaceInterface() accepted a key a peer already uses")
+	}
+	if got := cfg.GetInterface(); got.PrivateKey != newPriv {
+		t.Fatal("rejected ReplaceInterface changed the interface")
+	}
+
+	iface.Address = "10.200.0.1/24, fd00::1/64"
+	iface.ListenPort = 51821
+	iface.PostUp = []string{"iptables -A FORWARD -i wg0 -j ACCEPT"}
+	if err := cfg.ReplaceInterface(iface); err != nil {
+		t.Fatalf("ReplaceInterface() error = %v", err)
+	}
+	data, _ := os.ReadFile(configPath)
+	for _, want := range []string{"Address = 10.200.0.1/24, fd00::1/64", "ListenPort = 51821", "PostUp = iptables", "Table = off", "# self"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	_, alicePub, _ := GenerateKeyPair()
+	_, bobPub, _ := GenerateKeyPair()
+	_, carolPub, _ := GenerateKeyPair()
+
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = %s
+AllowedIPs = 10.100.0.2/32
+
+[Peer]
+# bob
+PublicKey = %s
+AllowedIPs = 10.100.0.3/32
+`, alicePub, bobPub)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	dry := NewConfig(configPath, "wg0", WithDryRun(true))
+	if err := dry.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	desired := []Peer{
+		{Name: "carol", PublicKey: carolPub, AllowedIPs: []string{"10.100.0.4/32"}},
+		{Name: "alice-laptop", PublicKey: alicePub, AllowedIPs: []string{"10.100.0.2/32"}},
+	}
+	names := func(peers []Peer) []string {
+		var out []string
+		for _, p := range peers {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+
+	before, _ := os.ReadFile(configPath)
+	added, removed, updated, err := dry.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("dry-run Reconcile() error = %v", err)
+	}
+	if len(added) != 1 || len(removed) != 1 || len(updated) != 1 {
+		t.Errorf("dry-run Reconcile() = %v, %v, %v", names(added), names(removed), names(updated))
+	}
+	if got := names(dry.GetPeers()); !slices.Equal(got, []string{"alice", "bob"}) {
+		t.Errorf("dry run changed peers: %v", got)
+	}
+	if after, _ := os.ReadFile(configPath); string(after) != string(before) {
+		t.Errorf("dry run rewrote the file:\n%s", after)
+	}
+
+	added, removed, updated, err = cfg.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if !slices.Equal(names(added), []string{"carol"}) ||
+		!slices.Equal(names(removed), []string{"bob"}) ||
+		!slices.Equal(names(updated), []string{"alice-laptop"}) {
+		t.Errorf("Reconcile() = %v, %v, %v", names(added), names(removed), names(updated))
+	}
+	// Surviving peers keep their position; new ones go last.
+	if got := names(cfg.GetPeers()); !slices.Equal(got, []string{"alice-laptop", "carol"}) {
+		t.Errorf("peers = %v", got)
+	}
+
+	before, _ = os.ReadFile(configPath)
+	added, removed, updated, err = cfg.Reconcile(desired)
+	if err != nil || len(added)+len(removed)+len(updated) != 0 {
+		t.Errorf("second Reconcile() = %v, %v, %v, %v; want no changes", added, removed, updated, err)
+	}
+	after, _ := os.ReadFile(configPath)
+	if string(before) != string(after) {
+		t.Errorf("idempotent Reconcile rewrote the file:\n%s", after)
+	}
+
+	if _, _, _, err := cfg.Reconcile([]Peer{{Name: "x", PublicKey: "bad", AllowedIPs: []string{"10.100.0.9/32"}}}); err == nil {
+		t.Error("Reconcile() accepted an invalid desired set")
+	}
+}
+
+func TestSaveBackupRotation(t *testing.T) {
+	fs := system.NewDryRunFileSystem()
+	fs.AddFile("/etc/wireguard/wg0.conf", []byte("[Interface]\nAddress = 10.100.0.1/24\n"))
+	// Not ours: an operator's copy and a look-alike with a bad timestamp.
+	fs.AddFile("/etc/wireguard/wg0.conf.old.bak", []byte("keep me"))
+	fs.AddFile("/etc/wireguard/wg0.conf.20060102T150405.bak", []byte("keep me"))
+
+	cfg := NewConfig("/etc/wireguard/wg0.conf", "wg0")
+	cfg.fs = fs
+	if err := cfg.parse([]byte("[Interface]\nAddress = 10.100.0.1/24\n")); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SetBackupCount(2)
+
+	// Unchanged content: nothing to back up.
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if backups, _ := cfg.listBackups(); len(backups) != 0 {
+		t.Fatalf("no-op Save made backups: %v", backups)
+	}
+
+	var previous []string
+	for i := 0; i < 4; i++ {
+		data, _ := fs.ReadFile("/etc/wireguard/wg0.conf")
+		previous = append(previous, string(data))
+		cfg.mtu = 1400 + i
+		if err := cfg.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	backups, err := cfg.listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("kept %d backups, want 2: %v", len(backups), backups)
+	}
+	for i, path := range backups {
+		if !strings.HasPrefix(path, "/etc/wireguard/wg0.conf.") {
+			t.Errorf("backup %s is not next to wg0.conf", path)
+		}
+		data, _ := fs.ReadFile(path)
+		if want := previous[len(previous)-2+i]; string(data) != want {
+			t.Errorf("backup %d = %q, want %q", i, data, want)
+		}
+	}
+
+	for _, path := range []string{"/etc/wireguard/wg0.conf.old.bak", "/etc/wireguard/wg0.conf.20060102T150405.bak"} {
+		if !fs.Exists(path) {
+			t.Errorf("rotation removed %s, which is not a backup it wrote", path)
+		}
+	}
+
+	cfg.SetBackupCount(0)
+	cfg.mtu = 1500
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := cfg.listBackups(); len(after) != 2 {
+		t.Errorf("Save with backups disabled changed backups: %v", after)
+	}
+}
+
+func TestRotatePresharedKey(t *testing.T) {
+	psk, err := GeneratePresharedKey()
+	if err != nil {
+		t.Fatalf("GeneratePresharedKey() error = %v", err)
+	}
+	if !ValidatePresharedKey(psk) {
+		t.Errorf("GeneratePresharedKey() = %q, which does not validate", psk)
+	}
+	if other, _ := GeneratePresharedKey(); other == psk {
+		t.Error("GeneratePresharedKey() returned the same key twice")
+	}
+
+	_, alicePub, _ := GenerateKeyPair()
+	configPath := filepath.Join(t.TempDir(), "wg0.conf")
+	os.WriteFile(configPath, []byte(fmt.Sprintf(`[Interface]
+Address = 10.100.0.1/24
+
+[Peer]
+# alice
+PublicKey = %s
+PresharedKey = %s
+AllowedIPs = 10.100.0.2/32
+`, alicePub, psk)), 0600)
+	cfg := NewConfig(configPath, "wg0")
+	if err := cfg.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	rotated, err := cfg.RotatePresharedKey(alicePub)
+	if err != nil {
+		t.Fatalf("RotatePresharedKey() error = %v", err)
+	}
+	if rotated == psk || !ValidatePresharedKey(rotated) {
+		t.Errorf("RotatePresharedKey() = %q, want a new valid key", rotated)
+	}
+
+	reloaded := NewConfig(configPath, "wg0")
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("reload error = %v", err)
+	}
+	if got := reloaded.GetPeerByPublicKey(alicePub).PresharedKey; got != rotated {
+		t.Errorf("PresharedKey on disk = %q, want %q", got, rotated)
+	}
+
+	if _, err := cfg.RotatePresharedKey("missing"); err == nil {
+		t.Error("RotatePresharedKey() accepted an unknown peer")
+	}
 }